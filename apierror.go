@@ -0,0 +1,54 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// APIError is the body of every error response. Code is stable and meant for
+// programs; Message is for people and may change. Message keeps the "error"
+// key earlier clients read, and Details carries what the caller needs to fix
+// the request, e.g. {"expected": ...}.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Error codes. They are part of the API, so existing ones never change meaning.
+const (
+	codeInvalidRequest      = "invalid_request"  // a malformed body, query parameter or form field
+	codeMissingFields       = "missing_fields"   // a required field or file is absent
+	codeInvalidPlatform     = "invalid_platform" // unknown platform, or one that does not match the version
+	codeInvalidChannel      = "invalid_channel"
+	codeInvalidFile         = "invalid_file" // the uploaded artifact failed validation
+	codeUnauthorized        = "unauthorized"
+	codePlatformForbidden   = "platform_forbidden" // the API key is scoped to other platforms
+	codeUnknownApp          = "unknown_app"
+	codeNotFound            = "not_found"
+	codeVersionExists       = "version_exists"
+	codePreconditionFailed  = "precondition_failed" // If-Match named an older state of the record
+	codeChecksumMismatch    = "checksum_mismatch"   // the stored file does not match its recorded checksum or size
+	codeVersionDisabled     = "version_disabled"
+	codeRolloutPaused       = "rollout_paused" // the version's rollout percentage is frozen
+	codeDowngradeBlocked    = "downgrade_blocked"
+	codeRangeNotSatisfiable = "range_not_satisfiable"
+	codeTooLarge            = "too_large"
+	codeRateLimited         = "rate_limited"
+	codeServerBusy          = "server_busy"
+	codeSourceFetchFailed   = "source_fetch_failed" // an upload's source_url could not be fetched
+	codeUnsupported         = "unsupported"         // e.g. an unsupported tus version or content type
+	codeUploadConflict      = "upload_conflict"     // a resumable upload's offset does not match
+	codePathConflict        = "path_conflict"       // an upload's storage path already holds an object
+	codeUploadExpired       = "upload_expired"
+	codeDatabaseError       = "database_error"
+	codeStorageError        = "storage_error"
+	codeInternalError       = "internal_error"
+)
+
+// respondError ends the request with an APIError
+func respondError(c *gin.Context, status int, code, message string) {
+	respondErrorDetails(c, status, code, message, nil)
+}
+
+// respondErrorDetails ends the request with an APIError carrying details
+func respondErrorDetails(c *gin.Context, status int, code, message string, details any) {
+	c.AbortWithStatusJSON(status, APIError{Code: code, Message: message, Details: details})
+}
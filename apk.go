@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// apkManifest is the subset of AndroidManifest.xml checked on upload
+type apkManifest struct {
+	Package     string
+	VersionCode int
+	VersionName string
+}
+
+// Chunk types of Android's binary XML format
+const (
+	axmlStringPool   = 0x0001
+	axmlResourceMap  = 0x0180
+	axmlStartElement = 0x0102
+)
+
+// Resource ids of the manifest attributes, used when their names are stripped
+const (
+	attrVersionCode = 0x0101021b
+	attrVersionName = 0x0101021c
+)
+
+// Typed value kinds used by the attributes we read
+const (
+	typeString = 0x03
+	typeIntDec = 0x10
+	typeIntHex = 0x11
+)
+
+// maxManifestLen bounds how much of an archived manifest is read into memory
+const maxManifestLen = 8 << 20
+
+// readAPKManifest unzips AndroidManifest.xml from an APK and decodes the
+// package, versionCode and versionName of its root element
+func readAPKManifest(r io.ReaderAt, size int64) (*apkManifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid APK: %w", err)
+	}
+	f, err := zr.Open("AndroidManifest.xml")
+	if err != nil {
+		return nil, fmt.Errorf("AndroidManifest.xml: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxManifestLen))
+	if err != nil {
+		return nil, fmt.Errorf("AndroidManifest.xml: %w", err)
+	}
+	return parseAXMLManifest(data)
+}
+
+// parseAXMLManifest walks the chunks of a binary XML document up to the
+// first element, which in a manifest is <manifest>
+func parseAXMLManifest(data []byte) (*apkManifest, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint16(data) != 0x0003 {
+		return nil, errors.New("AndroidManifest.xml is not binary XML")
+	}
+
+	var strs []string
+	var resIDs []uint32
+	pos := int(binary.LittleEndian.Uint16(data[2:]))
+	for pos+8 <= len(data) {
+		typ := binary.LittleEndian.Uint16(data[pos:])
+		headerSize := int(binary.LittleEndian.Uint16(data[pos+2:]))
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if size < 8 || pos+size > len(data) || headerSize > size {
+			return nil, errors.New("AndroidManifest.xml is truncated")
+		}
+		chunk := data[pos : pos+size]
+
+		switch typ {
+		case axmlStringPool:
+			var err error
+			if strs, err = parseStringPool(chunk); err != nil {
+				return nil, err
+			}
+		case axmlResourceMap:
+			for i := headerSize; i+4 <= len(chunk); i += 4 {
+				resIDs = append(resIDs, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case axmlStartElement:
+			return parseManifestElement(chunk, headerSize, strs, resIDs)
+		}
+		pos += size
+	}
+	return nil, errors.New("AndroidManifest.xml has no <manifest> element")
+}
+
+func parseManifestElement(chunk []byte, headerSize int, strs []string, resIDs []uint32) (*apkManifest, error) {
+	str := func(i uint32) string {
+		if int(i) < len(strs) {
+			return strs[i]
+		}
+		return ""
+	}
+
+	ext := chunk[headerSize:]
+	if len(ext) < 20 {
+		return nil, errors.New("AndroidManifest.xml is truncated")
+	}
+	if name := str(binary.LittleEndian.Uint32(ext[4:])); name != "manifest" {
+		return nil, fmt.Errorf("AndroidManifest.xml root element is <%s>", name)
+	}
+	attrStart := int(binary.LittleEndian.Uint16(ext[8:]))
+	attrSize := int(binary.LittleEndian.Uint16(ext[10:]))
+	attrCount := int(binary.LittleEndian.Uint16(ext[12:]))
+	if attrSize < 20 || attrStart+attrCount*attrSize > len(ext) {
+		return nil, errors.New("AndroidManifest.xml is truncated")
+	}
+
+	m := &apkManifest{}
+	foundCode := false
+	for i := 0; i < attrCount; i++ {
+		attr := ext[attrStart+i*attrSize:]
+		nameIdx := binary.LittleEndian.Uint32(attr[4:])
+		raw := binary.LittleEndian.Uint32(attr[8:])
+		dataType := attr[15]
+		value := binary.LittleEndian.Uint32(attr[16:])
+
+		// Attribute names can be obfuscated; the resource map still identifies them
+		var resID uint32
+		if int(nameIdx) < len(resIDs) {
+			resID = resIDs[nameIdx]
+		}
+		name := str(nameIdx)
+
+		switch {
+		case name == "package":
+			m.Package = str(raw)
+		case name == "versionCode" || resID == attrVersionCode:
+			if dataType != typeIntDec && dataType != typeIntHex {
+				return nil, errors.New("AndroidManifest.xml versionCode is not an integer")
+			}
+			m.VersionCode = int(int32(value))
+			foundCode = true
+		case name == "versionName" || resID == attrVersionName:
+			if dataType == typeString {
+				m.VersionName = str(value)
+			} else {
+				m.VersionName = str(raw)
+			}
+		}
+	}
+	if !foundCode {
+		return nil, errors.New("AndroidManifest.xml has no versionCode")
+	}
+	return m, nil
+}
+
+// parseStringPool decodes a ResStringPool chunk in either UTF-8 or UTF-16
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, errors.New("AndroidManifest.xml string pool is truncated")
+	}
+	count := int(binary.LittleEndian.Uint32(chunk[8:]))
+	utf8Pool := binary.LittleEndian.Uint32(chunk[16:])&(1<<8) != 0
+	stringsStart := int(binary.LittleEndian.Uint32(chunk[20:]))
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	if headerSize+count*4 > len(chunk) || stringsStart > len(chunk) {
+		return nil, errors.New("AndroidManifest.xml string pool is truncated")
+	}
+
+	strs := make([]string, count)
+	for i := range strs {
+		off := stringsStart + int(binary.LittleEndian.Uint32(chunk[headerSize+i*4:]))
+		if off >= len(chunk) {
+			return nil, errors.New("AndroidManifest.xml string pool is truncated")
+		}
+		var ok bool
+		if utf8Pool {
+			strs[i], ok = decodeUTF8PoolString(chunk[off:])
+		} else {
+			strs[i], ok = decodeUTF16PoolString(chunk[off:])
+		}
+		if !ok {
+			return nil, errors.New("AndroidManifest.xml string pool is truncated")
+		}
+	}
+	return strs, nil
+}
+
+func decodeUTF8PoolString(b []byte) (string, bool) {
+	// Character count then byte count; only the latter is needed
+	_, pos, ok := poolLength8(b, 0)
+	if !ok {
+		return "", false
+	}
+	n, pos, ok := poolLength8(b, pos)
+	if !ok || pos+n > len(b) {
+		return "", false
+	}
+	return string(b[pos : pos+n]), true
+}
+
+// poolLength8 reads a one- or two-byte length from a UTF-8 string pool entry
+func poolLength8(b []byte, pos int) (n, next int, ok bool) {
+	if pos >= len(b) {
+		return 0, 0, false
+	}
+	if b[pos]&0x80 == 0 {
+		return int(b[pos]), pos + 1, true
+	}
+	if pos+1 >= len(b) {
+		return 0, 0, false
+	}
+	return int(b[pos]&0x7f)<<8 | int(b[pos+1]), pos + 2, true
+}
+
+func decodeUTF16PoolString(b []byte) (string, bool) {
+	if len(b) < 2 {
+		return "", false
+	}
+	n := int(binary.LittleEndian.Uint16(b))
+	pos := 2
+	if n&0x8000 != 0 {
+		if len(b) < 4 {
+			return "", false
+		}
+		n = (n&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b[2:]))
+		pos = 4
+	}
+	if pos+n*2 > len(b) {
+		return "", false
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[pos+i*2:])
+	}
+	return string(utf16.Decode(units)), true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// buildAXMLManifest encodes a binary AndroidManifest.xml whose <manifest>
+// carries package and versionCode
+func buildAXMLManifest(pkg string, versionCode int) []byte {
+	le := binary.LittleEndian
+	strs := []string{"manifest", "package", "versionCode", pkg}
+
+	// UTF-8 string pool
+	var data []byte
+	offsets := make([]byte, 0, len(strs)*4)
+	for _, s := range strs {
+		offsets = le.AppendUint32(offsets, uint32(len(data)))
+		data = append(data, byte(len(s)), byte(len(s)))
+		data = append(data, s...)
+		data = append(data, 0)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	pool := le.AppendUint16(nil, axmlStringPool)
+	pool = le.AppendUint16(pool, 28)
+	pool = le.AppendUint32(pool, uint32(28+len(offsets)+len(data)))
+	pool = le.AppendUint32(pool, uint32(len(strs)))
+	pool = le.AppendUint32(pool, 0)
+	pool = le.AppendUint32(pool, 1<<8)
+	pool = le.AppendUint32(pool, uint32(28+len(offsets)))
+	pool = le.AppendUint32(pool, 0)
+	pool = append(pool, offsets...)
+	pool = append(pool, data...)
+
+	attr := func(b []byte, name, raw uint32, dataType byte, value uint32) []byte {
+		b = le.AppendUint32(b, 0xffffffff)
+		b = le.AppendUint32(b, name)
+		b = le.AppendUint32(b, raw)
+		b = le.AppendUint16(b, 8)
+		b = append(b, 0, dataType)
+		return le.AppendUint32(b, value)
+	}
+	var elem []byte
+	elem = le.AppendUint32(elem, 0xffffffff)
+	elem = le.AppendUint32(elem, 0)
+	elem = le.AppendUint16(elem, 20)
+	elem = le.AppendUint16(elem, 20)
+	elem = le.AppendUint16(elem, 2)
+	elem = append(elem, make([]byte, 6)...)
+	elem = attr(elem, 1, 3, typeString, 3)
+	elem = attr(elem, 2, 0xffffffff, typeIntDec, uint32(versionCode))
+	start := le.AppendUint16(nil, axmlStartElement)
+	start = le.AppendUint16(start, 16)
+	start = le.AppendUint32(start, uint32(16+len(elem)))
+	start = append(start, make([]byte, 8)...)
+	start = append(start, elem...)
+
+	doc := le.AppendUint16(nil, 0x0003)
+	doc = le.AppendUint16(doc, 8)
+	doc = le.AppendUint32(doc, uint32(8+len(pool)+len(start)))
+	doc = append(doc, pool...)
+	return append(doc, start...)
+}
+
+// buildZip returns a ZIP archive holding files, keyed by name
+func buildZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// buildAPK returns an APK whose manifest declares pkg and versionCode
+func buildAPK(t *testing.T, pkg string, versionCode int) []byte {
+	t.Helper()
+	return buildZip(t, map[string][]byte{"AndroidManifest.xml": buildAXMLManifest(pkg, versionCode)})
+}
+
+func TestReadAPKManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		apk      []byte
+		wantPkg  string
+		wantCode int
+		wantErr  bool
+	}{
+		{name: "manifest", apk: buildAPK(t, "com.example.app", 42), wantPkg: "com.example.app", wantCode: 42},
+		{name: "no manifest", apk: buildZip(t, map[string][]byte{"classes.dex": {}}), wantErr: true},
+		{name: "text manifest", apk: buildZip(t, map[string][]byte{"AndroidManifest.xml": []byte("<manifest/>")}), wantErr: true},
+		{name: "truncated manifest", apk: buildZip(t, map[string][]byte{"AndroidManifest.xml": buildAXMLManifest("com.example.app", 42)[:60]}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := readAPKManifest(bytes.NewReader(tt.apk), int64(len(tt.apk)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && (m.Package != tt.wantPkg || m.VersionCode != tt.wantCode) {
+				t.Errorf("manifest = %+v, want package %q versionCode %d", m, tt.wantPkg, tt.wantCode)
+			}
+		})
+	}
+}
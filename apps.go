@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appScope returns the database and storage prefix of an app: apps/<appID>/,
+// or "" when the server isn't multi-tenant
+func appScope(appID string) string {
+	if appID == "" {
+		return ""
+	}
+	return "apps/" + appID + "/"
+}
+
+// tenants routes each request to the Server of the app it names. Without
+// OTA_APPS there is a single Server at the root and app_id is ignored.
+type tenants struct {
+	single *Server
+	apps   map[string]*Server
+	ids    []string
+}
+
+// newTenants builds one Server per configured app id, or the single root
+// Server when there are none
+func newTenants(appIDs []string, newServer func(appID string) *Server) *tenants {
+	if len(appIDs) == 0 {
+		return &tenants{single: newServer("")}
+	}
+	t := &tenants{apps: map[string]*Server{}}
+	for _, id := range appIDs {
+		t.apps[id] = newServer(id)
+		t.ids = append(t.ids, id)
+	}
+	sort.Strings(t.ids)
+	return t
+}
+
+// any returns some Server, for routes that only need the shared backends
+func (t *tenants) any() *Server {
+	if t.single != nil {
+		return t.single
+	}
+	return t.apps[t.ids[0]]
+}
+
+// all returns every app's Server
+func (t *tenants) all() []*Server {
+	if t.single != nil {
+		return []*Server{t.single}
+	}
+	servers := make([]*Server, 0, len(t.ids))
+	for _, id := range t.ids {
+		servers = append(servers, t.apps[id])
+	}
+	return servers
+}
+
+// handle wraps a Server method as a route handler for the request's app
+func (t *tenants) handle(h func(*Server, *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s, ok := t.resolve(c); ok {
+			h(s, c)
+		}
+	}
+}
+
+// resolve finds the app named by the app_id query parameter, or for
+// multipart uploads the app_id form field. It writes 400 when the id is
+// missing or unknown.
+func (t *tenants) resolve(c *gin.Context) (*Server, bool) {
+	if t.single != nil {
+		return t.single, true
+	}
+
+	appID := c.Query("app_id")
+	if appID == "" && c.ContentType() == "multipart/form-data" {
+		// Parsing the form here must still respect the upload size limit
+		if !limitUploadBody(c) {
+			return nil, false
+		}
+		appID = c.PostForm("app_id")
+	}
+	if appID == "" {
+		respondErrorDetails(c, http.StatusBadRequest, codeMissingFields, "Missing app_id", gin.H{"expected": t.ids})
+		return nil, false
+	}
+	s, ok := t.apps[appID]
+	if !ok {
+		respondErrorDetails(c, http.StatusBadRequest, codeUnknownApp, "Unknown app_id", gin.H{"expected": t.ids})
+		return nil, false
+	}
+	return s, true
+}
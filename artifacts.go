@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"mime/multipart"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Artifact is one file of a release. Split builds have one per ABI; a
+// universal build has a single artifact with an empty ABI.
+type Artifact struct {
+	ABI         string `json:"abi,omitempty"` // e.g. "arm64-v8a" or "x86_64"; "" = universal
+	StoragePath string `json:"storage_path"`
+	FileSize    int64  `json:"file_size"`
+	Checksum    string `json:"checksum"` // SHA-256
+}
+
+// artifactFileField prefixes the upload fields of split builds, e.g.
+// file_arm64-v8a; the plain "file" field carries the universal build
+const artifactFileField = "file_"
+
+// abiPattern is the form ABI labels are stored in after lowercasing
+var abiPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// uploadFile is an uploaded file and the ABI it was submitted for
+type uploadFile struct {
+	ABI    string
+	Header *multipart.FileHeader
+	// Content holds files that did not arrive in the form, e.g. a fetched
+	// source_url; Header then only names the file and gives its size
+	Content multipart.File
+}
+
+// open returns the file's content
+func (f uploadFile) open() (multipart.File, error) {
+	if f.Content != nil {
+		return f.Content, nil
+	}
+	return f.Header.Open()
+}
+
+// parseUploadFiles collects the files of an upload: the universal build from
+// "file" first, then the file_<abi> builds by ABI. The first is the primary
+// artifact, which the version's own storage_path, file_size and checksum describe.
+func parseUploadFiles(form *multipart.Form) ([]uploadFile, error) {
+	if form == nil {
+		return nil, nil
+	}
+	var files []uploadFile
+	seen := map[string]bool{}
+	for field, headers := range form.File {
+		if len(headers) == 0 {
+			continue
+		}
+		abi, ok := strings.CutPrefix(field, artifactFileField)
+		if field == "file" {
+			abi, ok = "", true
+		}
+		if !ok {
+			continue
+		}
+		abi = strings.ToLower(strings.TrimSpace(abi))
+		if field != "file" && !abiPattern.MatchString(abi) {
+			return nil, fmt.Errorf("invalid ABI in field %q", field)
+		}
+		if len(headers) > 1 || seen[abi] {
+			return nil, fmt.Errorf("more than one file for ABI %q", abi)
+		}
+		seen[abi] = true
+		files = append(files, uploadFile{ABI: abi, Header: headers[0]})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ABI < files[j].ABI })
+	return files, nil
+}
+
+// artifactsOf returns a version's artifacts; records from before split
+// builds have their single file described by the version itself
+func artifactsOf(v AppVersion) []Artifact {
+	if len(v.Artifacts) > 0 {
+		return v.Artifacts
+	}
+	return []Artifact{{StoragePath: v.StoragePath, FileSize: v.FileSize, Checksum: v.Checksum}}
+}
+
+// artifactABIs lists the ABIs a version has split builds for
+func artifactABIs(v AppVersion) []string {
+	abis := []string{}
+	for _, a := range artifactsOf(v) {
+		if a.ABI != "" {
+			abis = append(abis, a.ABI)
+		}
+	}
+	return abis
+}
+
+// selectArtifact picks the artifact to serve a device with abi: its own
+// split build, else the universal build. Without an abi only the universal
+// build qualifies.
+func selectArtifact(v AppVersion, abi string) (Artifact, bool) {
+	abi = strings.ToLower(strings.TrimSpace(abi))
+	var universal *Artifact
+	for _, a := range artifactsOf(v) {
+		if abi != "" && a.ABI == abi {
+			return a, true
+		}
+		if a.ABI == "" {
+			universal = &a
+		}
+	}
+	if universal == nil {
+		return Artifact{}, false
+	}
+	return *universal, true
+}
+
+// withArtifact returns v describing artifact a in its own storage_path,
+// file_size and checksum, so download code serves a like any single file
+func withArtifact(v AppVersion, a Artifact) AppVersion {
+	v.StoragePath = a.StoragePath
+	v.FileSize = a.FileSize
+	v.Checksum = a.Checksum
+	return v
+}
+
+// stagedUpload is an uploaded file that passed validation, with its checksum
+type stagedUpload struct {
+	uploadFile
+	src      multipart.File
+	meta     *UploadArtifact
+	checksum string
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audited actions
+const (
+	auditUpload        = "version.upload"
+	auditUpdate        = "version.update"
+	auditDelete        = "version.delete"
+	auditRestore       = "version.restore"
+	auditDisable       = "version.disable"
+	auditEnable        = "version.enable"
+	auditRollback      = "version.rollback"
+	auditRolloutPause  = "version.rollout_pause"
+	auditRolloutResume = "version.rollout_resume"
+	auditPrune         = "version.prune"
+	auditPurge         = "version.purge"
+	auditRehash        = "version.rehash"
+	auditPatch         = "patch.upload"
+	auditPause         = "platform.pause"
+	auditResume        = "platform.resume"
+	auditMinSupported  = "platform.min_supported_code"
+	auditPolicy        = "platform.policy"
+)
+
+// AuditEntry records one write operation: who did what to which version, and when
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	VersionID string    `json:"version_id,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Platform  string    `json:"platform,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	APIKeyID  string    `json:"api_key_id,omitempty"`
+	// APIKeyPlatforms is the key's platform scope; empty for unscoped keys
+	APIKeyPlatforms []string `json:"api_key_platforms,omitempty"`
+	// Details holds action-specific context, e.g. the fields an edit changed
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// auditSystemActor is the api_key_id of entries for removals the server
+// makes on its own, like retention pruning after an upload and trash purges
+const auditSystemActor = "system"
+
+// audit records a write under audit/. A failed write is logged but never
+// fails the operation, which has already happened.
+func (s *Server) audit(c *gin.Context, action string, v *AppVersion, details map[string]string) {
+	s.appendAudit(requestContext(c), AuditEntry{
+		Action:          action,
+		ClientIP:        clientIP(c),
+		APIKeyID:        c.GetString("api_key_id"),
+		APIKeyPlatforms: keyPlatforms(c),
+		Details:         details,
+	}, v)
+}
+
+// auditSystem records a write no request asked for, with the system as the actor
+func (s *Server) auditSystem(ctx context.Context, action string, v *AppVersion, details map[string]string) {
+	s.appendAudit(ctx, AuditEntry{Action: action, APIKeyID: auditSystemActor, Details: details}, v)
+}
+
+func (s *Server) appendAudit(ctx context.Context, entry AuditEntry, v *AppVersion) {
+	now := time.Now()
+	entry.ID = newPushID(now)
+	entry.Timestamp = now
+	if v != nil {
+		entry.VersionID = v.ID
+		entry.Version = v.Version
+		entry.Platform = v.Platform
+	}
+	if err := s.store.AppendAudit(ctx, entry); err != nil {
+		loggerFrom(ctx).Error("audit write failed", "action", entry.Action, "version_id", entry.VersionID, "err", err)
+	}
+}
+
+// getAuditLog returns audit entries newest first, always paginated with
+// limit/offset like /versions. action and version_id narrow the results.
+func (s *Server) getAuditLog(c *gin.Context) {
+	ctx := requestContext(c)
+	limit, offset, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	entries, err := s.store.ListAudit(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("audit read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+
+	action, versionID := c.Query("action"), c.Query("version_id")
+	filtered := []AuditEntry{}
+	for _, e := range entries {
+		if (action == "" || e.Action == action) && (versionID == "" || e.VersionID == versionID) {
+			filtered = append(filtered, e)
+		}
+	}
+	// Push ids sort chronologically
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].ID > filtered[j].ID })
+
+	total := len(filtered)
+	var nextOffset *int
+	if offset+limit < total {
+		next := offset + limit
+		nextOffset = &next
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"entries":     filtered[min(offset, total):min(offset+limit, total)],
+		"total":       total,
+		"next_offset": nextOffset,
+	})
+}
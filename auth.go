@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKey is a key accepted on write endpoints, kept as its SHA-256 digest.
+// platforms scopes it to those platforms' versions; nil allows every platform.
+type apiKey struct {
+	digest    [sha256.Size]byte
+	platforms []string
+}
+
+// parseAPIKeys parses OTA_API_KEYS: comma-separated keys, each optionally
+// followed by ":" and the platforms it is limited to. Platform names after a
+// scoped key extend its scope, so "ci:android,ios,admin" is the key "ci" for
+// both platforms and the unscoped key "admin". Blanks are ignored. Several
+// keys can be active at once so they can be rotated without downtime.
+func parseAPIKeys(raw string) ([]apiKey, error) {
+	var keys []apiKey
+	scoped := false
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if scoped && isSupportedPlatform(field) {
+			last := &keys[len(keys)-1]
+			last.platforms = append(last.platforms, field)
+			continue
+		}
+
+		secret, scope, hasScope := strings.Cut(field, ":")
+		if secret == "" {
+			return nil, fmt.Errorf("contains a scope without a key")
+		}
+		key := apiKey{digest: sha256.Sum256([]byte(secret))}
+		if hasScope {
+			if !isSupportedPlatform(scope) {
+				return nil, fmt.Errorf("scopes a key to unknown platform %q", scope)
+			}
+			key.platforms = []string{scope}
+		}
+		keys = append(keys, key)
+		scoped = hasScope
+	}
+	return keys, nil
+}
+
+// requireAPIKey rejects requests without a valid X-API-Key header. Keys are
+// compared as fixed-length digests in constant time, and every configured
+// key is checked, so timing reveals neither the key nor which one matched.
+// The matched key's identifier is stored in the context as "api_key_id" and
+// its platform scope, if any, as "api_key_platforms".
+func requireAPIKey(c *gin.Context) {
+	key := c.GetHeader("X-API-Key")
+	if key == "" {
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, "Missing API key")
+		return
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	matched := -1
+	for i, want := range config.APIKeys {
+		matched = subtle.ConstantTimeSelect(subtle.ConstantTimeCompare(digest[:], want.digest[:]), i, matched)
+	}
+	if matched < 0 {
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, "Invalid API key")
+		return
+	}
+
+	c.Set("api_key_id", apiKeyID(digest))
+	if platforms := config.APIKeys[matched].platforms; platforms != nil {
+		c.Set("api_key_platforms", platforms)
+	}
+	c.Next()
+}
+
+// apiKeyID is a short, non-secret identifier for a key, safe to log
+func apiKeyID(digest [sha256.Size]byte) string {
+	return hex.EncodeToString(digest[:4])
+}
+
+// keyPlatforms returns the platforms the request's API key is scoped to,
+// nil when it may act on every platform
+func keyPlatforms(c *gin.Context) []string {
+	platforms, _ := c.Get("api_key_platforms")
+	scope, _ := platforms.([]string)
+	return scope
+}
+
+// keyAllowsPlatform reports whether the request's API key may act on
+// platform's versions
+func keyAllowsPlatform(c *gin.Context, platform string) bool {
+	scope := keyPlatforms(c)
+	return scope == nil || slices.Contains(scope, platform)
+}
+
+// authorizePlatform writes 403 when the request's API key is scoped to other
+// platforms than platform, so one release pipeline cannot publish to or
+// change another's builds
+func authorizePlatform(c *gin.Context, platform string) bool {
+	if keyAllowsPlatform(c, platform) {
+		return true
+	}
+	respondPlatformForbidden(c, platform)
+	return false
+}
+
+// requireUnscopedKey writes 403 for platform-scoped keys on endpoints that
+// act on every platform at once (verify-all, gc, the audit log), which a
+// scope could not narrow
+func requireUnscopedKey(c *gin.Context) {
+	if scope := keyPlatforms(c); scope != nil {
+		respondErrorDetails(c, http.StatusForbidden, codePlatformForbidden, "API key is scoped to platforms; this endpoint needs an unscoped key", gin.H{
+			"allowed": scope,
+		})
+		return
+	}
+	c.Next()
+}
+
+func respondPlatformForbidden(c *gin.Context, platform string) {
+	respondErrorDetails(c, http.StatusForbidden, codePlatformForbidden, "API key is not allowed to act on this platform", gin.H{
+		"platform": platform,
+		"allowed":  keyPlatforms(c),
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    [][]string // each key's platforms, in order
+		wantErr bool
+	}{
+		{raw: "", want: nil},
+		{raw: "a, b", want: [][]string{nil, nil}},
+		{raw: "a:android", want: [][]string{{"android"}}},
+		{raw: "a:android,ios,b", want: [][]string{{"android", "ios"}, nil}},
+		{raw: "a,ios", want: [][]string{nil, nil}},
+		{raw: "a:ios, b:android ,c", want: [][]string{{"ios"}, {"android"}, nil}},
+		{raw: "a:symbian", wantErr: true},
+		{raw: ":android", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			keys, err := parseAPIKeys(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.want) {
+				t.Fatalf("got %d keys, want %d", len(keys), len(tt.want))
+			}
+			for i, k := range keys {
+				if !slices.Equal(k.platforms, tt.want[i]) {
+					t.Errorf("key %d platforms = %v, want %v", i, k.platforms, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPlatformScopedKeys(t *testing.T) {
+	const keys = "OTA_API_KEYS=droid:android,apple:ios,both:android,ios,admin"
+	tus := func(ts *testServer, platform, key string) *httptest.ResponseRecorder {
+		meta := func(v string) string { return base64.StdEncoding.EncodeToString([]byte(v)) }
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ota/uploads", nil)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Length", "10")
+		req.Header.Set("Upload-Metadata", "version "+meta("2.0.0")+",version_code "+meta("20")+
+			",platform "+meta(platform)+",filename "+meta("app.aab"))
+		return ts.send(req, key)
+	}
+	upload := func(ts *testServer, platform, key string) *httptest.ResponseRecorder {
+		fields := map[string]string{"version": "2.0.0", "version_code": "20", "platform": platform}
+		return ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), key)
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		call       func(ts *testServer) *httptest.ResponseRecorder
+		wantStatus int
+	}{
+		{"upload own platform", "droid", func(ts *testServer) *httptest.ResponseRecorder { return upload(ts, "android", "droid") }, http.StatusOK},
+		{"upload other platform", "apple", func(ts *testServer) *httptest.ResponseRecorder { return upload(ts, "android", "apple") }, http.StatusForbidden},
+		{"upload multi-platform key", "both", func(ts *testServer) *httptest.ResponseRecorder { return upload(ts, "android", "both") }, http.StatusOK},
+		{"upload unscoped key", "admin", func(ts *testServer) *httptest.ResponseRecorder { return upload(ts, "android", "admin") }, http.StatusOK},
+		{"delete own platform", "droid", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodDelete, "/api/v1/ota/versions/android-1", nil, "droid")
+		}, http.StatusOK},
+		{"delete other platform", "apple", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodDelete, "/api/v1/ota/versions/android-1", nil, "apple")
+		}, http.StatusForbidden},
+		{"disable other platform", "apple", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodPost, "/api/v1/ota/versions/android-1/disable", nil, "apple")
+		}, http.StatusForbidden},
+		{"pause other platform", "apple", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodPost, "/api/v1/ota/platforms/android/pause", nil, "apple")
+		}, http.StatusForbidden},
+		{"resumable upload own platform", "droid", func(ts *testServer) *httptest.ResponseRecorder { return tus(ts, "android", "droid") }, http.StatusCreated},
+		{"resumable upload other platform", "apple", func(ts *testServer) *httptest.ResponseRecorder { return tus(ts, "android", "apple") }, http.StatusForbidden},
+		{"patch upload other platform", "apple", func(ts *testServer) *httptest.ResponseRecorder {
+			fields := map[string]string{"platform": "android", "from_code": "1", "to_code": "2"}
+			return ts.upload("/api/v1/ota/patches", fields, "1-2.patch", []byte("patch"), "apple")
+		}, http.StatusForbidden},
+		{"verify-all scoped key", "both", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodPost, "/api/v1/ota/verify-all", nil, "both")
+		}, http.StatusForbidden},
+		{"verify-all unscoped key", "admin", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodPost, "/api/v1/ota/verify-all", nil, "admin")
+		}, http.StatusOK},
+		{"gc scoped key", "droid", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodPost, "/api/v1/ota/gc", nil, "droid")
+		}, http.StatusForbidden},
+		{"gc unscoped key", "admin", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodPost, "/api/v1/ota/gc", nil, "admin")
+		}, http.StatusOK},
+		{"audit log scoped key", "droid", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodGet, "/api/v1/ota/audit", nil, "droid")
+		}, http.StatusForbidden},
+		{"audit log unscoped key", "admin", func(ts *testServer) *httptest.ResponseRecorder {
+			return ts.do(http.MethodGet, "/api/v1/ota/audit", nil, "admin")
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, keys)
+			ts.seed(AppVersion{VersionCode: 1})
+			w := tt.call(ts)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusForbidden {
+				if code := errorCode(t, w); code != codePlatformForbidden {
+					t.Errorf("code = %q, want %q", code, codePlatformForbidden)
+				}
+			}
+		})
+	}
+}
+
+func TestPlatformScopedBatchDelete(t *testing.T) {
+	ts := newTestServer(t, "OTA_API_KEYS=droid:android")
+	ts.seed(AppVersion{VersionCode: 1})
+	ts.seed(AppVersion{VersionCode: 2, Platform: "ios"})
+
+	w := ts.do(http.MethodPost, "/api/v1/ota/versions/delete-batch", []string{"android-1", "ios-2"}, "droid")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body)
+	}
+	var body struct {
+		Results []BatchDeleteResult `json:"results"`
+	}
+	decodeJSON(t, w, &body)
+	got := map[string]string{}
+	for _, r := range body.Results {
+		got[r.ID] = r.Status
+	}
+	if got["android-1"] != batchDeleted || got["ios-2"] != batchForbidden {
+		t.Errorf("results = %v", got)
+	}
+}
+
+func TestAuditRecordsKeyScope(t *testing.T) {
+	ts := newTestServer(t, "OTA_API_KEYS=droid:android")
+	ts.seed(AppVersion{VersionCode: 1})
+	if w := ts.do(http.MethodDelete, "/api/v1/ota/versions/android-1", nil, "droid"); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+
+	entries, err := ts.store.ListAudit(context.Background())
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("audit = %v, %v", entries, err)
+	}
+	if got := entries[0].APIKeyPlatforms; !slices.Equal(got, []string{"android"}) {
+		t.Errorf("api_key_platforms = %v", got)
+	}
+}
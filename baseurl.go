@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkBaseURL validates PUBLIC_BASE_URL, returning a problem or ""
+func checkBaseURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "expected an absolute URL such as https://ota.example.com"
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "a base URL has no query, fragment or credentials"
+	}
+	return ""
+}
+
+// baseURL is the scheme and host clients reach this server at: PUBLIC_BASE_URL
+// when set, otherwise the request's own, honouring X-Forwarded-Proto and
+// X-Forwarded-Host from a proxy such as Cloud Run's front end
+func baseURL(c *gin.Context) string {
+	if config.PublicBaseURL != "" {
+		return config.PublicBaseURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := forwardedValue(c.GetHeader("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := c.Request.Host
+	if forwarded := forwardedValue(c.GetHeader("X-Forwarded-Host")); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
+
+// forwardedValue returns the first entry of a comma-separated X-Forwarded-*
+// header, which is the one set by the proxy nearest the client
+func forwardedValue(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	return strings.ToLower(strings.TrimSpace(first))
+}
+
+// absoluteURL prefixes a server path such as a download URL with baseURL
+func absoluteURL(c *gin.Context, path string) string {
+	return baseURL(c) + path
+}
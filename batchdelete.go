@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchDelete bounds one batch so a request can't run for minutes
+const maxBatchDelete = 100
+
+// Per-id outcomes of a batch delete
+const (
+	batchDeleted   = "deleted"
+	batchNotFound  = "not-found"
+	batchForbidden = "forbidden" // the API key is scoped to other platforms
+	batchError     = "error"
+)
+
+// BatchDeleteResult is the outcome for one id of a batch delete
+type BatchDeleteResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// deleteVersionsBatch deletes every version in a JSON array of ids. Each id
+// goes through the single-delete path and gets its own result, so one failure
+// doesn't stop the rest.
+func (s *Server) deleteVersionsBatch(c *gin.Context) {
+	var ids []string
+	if err := c.ShouldBindJSON(&ids); err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid body", gin.H{
+			"expected": "JSON array of version ids",
+		})
+		return
+	}
+	if len(ids) == 0 || len(ids) > maxBatchDelete {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid number of ids", gin.H{
+			"expected": fmt.Sprintf("between 1 and %d ids", maxBatchDelete),
+		})
+		return
+	}
+
+	results := []BatchDeleteResult{}
+	seen := map[string]bool{}
+	deleted, failed := 0, 0
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		result := BatchDeleteResult{ID: id, Status: batchDeleted}
+		err := s.deleteByID(c, id, "")
+		switch {
+		case errors.Is(err, errVersionNotFound):
+			result.Status = batchNotFound
+		case errors.As(err, new(*platformForbiddenError)):
+			result.Status = batchForbidden
+			result.Error = err.Error()
+			failed++
+		case err != nil:
+			result.Status = batchError
+			result.Error = "Failed to delete version"
+			failed++
+		default:
+			deleted++
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"deleted": deleted,
+		"failed":  failed,
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDeleteVersionsBatch(t *testing.T) {
+	tooMany := make([]string, maxBatchDelete+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("android-%d", i)
+	}
+	tests := []struct {
+		name        string
+		body        any
+		env         []string
+		failDelete  bool
+		wantStatus  int
+		wantResults []BatchDeleteResult // id and status only
+		wantDeleted int
+		wantFailed  int
+		wantLeft    []string // versions still stored
+	}{
+		{
+			name:        "deletes each id",
+			body:        []string{"android-1", "android-2"},
+			wantStatus:  http.StatusOK,
+			wantResults: []BatchDeleteResult{{ID: "android-1", Status: batchDeleted}, {ID: "android-2", Status: batchDeleted}},
+			wantDeleted: 2,
+			wantLeft:    []string{"android-3"},
+		},
+		{
+			name:       "unknown and invalid ids don't stop the batch",
+			body:       []string{"missing", "android-1", "a/b"},
+			wantStatus: http.StatusOK,
+			wantResults: []BatchDeleteResult{
+				{ID: "missing", Status: batchNotFound},
+				{ID: "android-1", Status: batchDeleted},
+				{ID: "a/b", Status: batchNotFound},
+			},
+			wantDeleted: 1,
+			wantLeft:    []string{"android-2", "android-3"},
+		},
+		{
+			name:        "duplicates reported once",
+			body:        []string{"android-1", "android-1"},
+			wantStatus:  http.StatusOK,
+			wantResults: []BatchDeleteResult{{ID: "android-1", Status: batchDeleted}},
+			wantDeleted: 1,
+			wantLeft:    []string{"android-2", "android-3"},
+		},
+		{
+			name:        "store failure per id",
+			body:        []string{"android-1", "missing"},
+			env:         []string{"TRASH_RETENTION=0"},
+			failDelete:  true,
+			wantStatus:  http.StatusOK,
+			wantResults: []BatchDeleteResult{{ID: "android-1", Status: batchError}, {ID: "missing", Status: batchNotFound}},
+			wantFailed:  1,
+			wantLeft:    []string{"android-1", "android-2", "android-3"},
+		},
+		{name: "empty array", body: []string{}, wantStatus: http.StatusBadRequest, wantLeft: []string{"android-1", "android-2", "android-3"}},
+		{name: "not an array", body: map[string]string{"id": "android-1"}, wantStatus: http.StatusBadRequest, wantLeft: []string{"android-1", "android-2", "android-3"}},
+		{name: "too many ids", body: tooMany, wantStatus: http.StatusBadRequest, wantLeft: []string{"android-1", "android-2", "android-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingStore{memoryStore: newMemoryStore()}
+			ts := newTestServerWithStore(t, store, tt.env...)
+			for code := 1; code <= 3; code++ {
+				ts.seed(AppVersion{VersionCode: code})
+			}
+			store.failDelete = tt.failDelete
+
+			w := ts.do(http.MethodPost, "/api/v1/ota/versions/delete-batch", tt.body, testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != codeInvalidRequest {
+					t.Errorf("code = %q, want %q", code, codeInvalidRequest)
+				}
+			} else {
+				var body struct {
+					Results []BatchDeleteResult `json:"results"`
+					Deleted int                 `json:"deleted"`
+					Failed  int                 `json:"failed"`
+				}
+				decodeJSON(t, w, &body)
+				if len(body.Results) != len(tt.wantResults) {
+					t.Fatalf("results = %+v, want %+v", body.Results, tt.wantResults)
+				}
+				for i, r := range body.Results {
+					if r.ID != tt.wantResults[i].ID || r.Status != tt.wantResults[i].Status {
+						t.Errorf("result %d = %+v, want %+v", i, r, tt.wantResults[i])
+					}
+					if (r.Status == batchError) != (r.Error != "") {
+						t.Errorf("result %d error = %q", i, r.Error)
+					}
+				}
+				if body.Deleted != tt.wantDeleted || body.Failed != tt.wantFailed {
+					t.Errorf("deleted = %d, failed = %d, want %d, %d", body.Deleted, body.Failed, tt.wantDeleted, tt.wantFailed)
+				}
+			}
+
+			versions, err := store.memoryStore.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != len(tt.wantLeft) {
+				t.Errorf("%d versions left, want %v", len(versions), tt.wantLeft)
+			}
+			for _, id := range tt.wantLeft {
+				if _, ok := versions[id]; !ok {
+					t.Errorf("%s was deleted", id)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteVersionsBatchObjects(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         []string
+		wantObject  bool
+		wantRestore int
+	}{
+		{name: "trashed by default", wantObject: true, wantRestore: http.StatusOK},
+		{name: "deleted without a trash", env: []string{"TRASH_RETENTION=0"}, wantObject: false, wantRestore: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.env...)
+			v := ts.seed(AppVersion{VersionCode: 1})
+			w := ts.do(http.MethodPost, "/api/v1/ota/versions/delete-batch", []string{v.ID}, testAPIKey)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", w.Code, w.Body)
+			}
+			_, err := ts.store.OpenObject(context.Background(), v.StoragePath, 0, -1)
+			if (err == nil) != tt.wantObject {
+				t.Errorf("object %s stored = %t, want %t", v.StoragePath, err == nil, tt.wantObject)
+			}
+			if w := ts.do(http.MethodPost, "/api/v1/ota/versions/"+v.ID+"/restore", nil, testAPIKey); w.Code != tt.wantRestore {
+				t.Errorf("restore status = %d, want %d (%s)", w.Code, tt.wantRestore, w.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteVersionsBatchRequiresAPIKey(t *testing.T) {
+	ts := newTestServer(t)
+	ts.seed(AppVersion{VersionCode: 1})
+	for _, key := range []string{"", "wrong"} {
+		w := ts.do(http.MethodPost, "/api/v1/ota/versions/delete-batch", []string{"android-1"}, key)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("key %q: status = %d, want 401", key, w.Code)
+		}
+	}
+	if v, _ := ts.store.GetVersion(context.Background(), "android-1"); v == nil {
+		t.Error("version deleted without a valid key")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errRangeNotSatisfiable means the Range header cannot be served for the object size
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// byteRange is a resolved, inclusive range within an object
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// parseByteRange resolves a Range header against an object of the given size.
+// It returns nil when the whole object should be sent (no header or a unit
+// other than bytes). Only the first range of a multi-range request is honoured.
+func parseByteRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return nil, nil
+	}
+	spec, _, _ = strings.Cut(spec, ",")
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok || size <= 0 {
+		return nil, errRangeNotSatisfiable
+	}
+
+	// Suffix range: the final N bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return &byteRange{start: size - n, end: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, errRangeNotSatisfiable
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return &byteRange{start: start, end: end}, nil
+}
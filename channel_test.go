@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCheckUpdateChannels(t *testing.T) {
+	tests := []struct {
+		name       string
+		channel    string
+		versions   []AppVersion
+		wantStatus int
+		wantLatest int // 0 when no update is offered
+	}{
+		{name: "stable skips beta", versions: []AppVersion{{VersionCode: 2}, {VersionCode: 3, Channel: "beta"}}, wantStatus: http.StatusOK, wantLatest: 2},
+		{name: "beta gets beta", channel: "beta", versions: []AppVersion{{VersionCode: 2}, {VersionCode: 3, Channel: "beta"}}, wantStatus: http.StatusOK, wantLatest: 3},
+		{name: "beta falls through to newer stable", channel: "beta", versions: []AppVersion{{VersionCode: 2, Channel: "beta"}, {VersionCode: 3}}, wantStatus: http.StatusOK, wantLatest: 3},
+		{name: "beta skips alpha", channel: "beta", versions: []AppVersion{{VersionCode: 2, Channel: "alpha"}}, wantStatus: http.StatusOK},
+		{name: "only beta builds for stable", versions: []AppVersion{{VersionCode: 2, Channel: "beta"}}, wantStatus: http.StatusOK},
+		{name: "unknown channel", channel: "nightly", versions: []AppVersion{{VersionCode: 2}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			for _, v := range tt.versions {
+				ts.seed(v)
+			}
+
+			req := UpdateCheckRequest{CurrentVersion: "1.0.1", CurrentCode: 1, Platform: "android", Channel: tt.channel}
+			w := ts.do(http.MethodPost, "/api/v1/ota/check-update", req, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != codeInvalidChannel {
+					t.Errorf("code = %q, want %q", code, codeInvalidChannel)
+				}
+				return
+			}
+			var resp UpdateCheckResponse
+			decodeJSON(t, w, &resp)
+			if resp.UpdateAvailable != (tt.wantLatest != 0) {
+				t.Fatalf("update_available = %t, want %t", resp.UpdateAvailable, tt.wantLatest != 0)
+			}
+			if tt.wantLatest != 0 && resp.LatestVersion.VersionCode != tt.wantLatest {
+				t.Errorf("latest = %d, want %d", resp.LatestVersion.VersionCode, tt.wantLatest)
+			}
+		})
+	}
+}
+
+func TestUploadChannel(t *testing.T) {
+	tests := []struct {
+		name        string
+		channel     string
+		wantStatus  int
+		wantChannel string
+	}{
+		{name: "default", wantStatus: http.StatusOK, wantChannel: "stable"},
+		{name: "beta", channel: "beta", wantStatus: http.StatusOK, wantChannel: "beta"},
+		{name: "alpha", channel: "alpha", wantStatus: http.StatusOK, wantChannel: "alpha"},
+		{name: "unknown", channel: "nightly", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			fields := map[string]string{"version": "1.0.1", "version_code": "1", "platform": "android"}
+			if tt.channel != "" {
+				fields["channel"] = tt.channel
+			}
+			w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != codeInvalidChannel || len(versions) != 0 {
+					t.Errorf("code = %q with %d versions stored", code, len(versions))
+				}
+				return
+			}
+			for _, v := range versions {
+				if v.Channel != tt.wantChannel {
+					t.Errorf("channel = %q, want %q", v.Channel, tt.wantChannel)
+				}
+			}
+		})
+	}
+}
+
+func TestGetVersionsChannel(t *testing.T) {
+	tests := []struct {
+		channel    string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{channel: "", wantStatus: http.StatusOK, wantIDs: []string{"android-1", "android-2", "android-3"}},
+		{channel: "stable", wantStatus: http.StatusOK, wantIDs: []string{"android-1"}},
+		{channel: "beta", wantStatus: http.StatusOK, wantIDs: []string{"android-2"}},
+		{channel: "nightly", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run("channel="+tt.channel, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2, Channel: "beta"})
+			ts.seed(AppVersion{VersionCode: 3, Channel: "alpha"})
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/versions?sort=version_code&channel="+tt.channel, nil, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var versions []AppVersion
+			decodeJSON(t, w, &versions)
+			if len(versions) != len(tt.wantIDs) {
+				t.Fatalf("listed %d versions, want %v", len(versions), tt.wantIDs)
+			}
+			for i, v := range versions {
+				if v.ID != tt.wantIDs[i] {
+					t.Errorf("versions[%d] = %s, want %s", i, v.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
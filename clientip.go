@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIP is the address rate limits, audit entries and request logs are
+// keyed by. Each proxy in front of the server appends the address it got
+// the request from to X-Forwarded-For, so with TRUSTED_PROXY_HOPS proxies
+// the client is that many entries from the right; anything further left was
+// sent by the client and can't be trusted. Without a usable header, or with
+// no trusted proxies, it is the connection's peer address.
+func clientIP(c *gin.Context) string {
+	if hops := config.TrustedProxyHops; hops > 0 {
+		var entries []string
+		for _, header := range c.Request.Header.Values("X-Forwarded-For") {
+			entries = append(entries, strings.Split(header, ",")...)
+		}
+		if len(entries) >= hops {
+			if addr, err := netip.ParseAddr(strings.TrimSpace(entries[len(entries)-hops])); err == nil {
+				return addr.Unmap().String()
+			}
+		}
+	}
+	return c.RemoteIP()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressJSON gzips JSON responses of at least minBytes for clients that
+// accept it. Only application/json bodies are buffered and compressed, so
+// artifact and patch downloads (already ZIP-compressed, and streamed) pass
+// through untouched. A minBytes of 0 disables compression.
+func compressJSON(minBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if minBytes <= 0 || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		w := &jsonCompressWriter{
+			ResponseWriter: c.Writer,
+			minBytes:       minBytes,
+			gzip:           acceptsGzip(c.GetHeader("Accept-Encoding")),
+		}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+			if err := w.finish(); err != nil {
+				loggerFrom(requestContext(c)).Warn("writing compressed response failed", "err", err)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through *, with a non-zero quality
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonCompressWriter holds back a JSON body until the handler is done, since
+// only then is its size known; any other body is written straight through
+type jsonCompressWriter struct {
+	gin.ResponseWriter
+	minBytes  int
+	gzip      bool
+	decided   bool
+	buffering bool
+	buf       bytes.Buffer
+}
+
+// decide picks buffering or pass-through on the first write, once the
+// handler has set the content type
+func (w *jsonCompressWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	h := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType != "application/json" || h.Get("Content-Encoding") != "" {
+		return
+	}
+	w.buffering = true
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. for write deadlines
+func (w *jsonCompressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *jsonCompressWriter) Write(p []byte) (int, error) {
+	w.decide()
+	if !w.buffering {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *jsonCompressWriter) WriteString(s string) (int, error) {
+	w.decide()
+	if !w.buffering {
+		return w.ResponseWriter.WriteString(s)
+	}
+	return w.buf.WriteString(s)
+}
+
+// Flush gives up on compression: what is buffered goes out as is, and the
+// rest of the body follows unbuffered
+func (w *jsonCompressWriter) Flush() {
+	if w.buffering {
+		w.buffering = false
+		if _, err := w.ResponseWriter.Write(w.buf.Bytes()); err != nil {
+			return
+		}
+		w.buf.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the buffered body, gzipped when it is large enough and the
+// client accepts it
+func (w *jsonCompressWriter) finish() error {
+	if !w.buffering || w.buf.Len() == 0 {
+		return nil
+	}
+	if !w.gzip || w.buf.Len() < w.minBytes {
+		_, err := w.ResponseWriter.Write(w.buf.Bytes())
+		return err
+	}
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	zw := gzip.NewWriter(w.ResponseWriter)
+	if _, err := zw.Write(w.buf.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
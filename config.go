@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the server's settings, read and validated once at startup by
+// loadConfig. Handlers read it instead of the environment.
+type Config struct {
+	Port  string
+	Store string // "firebase" or "memory"
+	// Apps are the app ids served; empty means a single app at the root
+	Apps []string
+
+	// Firebase; required unless Store is "memory"
+	CredentialsJSON string // JSON document or path to a credentials file
+	ProjectID       string
+	DatabaseURL     string
+	StorageBucket   string
+
+	StorageWriteProbe bool
+	// PublicArtifacts makes uploaded files world-readable by their GCS URL
+	PublicArtifacts bool
+	APIKeys         []apiKey
+	FilenamePattern *regexp.Regexp
+	// VersionPattern is the format version strings must have, semver unless overridden
+	VersionPattern *regexp.Regexp
+	// StripVersionPrefix turns "v1.2.0" into "1.2.0" before VersionPattern is checked
+	StripVersionPrefix bool
+	// StoragePathTemplate lays out uploaded objects, see storagePathPlaceholders
+	StoragePathTemplate string
+	// StoragePathPattern matches paths StoragePathTemplate renders to
+	StoragePathPattern *regexp.Regexp
+	// CORSAllowedOrigins are the browser origins allowed cross-origin access;
+	// empty allows none, ["*"] allows all
+	CORSAllowedOrigins []string
+	// SourceURLAllowedHosts limits which hosts source_url uploads are fetched
+	// from, exact names or "*.example.com"; empty allows any public host
+	SourceURLAllowedHosts []string
+	// PublicBaseURL is where clients reach the server, for absolute URLs;
+	// empty derives it from each request
+	PublicBaseURL string
+
+	MaxUploadBytes          int64
+	KeepLastN               int
+	DistributionMagnetLinks bool
+	UploadSessionTTL        time.Duration
+
+	// DefaultLocale is the release notes locale used when the requested one is missing
+	DefaultLocale string
+
+	// MandatoryCodesBehind is how many version codes behind the latest a
+	// client must be for an update without an is_mandatory flag to be
+	// mandatory; 0 never makes such updates mandatory
+	MandatoryCodesBehind int
+
+	BlockDowngrades     bool
+	DownloadCacheMaxAge time.Duration
+	// DownloadStallTimeout aborts downloads making no progress for this long; 0 disables
+	DownloadStallTimeout time.Duration
+	SignedURLTTL         time.Duration
+	StaleLatestEnabled   bool
+	StaleLatestMaxAge    time.Duration
+	// StatsCacheTTL is how long /stats results are reused; 0 recomputes every time
+	StatsCacheTTL time.Duration
+
+	// FCM messages on publish, sent to a topic per platform
+	PushNotifications bool
+	PushTopicAndroid  string
+	PushTopicIOS      string
+
+	// Release channel announcements; WebhookKind is "slack" or "discord"
+	WebhookURL  string
+	WebhookKind string
+
+	// CheckUpdateWindow is how many of a platform's newest versions
+	// check-update reads before falling back to all of them
+	CheckUpdateWindow int
+
+	// Per-IP rate limits in requests per minute (0 = unlimited) and bursts
+	CheckUpdateRateLimit int
+	CheckUpdateBurst     int
+	UploadRateLimit      int
+	UploadBurst          int
+	// TrustedProxyHops is how many proxies append to X-Forwarded-For in front
+	// of the server; the client's address is that many entries from the right
+	TrustedProxyHops int
+
+	// JSON responses at least this large are gzipped; 0 disables compression
+	CompressMinBytes int
+
+	MaxInFlightRequests  int
+	MaxQueuedRequests    int
+	QueueTimeout         time.Duration
+	ShutdownGracePeriod  time.Duration
+	SlowDBThreshold      time.Duration
+	SlowStorageThreshold time.Duration
+	VerifyConcurrency    int
+	GCGracePeriod        time.Duration
+	ReadinessTimeout     time.Duration
+	// TrashRetention is how long deleted versions stay restorable; 0 deletes immediately
+	TrashRetention time.Duration
+}
+
+// config is loaded in main before any handler runs
+var config Config
+
+// appIDPattern keeps app ids safe as database keys and storage path segments
+var appIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ConfigError is one invalid or missing setting and how to fix it
+type ConfigError struct {
+	Var     string
+	Problem string
+	Hint    string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s %s (%s)", e.Var, e.Problem, e.Hint)
+}
+
+// ConfigErrors is every problem loadConfig found, so a deploy can be fixed in one go
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// envReader reads typed settings, collecting a ConfigError for each bad value
+type envReader struct {
+	errs ConfigErrors
+}
+
+func (r *envReader) fail(name, problem, hint string) {
+	r.errs = append(r.errs, &ConfigError{Var: name, Problem: problem, Hint: hint})
+}
+
+func (r *envReader) str(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
+
+func (r *envReader) required(name, hint string) string {
+	v := r.str(name)
+	if v == "" {
+		r.fail(name, "is not set", hint)
+	}
+	return v
+}
+
+func (r *envReader) int(name string, def int) int {
+	raw := r.str(name)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		r.fail(name, fmt.Sprintf("is %q", raw), "expected a non-negative integer")
+		return def
+	}
+	return v
+}
+
+func (r *envReader) duration(name string, def time.Duration) time.Duration {
+	raw := r.str(name)
+	if raw == "" {
+		return def
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v < 0 {
+		r.fail(name, fmt.Sprintf("is %q", raw), `expected a Go duration such as "90s" or "24h"`)
+		return def
+	}
+	return v
+}
+
+func (r *envReader) bool(name string) bool {
+	raw := r.str(name)
+	if raw == "" {
+		return false
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		r.fail(name, fmt.Sprintf("is %q", raw), "expected true or false")
+		return false
+	}
+	return v
+}
+
+// checkOrigin describes what is wrong with a CORS origin, or returns "". An
+// origin is "*" or scheme://host[:port], where the host may start with one
+// "*." wildcard label.
+func checkOrigin(origin string) string {
+	if origin == "*" {
+		return ""
+	}
+	u, err := url.Parse(strings.Replace(origin, "*.", "wildcard.", 1))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "expected an origin such as https://admin.example.com"
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "an origin has no path, query or credentials"
+	}
+	if strings.Count(origin, "*") > 1 || (strings.Contains(origin, "*") && !strings.HasPrefix(u.Host, "wildcard.")) {
+		return `only a single leading "*." subdomain wildcard is allowed, e.g. https://*.example.com`
+	}
+	return ""
+}
+
+// loadConfig reads every setting from the environment and validates it. The
+// error, a ConfigErrors, names each offending variable.
+func loadConfig() (Config, error) {
+	var r envReader
+	cfg := Config{
+		Port:  r.str("PORT"),
+		Store: r.str("OTA_STORE"),
+	}
+
+	if cfg.Port == "" {
+		cfg.Port = "8080"
+	}
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		r.fail("PORT", fmt.Sprintf("is %q", cfg.Port), "expected a TCP port between 1 and 65535")
+	}
+
+	switch cfg.Store {
+	case "":
+		cfg.Store = "firebase"
+	case "firebase", "memory":
+	default:
+		r.fail("OTA_STORE", fmt.Sprintf("is %q", cfg.Store), "expected firebase or memory")
+	}
+	for _, id := range strings.Split(r.str("OTA_APPS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !appIDPattern.MatchString(id) {
+			r.fail("OTA_APPS", fmt.Sprintf("contains %q", id), "app ids may only use letters, digits, '-' and '_'")
+			continue
+		}
+		cfg.Apps = append(cfg.Apps, id)
+	}
+
+	if cfg.Store == "firebase" {
+		cfg.CredentialsJSON = r.required("FIREBASE_CREDENTIALS_JSON",
+			"set it to the service account JSON or the path of its key file")
+		cfg.ProjectID = r.required("FIREBASE_PROJECT_ID",
+			"set it to the Firebase project id, e.g. my-app-12345")
+		cfg.DatabaseURL = r.required("FIREBASE_DB_URL",
+			"set it to the Realtime Database URL, e.g. https://my-app-12345-default-rtdb.firebaseio.com")
+		cfg.StorageBucket = r.required("FIREBASE_STORAGE_BUCKET",
+			"set it to the Storage bucket name, e.g. my-app-12345.appspot.com")
+		cfg.StorageWriteProbe = r.bool("STORAGE_WRITE_PROBE")
+	}
+
+	cfg.PublicArtifacts = r.bool("PUBLIC_ARTIFACTS")
+	if keys, err := parseAPIKeys(r.str("OTA_API_KEYS")); err != nil {
+		r.fail("OTA_API_KEYS", err.Error(), `expected comma-separated keys, each optionally scoped like "key:android" or "key:android,ios"`)
+	} else {
+		cfg.APIKeys = keys
+	}
+	for _, origin := range strings.Split(r.str("CORS_ALLOWED_ORIGINS"), ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if problem := checkOrigin(origin); problem != "" {
+			r.fail("CORS_ALLOWED_ORIGINS", fmt.Sprintf("contains %q", origin), problem)
+			continue
+		}
+		cfg.CORSAllowedOrigins = append(cfg.CORSAllowedOrigins, origin)
+	}
+	if len(cfg.CORSAllowedOrigins) > 1 && slices.Contains(cfg.CORSAllowedOrigins, "*") {
+		r.fail("CORS_ALLOWED_ORIGINS", "mixes * with other origins", "use * alone to allow every origin, or list the origins")
+	}
+
+	for _, host := range strings.Split(r.str("SOURCE_URL_ALLOWED_HOSTS"), ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		if name := strings.TrimPrefix(host, "*."); name == "" || strings.ContainsAny(name, "*/:@ ") {
+			r.fail("SOURCE_URL_ALLOWED_HOSTS", fmt.Sprintf("contains %q", host), `expected host names such as "ci.example.com" or "*.example.com", without scheme or port`)
+			continue
+		}
+		cfg.SourceURLAllowedHosts = append(cfg.SourceURLAllowedHosts, host)
+	}
+
+	cfg.PublicBaseURL = strings.TrimSuffix(r.str("PUBLIC_BASE_URL"), "/")
+	if cfg.PublicBaseURL != "" {
+		if problem := checkBaseURL(cfg.PublicBaseURL); problem != "" {
+			r.fail("PUBLIC_BASE_URL", fmt.Sprintf("is %q", cfg.PublicBaseURL), problem)
+		}
+	}
+
+	if pattern := r.str("UPLOAD_FILENAME_PATTERN"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			r.fail("UPLOAD_FILENAME_PATTERN", "is not a valid regular expression", err.Error())
+		}
+		cfg.FilenamePattern = re
+	}
+
+	cfg.VersionPattern = regexp.MustCompile(defaultVersionPattern)
+	if pattern := r.str("VERSION_PATTERN"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			r.fail("VERSION_PATTERN", "is not a valid regular expression", err.Error())
+		}
+		cfg.VersionPattern = re
+	}
+	cfg.StripVersionPrefix = r.bool("STRIP_VERSION_PREFIX")
+
+	cfg.StoragePathTemplate = defaultStoragePathTemplate
+	if template := r.str("STORAGE_PATH_TEMPLATE"); template != "" {
+		if problem := checkStoragePathTemplate(template); problem != "" {
+			r.fail("STORAGE_PATH_TEMPLATE", fmt.Sprintf("is %q", template), problem)
+		}
+		cfg.StoragePathTemplate = template
+	}
+	cfg.StoragePathPattern = storagePathPattern(cfg.StoragePathTemplate)
+
+	cfg.MaxUploadBytes = int64(r.int("MAX_UPLOAD_BYTES", 0))
+	cfg.KeepLastN = r.int("KEEP_LAST_N", 0)
+	if cfg.KeepLastN == 0 {
+		// Older name of the same setting
+		cfg.KeepLastN = r.int("MAX_VERSIONS_PER_PLATFORM", 0)
+	}
+	cfg.DistributionMagnetLinks = r.bool("DISTRIBUTION_MAGNET_LINKS")
+	cfg.UploadSessionTTL = r.duration("UPLOAD_SESSION_TTL", 24*time.Hour)
+
+	cfg.DefaultLocale = normalizeLocale(r.str("DEFAULT_LOCALE"))
+	if cfg.DefaultLocale == "" {
+		cfg.DefaultLocale = "en"
+	} else if !localePattern.MatchString(cfg.DefaultLocale) {
+		r.fail("DEFAULT_LOCALE", fmt.Sprintf("is %q", cfg.DefaultLocale), `expected a locale such as "en" or "pt-BR"`)
+	}
+
+	cfg.BlockDowngrades = r.bool("BLOCK_DOWNGRADES")
+	cfg.DownloadCacheMaxAge = r.duration("DOWNLOAD_CACHE_MAX_AGE", time.Hour)
+	cfg.DownloadStallTimeout = r.duration("DOWNLOAD_STALL_TIMEOUT", time.Minute)
+	if cfg.DownloadStallTimeout > 0 && cfg.DownloadStallTimeout < time.Second {
+		r.fail("DOWNLOAD_STALL_TIMEOUT", fmt.Sprintf("is %s", cfg.DownloadStallTimeout), "expected 0 to disable or at least 1s")
+	}
+	cfg.SignedURLTTL = r.duration("SIGNED_URL_TTL", 15*time.Minute)
+	cfg.StaleLatestEnabled = r.bool("STALE_LATEST_ENABLED")
+	cfg.StaleLatestMaxAge = r.duration("STALE_LATEST_MAX_AGE", 10*time.Minute)
+	cfg.StatsCacheTTL = r.duration("STATS_CACHE_TTL", 30*time.Second)
+
+	cfg.PushNotifications = r.bool("PUSH_NOTIFICATIONS")
+	cfg.PushTopicAndroid = r.str("PUSH_TOPIC_ANDROID")
+	if cfg.PushTopicAndroid == "" {
+		cfg.PushTopicAndroid = "ota-android"
+	}
+	cfg.PushTopicIOS = r.str("PUSH_TOPIC_IOS")
+	if cfg.PushTopicIOS == "" {
+		cfg.PushTopicIOS = "ota-ios"
+	}
+	for _, t := range []struct{ name, topic string }{
+		{"PUSH_TOPIC_ANDROID", cfg.PushTopicAndroid},
+		{"PUSH_TOPIC_IOS", cfg.PushTopicIOS},
+	} {
+		if !topicPattern.MatchString(t.topic) {
+			r.fail(t.name, fmt.Sprintf("is %q", t.topic), "FCM topics may only use letters, digits and -_.~%")
+		}
+	}
+
+	cfg.WebhookURL = r.str("WEBHOOK_URL")
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			r.fail("WEBHOOK_URL", "is not an http(s) URL", "set it to the incoming webhook URL from Slack or Discord")
+		}
+	}
+	cfg.WebhookKind = strings.ToLower(r.str("WEBHOOK_PLATFORM"))
+	switch cfg.WebhookKind {
+	case "":
+		cfg.WebhookKind = "slack"
+	case "slack", "discord":
+	default:
+		r.fail("WEBHOOK_PLATFORM", fmt.Sprintf("is %q", cfg.WebhookKind), "expected slack or discord")
+	}
+
+	cfg.CheckUpdateWindow = r.int("CHECK_UPDATE_WINDOW", 10)
+	if cfg.CheckUpdateWindow == 0 {
+		r.fail("CHECK_UPDATE_WINDOW", "is 0", "expected a positive number of versions")
+	}
+
+	cfg.MandatoryCodesBehind = r.int("MANDATORY_CODES_BEHIND", 2)
+
+	cfg.CheckUpdateRateLimit = r.int("CHECK_UPDATE_RATE_LIMIT", 0)
+	cfg.CheckUpdateBurst = r.int("CHECK_UPDATE_BURST", 0)
+	cfg.UploadRateLimit = r.int("UPLOAD_RATE_LIMIT", 0)
+	cfg.UploadBurst = r.int("UPLOAD_BURST", 0)
+	cfg.TrustedProxyHops = r.int("TRUSTED_PROXY_HOPS", 1)
+
+	cfg.CompressMinBytes = r.int("COMPRESS_MIN_BYTES", 1024)
+
+	cfg.MaxInFlightRequests = r.int("MAX_INFLIGHT_REQUESTS", 0)
+	cfg.MaxQueuedRequests = r.int("MAX_QUEUED_REQUESTS", 0)
+	cfg.QueueTimeout = r.duration("QUEUE_TIMEOUT", 10*time.Second)
+	cfg.ShutdownGracePeriod = r.duration("SHUTDOWN_GRACE_PERIOD", 10*time.Second)
+	cfg.SlowDBThreshold = r.duration("SLOW_DB_THRESHOLD", 500*time.Millisecond)
+	cfg.SlowStorageThreshold = r.duration("SLOW_STORAGE_THRESHOLD", time.Second)
+	cfg.VerifyConcurrency = r.int("VERIFY_CONCURRENCY", 4)
+	cfg.GCGracePeriod = r.duration("GC_GRACE_PERIOD", 24*time.Hour)
+	cfg.TrashRetention = r.duration("TRASH_RETENTION", 7*24*time.Hour)
+	cfg.ReadinessTimeout = r.duration("READINESS_TIMEOUT", 3*time.Second)
+
+	if len(r.errs) > 0 {
+		return cfg, r.errs
+	}
+	return cfg, nil
+}
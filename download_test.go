@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDownloadDigest(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		rangeHdr   string
+		wantStatus int
+		wantDigest bool
+	}{
+		{name: "full response", method: http.MethodGet, wantStatus: http.StatusOK, wantDigest: true},
+		{name: "head", method: http.MethodHead, wantStatus: http.StatusOK, wantDigest: true},
+		{name: "range response", method: http.MethodGet, rangeHdr: "bytes=0-3", wantStatus: http.StatusPartialContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			v := ts.seed(AppVersion{VersionCode: 1})
+			raw, _ := hex.DecodeString(v.Checksum)
+			want := base64.StdEncoding.EncodeToString(raw)
+
+			req := httptest.NewRequest(tt.method, "/api/v1/ota/download/1.0.1?platform=android", nil)
+			if tt.rangeHdr != "" {
+				req.Header.Set("Range", tt.rangeHdr)
+			}
+			w := ts.send(req, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("Repr-Digest"); got != "sha-256=:"+want+":" {
+				t.Errorf("Repr-Digest = %q, want %q", got, want)
+			}
+			wantDigest := ""
+			if tt.wantDigest {
+				wantDigest = "sha-256=" + want
+			}
+			if got := w.Header().Get("Digest"); got != wantDigest {
+				t.Errorf("Digest = %q, want %q", got, wantDigest)
+			}
+			if tt.method == http.MethodGet && tt.rangeHdr == "" {
+				if sum := sha256.Sum256(w.Body.Bytes()); base64.StdEncoding.EncodeToString(sum[:]) != want {
+					t.Errorf("Digest does not match the body")
+				}
+			}
+		})
+	}
+}
+
+func TestSHA256Digest(t *testing.T) {
+	tests := []struct {
+		checksum string
+		want     string
+		wantOK   bool
+	}{
+		{"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+		{"E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+		{"", "", false},
+		{"d41d8cd98f00b204e9800998ecf8427e", "", false},
+		{"not hex", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := sha256Digest(tt.checksum)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("sha256Digest(%q) = %q, %t, want %q, %t", tt.checksum, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestDownloadURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		body    any
+		extract func(t *testing.T, w *httptest.ResponseRecorder) string
+	}{
+		{name: "list with platform", target: "/api/v1/ota/versions?platform=android", extract: func(t *testing.T, w *httptest.ResponseRecorder) string {
+			var versions []AppVersion
+			decodeJSON(t, w, &versions)
+			return versions[len(versions)-1].DownloadURL
+		}},
+		{name: "latest", target: "/api/v1/ota/versions/latest?platform=android", extract: func(t *testing.T, w *httptest.ResponseRecorder) string {
+			var v AppVersion
+			decodeJSON(t, w, &v)
+			return v.DownloadURL
+		}},
+		{name: "by id", target: "/api/v1/ota/versions/android-2", extract: func(t *testing.T, w *httptest.ResponseRecorder) string {
+			var v AppVersion
+			decodeJSON(t, w, &v)
+			return v.DownloadURL
+		}},
+		{name: "check-update", target: "/api/v1/ota/check-update", body: UpdateCheckRequest{CurrentVersion: "1.0.1", CurrentCode: 1, Platform: "android"}, extract: func(t *testing.T, w *httptest.ResponseRecorder) string {
+			var resp UpdateCheckResponse
+			decodeJSON(t, w, &resp)
+			return resp.DownloadURL
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2, Version: "2.0.0+build 7"})
+
+			method := http.MethodGet
+			if tt.body != nil {
+				method = http.MethodPost
+			}
+			w := ts.do(method, tt.target, tt.body, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", w.Code, w.Body)
+			}
+			u := checkDownloadURL(t, tt.extract(t, w), "2.0.0+build 7", "android")
+			// The URL must lead back to the version it names
+			if w := ts.do(http.MethodGet, u.RequestURI(), nil, ""); w.Code != http.StatusOK || w.Body.String() != "PK\x03\x04android-2" {
+				t.Errorf("following %s: %d %q", u, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestUploadDownloadURL(t *testing.T) {
+	ts := newTestServer(t)
+	fields := map[string]string{"version": "1.0.1", "version_code": "1", "platform": "android"}
+	w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body)
+	}
+	var body struct {
+		DownloadURL string `json:"download_url"`
+	}
+	decodeJSON(t, w, &body)
+	checkDownloadURL(t, body.DownloadURL, "1.0.1", "android")
+}
+
+// checkDownloadURL fails unless raw is a well-formed download URL for
+// version with platform given exactly once, and returns it parsed
+func checkDownloadURL(t *testing.T, raw, version, platform string) *url.URL {
+	t.Helper()
+	if strings.Count(raw, "?") != 1 {
+		t.Fatalf("download_url %q has %d query separators", raw, strings.Count(raw, "?"))
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("download_url %q: %v", raw, err)
+	}
+	if want := "/api/v1/ota/download/" + version; u.Path != want {
+		t.Errorf("path = %q, want %q", u.Path, want)
+	}
+	for name, values := range u.Query() {
+		if len(values) != 1 {
+			t.Errorf("%s given %d times in %q", name, len(values), raw)
+		}
+	}
+	if got := u.Query().Get("platform"); got != platform {
+		t.Errorf("platform = %q, want %q", got, platform)
+	}
+	return u
+}
+
+func TestDownloadCount(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		headers    map[string]string
+		wantStatus int
+		want       int64
+	}{
+		{name: "full download", method: http.MethodGet, wantStatus: http.StatusOK, want: 1},
+		{name: "head", method: http.MethodHead, wantStatus: http.StatusOK, want: 0},
+		{name: "range probe", method: http.MethodGet, headers: map[string]string{"Range": "bytes=0-0"}, wantStatus: http.StatusPartialContent, want: 0},
+		{name: "not modified", method: http.MethodGet, headers: map[string]string{"If-None-Match": "etag"}, wantStatus: http.StatusNotModified, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			v := ts.seed(AppVersion{VersionCode: 1})
+			req := httptest.NewRequest(tt.method, "/api/v1/ota/download/1.0.1?platform=android", nil)
+			for name, value := range tt.headers {
+				if value == "etag" {
+					value = `"` + v.Checksum + `"`
+				}
+				req.Header.Set(name, value)
+			}
+			if w := ts.send(req, ""); w.Code != tt.wantStatus {
+				t.Fatalf("download: %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := downloadCount(t, ts, "android-1"); got != tt.want {
+				t.Errorf("download_count = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentDownloadCount(t *testing.T) {
+	ts := newTestServer(t)
+	ts.seed(AppVersion{VersionCode: 1})
+
+	const downloads = 50
+	var wg sync.WaitGroup
+	for range downloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			ts.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/ota/download/1.0.1?platform=android", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("download: %d", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := downloadCount(t, ts, "android-1"); got != downloads {
+		t.Errorf("download_count = %d, want %d", got, downloads)
+	}
+
+	// The list shows the same count
+	w := ts.do(http.MethodGet, "/api/v1/ota/versions", nil, "")
+	var versions []AppVersion
+	decodeJSON(t, w, &versions)
+	if len(versions) != 1 || versions[0].DownloadCount != downloads {
+		t.Errorf("listed %+v", versions)
+	}
+}
+
+// downloadCount reads a version's download_count through GET /versions/:id
+func downloadCount(t *testing.T, ts *testServer, id string) int64 {
+	t.Helper()
+	w := ts.do(http.MethodGet, "/api/v1/ota/versions/"+id, nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("get version: %d %s", w.Code, w.Body)
+	}
+	var v AppVersion
+	decodeJSON(t, w, &v)
+	return v.DownloadCount
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// artifactPrefixes are the storage prefixes whose objects belong to a version
+// record: the default releases/, the fixed directories STORAGE_PATH_TEMPLATE
+// starts with and patches/. Anything else (e.g. uploads/ staging) has its own
+// cleanup.
+func artifactPrefixes() []string {
+	prefixes := []string{"releases/"}
+	root := storagePathRoot(config.StoragePathTemplate)
+	// A template under releases/ is already covered
+	if root != "" && !strings.HasPrefix(root, "releases/") {
+		prefixes = append(prefixes, root)
+	}
+	return append(prefixes, "patches/")
+}
+
+// GCReport lists the orphaned objects a collection found
+type GCReport struct {
+	DryRun      bool   `json:"dry_run"`
+	GracePeriod string `json:"grace_period"`
+	Scanned     int    `json:"scanned"`
+	// Orphaned holds the objects deleted, or on a dry run the ones that would be
+	Orphaned []ObjectInfo `json:"orphaned"`
+	Bytes    int64        `json:"bytes"`
+	Failed   []string     `json:"failed"`
+}
+
+// collectGarbage deletes artifact objects that no version record refers to,
+// counting trashed versions since they can still be restored. Objects
+// younger than GC_GRACE_PERIOD are kept, since an upload in progress writes
+// its object before its record. It only reports unless dry_run=false.
+func (s *Server) collectGarbage(c *gin.Context) {
+	ctx := requestContext(c)
+	dryRun := true
+	if raw := c.Query("dry_run"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid dry_run", gin.H{"expected": "true or false"})
+			return
+		}
+		dryRun = parsed
+	}
+	grace := config.GCGracePeriod
+
+	// List objects before records: an object written in between then shows
+	// up as owned rather than orphaned
+	var objects []ObjectInfo
+	for _, prefix := range artifactPrefixes() {
+		listed, err := s.store.ListObjects(ctx, s.prefix+prefix)
+		if err != nil {
+			loggerFrom(ctx).Error("listing objects failed", "prefix", prefix, "err", err)
+			respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to list storage objects")
+			return
+		}
+		objects = append(objects, listed...)
+	}
+
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("version fetch failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to fetch versions")
+		return
+	}
+	trashed, err := s.store.ListTrashed(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("trashed version fetch failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to fetch versions")
+		return
+	}
+	owned := map[string]bool{}
+	for _, records := range []map[string]AppVersion{versions, trashed} {
+		for _, v := range records {
+			for _, a := range artifactsOf(v) {
+				owned[a.StoragePath] = true
+			}
+			for _, p := range v.Patches {
+				owned[p.StoragePath] = true
+			}
+		}
+	}
+
+	report := GCReport{
+		DryRun:      dryRun,
+		GracePeriod: grace.String(),
+		Scanned:     len(objects),
+		Orphaned:    []ObjectInfo{},
+		Failed:      []string{},
+	}
+	cutoff := time.Now().Add(-grace)
+	for _, obj := range objects {
+		if owned[obj.Path] || obj.Updated.After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := s.store.DeleteObject(ctx, obj.Path); err != nil {
+				loggerFrom(ctx).Warn("deleting orphaned object failed", "storage_path", obj.Path, "err", err)
+				report.Failed = append(report.Failed, obj.Path)
+				continue
+			}
+		}
+		report.Orphaned = append(report.Orphaned, obj)
+		report.Bytes += obj.Size
+	}
+	if !dryRun {
+		loggerFrom(ctx).Info("orphaned objects collected",
+			"scanned", report.Scanned, "deleted", len(report.Orphaned), "bytes", report.Bytes, "failed", len(report.Failed))
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// hashAlgorithms are the digests hashStream can compute, by name
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// hashStream reads r to the end and returns the lowercase hex digest for each
+// requested algorithm (sha256 when none is given) and the number of bytes read.
+// Every path that checksums artifacts goes through here so they all agree on
+// algorithm and encoding.
+func hashStream(r io.Reader, algos ...string) (map[string]string, int64, error) {
+	if len(algos) == 0 {
+		algos = []string{"sha256"}
+	}
+
+	hashes := make(map[string]hash.Hash, len(algos))
+	writers := make([]io.Writer, 0, len(algos))
+	for _, algo := range algos {
+		newHash, ok := hashAlgorithms[algo]
+		if !ok {
+			return nil, 0, fmt.Errorf("unsupported hash algorithm %q", algo)
+		}
+		if _, dup := hashes[algo]; dup {
+			continue
+		}
+		h := newHash()
+		hashes[algo] = h
+		writers = append(writers, h)
+	}
+
+	n, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return nil, n, err
+	}
+
+	sums := make(map[string]string, len(hashes))
+	for algo, h := range hashes {
+		sums[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, n, nil
+}
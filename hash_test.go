@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestHashStream(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<20) // 16 MiB
+	largeSHA256 := sha256.Sum256(large)
+	largeSHA512 := sha512.Sum512(large)
+
+	tests := []struct {
+		name    string
+		r       io.Reader
+		algos   []string
+		want    map[string]string
+		wantN   int64
+		wantErr bool
+	}{
+		{
+			name:  "empty defaults to sha256",
+			r:     strings.NewReader(""),
+			want:  map[string]string{"sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+			wantN: 0,
+		},
+		{
+			name:  "empty with every algorithm",
+			r:     strings.NewReader(""),
+			algos: []string{"md5", "sha1", "sha256", "sha512"},
+			want: map[string]string{
+				"md5":    "d41d8cd98f00b204e9800998ecf8427e",
+				"sha1":   "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+				"sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				"sha512": "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+			},
+		},
+		{
+			name:  "known answer",
+			r:     strings.NewReader("abc"),
+			algos: []string{"md5", "sha1", "sha256"},
+			want: map[string]string{
+				"md5":    "900150983cd24fb0d6963f7d28e17f72",
+				"sha1":   "a9993e364706816aba3e25717850c26c9cd0d89d",
+				"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			},
+			wantN: 3,
+		},
+		{
+			name:  "duplicate algorithm",
+			r:     strings.NewReader("abc"),
+			algos: []string{"sha256", "sha256"},
+			want:  map[string]string{"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+			wantN: 3,
+		},
+		{
+			name:  "large input",
+			r:     bytes.NewReader(large),
+			algos: []string{"sha256", "sha512"},
+			want: map[string]string{
+				"sha256": hex.EncodeToString(largeSHA256[:]),
+				"sha512": hex.EncodeToString(largeSHA512[:]),
+			},
+			wantN: int64(len(large)),
+		},
+		{
+			name:  "one byte at a time",
+			r:     iotest.OneByteReader(strings.NewReader("abc")),
+			want:  map[string]string{"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+			wantN: 3,
+		},
+		{name: "unsupported algorithm", r: strings.NewReader("abc"), algos: []string{"crc32"}, wantErr: true},
+		{name: "read error", r: iotest.ErrReader(errors.New("connection reset")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sums, n, err := hashStream(tt.r, tt.algos...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if n != tt.wantN {
+				t.Errorf("n = %d, want %d", n, tt.wantN)
+			}
+			if len(sums) != len(tt.want) {
+				t.Errorf("sums = %v, want %v", sums, tt.want)
+			}
+			for algo, want := range tt.want {
+				if sums[algo] != want {
+					t.Errorf("%s = %s, want %s", algo, sums[algo], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// livez only confirms the process is serving requests; it never touches a
+// backend, so a Firebase outage doesn't get healthy instances restarted
+func livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz checks that the database and storage bucket are reachable and
+// answers 503 with the status of each dependency when one is not, so the load
+// balancer takes the instance out of rotation
+func (s *Server) readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.ReadinessTimeout)
+	defer cancel()
+
+	// Ping runs in the background so a hung call can't outlive the timeout
+	var results map[string]error
+	done := make(chan struct{})
+	go func() {
+		results = s.store.Ping(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		loggerFrom(ctx).Warn("readiness check timed out", "timeout", config.ReadinessTimeout)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "Readiness check timed out"})
+		return
+	}
+
+	status := http.StatusOK
+	checks := gin.H{}
+	for name, err := range results {
+		if err != nil {
+			loggerFrom(ctx).Warn("dependency unavailable", "dependency", name, "err", err)
+			checks[name] = gin.H{"status": "unavailable", "error": err.Error()}
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		checks[name] = gin.H{"status": "ok"}
+	}
+	overall := "ok"
+	if status != http.StatusOK {
+		overall = "unavailable"
+	}
+	c.JSON(status, gin.H{"status": overall, "checks": checks})
+}
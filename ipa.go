@@ -0,0 +1,247 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ipaInfo is the subset of an app's Info.plist checked on upload
+type ipaInfo struct {
+	BundleID      string // CFBundleIdentifier
+	ShortVersion  string // CFBundleShortVersionString
+	BundleVersion string // CFBundleVersion
+}
+
+// maxPlistLen bounds how much of an archived Info.plist is read into memory
+const maxPlistLen = 4 << 20
+
+// readIPAInfo unzips Payload/<App>.app/Info.plist from an IPA and reads its
+// identifier and version keys. Both XML and binary plists are supported.
+func readIPAInfo(r io.ReaderAt, size int64) (*ipaInfo, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid IPA: %w", err)
+	}
+
+	var plist *zip.File
+	for _, f := range zr.File {
+		dir, name := path.Split(f.Name)
+		if name == "Info.plist" && strings.HasPrefix(dir, "Payload/") && strings.HasSuffix(dir, ".app/") &&
+			strings.Count(dir, "/") == 2 {
+			plist = f
+			break
+		}
+	}
+	if plist == nil {
+		return nil, errors.New("no Payload/*.app/Info.plist")
+	}
+
+	rc, err := plist.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Info.plist: %w", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxPlistLen))
+	if err != nil {
+		return nil, fmt.Errorf("Info.plist: %w", err)
+	}
+
+	var values map[string]string
+	if bytes.HasPrefix(data, []byte("bplist00")) {
+		values, err = parseBinaryPlist(data)
+	} else {
+		values, err = parseXMLPlist(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Info.plist: %w", err)
+	}
+	return &ipaInfo{
+		BundleID:      values["CFBundleIdentifier"],
+		ShortVersion:  values["CFBundleShortVersionString"],
+		BundleVersion: values["CFBundleVersion"],
+	}, nil
+}
+
+// parseXMLPlist returns the string and integer values of the top-level dict
+func parseXMLPlist(data []byte) (map[string]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	values := map[string]string{}
+	depth := 0
+	key := ""
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			if _, end := tok.(xml.EndElement); end {
+				depth--
+			}
+			continue
+		}
+		depth++
+		// <plist><dict> puts the top-level entries at depth 3
+		if depth != 3 {
+			continue
+		}
+		switch se.Name.Local {
+		case "key", "string", "integer":
+			var text string
+			if err := dec.DecodeElement(&text, &se); err != nil {
+				return nil, err
+			}
+			depth--
+			if se.Name.Local == "key" {
+				key = text
+			} else if key != "" {
+				values[key] = strings.TrimSpace(text)
+				key = ""
+			}
+		default:
+			key = ""
+		}
+	}
+}
+
+// parseBinaryPlist returns the string and integer values of the top-level
+// dict of a bplist00 document
+func parseBinaryPlist(data []byte) (map[string]string, error) {
+	if len(data) < 40 {
+		return nil, errors.New("binary plist is truncated")
+	}
+	trailer := data[len(data)-32:]
+	offsetSize := int(trailer[6])
+	refSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	topObject := binary.BigEndian.Uint64(trailer[16:])
+	tableOffset := binary.BigEndian.Uint64(trailer[24:])
+	// Compared by division so a crafted tableOffset or numObjects can't wrap
+	// the bound around
+	if offsetSize == 0 || offsetSize > 8 || refSize == 0 || refSize > 8 ||
+		tableOffset > uint64(len(data)) || numObjects > (uint64(len(data))-tableOffset)/uint64(offsetSize) {
+		return nil, errors.New("binary plist has an invalid trailer")
+	}
+
+	offset := func(ref uint64) (int, error) {
+		if ref >= numObjects {
+			return 0, errors.New("binary plist object reference out of range")
+		}
+		pos := tableOffset + ref*uint64(offsetSize)
+		off := readBigEndian(data[pos : pos+uint64(offsetSize)])
+		if off >= uint64(len(data)) {
+			return 0, errors.New("binary plist object offset out of range")
+		}
+		return int(off), nil
+	}
+
+	top, err := offset(topObject)
+	if err != nil {
+		return nil, err
+	}
+	if data[top]>>4 != 0xD {
+		return nil, errors.New("binary plist root is not a dict")
+	}
+	count, pos, err := bplistCount(data, top)
+	if err != nil {
+		return nil, err
+	}
+	if pos+2*count*refSize > len(data) {
+		return nil, errors.New("binary plist is truncated")
+	}
+
+	values := map[string]string{}
+	for i := 0; i < count; i++ {
+		keyRef := readBigEndian(data[pos+i*refSize : pos+(i+1)*refSize])
+		valRef := readBigEndian(data[pos+(count+i)*refSize : pos+(count+i+1)*refSize])
+		keyOff, err := offset(keyRef)
+		if err != nil {
+			return nil, err
+		}
+		valOff, err := offset(valRef)
+		if err != nil {
+			return nil, err
+		}
+		key, ok, err := bplistScalar(data, keyOff)
+		if err != nil || !ok {
+			continue
+		}
+		if val, ok, err := bplistScalar(data, valOff); err == nil && ok {
+			values[key] = val
+		}
+	}
+	return values, nil
+}
+
+// bplistCount decodes an object's length from its marker, returning it along
+// with the position of the object's payload
+func bplistCount(data []byte, off int) (int, int, error) {
+	n := int(data[off] & 0x0F)
+	pos := off + 1
+	if n != 0x0F {
+		return n, pos, nil
+	}
+	// The real length follows as an int object
+	if pos >= len(data) || data[pos]>>4 != 0x1 {
+		return 0, 0, errors.New("binary plist has an invalid length")
+	}
+	width := 1 << (data[pos] & 0x0F)
+	if width > 8 || pos+1+width > len(data) {
+		return 0, 0, errors.New("binary plist is truncated")
+	}
+	count := readBigEndian(data[pos+1 : pos+1+width])
+	if count > uint64(len(data)) {
+		return 0, 0, errors.New("binary plist is truncated")
+	}
+	return int(count), pos + 1 + width, nil
+}
+
+// bplistScalar renders string and integer objects as strings; other object
+// kinds report ok == false
+func bplistScalar(data []byte, off int) (string, bool, error) {
+	switch data[off] >> 4 {
+	case 0x1:
+		width := 1 << (data[off] & 0x0F)
+		if width > 8 || off+1+width > len(data) {
+			return "", false, errors.New("binary plist is truncated")
+		}
+		return strconv.FormatUint(readBigEndian(data[off+1:off+1+width]), 10), true, nil
+	case 0x5:
+		n, pos, err := bplistCount(data, off)
+		if err != nil || pos+n > len(data) {
+			return "", false, errors.New("binary plist is truncated")
+		}
+		return string(data[pos : pos+n]), true, nil
+	case 0x6:
+		n, pos, err := bplistCount(data, off)
+		if err != nil || pos+2*n > len(data) {
+			return "", false, errors.New("binary plist is truncated")
+		}
+		units := make([]uint16, n)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(data[pos+2*i:])
+		}
+		return string(utf16.Decode(units)), true, nil
+	}
+	return "", false, nil
+}
+
+func readBigEndian(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// buildBinaryPlist encodes a bplist00 document whose root dict maps keys to
+// ASCII strings shorter than 256 bytes
+func buildBinaryPlist(values map[string]string) []byte {
+	var keys, vals []string
+	for k, v := range values {
+		keys, vals = append(keys, k), append(vals, v)
+	}
+	n := len(keys)
+	data := []byte("bplist00")
+	offsets := []int{len(data)}
+	data = append(data, 0xD0|byte(n))
+	for i := 0; i < 2*n; i++ {
+		data = append(data, byte(1+i))
+	}
+	for _, s := range append(keys, vals...) {
+		offsets = append(offsets, len(data))
+		if len(s) < 0x0F {
+			data = append(data, 0x50|byte(len(s)))
+		} else {
+			data = append(data, 0x5F, 0x10, byte(len(s)))
+		}
+		data = append(data, s...)
+	}
+	table := len(data)
+	for _, off := range offsets {
+		data = append(data, byte(off))
+	}
+	trailer := make([]byte, 32)
+	trailer[6], trailer[7] = 1, 1
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(offsets)))
+	binary.BigEndian.PutUint64(trailer[24:], uint64(table))
+	return append(data, trailer...)
+}
+
+// buildIPA returns an IPA whose Info.plist declares the bundle id and versions
+func buildIPA(t *testing.T, bundleID, version string, versionCode int) []byte {
+	t.Helper()
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+<key>CFBundleIdentifier</key><string>%s</string>
+<key>CFBundleShortVersionString</key><string>%s</string>
+<key>CFBundleVersion</key><string>%d</string>
+</dict></plist>`, bundleID, version, versionCode)
+	return buildZip(t, map[string][]byte{"Payload/App.app/Info.plist": []byte(plist)})
+}
+
+func TestParseBinaryPlist(t *testing.T) {
+	valid := buildBinaryPlist(map[string]string{"CFBundleIdentifier": "com.example.app", "CFBundleVersion": "7"})
+	withTrailer := func(numObjects, tableOffset uint64) []byte {
+		data := bytes.Clone(valid)
+		trailer := data[len(data)-32:]
+		binary.BigEndian.PutUint64(trailer[8:], numObjects)
+		binary.BigEndian.PutUint64(trailer[24:], tableOffset)
+		return data
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "valid", data: valid, want: map[string]string{"CFBundleIdentifier": "com.example.app", "CFBundleVersion": "7"}},
+		{name: "table past end", data: withTrailer(5, uint64(len(valid))), wantErr: true},
+		{name: "table offset wraps", data: withTrailer(1, 1<<64-1), wantErr: true},
+		{name: "object count wraps", data: withTrailer(1<<63, 8), wantErr: true},
+		{name: "truncated", data: valid[:30], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBinaryPlist(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestIPAUploads(t *testing.T) {
+	tests := []struct {
+		name       string
+		resumable  bool
+		content    []byte
+		wantStatus int
+	}{
+		{name: "upload", content: buildIPA(t, "com.example.app", "1.0.7", 7), wantStatus: http.StatusOK},
+		{name: "upload version mismatch", content: buildIPA(t, "com.example.app", "1.0.6", 7), wantStatus: http.StatusBadRequest},
+		{name: "resumable", resumable: true, content: buildIPA(t, "com.example.app", "1.0.7", 7), wantStatus: http.StatusNoContent},
+		{name: "resumable code mismatch", resumable: true, content: buildIPA(t, "com.example.app", "1.0.7", 8), wantStatus: http.StatusBadRequest},
+		{name: "resumable without Info.plist", resumable: true, content: buildZip(t, map[string][]byte{"Payload/App.app/App": {}}), wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			fields := map[string]string{"version": "1.0.7", "version_code": "7", "platform": "ios"}
+			var status int
+			if tt.resumable {
+				fields["filename"] = "app.ipa"
+				status = ts.tusUpload(fields, tt.content).Code
+			} else {
+				status = ts.upload("/api/v1/ota/upload", fields, "app.ipa", tt.content, testAPIKey).Code
+			}
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if len(versions) != 0 {
+					t.Errorf("rejected upload published %d versions", len(versions))
+				}
+				return
+			}
+			for _, v := range versions {
+				if v.BundleID != "com.example.app" {
+					t.Errorf("bundle_id = %q", v.BundleID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetLatestVersion(t *testing.T) {
+	no := false
+	later := time.Now().Add(time.Hour)
+	tests := []struct {
+		name       string
+		versions   []AppVersion
+		query      string
+		wantStatus int
+		wantCode   string // error code
+		wantID     string
+	}{
+		{
+			name:       "highest code",
+			versions:   []AppVersion{{VersionCode: 1}, {VersionCode: 3}, {VersionCode: 2}},
+			query:      "platform=android",
+			wantStatus: http.StatusOK,
+			wantID:     "android-3",
+		},
+		{
+			name:       "skips disabled and scheduled",
+			versions:   []AppVersion{{VersionCode: 1}, {VersionCode: 2, Enabled: &no}, {VersionCode: 3, PublishAt: &later}},
+			query:      "platform=android",
+			wantStatus: http.StatusOK,
+			wantID:     "android-1",
+		},
+		{
+			name:       "other platforms and channels ignored",
+			versions:   []AppVersion{{VersionCode: 1}, {VersionCode: 2, Platform: "ios"}, {VersionCode: 3, Channel: "beta"}},
+			query:      "platform=android",
+			wantStatus: http.StatusOK,
+			wantID:     "android-1",
+		},
+		{
+			name:       "channel",
+			versions:   []AppVersion{{VersionCode: 1}, {VersionCode: 3, Channel: "beta"}},
+			query:      "platform=android&channel=beta",
+			wantStatus: http.StatusOK,
+			wantID:     "android-3",
+		},
+		{
+			name:       "none released",
+			versions:   []AppVersion{{VersionCode: 1, Enabled: &no}, {VersionCode: 2, Platform: "ios"}},
+			query:      "platform=android",
+			wantStatus: http.StatusNotFound,
+			wantCode:   codeNotFound,
+		},
+		{name: "missing platform", query: "", wantStatus: http.StatusBadRequest, wantCode: codeInvalidPlatform},
+		{name: "invalid platform", query: "platform=symbian", wantStatus: http.StatusBadRequest, wantCode: codeInvalidPlatform},
+		{name: "invalid channel", query: "platform=android&channel=nightly", wantStatus: http.StatusBadRequest, wantCode: codeInvalidChannel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, v := range tt.versions {
+				ts.seed(v)
+			}
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/versions/latest?"+tt.query, nil, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+				return
+			}
+			var v AppVersion
+			decodeJSON(t, w, &v)
+			if v.ID != tt.wantID || v.DownloadURL == "" || v.Status != statusPublished {
+				t.Errorf("latest = %+v, want %s", v, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestLatestVersionMatchesCheckUpdate(t *testing.T) {
+	ts := newTestServer(t)
+	for code := 1; code <= 5; code++ {
+		ts.seed(AppVersion{VersionCode: code})
+	}
+	if w := ts.do(http.MethodPost, "/api/v1/ota/versions/android-5/disable", nil, testAPIKey); w.Code != http.StatusOK {
+		t.Fatalf("disable: %d %s", w.Code, w.Body)
+	}
+
+	w := ts.do(http.MethodGet, "/api/v1/ota/versions/latest?platform=android", nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("latest: %d %s", w.Code, w.Body)
+	}
+	var latest AppVersion
+	decodeJSON(t, w, &latest)
+	resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1})
+	if !resp.UpdateAvailable || resp.LatestVersion.ID != latest.ID {
+		t.Errorf("check-update offers %+v, /versions/latest returns %s", resp.LatestVersion, latest.ID)
+	}
+}
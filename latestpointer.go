@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// LatestPointer is the latest/<platform> node: the enabled version with the
+// highest version code, whatever its channel, rollout or publish time. Readers
+// that find it offered to them need no other version, since nothing enabled
+// ranks above it.
+type LatestPointer struct {
+	ID          string `json:"id"`
+	VersionCode int    `json:"version_code"`
+}
+
+// highestEnabled returns the pointer a platform should have given versions,
+// or nil when none of them is enabled
+func highestEnabled(versions map[string]AppVersion, platform string) *LatestPointer {
+	var best *LatestPointer
+	for id, v := range versions {
+		if !matchesPlatform(v, platform) || !isEnabled(v) {
+			continue
+		}
+		if best == nil || v.VersionCode > best.VersionCode {
+			best = &LatestPointer{ID: id, VersionCode: v.VersionCode}
+		}
+	}
+	return best
+}
+
+// trackLatest keeps latest/<platform> in step after v was saved, enabled,
+// disabled or (removed) deleted. A version that is now enabled replaces a
+// lower pointer; one that is gone or disabled, when it is the pointer, gives
+// way to the next highest enabled version. Both are transactions on the
+// pointer, so a concurrent upload is never overwritten. Failures are only
+// logged: readers check what the pointer names and fall back to the full list.
+func (s *Server) trackLatest(ctx context.Context, v AppVersion, removed bool) {
+	platform := platformOf(v)
+	if platform == "" {
+		return
+	}
+	var err error
+	if !removed && isEnabled(v) {
+		err = s.store.UpdateLatestPointer(ctx, platform, func(current *LatestPointer) *LatestPointer {
+			if current != nil && current.ID != v.ID && current.VersionCode >= v.VersionCode {
+				return current
+			}
+			return &LatestPointer{ID: v.ID, VersionCode: v.VersionCode}
+		})
+	} else {
+		err = s.retractLatest(ctx, platform, v.ID)
+	}
+	if err != nil {
+		loggerFrom(ctx).Warn("updating latest pointer failed", "platform", platform, "version_id", v.ID, "err", err)
+	}
+}
+
+// retractLatest moves the pointer off id, if it is there, to the highest
+// enabled version left
+func (s *Server) retractLatest(ctx context.Context, platform, id string) error {
+	current, err := s.store.GetLatestPointer(ctx, platform)
+	if err != nil || current == nil || current.ID != id {
+		return err
+	}
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		return err
+	}
+	delete(versions, id)
+	next := highestEnabled(versions, platform)
+	return s.store.UpdateLatestPointer(ctx, platform, func(current *LatestPointer) *LatestPointer {
+		if current == nil || current.ID != id {
+			// Moved on meanwhile, e.g. by an upload
+			return current
+		}
+		return next
+	})
+}
+
+// latestWindow reads the version latest/<platform> names, for lookups that
+// can be answered from it alone. It is empty when the pointer is missing,
+// unreadable or names a version that is gone or disabled.
+func (s *Server) latestWindow(ctx context.Context, platform string) map[string]AppVersion {
+	pointer, err := s.store.GetLatestPointer(ctx, platform)
+	if err != nil {
+		if !errors.Is(err, errIndexNotReady) {
+			loggerFrom(ctx).Warn("reading latest pointer failed", "platform", platform, "err", err)
+		}
+		return nil
+	}
+	if pointer == nil {
+		return nil
+	}
+	v, err := s.store.GetVersion(ctx, pointer.ID)
+	if err != nil {
+		loggerFrom(ctx).Warn("reading latest version failed", "platform", platform, "version_id", pointer.ID, "err", err)
+		return nil
+	}
+	if v == nil || !isEnabled(*v) || !matchesPlatform(*v, platform) {
+		return nil
+	}
+	return map[string]AppVersion{v.ID: *v}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLatestPointer(t *testing.T) {
+	ts := newTestServer(t)
+	post := func(target string, body any) func() {
+		return func() {
+			if w := ts.do(http.MethodPost, target, body, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("POST %s: %d %s", target, w.Code, w.Body)
+			}
+		}
+	}
+	del := func(id string) func() {
+		return func() {
+			if w := ts.do(http.MethodDelete, "/api/v1/ota/versions/"+id, nil, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("delete %s: %d %s", id, w.Code, w.Body)
+			}
+		}
+	}
+	seed := func(v AppVersion) func() { return func() { ts.seed(v) } }
+
+	steps := []struct {
+		name        string
+		do          func()
+		wantAndroid string // pointer id, "" for none
+		wantIOS     string
+	}{
+		{"first upload", seed(AppVersion{VersionCode: 1}), "android-1", ""},
+		{"higher upload", seed(AppVersion{VersionCode: 3}), "android-3", ""},
+		{"lower upload", seed(AppVersion{VersionCode: 2}), "android-3", ""},
+		{"beta build counts", seed(AppVersion{VersionCode: 4, Channel: "beta"}), "android-4", ""},
+		{"other platform", seed(AppVersion{VersionCode: 5, Platform: "ios"}), "android-4", "ios-5"},
+		{"disable latest", post("/api/v1/ota/versions/android-4/disable", nil), "android-3", "ios-5"},
+		{"disable below latest", post("/api/v1/ota/versions/android-1/disable", nil), "android-3", "ios-5"},
+		{"re-enable", post("/api/v1/ota/versions/android-4/enable", nil), "android-4", "ios-5"},
+		{"delete latest", del("android-4"), "android-3", "ios-5"},
+		{"rollback", post("/api/v1/ota/rollback", gin.H{"version_id": "android-2"}), "android-2", "ios-5"},
+		{"delete the last enabled", del("android-2"), "", "ios-5"},
+		{"delete other platform", del("ios-5"), "", ""},
+	}
+	for _, st := range steps {
+		st.do()
+		for platform, want := range map[string]string{"android": st.wantAndroid, "ios": st.wantIOS} {
+			pointer, err := ts.store.GetLatestPointer(context.Background(), platform)
+			if err != nil {
+				t.Fatalf("%s: %v", st.name, err)
+			}
+			got := ""
+			if pointer != nil {
+				got = pointer.ID
+			}
+			if got != want {
+				t.Errorf("%s: latest/%s = %q, want %q", st.name, platform, got, want)
+			}
+		}
+	}
+}
+
+func TestLatestWindow(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name    string
+		pointer *LatestPointer
+		edit    func(v *AppVersion) // applied to android-2 behind the pointer's back
+		wantID  string
+	}{
+		{name: "pointer", pointer: &LatestPointer{ID: "android-2", VersionCode: 2}, wantID: "android-2"},
+		{name: "no pointer", wantID: ""},
+		{name: "pointer to a missing version", pointer: &LatestPointer{ID: "android-9", VersionCode: 9}, wantID: ""},
+		{name: "pointer to a disabled version", pointer: &LatestPointer{ID: "android-2", VersionCode: 2}, edit: func(v *AppVersion) { v.Enabled = &disabled }, wantID: ""},
+		{name: "pointer to another platform", pointer: &LatestPointer{ID: "ios-3", VersionCode: 3}, wantID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2})
+			ts.seed(AppVersion{VersionCode: 3, Platform: "ios"})
+			ts.store.UpdateLatestPointer(ctx, "android", func(*LatestPointer) *LatestPointer { return tt.pointer })
+			if tt.edit != nil {
+				v, _ := ts.store.GetVersion(ctx, "android-2")
+				tt.edit(v)
+				ts.store.PutVersion(ctx, *v)
+			}
+
+			window := ts.latestWindow(ctx, "android")
+			if tt.wantID == "" {
+				if len(window) != 0 {
+					t.Errorf("window = %v, want empty", window)
+				}
+				return
+			}
+			if _, ok := window[tt.wantID]; !ok || len(window) != 1 {
+				t.Errorf("window = %v, want %s", window, tt.wantID)
+			}
+		})
+	}
+}
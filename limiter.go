@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concurrencyLimiter caps the number of requests handled at once. Requests
+// over the cap wait in a bounded queue for up to timeout; anything beyond
+// that is shed with a 503 so a small instance degrades instead of running
+// out of memory or Firebase quota.
+type concurrencyLimiter struct {
+	slots    chan struct{}
+	maxQueue int64
+	timeout  time.Duration
+	inFlight atomic.Int64
+	queued   atomic.Int64
+}
+
+// requestLimiter is the active limiter, nil when MAX_INFLIGHT_REQUESTS is unset
+var requestLimiter *concurrencyLimiter
+
+func newConcurrencyLimiter(maxInFlight, maxQueue int, timeout time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:    make(chan struct{}, maxInFlight),
+		maxQueue: int64(maxQueue),
+		timeout:  timeout,
+	}
+}
+
+// InFlight returns the number of requests currently being handled
+func (l *concurrencyLimiter) InFlight() int64 {
+	return l.inFlight.Load()
+}
+
+// Queued returns the number of requests waiting for a slot
+func (l *concurrencyLimiter) Queued() int64 {
+	return l.queued.Load()
+}
+
+// middleware applies the limit to every route except the liveness checks
+func (l *concurrencyLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if path := c.Request.URL.Path; path == "/health" || path == "/livez" {
+			c.Next()
+			return
+		}
+
+		if !l.acquire(c) {
+			return
+		}
+		l.inFlight.Add(1)
+		defer func() {
+			l.inFlight.Add(-1)
+			<-l.slots
+		}()
+
+		c.Next()
+	}
+}
+
+// acquire takes a slot, queueing if allowed. It writes the response and
+// returns false when the request is shed.
+func (l *concurrencyLimiter) acquire(c *gin.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		l.shed(c)
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		l.shed(c)
+		return false
+	case <-c.Request.Context().Done():
+		c.Abort()
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) shed(c *gin.Context) {
+	retryAfter := int(math.Ceil(l.timeout.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	respondError(c, http.StatusServiceUnavailable, codeServerBusy, "Server is busy, please retry later")
+}
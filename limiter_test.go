@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxQueue   int
+		timeout    time.Duration
+		extra      int   // requests sent while the only slot is taken
+		wantStatus []int // of the extra requests, once the slot frees up
+	}{
+		{name: "no queue sheds at once", timeout: time.Minute, extra: 2, wantStatus: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}},
+		{name: "queue holds one", maxQueue: 1, timeout: time.Minute, extra: 2, wantStatus: []int{http.StatusOK, http.StatusServiceUnavailable}},
+		{name: "queue holds both", maxQueue: 2, timeout: time.Minute, extra: 2, wantStatus: []int{http.StatusOK, http.StatusOK}},
+		{name: "queued too long", maxQueue: 1, timeout: 10 * time.Millisecond, extra: 1, wantStatus: []int{http.StatusServiceUnavailable}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newConcurrencyLimiter(1, tt.maxQueue, tt.timeout)
+			requestLimiter = l
+			t.Cleanup(func() { requestLimiter = nil })
+			release := make(chan struct{})
+			r := gin.New()
+			r.Use(l.middleware())
+			r.GET("/work", func(c *gin.Context) {
+				<-release
+				c.Status(http.StatusOK)
+			})
+			r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			var wg sync.WaitGroup
+			send := func(w *httptest.ResponseRecorder) <-chan struct{} {
+				done := make(chan struct{})
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer close(done)
+					r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/work", nil))
+				}()
+				return done
+			}
+			first := httptest.NewRecorder()
+			send(first)
+			waitFor(t, func() bool { return l.InFlight() == 1 })
+
+			extra := make([]*httptest.ResponseRecorder, tt.extra)
+			for i := range extra {
+				extra[i] = httptest.NewRecorder()
+				queued := l.Queued()
+				done := send(extra[i])
+				// A request to be shed is answered before the slot frees up;
+				// any other waits in the queue
+				waitFor(t, func() bool {
+					select {
+					case <-done:
+						return true
+					default:
+						return tt.wantStatus[i] == http.StatusOK && l.Queued() > queued
+					}
+				})
+			}
+
+			// Liveness checks bypass the limit
+			health := httptest.NewRecorder()
+			r.ServeHTTP(health, httptest.NewRequest(http.MethodGet, "/health", nil))
+			if health.Code != http.StatusOK {
+				t.Errorf("/health = %d while saturated", health.Code)
+			}
+
+			wantQueued := 0
+			for _, status := range tt.wantStatus {
+				if status == http.StatusOK {
+					wantQueued++
+				}
+			}
+			if got := gaugeValue(t, "ota_requests_in_flight"); got != 1 {
+				t.Errorf("ota_requests_in_flight = %v, want 1", got)
+			}
+			if got := gaugeValue(t, "ota_requests_queued"); got != float64(wantQueued) {
+				t.Errorf("ota_requests_queued = %v, want %d", got, wantQueued)
+			}
+
+			close(release)
+			wg.Wait()
+			if first.Code != http.StatusOK {
+				t.Errorf("first request = %d", first.Code)
+			}
+			for i, w := range extra {
+				if w.Code != tt.wantStatus[i] {
+					t.Errorf("request %d = %d, want %d", i, w.Code, tt.wantStatus[i])
+				}
+				if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d shed without Retry-After", i)
+				}
+			}
+			if l.InFlight() != 0 || l.Queued() != 0 {
+				t.Errorf("in flight %d, queued %d after draining", l.InFlight(), l.Queued())
+			}
+		})
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// gaugeValue reads an unlabelled gauge from the default registry
+func gaugeValue(t *testing.T, name string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.GetMetric()) == 1 {
+			return f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("no gauge %s", name)
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// localizedNotesField prefixes the upload form fields carrying release notes
+// for one locale, e.g. release_notes_es or release_notes_pt_BR
+const localizedNotesField = "release_notes_"
+
+// localePattern accepts normalized BCP 47 style tags such as "es" or "pt-br"
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// normalizeLocale lowercases a locale and uses "-" as separator, so "pt_BR",
+// "pt-BR" and "pt-br" are the same key
+func normalizeLocale(locale string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+}
+
+// parseLocalizedNotes collects the release_notes_<locale> fields of an
+// upload form, returning nil when there are none
+func parseLocalizedNotes(form map[string][]string) (map[string]string, error) {
+	var notes map[string]string
+	for field, values := range form {
+		raw, ok := strings.CutPrefix(field, localizedNotesField)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		locale := normalizeLocale(raw)
+		if !localePattern.MatchString(locale) {
+			return nil, fmt.Errorf("invalid locale %q in %s", raw, field)
+		}
+		text := strings.TrimSpace(values[0])
+		if text == "" {
+			continue
+		}
+		if notes == nil {
+			notes = map[string]string{}
+		}
+		notes[locale] = text
+	}
+	return notes, nil
+}
+
+// notesFor picks the release notes for locale: the exact locale, then its
+// language ("es" for "es-mx"), then DEFAULT_LOCALE, then the plain
+// release_notes every version has
+func notesFor(v AppVersion, locale string) string {
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if lang, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, lang)
+	}
+	candidates = append(candidates, config.DefaultLocale)
+	for _, candidate := range candidates {
+		if text, ok := v.LocalizedReleaseNotes[candidate]; ok && candidate != "" {
+			return text
+		}
+	}
+	return v.ReleaseNotes
+}
+
+// localize replaces a version's release notes with those for locale; without
+// a locale the version is left as stored
+func localize(v *AppVersion, locale string) {
+	if v == nil || strings.TrimSpace(locale) == "" {
+		return
+	}
+	v.ReleaseNotes = notesFor(*v, locale)
+}
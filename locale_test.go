@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestNotesFor(t *testing.T) {
+	v := AppVersion{
+		ReleaseNotes:          "plain",
+		LocalizedReleaseNotes: map[string]string{"en": "english", "es": "spanish", "pt-br": "brazilian"},
+	}
+	tests := []struct {
+		name   string
+		env    []string
+		v      AppVersion
+		locale string
+		want   string
+	}{
+		{name: "exact locale", v: v, locale: "es", want: "spanish"},
+		{name: "region normalized", v: v, locale: "pt_BR", want: "brazilian"},
+		{name: "region falls back to language", v: v, locale: "es-MX", want: "spanish"},
+		{name: "missing locale falls back to default", v: v, locale: "fr", want: "english"},
+		{name: "configured default", env: []string{"DEFAULT_LOCALE=es"}, v: v, locale: "fr", want: "spanish"},
+		{name: "no default note falls back to plain", env: []string{"DEFAULT_LOCALE=de"}, v: v, locale: "fr", want: "plain"},
+		{name: "version without localized notes", v: AppVersion{ReleaseNotes: "plain"}, locale: "es", want: "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, tt.env...)
+			if got := notesFor(tt.v, tt.locale); got != tt.want {
+				t.Errorf("notesFor(%q) = %q, want %q", tt.locale, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadLocalizedNotes(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]string
+		wantStatus int
+		wantNotes  map[string]string
+	}{
+		{name: "plain notes only", fields: map[string]string{"release_notes": "plain"}, wantStatus: http.StatusOK},
+		{
+			name:       "localized fields",
+			fields:     map[string]string{"release_notes": "plain", "release_notes_es": "spanish", "release_notes_pt_BR": "brazilian", "release_notes_fr": " "},
+			wantStatus: http.StatusOK,
+			wantNotes:  map[string]string{"es": "spanish", "pt-br": "brazilian"},
+		},
+		{name: "invalid locale", fields: map[string]string{"release_notes_not a locale": "x"}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			fields := map[string]string{"version": "1.0.1", "version_code": "1", "platform": "android"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != codeInvalidRequest {
+					t.Errorf("code = %q, want %q", code, codeInvalidRequest)
+				}
+				return
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil || len(versions) != 1 {
+				t.Fatalf("versions = %v, %v", versions, err)
+			}
+			for _, v := range versions {
+				if v.ReleaseNotes != "plain" {
+					t.Errorf("release_notes = %q", v.ReleaseNotes)
+				}
+				if len(v.LocalizedReleaseNotes) != len(tt.wantNotes) {
+					t.Errorf("localized_release_notes = %v, want %v", v.LocalizedReleaseNotes, tt.wantNotes)
+				}
+				for locale, want := range tt.wantNotes {
+					if got := v.LocalizedReleaseNotes[locale]; got != want {
+						t.Errorf("localized_release_notes[%s] = %q, want %q", locale, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLocalizedNotesEndpoints(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "", want: "plain"},
+		{locale: "es", want: "spanish"},
+		{locale: "es-AR", want: "spanish"},
+		{locale: "ja", want: "english"},
+	}
+	for _, tt := range tests {
+		t.Run("locale "+tt.locale, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{
+				VersionCode:           2,
+				ReleaseNotes:          "plain",
+				LocalizedReleaseNotes: map[string]string{"en": "english", "es": "spanish"},
+			})
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1, Locale: tt.locale})
+			if !resp.UpdateAvailable || resp.LatestVersion.ReleaseNotes != tt.want {
+				t.Errorf("check-update release_notes = %+v, want %q", resp.LatestVersion, tt.want)
+			}
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/versions?platform=android&locale="+tt.locale, nil, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("versions: %d %s", w.Code, w.Body)
+			}
+			var versions []AppVersion
+			decodeJSON(t, w, &versions)
+			if len(versions) != 2 {
+				t.Fatalf("got %d versions", len(versions))
+			}
+			for _, v := range versions {
+				if v.VersionCode == 2 && v.ReleaseNotes != tt.want {
+					t.Errorf("versions release_notes = %q, want %q", v.ReleaseNotes, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the request id in and out; an incoming id from a
+// load balancer or client is kept so logs correlate across services
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds how much of an incoming id is trusted
+const maxRequestIDLen = 128
+
+type loggerKey struct{}
+
+// initLogging installs the process-wide slog handler; LOG_FORMAT=json emits
+// one JSON object per line, as Cloud Logging expects. Plain log calls are
+// routed through the same handler.
+func initLogging() {
+	var handler slog.Handler
+	if os.Getenv("LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, nil)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// requestLogging assigns every request an id, echoes it in the response and
+// attaches a logger carrying it to the request context. Once the request is
+// done it logs a summary line with status and latency.
+func requestLogging(c *gin.Context) {
+	start := time.Now()
+
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = uuid.NewString()
+	}
+	c.Header(requestIDHeader, id)
+
+	logger := slog.Default().With(
+		"request_id", id,
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+	)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), loggerKey{}, logger))
+
+	c.Next()
+
+	logger.Info("request",
+		"status", c.Writer.Status(),
+		"latency_ms", time.Since(start).Milliseconds(),
+		"client_ip", clientIP(c),
+	)
+}
+
+// validRequestID accepts printable ASCII ids of reasonable length, so a
+// client can't inject line breaks or megabytes into every log line
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// loggerFrom returns the request-scoped logger carried by ctx, or the
+// default logger outside a request
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// requestContext returns a context carrying the request's logger but not its
+// cancellation, so backend writes complete even if the client goes away
+func requestContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
@@ -1,18 +1,30 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/api/iterator"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -35,19 +47,84 @@ type AppVersion struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	StoragePath  string    `json:"storage_path"` // Path in Firebase Storage
+	Platform     string    `json:"platform"`     // a supportedPlatforms name; derived from StoragePath for older records
+	// DistributionLinks holds alternative distribution descriptors, e.g. a magnet link
+	DistributionLinks map[string]string `json:"distribution_links,omitempty"`
+	// IsMandatory is set by the uploader; nil means the check-update heuristic decides
+	IsMandatory *bool `json:"is_mandatory,omitempty"`
+	// Channel is "stable", "beta" or "alpha"; older records without one are stable
+	Channel string `json:"channel"`
+	// RolloutPercentage limits the version to a share of devices (0-100); nil means everyone
+	RolloutPercentage *int `json:"rollout_percentage,omitempty"`
+	// RolloutPaused freezes RolloutPercentage, e.g. during an incident, until resumed
+	RolloutPaused bool `json:"rollout_paused,omitempty"`
+	// DownloadCount counts complete downloads, maintained by Store.IncrementDownloadCount
+	DownloadCount int64 `json:"download_count"`
+	// PackageName is the Android application id read from the APK manifest
+	PackageName string `json:"package_name,omitempty"`
+	// BundleID is the iOS CFBundleIdentifier read from the IPA's Info.plist
+	BundleID string `json:"bundle_id,omitempty"`
+	// Enabled false withholds the version from clients, e.g. after a rollback; nil means enabled
+	Enabled *bool `json:"enabled,omitempty"`
+	// MinOSVersion is the lowest OS version (e.g. "8" or "14.2") the build installs on; empty means any
+	MinOSVersion string `json:"min_os_version,omitempty"`
+	// Patches are binary diffs from earlier builds to this one, served by /patch
+	Patches []PatchInfo `json:"patches,omitempty"`
+	// LocalizedReleaseNotes maps a normalized locale ("es", "pt-br") to its
+	// release notes; ReleaseNotes stays the default text
+	LocalizedReleaseNotes map[string]string `json:"localized_release_notes,omitempty"`
+	// PublishAt schedules the release: until then clients are not offered it
+	// and cannot download it. nil means published on upload.
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	// Status is computed for listings ("published", "scheduled", "disabled", "trashed") and never stored
+	Status string `json:"status,omitempty"`
+	// Artifacts are the release's files, one per ABI for split builds. StoragePath,
+	// FileSize and Checksum describe the first; records from before it have none.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// DeletedAt is set while the version is in the trash (trash/<id>), from
+	// where it can be restored until TRASH_RETENTION has passed
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type UpdateCheckRequest struct {
 	CurrentVersion string `json:"current_version" binding:"required"`
 	CurrentCode    int    `json:"current_code" binding:"required"`
 	Platform       string `json:"platform" binding:"required"`
+	// IncludePrevious asks for the build preceding the latest to be returned as well
+	IncludePrevious bool `json:"include_previous"`
+	// CompareMode is "version_code" (default) or "semver" to order builds by their version strings
+	CompareMode string `json:"compare_mode"`
+	// Channel the client follows (default stable); stable builds are always offered too
+	Channel string `json:"channel"`
+	// DeviceID places the device in staged rollouts; without it only fully rolled-out versions are offered
+	DeviceID string `json:"device_id"`
+	// OSVersion is the device's OS version; versions requiring a newer OS are not offered
+	OSVersion string `json:"os_version"`
+	// Locale selects localized release notes, e.g. "es" or "pt-BR"
+	Locale string `json:"locale"`
+	// ABI selects the split build download URLs point to, e.g. "arm64-v8a"
+	ABI string `json:"abi"`
+	// IncludeSignedURL asks for a signed Storage URL of the latest version when an update is available
+	IncludeSignedURL bool `json:"include_signed_url"`
 }
 
 type UpdateCheckResponse struct {
 	UpdateAvailable bool        `json:"update_available"`
 	IsMandatory     bool        `json:"is_mandatory,omitempty"`
 	LatestVersion   *AppVersion `json:"latest_version,omitempty"`
+	PreviousVersion *AppVersion `json:"previous_version,omitempty"`
 	ChangeLog       string      `json:"change_log,omitempty"`
+	// DownloadURL is the latest version's download URL made absolute
+	DownloadURL string `json:"download_url,omitempty"`
+	// SignedURL downloads the latest version straight from Storage until SignedURLExpiresAt
+	SignedURL          string `json:"signed_url,omitempty"`
+	SignedURLExpiresAt string `json:"signed_url_expires_at,omitempty"`
+	// MinSupportedCode is the platform's floor; clients below it must update
+	MinSupportedCode int `json:"min_supported_code,omitempty"`
+	// Paused is set when updates for the platform are paused by an admin
+	Paused bool `json:"paused,omitempty"`
+	// Stale is set when the answer comes from the cache during a database outage
+	Stale bool `json:"stale,omitempty"`
 }
 
 var (
@@ -56,63 +133,278 @@ var (
 	ctx           = context.Background()
 )
 
+// Server holds what the HTTP handlers share; they reach the database and
+// object storage only through its Store. With several apps configured there
+// is one Server per app, each scoped to apps/<app_id>/.
+type Server struct {
+	appID string // "" without tenants
+	store Store
+	// bucket is the Firebase Storage bucket, resolved once at startup, for
+	// what the Store doesn't cover (signed URLs); nil with the memory store
+	bucket *storage.BucketHandle
+	// prefix namespaces the app's storage objects and database nodes
+	prefix string
+	latest *staleLatestCache
+	stats  *statsCache
+}
+
+func newServer(appID string, store Store, bucket *storage.BucketHandle) *Server {
+	return &Server{
+		appID:  appID,
+		store:  store,
+		bucket: bucket,
+		prefix: appScope(appID),
+		latest: newStaleLatestCache(),
+		stats:  &statsCache{},
+	}
+}
+
+// parseOptionalBool parses a boolean form value, returning nil when it is blank
+func parseOptionalBool(s string) (*bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
 func main() {
 	err := godotenv.Load()
 	if err != nil {
 		log.Println("Warning: Could not load .env file (proceeding with system env vars)")
 	}
+	initLogging()
+
+	// Validate every setting before connecting to anything
+	config, err = loadConfig()
+	if err != nil {
+		var problems ConfigErrors
+		if !errors.As(err, &problems) {
+			log.Fatal(err)
+		}
+		for _, p := range problems {
+			slog.Error("invalid configuration", "var", p.Var, "problem", p.Problem, "hint", p.Hint)
+		}
+		os.Exit(1)
+	}
 
-	// Initialize Firebase
-	initFirebase()
+	// Optional upload filename convention
+	if config.FilenamePattern != nil {
+		log.Printf("Enforcing upload filename convention: %s", config.FilenamePattern)
+	}
 
-	// Initialize Gin router
-	r := gin.Default()
+	// API keys for write endpoints
+	if len(config.APIKeys) == 0 {
+		log.Println("Warning: OTA_API_KEYS not set; all write endpoints will reject requests")
+	}
 
-	// Configure CORS
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
-	r.Use(cors.New(config))
+	// Optional release channel announcements
+	webhook = newWebhookNotifier(config.WebhookURL, config.WebhookKind)
+	if webhook != nil {
+		log.Printf("Posting release events to a %s webhook", config.WebhookKind)
+	}
 
-	// OTA API routes
-	api := r.Group("/api/v1/ota")
-	{
-		api.POST("/check-update", checkForUpdate)
-		api.GET("/download/:version", downloadUpdate)
-		api.POST("/upload", uploadUpdate)
-		api.GET("/versions", getVersions)
-		api.DELETE("/versions/:id", deleteVersion)
+	// Choose the backing store; memory is for local development only
+	var newStore func(appID string) Store
+	var bucket *storage.BucketHandle
+	switch config.Store {
+	case "firebase":
+		initFirebase()
+		root := newFirebaseStore(firebaseDB, storageClient, config.StorageBucket)
+		bucket = root.bucket
+		newStore = func(appID string) Store {
+			store := root.forApp(appID)
+			// Records from before versionIndex/ get their entries; until then
+			// check-update reads every version
+			go func() {
+				if err := store.buildVersionIndex(ctx); err != nil {
+					log.Printf("Warning: building the version index failed (app %q): %v; check-update reads every version", appID, err)
+				}
+			}()
+			return store
+		}
+	case "memory":
+		log.Println("Warning: OTA_STORE=memory; versions and files are lost on restart")
+		if config.PushNotifications {
+			log.Println("Warning: PUSH_NOTIFICATIONS needs Firebase; no push notifications will be sent")
+		}
+		newStore = func(string) Store { return newMemoryStore() }
 	}
 
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	// One Server per app, each confined to its own apps/<app_id>/ namespace
+	apps := newTenants(config.Apps, func(appID string) *Server {
+		return newServer(appID, instrumentedStore{Store: newStore(appID), app: appID}, bucket)
 	})
+	if len(config.Apps) > 0 {
+		log.Printf("Serving apps: %s", strings.Join(config.Apps, ", "))
+	}
+	if config.TrashRetention > 0 {
+		go runTrashPurge(ctx, apps.all())
+	}
+
+	r := newRouter(apps)
 
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	port := config.Port
+	srv := &http.Server{
+		Addr:    "0.0.0.0:" + port,
+		Handler: r,
+	}
+	go func() {
+		log.Printf("Starting Flutter OTA Update Server on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGINT/SIGTERM (e.g. a Cloud Run deploy) stop accepting connections
+	// and give in-flight requests, uploads in particular, time to finish
+	stop, cancelStop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancelStop()
+	<-stop.Done()
+
+	grace := config.ShutdownGracePeriod
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", grace)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), grace)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown incomplete: %v", err)
 	}
 
-	log.Printf("Starting Flutter OTA Update Server on port %s", port)
-	log.Fatal(r.Run("0.0.0.0:" + port))
+	if storageClient != nil {
+		if err := storageClient.Close(); err != nil {
+			log.Printf("Storage client close error: %v", err)
+		}
+	}
+	log.Println("Server stopped")
 }
 
-func initFirebase() {
-	credsJSON := os.Getenv("FIREBASE_CREDENTIALS_JSON")
-	if credsJSON == "" {
-		log.Fatal("FIREBASE_CREDENTIALS_JSON environment variable not set")
+// newRouter sets up the middleware and routes, serving apps
+func newRouter(apps *tenants) *gin.Engine {
+	// Initialize Gin router; requestLogging replaces gin's access log
+	r := gin.New()
+	// X-Forwarded-For is read by clientIP alone, counting trusted hops from
+	// the right; gin would otherwise take the client-supplied leftmost entry
+	if err := r.SetTrustedProxies(nil); err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	r.Use(gin.Recovery(), requestLogging, requestMetrics)
+
+	// Configure CORS for the configured origins only; without any, browsers
+	// are limited to same-origin requests
+	corsConfig := cors.DefaultConfig()
+	if len(config.CORSAllowedOrigins) == 1 && config.CORSAllowedOrigins[0] == "*" {
+		log.Println("Warning: CORS_ALLOWED_ORIGINS=*; any website can call the API from a browser")
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = config.CORSAllowedOrigins
+		corsConfig.AllowWildcard = true
+	}
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "HEAD", "DELETE", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-API-Key",
+		"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "If-Match", requestIDHeader}
+	corsConfig.ExposeHeaders = []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension",
+		"Upload-Offset", "Upload-Length", "Upload-Expires", "X-Version-ID", "X-Duplicate", "X-Patch-Checksum", "X-Target-Checksum",
+		"ETag", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", requestIDHeader}
+	if len(config.CORSAllowedOrigins) > 0 {
+		r.Use(cors.New(corsConfig))
+	} else {
+		log.Println("CORS_ALLOWED_ORIGINS not set; cross-origin browser requests are refused")
+	}
+
+	// Gzip large JSON bodies such as version lists; downloads stay as stored
+	r.Use(compressJSON(config.CompressMinBytes))
+
+	// Optional global concurrency limit
+	if config.MaxInFlightRequests > 0 {
+		requestLimiter = newConcurrencyLimiter(
+			config.MaxInFlightRequests,
+			config.MaxQueuedRequests,
+			config.QueueTimeout,
+		)
+		r.Use(requestLimiter.middleware())
+	}
+
+	// Per-IP rate limits; check-update is polled, so it gets the looser one
+	checkUpdateLimit := newIPRateLimiter(config.CheckUpdateRateLimit, config.CheckUpdateBurst).middleware()
+	uploadLimit := newIPRateLimiter(config.UploadRateLimit, config.UploadBurst).middleware()
+
+	// OTA API routes
+	api := r.Group("/api/v1/ota")
+	{
+		api.POST("/check-update", checkUpdateLimit, apps.handle((*Server).checkForUpdate))
+		api.GET("/download/:version", apps.handle((*Server).downloadUpdate))
+		api.HEAD("/download/:version", apps.handle((*Server).downloadUpdate))
+		api.GET("/download-url/:version", apps.handle((*Server).getDownloadURL))
+		api.GET("/versions", apps.handle((*Server).getVersions))
+		api.GET("/versions/latest", apps.handle((*Server).getLatestVersion))
+		api.GET("/versions/:id", apps.handle((*Server).getVersionByID))
+		api.GET("/whatsnew", apps.handle((*Server).getWhatsNew))
+		api.GET("/review", apps.handle((*Server).reviewBuilds))
+		api.GET("/patch", apps.handle((*Server).downloadPatch))
+		api.GET("/stats", apps.handle((*Server).getStats))
+		api.GET("/openapi.json", serveOpenAPI)
 	}
 
-	projectID := os.Getenv("FIREBASE_PROJECT_ID")
-	if projectID == "" {
-		log.Fatal("FIREBASE_PROJECT_ID environment variable not set")
+	// API reference (Swagger UI over openapi.json)
+	r.GET("/docs", serveDocs)
+
+	// Write and admin routes require an API key
+	admin := api.Group("", requireAPIKey)
+	{
+		admin.POST("/upload", uploadLimit, apps.handle((*Server).uploadUpdate))
+		admin.POST("/patches", uploadLimit, apps.handle((*Server).uploadPatch))
+
+		// Resumable uploads (tus protocol) stage each chunk as its own object;
+		// /upload/sessions is an alias for clients not using a tus library
+		for _, path := range []string{"/uploads", "/upload/sessions"} {
+			admin.POST(path, uploadLimit, apps.handle((*Server).createUploadSession))
+			admin.HEAD(path+"/:id", apps.handle((*Server).headUploadSession))
+			admin.PATCH(path+"/:id", apps.handle((*Server).patchUploadSession))
+			api.OPTIONS(path, tusOptions)
+		}
+
+		admin.PUT("/versions/:id", apps.handle((*Server).updateVersion))
+		admin.DELETE("/versions/:id", apps.handle((*Server).deleteVersion))
+		admin.POST("/versions/:id/restore", apps.handle((*Server).restoreVersion))
+		admin.POST("/versions/delete-batch", apps.handle((*Server).deleteVersionsBatch))
+		admin.POST("/versions/:id/disable", apps.handle(func(s *Server, c *gin.Context) { s.setVersionEnabled(false)(c) }))
+		admin.POST("/versions/:id/enable", apps.handle(func(s *Server, c *gin.Context) { s.setVersionEnabled(true)(c) }))
+		admin.POST("/versions/:id/rollout/pause", apps.handle(func(s *Server, c *gin.Context) { s.setRolloutPaused(true)(c) }))
+		admin.POST("/versions/:id/rollout/resume", apps.handle(func(s *Server, c *gin.Context) { s.setRolloutPaused(false)(c) }))
+		admin.POST("/rollback", apps.handle((*Server).rollback))
+		admin.GET("/versions/:id/verify", apps.handle((*Server).verifyVersionByID))
+		admin.POST("/versions/:id/rehash", apps.handle((*Server).rehashVersion))
+		admin.POST("/verify-all", requireUnscopedKey, apps.handle((*Server).verifyAll))
+		admin.POST("/gc", requireUnscopedKey, apps.handle((*Server).collectGarbage))
+		admin.POST("/prune", apps.handle((*Server).pruneVersionsHandler))
+		admin.POST("/platforms/:platform/pause", apps.handle(func(s *Server, c *gin.Context) { s.setPlatformPaused(true)(c) }))
+		admin.GET("/audit", requireUnscopedKey, apps.handle((*Server).getAuditLog))
+		admin.POST("/platforms/:platform/resume", apps.handle(func(s *Server, c *gin.Context) { s.setPlatformPaused(false)(c) }))
+		admin.PUT("/platforms/:platform/min-supported-code", apps.handle((*Server).setMinSupportedCode))
+		admin.GET("/platforms/:platform/policy", apps.handle((*Server).getPlatformPolicy))
+		admin.PUT("/platforms/:platform/policy", apps.handle((*Server).setPlatformPolicy))
 	}
 
-	dbURL := os.Getenv("FIREBASE_DB_URL")
-	bucketName := os.Getenv("FIREBASE_STORAGE_BUCKET")
+	// Prometheus scrape endpoint
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Liveness (process up) and readiness (Firebase reachable) checks
+	r.GET("/health", livez)
+	r.GET("/livez", livez)
+	r.GET("/readyz", apps.any().readyz)
+	return r
+}
+
+func initFirebase() {
+	credsJSON := config.CredentialsJSON
+	projectID := config.ProjectID
+	dbURL := config.DatabaseURL
+	bucketName := config.StorageBucket
 
 	// 🔍 Log the config values
 	log.Printf("Using Firebase project ID: %q", projectID)
@@ -120,6 +412,7 @@ func initFirebase() {
 	log.Printf("Using Firebase storage bucket: %q", bucketName)
 
 	conf := &firebase.Config{
+		ProjectID:     projectID,
 		DatabaseURL:   dbURL,
 		StorageBucket: bucketName,
 	}
@@ -151,6 +444,14 @@ func initFirebase() {
 		log.Fatalf("Failed to initialize Storage client: %v", err)
 	}
 
+	if config.PushNotifications {
+		pushClient, err = app.Messaging(ctx)
+		if err != nil {
+			log.Fatalf("Failed to initialize Messaging client: %v", err)
+		}
+		log.Printf("Push notifications enabled (topics %q, %q)", config.PushTopicAndroid, config.PushTopicIOS)
+	}
+
 	log.Println("Successfully connected to Firebase services")
 
 	// Optional: List buckets (already in your code)
@@ -166,387 +467,1956 @@ func initFirebase() {
 		}
 		log.Println("Found bucket:", bucketAttrs.Name)
 	}
+
+	// Optional: confirm the service account can write to the bucket
+	if config.StorageWriteProbe {
+		if err := probeStorageWrite(ctx, bucketName); err != nil {
+			log.Fatalf("Storage write probe failed: %v", err)
+		}
+		log.Println("Storage write probe succeeded")
+	}
+}
+
+// probeStorageWrite writes and deletes a tiny sentinel object so IAM problems
+// show up at boot instead of on the first upload
+func probeStorageWrite(ctx context.Context, bucketName string) error {
+	if bucketName == "" {
+		return errors.New("FIREBASE_STORAGE_BUCKET not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	obj := storageClient.Bucket(bucketName).Object(fmt.Sprintf("_healthcheck/probe-%d", time.Now().UnixNano()))
+	w := obj.NewWriter(ctx)
+	if _, err := io.WriteString(w, "ok"); err != nil {
+		w.Close()
+		return fmt.Errorf("writing sentinel object: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("writing sentinel object: %w", err)
+	}
+	if err := obj.Delete(ctx); err != nil {
+		return fmt.Errorf("deleting sentinel object: %w", err)
+	}
+	return nil
 }
 
-func checkForUpdate(c *gin.Context) {
+func (s *Server) checkForUpdate(c *gin.Context) {
+	ctx := requestContext(c)
 	var req UpdateCheckRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
 		return
 	}
 
-	if req.Platform != "android" && req.Platform != "ios" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid platform"})
+	if !isSupportedPlatform(req.Platform) {
+		respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
 		return
 	}
 
-	ref := firebaseDB.NewRef("versions")
-	var versions map[string]AppVersion
-	if err := ref.Get(ctx, &versions); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	if req.Channel == "" {
+		req.Channel = defaultChannel
+	}
+	if !isReleaseChannel(req.Channel) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidChannel, "Invalid channel", gin.H{"expected": releaseChannels})
 		return
 	}
 
-	var latest *AppVersion
-	for _, v := range versions {
-		if !strings.HasPrefix(v.StoragePath, "releases/"+req.Platform+"/") {
-			continue
-		}
-		if latest == nil || v.VersionCode > latest.VersionCode {
-			temp := v // prevent referencing loop variable
-			latest = &temp
-		}
+	compare := compareByCode
+	switch req.CompareMode {
+	case "", "version_code":
+	case "semver":
+		compare = compareBySemver
+	default:
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid compare_mode", gin.H{
+			"expected": "version_code or semver",
+		})
+		return
 	}
 
-	if latest == nil {
-		c.JSON(http.StatusOK, UpdateCheckResponse{UpdateAvailable: false})
+	// A paused platform is offered nothing, regardless of available versions;
+	// its policy fills in versions without their own mandatory or rollout setting
+	platformConfig, cfgErr := s.store.GetPlatformConfig(ctx, req.Platform)
+	if cfgErr != nil {
+		loggerFrom(ctx).Error("platform config read failed", "err", cfgErr)
+	} else if platformConfig.Paused {
+		c.JSON(http.StatusOK, UpdateCheckResponse{UpdateAvailable: false, Paused: true})
 		return
 	}
 
-	updateAvailable := req.CurrentCode < latest.VersionCode
+	// Most clients are up to date, so the version latest/<platform> names,
+	// or else the platform's newest versions, usually settle the answer;
+	// otherwise every version is read
+	var latest, previous *AppVersion
+	stale := false
+	var err error
+	versions, complete := platformConfig.applyPolicy(s.latestWindow(ctx, req.Platform)), false
+	if !windowSuffices(versions, req, time.Now()) {
+		versions, complete, err = s.store.RecentVersions(ctx, req.Platform, config.CheckUpdateWindow)
+		if err != nil && !errors.Is(err, errIndexNotReady) {
+			loggerFrom(ctx).Warn("reading recent versions failed", "platform", req.Platform, "err", err)
+		}
+		versions = platformConfig.applyPolicy(versions)
+		if err != nil || (!complete && !windowSuffices(versions, req, time.Now())) {
+			versions, err = s.store.ListVersions(ctx)
+			versions = platformConfig.applyPolicy(versions)
+			complete = true
+		}
+	}
+	if err != nil {
+		// Fall back to the last-known-good latest while the database is unavailable
+		snap, ok := s.latest.get(req.Platform, req.Channel)
+		if !ok {
+			loggerFrom(ctx).Error("version fetch failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+			return
+		}
+		loggerFrom(ctx).Warn("version fetch failed, serving stale latest",
+			"platform", req.Platform, "fetched_at", snap.fetchedAt.Format(time.RFC3339), "err", err)
+		latest, previous, stale = snap.latest, snap.previous, true
+	} else {
+		versions = offeredVersions(versions, req.Channel, time.Now())
+
+		// The cache is shared by every device, so it only holds fully rolled-out
+		// builds; a window without the previous build only refreshes the latest
+		cachedLatest, cachedPrevious := selectLatest(rolledOutTo(versions, ""), req.Platform)
+		if complete || cachedPrevious != nil {
+			s.latest.put(req.Platform, req.Channel, cachedLatest, cachedPrevious)
+		} else if cachedLatest != nil {
+			s.latest.refresh(req.Platform, req.Channel, cachedLatest)
+		}
 
-	response := UpdateCheckResponse{
-		UpdateAvailable: updateAvailable,
-		IsMandatory:     latest.VersionCode-req.CurrentCode >= 2,
-		LatestVersion:   latest,
+		versions = compatibleWith(rolledOutTo(versions, req.DeviceID), req.OSVersion)
+		latest, previous = selectLatestBy(versions, req.Platform, compare)
 	}
 
-	c.JSON(http.StatusOK, response)
-}
-func getVersions(c *gin.Context) {
-	platform := c.Query("platform")
+	// The cached builds were picked without regard to the device's OS
+	if stale && previous != nil && !supportsOS(*previous, req.OSVersion) {
+		previous = nil
+	}
+	if stale && latest != nil && !supportsOS(*latest, req.OSVersion) {
+		latest, previous = previous, nil
+	}
 
-	ref := firebaseDB.NewRef("versions")
-	var versions map[string]AppVersion
-	log.Println("Fetching versions from Firebase...")
-	if err := ref.OrderByChild("created_at").Get(ctx, &versions); err != nil {
-		log.Printf("Firebase fetch error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+	if latest == nil {
+		c.JSON(http.StatusOK, UpdateCheckResponse{UpdateAvailable: false, Stale: stale})
 		return
 	}
-	log.Println("Successfully fetched versions")
 
-	// Convert map to slice and filter by platform if specified
-	var versionsList []AppVersion
-	for _, v := range versions {
-		// If platform is specified, filter versions
-		if platform != "" {
-			isPlatformMatch := false
-			if platform == "ios" && (v.StoragePath == "" || (len(v.StoragePath) >= 4 && v.StoragePath[0:4] == "ios_")) {
-				isPlatformMatch = true
-			} else if platform == "android" && (v.StoragePath == "" || (len(v.StoragePath) >= 8 && v.StoragePath[0:8] == "android_")) {
-				isPlatformMatch = true
-			}
+	current := AppVersion{Version: req.CurrentVersion, VersionCode: req.CurrentCode}
 
-			if !isPlatformMatch {
-				continue // Skip this version if it doesn't match the platform
-			}
+	// Every build the client would skip over counts towards the mandatory decision and changelog
+	var skipped []AppVersion
+	if stale {
+		if previous != nil && compare(*previous, current) > 0 {
+			skipped = append(skipped, *previous)
 		}
-
-		// Add platform parameter to download URL if platform is specified
-		if platform != "" {
-			v.DownloadURL = fmt.Sprintf("%s?platform=%s", v.DownloadURL, platform)
+		if compare(*latest, current) > 0 {
+			skipped = append(skipped, *latest)
+		}
+	} else {
+		for _, v := range versions {
+			if matchesPlatform(v, req.Platform) && compare(v, current) > 0 && compare(v, *latest) <= 0 {
+				skipped = append(skipped, v)
+			}
 		}
+	}
 
-		versionsList = append(versionsList, v)
+	latest.DownloadURL = s.artifactDownloadURL(latest.Version, req.Platform, req.ABI)
+	localize(latest, req.Locale)
+	if previous != nil {
+		previous.DownloadURL = s.artifactDownloadURL(previous.Version, req.Platform, req.ABI)
+		localize(previous, req.Locale)
+	}
+	for i := range skipped {
+		localize(&skipped[i], req.Locale)
 	}
 
-	c.JSON(http.StatusOK, versionsList)
-}
+	updateAvailable := compare(*latest, current) > 0
+	// Below the platform's floor every update is mandatory, however few builds behind
+	belowFloor := req.CurrentCode < platformConfig.MinSupportedCode
 
-func downloadUpdate(c *gin.Context) {
-	version := c.Param("version")
-	platform := c.Query("platform")
-	if platform == "" {
-		platform = "android"
+	response := UpdateCheckResponse{
+		UpdateAvailable:  updateAvailable,
+		IsMandatory:      updateAvailable && (belowFloor || isMandatoryUpdate(skipped, latest.VersionCode-req.CurrentCode)),
+		LatestVersion:    latest,
+		ChangeLog:        changeLog(skipped, compare),
+		DownloadURL:      absoluteURL(c, latest.DownloadURL),
+		MinSupportedCode: platformConfig.MinSupportedCode,
+		Stale:            stale,
 	}
-
-	// Get all versions
-	ref := firebaseDB.NewRef("versions")
-	var versions map[string]AppVersion
-	if err := ref.Get(ctx, &versions); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
+	if req.IncludePrevious {
+		response.PreviousVersion = previous
 	}
-
-	var matched *AppVersion
-	for _, v := range versions {
-		if v.Version == version && strings.HasPrefix(v.StoragePath, "releases/"+platform+"/") {
-			matched = &v
-			break
+	if artifact, ok := selectArtifact(*latest, req.ABI); ok && updateAvailable && req.IncludeSignedURL {
+		// Without signing rights the client still has download_url
+		served := withArtifact(*latest, artifact)
+		signed, expires, err := s.signedDownloadURL(ctx, &served, req.Platform)
+		switch {
+		case errors.Is(err, errSigningUnsupported):
+		case err != nil:
+			loggerFrom(ctx).Warn("signing URL failed", "storage_path", latest.StoragePath, "err", err)
+		default:
+			response.SignedURL = signed
+			response.SignedURLExpiresAt = expires.UTC().Format(time.RFC3339)
 		}
 	}
 
-	if matched == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Requested platform/version does not match any available file"})
-		return
+	c.JSON(http.StatusOK, response)
+}
+
+// windowSuffices reports whether check-update can answer from window, a
+// platform's newest versions, exactly as it would from all of them: the
+// latest build offered to the device lies in the window, and so do the
+// builds the client skips and, when requested, the build before the latest.
+// Semver ordering doesn't follow version codes, so it always needs everything.
+func windowSuffices(window map[string]AppVersion, req UpdateCheckRequest, now time.Time) bool {
+	if req.CompareMode == "semver" || len(window) == 0 {
+		return false
+	}
+	lowest := 0
+	for _, v := range window {
+		if lowest == 0 || v.VersionCode < lowest {
+			lowest = v.VersionCode
+		}
 	}
+	// Anything between the client's build and the window would be skipped unseen
+	if lowest > req.CurrentCode+1 {
+		return false
+	}
+	offered := compatibleWith(rolledOutTo(offeredVersions(window, req.Channel, now), req.DeviceID), req.OSVersion)
+	latest, previous := selectLatest(offered, req.Platform)
+	return latest != nil && (previous != nil || !req.IncludePrevious)
+}
 
-	// Open from Firebase Storage
-	bucketName := os.Getenv("FIREBASE_STORAGE_BUCKET")
-	bucket := storageClient.Bucket(bucketName)
-	obj := bucket.Object(matched.StoragePath)
-	reader, err := obj.NewReader(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file from storage"})
-		return
+// changeLog joins the release notes of the given builds, oldest first, each
+// headed by its version string
+func changeLog(versions []AppVersion, compare func(a, b AppVersion) int) string {
+	sort.Slice(versions, func(i, j int) bool {
+		return compare(versions[i], versions[j]) < 0
+	})
+	entries := make([]string, 0, len(versions))
+	for _, v := range versions {
+		entries = append(entries, fmt.Sprintf("%s:\n%s", v.Version, v.ReleaseNotes))
 	}
-	defer reader.Close()
+	return strings.Join(entries, "\n\n")
+}
 
-	// Content headers
-	var fileExt, contentType string
-	if platform == "ios" {
-		fileExt = "ipa"
-		contentType = "application/octet-stream"
-	} else {
-		fileExt = "apk"
-		contentType = "application/vnd.android.package-archive"
+// isMandatoryUpdate reports whether any of the skipped builds was uploaded as mandatory.
+// Only when none of them carries the flag does the "MANDATORY_CODES_BEHIND or more codes
+// behind" heuristic apply.
+func isMandatoryUpdate(skipped []AppVersion, codesBehind int) bool {
+	flagged := false
+	for _, v := range skipped {
+		if v.IsMandatory == nil {
+			continue
+		}
+		if *v.IsMandatory {
+			return true
+		}
+		flagged = true
 	}
+	if flagged {
+		return false
+	}
+	return config.MandatoryCodesBehind > 0 && codesBehind >= config.MandatoryCodesBehind
+}
 
-	fileName := fmt.Sprintf("app-v%s.%s", version, fileExt)
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
-	c.Header("Content-Type", contentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", matched.FileSize))
+// selectLatest picks the highest version code for a platform and the highest
+// code below it
+func selectLatest(versions map[string]AppVersion, platform string) (latest, previous *AppVersion) {
+	return selectLatestBy(versions, platform, compareByCode)
+}
 
-	_, copyErr := io.Copy(c.Writer, reader)
-	if copyErr != nil {
-		log.Printf("Error streaming file: %v", copyErr)
+// selectLatestBy is selectLatest with a custom build ordering
+func selectLatestBy(versions map[string]AppVersion, platform string, compare func(a, b AppVersion) int) (latest, previous *AppVersion) {
+	for _, v := range versions {
+		if !matchesPlatform(v, platform) {
+			continue
+		}
+		temp := v // prevent referencing loop variable
+		if latest == nil || compare(v, *latest) > 0 {
+			previous = latest
+			latest = &temp
+		} else if compare(v, *latest) < 0 && (previous == nil || compare(v, *previous) > 0) {
+			previous = &temp
+		}
 	}
+	return latest, previous
 }
 
-func uploadUpdate(c *gin.Context) {
-	// 1. Initialize context with timeout (10 minutes for large file uploads)
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
-	defer cancel()
-
-	// 2. Parse and validate form data
-	version := strings.TrimSpace(c.PostForm("version"))
-	versionCodeStr := strings.TrimSpace(c.PostForm("version_code"))
-	releaseNotes := strings.TrimSpace(c.PostForm("release_notes"))
-	platform := strings.ToLower(strings.TrimSpace(c.PostForm("platform")))
+// compareByCode orders builds by version code
+func compareByCode(a, b AppVersion) int {
+	return cmp.Compare(a.VersionCode, b.VersionCode)
+}
 
-	// Set default platform if not specified
-	if platform == "" {
-		platform = "android"
+// compareBySemver orders builds by their version strings, falling back to
+// version code when either one is not valid semver
+func compareBySemver(a, b AppVersion) int {
+	if _, ok := parseSemver(a.Version); !ok {
+		return compareByCode(a, b)
 	}
-
-	// Validate required fields
-	if version == "" || versionCodeStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":    "Missing required fields",
-			"required": []string{"version", "version_code"},
-		})
-		return
+	if _, ok := parseSemver(b.Version); !ok {
+		return compareByCode(a, b)
 	}
-
-	// Validate version code
-	versionCode, err := strconv.Atoi(versionCodeStr)
-	if err != nil || versionCode <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":    "Invalid version_code",
-			"expected": "positive integer",
-		})
-		return
+	if c := compareSemver(a.Version, b.Version); c != 0 {
+		return c
 	}
+	return compareByCode(a, b)
+}
 
-	// 3. Check for existing versions
-	ref := firebaseDB.NewRef("versions")
+func (s *Server) getVersions(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Query("platform")
 
-	// Check by version code
-	query := ref.OrderByChild("version_code").EqualTo(versionCode).LimitToFirst(1)
-	var existingVersions map[string]AppVersion
-	if err := query.Get(ctx, &existingVersions); err != nil {
-		log.Printf("Database query error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Could not check for existing versions",
-		})
+	channel := c.Query("channel")
+	if channel != "" && !isReleaseChannel(channel) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidChannel, "Invalid channel", gin.H{"expected": releaseChannels})
 		return
 	}
 
-	if len(existingVersions) > 0 {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": fmt.Sprintf("Version code %d already exists", versionCode),
-		})
+	// Pagination is opt-in so existing clients keep getting the full array
+	paginate := c.Query("limit") != "" || c.Query("offset") != ""
+	limit, offset, ok := parsePage(c)
+	if !ok {
 		return
 	}
 
-	// 4. Process file upload
-	file, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "No file uploaded",
-		})
-		return
+	defaultSort := "created_at"
+	if paginate {
+		defaultSort = "-created_at"
 	}
-
-	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	expectedExt := map[string]string{
-		"ios":     ".ipa",
-		"android": ".apk",
-	}[platform]
-
-	if ext != expectedExt {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":    fmt.Sprintf("Invalid file extension for %s platform", platform),
-			"expected": expectedExt,
+	sortKey := c.DefaultQuery("sort", defaultSort)
+	less, ok := versionSorts[strings.TrimPrefix(sortKey, "-")]
+	if !ok {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid sort", gin.H{
+			"expected": "created_at or version_code, optionally prefixed with - for descending",
 		})
 		return
 	}
+	descending := strings.HasPrefix(sortKey, "-")
 
-	// 5. Open file stream
-	src, err := file.Open()
-	if err != nil {
-		log.Printf("File open error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to process uploaded file",
-		})
-		return
+	minCode := 0
+	if minCodeStr := c.Query("min_code"); minCodeStr != "" {
+		var err error
+		minCode, err = strconv.Atoi(minCodeStr)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid min_code", gin.H{"expected": "integer"})
+			return
+		}
 	}
-	defer src.Close()
-
-	// 6. Initialize Firebase Storage
-	bucketName := os.Getenv("FIREBASE_STORAGE_BUCKET")
-	log.Printf("Using Firebase storage bucket: %q", bucketName)
 
-	if bucketName == "" {
-		log.Println("FIREBASE_STORAGE_BUCKET not configured")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Server configuration error",
-		})
-		return
+	// since is inclusive and until exclusive, so consecutive ranges don't overlap
+	var bounds [2]time.Time
+	for i, param := range []string{"since", "until"} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid "+param, gin.H{
+				"expected": "RFC 3339 time, e.g. 2025-06-01T00:00:00Z",
+			})
+			return
+		}
+		bounds[i] = t
 	}
-
-	bucket := storageClient.Bucket(bucketName)
+	since, until := bounds[0], bounds[1]
+
+	// Disabled versions are hidden unless asked for, e.g. to re-enable one
+	includeDisabled, _ := strconv.ParseBool(c.Query("include_disabled"))
+	// trashed=true lists the trash instead, e.g. to restore a version
+	trashed, _ := strconv.ParseBool(c.Query("trashed"))
+	locale := c.Query("locale")
+
+	list := s.store.ListVersions
+	if trashed {
+		list = s.store.ListTrashed
+		includeDisabled = true
+	}
+	versions, err := list(ctx)
 	if err != nil {
-		log.Printf("Bucket initialization error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to initialize storage",
-		})
+		loggerFrom(ctx).Error("version fetch failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to fetch versions")
 		return
 	}
 
-	// 7. Prepare storage path
-	storagePath := fmt.Sprintf("releases/%s/%s-%d%s",
-		platform,
-		version,
-		time.Now().Unix(),
-		ext,
-	)
-
-	// 8. Upload to Firebase Storage with checksum calculation
-	obj := bucket.Object(storagePath)
-	w := obj.NewWriter(ctx)
-	defer w.Close()
-
-	hash := sha256.New()
-	multiWriter := io.MultiWriter(w, hash)
+	// Convert map to slice and filter by platform if specified
+	now := time.Now()
+	versionsList := []AppVersion{}
+	for _, v := range versions {
+		if v.VersionCode < minCode {
+			continue
+		}
+		if !includeDisabled && !isEnabled(v) {
+			continue
+		}
 
-	if _, err := io.Copy(multiWriter, src); err != nil {
-		log.Printf("File upload error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to upload file",
-		})
-		return
-	}
+		// If platform is specified, filter versions
+		if !matchesPlatform(v, platform) {
+			continue // Skip this version if it doesn't match the platform
+		}
+		if channel != "" && v.Channel != channel {
+			continue
+		}
+		if !since.IsZero() && v.CreatedAt.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !v.CreatedAt.Before(until) {
+			continue
+		}
 
-	if err := w.Close(); err != nil {
-		log.Printf("Upload finalization error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to complete upload",
-		})
-		return
-	}
+		// Rebuild the download URL for the requested platform; trashed
+		// versions can't be downloaded
+		if trashed {
+			v.DownloadURL = ""
+		} else if platform != "" {
+			v.DownloadURL = s.downloadURL(v.Version, platform)
+		}
+		localize(&v, locale)
+		v.Status = versionStatus(v, now)
 
-	// 9. Set public read access (optional)
-	if err := obj.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
-		log.Printf("Warning: Failed to set public access: %v", err)
+		versionsList = append(versionsList, v)
 	}
 
-	// 10. Create version record in database
-	newVersionRef, err := ref.Push(ctx, nil)
-	if err != nil {
-		log.Printf("Database reference creation error: %v", err)
-		// Clean up uploaded file
-		if err := obj.Delete(ctx); err != nil {
-			log.Printf("Failed to clean up uploaded file: %v", err)
+	// Map iteration order is random, so sort explicitly; ID breaks ties
+	sort.Slice(versionsList, func(i, j int) bool {
+		a, b := versionsList[i], versionsList[j]
+		if descending {
+			a, b = b, a
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create version record",
-		})
-		return
-	}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID
+	})
 
-	// 11. Prepare version data
-	appVersion := AppVersion{
-		ID:           newVersionRef.Key,
-		Version:      version,
-		VersionCode:  versionCode,
-		DownloadURL:  fmt.Sprintf("/api/v1/ota/download/%s?platform=%s", version, platform),
-		ReleaseNotes: releaseNotes,
-		FileSize:     file.Size,
-		Checksum:     fmt.Sprintf("%x", hash.Sum(nil)),
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-		StoragePath:  storagePath,
-	}
-
-	// 12. Save to database
-	if err := newVersionRef.Set(ctx, appVersion); err != nil {
-		log.Printf("Database save error: %v", err)
-		// Clean up uploaded file
-		if err := obj.Delete(ctx); err != nil {
-			log.Printf("Failed to clean up uploaded file: %v", err)
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to save version information",
-		})
+	if !paginate {
+		c.JSON(http.StatusOK, versionsList)
 		return
 	}
 
-	// 13. Return success response
+	total := len(versionsList)
+	page := versionsList[min(offset, total):min(offset+limit, total)]
+	var nextOffset *int
+	if offset+limit < total {
+		next := offset + limit
+		nextOffset = &next
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message":      "Version uploaded successfully",
-		"version":      appVersion,
-		"download_url": appVersion.DownloadURL,
+		"versions":    page,
+		"total":       total,
+		"next_offset": nextOffset,
 	})
 }
 
-func deleteVersion(c *gin.Context) {
-	id := c.Param("id")
+// Page sizes for paginated listings
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
 
-	// Get version info first
-	ref := firebaseDB.NewRef("versions/" + id)
-	var version AppVersion
-	if err := ref.Get(ctx, &version); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
+// parsePage reads the limit and offset query parameters, answering 400 and
+// returning false when either is invalid
+func parsePage(c *gin.Context) (limit, offset int, ok bool) {
+	limit = defaultPageSize
+	var err error
+	if s := c.Query("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxPageSize {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid limit", gin.H{
+				"expected": fmt.Sprintf("integer between 1 and %d", maxPageSize),
+			})
+			return 0, 0, false
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid offset", gin.H{
+				"expected": "non-negative integer",
+			})
+			return 0, 0, false
+		}
 	}
+	return limit, offset, true
+}
 
-	if version.Version == "" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+// WhatsNewEntry is the release note of a single version returned by getWhatsNew
+type WhatsNewEntry struct {
+	Version      string `json:"version"`
+	VersionCode  int    `json:"version_code"`
+	ReleaseNotes string `json:"release_notes"`
+	// Mandatory is the version's is_mandatory, or the platform policy's when it has none
+	Mandatory bool `json:"mandatory"`
+}
+
+// getWhatsNew returns the release notes of every version newer than since_code, oldest first
+func (s *Server) getWhatsNew(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Query("platform")
+	if !isSupportedPlatform(platform) {
+		respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
 		return
 	}
 
-	// Delete from Firebase Storage
-	bucketName := os.Getenv("FIREBASE_STORAGE_BUCKET")
-	if bucketName == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage bucket not configured"})
+	sinceCode, err := strconv.Atoi(c.Query("since_code"))
+	if err != nil || sinceCode < 0 {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid since_code", gin.H{
+			"expected": "non-negative integer",
+		})
 		return
 	}
-	bucket := storageClient.Bucket(bucketName)
 
-	if err := bucket.Object(version.StoragePath).Delete(ctx); err != nil {
-		log.Printf("Warning: Failed to delete file from storage: %v", err)
+	channel := c.DefaultQuery("channel", defaultChannel)
+	if !isReleaseChannel(channel) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidChannel, "Invalid channel", gin.H{"expected": releaseChannels})
+		return
 	}
+	locale := c.Query("locale")
 
-	// Delete from Firebase DB
-	if err := ref.Delete(ctx); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete version"})
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
 		return
 	}
+	platformConfig, err := s.store.GetPlatformConfig(ctx, platform)
+	if err != nil {
+		loggerFrom(ctx).Error("platform config read failed", "err", err)
+	}
+	versions = offeredVersions(platformConfig.applyPolicy(versions), channel, time.Now())
 
-	c.JSON(http.StatusOK, gin.H{"message": "Version deleted successfully"})
-}
-
+	entries := []WhatsNewEntry{}
+	for _, v := range versions {
+		if !matchesPlatform(v, platform) || v.VersionCode <= sinceCode {
+			continue
+		}
+		localize(&v, locale)
+		entries = append(entries, WhatsNewEntry{
+			Version:      v.Version,
+			VersionCode:  v.VersionCode,
+			ReleaseNotes: v.ReleaseNotes,
+			Mandatory:    v.IsMandatory != nil && *v.IsMandatory,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].VersionCode < entries[j].VersionCode
+	})
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// defaultChannel is the channel of versions uploaded without one
+const defaultChannel = "stable"
+
+// releaseChannels lists the channels a version can be released on
+var releaseChannels = []string{"stable", "beta", "alpha"}
+
+func isReleaseChannel(channel string) bool {
+	for _, ch := range releaseChannels {
+		if ch == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// channelOf returns a version's channel, treating records without one as stable
+func channelOf(v AppVersion) string {
+	if v.Channel == "" {
+		return defaultChannel
+	}
+	return v.Channel
+}
+
+// offeredVersions is what a client on channel may be offered at now, before
+// per-device rollout and OS filtering: released versions on its channel or stable
+func offeredVersions(versions map[string]AppVersion, channel string, now time.Time) map[string]AppVersion {
+	return offeredOnChannel(releasedVersions(versions, now), channel)
+}
+
+// offeredOnChannel keeps the versions a client following channel may be
+// offered: the channel's own builds plus stable ones, so testers fall through
+// to stable whenever it is newer.
+func offeredOnChannel(versions map[string]AppVersion, channel string) map[string]AppVersion {
+	offered := make(map[string]AppVersion, len(versions))
+	for id, v := range versions {
+		if v.Channel == channel || v.Channel == defaultChannel {
+			offered[id] = v
+		}
+	}
+	return offered
+}
+
+// platformOf returns a version's platform. Records written before the
+// Platform field existed fall back to reading it from their storage path,
+// past the apps/<app_id>/ prefix of a hosted app.
+func platformOf(v AppVersion) string {
+	if v.Platform != "" {
+		return v.Platform
+	}
+	p := v.StoragePath
+	if rest, ok := strings.CutPrefix(p, "apps/"); ok {
+		if _, scoped, found := strings.Cut(rest, "/"); found {
+			p = scoped
+		}
+	}
+	return storagePathPlatform(p)
+}
+
+// matchesPlatform reports whether a version was released for platform. An
+// empty platform matches every version.
+func matchesPlatform(v AppVersion, platform string) bool {
+	return platform == "" || platformOf(v) == platform
+}
+
+// downloadURL builds the download path for a version, carrying each query
+// parameter exactly once. Every response that exposes a download URL uses it.
+func (s *Server) downloadURL(version, platform string) string {
+	return s.artifactDownloadURL(version, platform, "")
+}
+
+// artifactDownloadURL is downloadURL for the split build of an ABI
+func (s *Server) artifactDownloadURL(version, platform, abi string) string {
+	query := url.Values{}
+	query.Set("platform", platform)
+	if s.appID != "" {
+		query.Set("app_id", s.appID)
+	}
+	if abi = strings.ToLower(strings.TrimSpace(abi)); abi != "" {
+		query.Set("abi", abi)
+	}
+	return "/api/v1/ota/download/" + url.PathEscape(version) + "?" + query.Encode()
+}
+
+// versionSorts maps the sort keys accepted by getVersions to their ascending order
+var versionSorts = map[string]func(a, b AppVersion) bool{
+	"created_at": func(a, b AppVersion) bool {
+		return a.CreatedAt.Before(b.CreatedAt)
+	},
+	"version_code": func(a, b AppVersion) bool {
+		return a.VersionCode < b.VersionCode
+	},
+}
+
+// artifactType returns the attachment filename and content type a version is served with
+func artifactType(v *AppVersion, platform string) (fileName, contentType string) {
+	spec, _ := platformSpec(platform)
+	ext := spec.artifactExtension(v.StoragePath)
+	return fmt.Sprintf("app-v%s%s", v.Version, ext), spec.contentType(ext)
+}
+
+// resolveDownload finds the version a download request refers to and applies
+// the downgrade policy, writing the error response itself when it fails
+func (s *Server) resolveDownload(c *gin.Context, version, platform string) (*AppVersion, bool) {
+	ctx := requestContext(c)
+	// A malformed request is the client's fault; an unknown version is not
+	if !isSupportedPlatform(platform) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform", gin.H{
+			"expected": supportedPlatforms,
+		})
+		return nil, false
+	}
+
+	var matched *AppVersion
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		// The cached latest build is most likely still in Storage, so keep serving it
+		for _, channel := range releaseChannels {
+			if snap, ok := s.latest.get(platform, channel); ok && snap.latest != nil && snap.latest.Version == version {
+				matched = snap.latest
+				break
+			}
+		}
+		if matched == nil {
+			loggerFrom(ctx).Error("version fetch failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+			return nil, false
+		}
+		loggerFrom(ctx).Warn("version fetch failed, serving stale latest", "platform", platform, "version", version, "err", err)
+	}
+
+	for _, v := range versions {
+		if v.Version == version && matchesPlatform(v, platform) {
+			matched = &v
+			break
+		}
+	}
+
+	// Until its publish time a scheduled version doesn't exist for clients
+	if matched == nil || !isPublished(*matched, time.Now()) {
+		respondError(c, http.StatusNotFound, codeNotFound, "Requested platform/version does not match any available file")
+		return nil, false
+	}
+	if !isEnabled(*matched) {
+		respondError(c, http.StatusGone, codeVersionDisabled, "Version has been disabled")
+		return nil, false
+	}
+
+	// Split builds are served per ABI, falling back to the universal build;
+	// the copy returned describes the artifact chosen
+	abi := c.Query("abi")
+	artifact, ok := selectArtifact(*matched, abi)
+	if !ok {
+		status, code, msg := http.StatusNotFound, codeNotFound, "No build for the requested ABI"
+		if abi == "" {
+			status, code, msg = http.StatusBadRequest, codeMissingFields, "This version has per-ABI builds only; abi is required"
+		}
+		respondErrorDetails(c, status, code, msg, gin.H{"expected": artifactABIs(*matched)})
+		return nil, false
+	}
+	served := withArtifact(*matched, artifact)
+	matched = &served
+
+	// Reject downgrades when the client reports what it is currently running,
+	// except to the platform's latest enabled build: after a rollback that is
+	// the build every client is meant to move to
+	if currentCodeStr := c.Query("current_code"); currentCodeStr != "" && config.BlockDowngrades {
+		currentCode, err := strconv.Atoi(currentCodeStr)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid current_code")
+			return nil, false
+		}
+		if matched.VersionCode < currentCode && !s.isRollbackTarget(ctx, *matched, versions, platform) {
+			respondErrorDetails(c, http.StatusForbidden, codeDowngradeBlocked, "Downgrades are blocked", gin.H{
+				"current_code":   currentCode,
+				"requested_code": matched.VersionCode,
+			})
+			return nil, false
+		}
+	}
+	return matched, true
+}
+
+// isRollbackTarget reports whether v is platform's highest enabled version,
+// read from versions or, when listing them failed, the latest pointer
+func (s *Server) isRollbackTarget(ctx context.Context, v AppVersion, versions map[string]AppVersion, platform string) bool {
+	if versions == nil {
+		_, ok := s.latestWindow(ctx, platform)[v.ID]
+		return ok
+	}
+	latest := highestEnabled(versions, platform)
+	return latest != nil && latest.ID == v.ID
+}
+
+func (s *Server) downloadUpdate(c *gin.Context) {
+	ctx := requestContext(c)
+	version := c.Param("version")
+	platform := c.Query("platform")
+	if platform == "" {
+		platform = "android"
+	}
+
+	matched, ok := s.resolveDownload(c, version, platform)
+	if !ok {
+		return
+	}
+
+	// nocache=true makes a device fetch its copy afresh, e.g. to repair a
+	// corrupt local file: nothing may be cached on the way, and no validator
+	// is sent or honoured, so the response is always the full body
+	nocache, _ := strconv.ParseBool(c.Query("nocache"))
+	if nocache {
+		c.Header("Cache-Control", "no-store")
+	} else {
+		// Let clients that already hold this build skip the transfer. An
+		// artifact never changes once uploaded, so it was last modified when
+		// its version was created; UpdatedAt moves with metadata edits only.
+		etag := versionETag(matched)
+		if etag != "" {
+			c.Header("ETag", etag)
+			c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(config.DownloadCacheMaxAge.Seconds())))
+		}
+		if !matched.CreatedAt.IsZero() {
+			c.Header("Last-Modified", matched.CreatedAt.UTC().Format(http.TimeFormat))
+		}
+		// If-None-Match takes precedence; If-Modified-Since counts only without it
+		if inm := c.GetHeader("If-None-Match"); inm != "" {
+			if etag != "" && etagMatches(inm, etag) {
+				c.Status(http.StatusNotModified)
+				return
+			}
+		} else if notModifiedSince(c.GetHeader("If-Modified-Since"), matched.CreatedAt) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Resolve a Range request so interrupted downloads can resume
+	c.Header("Accept-Ranges", "bytes")
+	rng, err := parseByteRange(c.GetHeader("Range"), matched.FileSize)
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", matched.FileSize))
+		respondError(c, http.StatusRequestedRangeNotSatisfiable, codeRangeNotSatisfiable, "Requested range not satisfiable")
+		return
+	}
+
+	// verify=true checks the whole object before sending a single byte
+	verify, _ := strconv.ParseBool(c.Query("verify"))
+	verify = verify && matched.Checksum != ""
+
+	// Open from Firebase Storage; HEAD only describes the file. A stalled
+	// transfer cancels the read through readCtx.
+	readCtx, cancelRead := context.WithCancel(ctx)
+	defer cancelRead()
+	var body io.Reader
+	if c.Request.Method != http.MethodHead {
+		offset, length := int64(0), int64(-1)
+		if rng != nil && !verify {
+			offset, length = rng.start, rng.length()
+		}
+		reader, err := s.store.OpenObject(readCtx, matched.StoragePath, offset, length)
+		if err != nil {
+			// The record exists, so a missing object is a server fault rather than a 404
+			loggerFrom(ctx).Error("storage read failed", "storage_path", matched.StoragePath, "version_id", matched.ID, "err", err)
+			respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to read file from storage")
+			return
+		}
+		defer reader.Close()
+		body = reader
+
+		if verify {
+			spool, err := spoolVerified(reader, matched.Checksum)
+			if err != nil {
+				if errors.Is(err, errChecksumMismatch) {
+					loggerFrom(ctx).Error("corrupt artifact",
+						"storage_path", matched.StoragePath, "version_id", matched.ID, "checksum", matched.Checksum, "err", err)
+					respondError(c, http.StatusInternalServerError, codeStorageError, "Stored file failed integrity check")
+					return
+				}
+				loggerFrom(ctx).Error("download verification failed", "err", err)
+				respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to read file from storage")
+				return
+			}
+			defer os.Remove(spool.Name())
+			defer spool.Close()
+			body = spool
+			if rng != nil {
+				if _, err := spool.Seek(rng.start, io.SeekStart); err != nil {
+					loggerFrom(ctx).Error("download verification failed", "err", err)
+					respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to read file from storage")
+					return
+				}
+				body = io.LimitReader(spool, rng.length())
+			}
+		}
+	}
+
+	// Content headers
+	fileName, contentType := artifactType(matched, platform)
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	c.Header("Content-Type", contentType)
+	if digest, ok := sha256Digest(matched.Checksum); ok {
+		// Digest covers the bytes in this response, Repr-Digest the whole
+		// representation, so only the latter is valid for ranges
+		if rng == nil {
+			c.Header("Digest", "sha-256="+digest)
+		}
+		c.Header("Repr-Digest", "sha-256=:"+digest+":")
+	}
+	if rng != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.end, matched.FileSize))
+		c.Header("Content-Length", fmt.Sprintf("%d", rng.length()))
+		c.Status(http.StatusPartialContent)
+	} else {
+		c.Header("Content-Length", fmt.Sprintf("%d", matched.FileSize))
+	}
+	if body == nil {
+		return
+	}
+
+	// Full, unverified downloads are hashed on the way out so truncated or
+	// corrupted objects at least get noticed
+	var sums map[string]string
+	var streamed int64
+	guard := newStallGuard(c, config.DownloadStallTimeout, cancelRead)
+	if rng != nil || verify || matched.Checksum == "" {
+		streamed, err = io.Copy(guard, body)
+	} else {
+		sums, streamed, err = hashStream(io.TeeReader(body, guard), "sha256")
+	}
+	stalled := guard.stop()
+	downloadBytes.WithLabelValues(platform).Observe(float64(streamed))
+	if err != nil {
+		if stalled {
+			loggerFrom(ctx).Warn("stalled download aborted",
+				"version_id", matched.ID, "bytes_sent", guard.sent.Load(), "stall_timeout", config.DownloadStallTimeout.String())
+			return
+		}
+		loggerFrom(ctx).Warn("streaming file failed", "err", err)
+		return
+	}
+	if sums != nil {
+		if !strings.EqualFold(sums["sha256"], matched.Checksum) {
+			loggerFrom(ctx).Error("corrupt artifact served",
+				"storage_path", matched.StoragePath, "version_id", matched.ID, "sha256", sums["sha256"], "checksum", matched.Checksum)
+		}
+	}
+
+	// Only complete transfers count; partial responses are resumes or probes
+	if rng == nil {
+		if err := s.store.IncrementDownloadCount(ctx, matched.ID); err != nil {
+			loggerFrom(ctx).Error("download count update failed", "version_id", matched.ID, "err", err)
+		}
+	}
+}
+
+func (s *Server) uploadUpdate(c *gin.Context) {
+	// 1. Initialize context with timeout (10 minutes for large file uploads)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+	start := time.Now()
+
+	// 2. Parse and validate form data, refusing oversized bodies up front
+	if !limitUploadBody(c) {
+		return
+	}
+	version := strings.TrimSpace(c.PostForm("version"))
+	versionCodeStr := strings.TrimSpace(c.PostForm("version_code"))
+	releaseNotes := strings.TrimSpace(c.PostForm("release_notes"))
+	localizedNotes, err := parseLocalizedNotes(c.Request.PostForm)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, err.Error(), gin.H{
+			"expected": "release_notes_<locale> fields such as release_notes_es or release_notes_pt_BR",
+		})
+		return
+	}
+	platform := strings.ToLower(strings.TrimSpace(c.PostForm("platform")))
+	isMandatory, err := parseOptionalBool(c.PostForm("is_mandatory"))
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid is_mandatory", gin.H{
+			"expected": "true or false",
+		})
+		return
+	}
+	channel := strings.ToLower(strings.TrimSpace(c.DefaultPostForm("channel", defaultChannel)))
+	if !isReleaseChannel(channel) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidChannel, "Invalid channel", gin.H{"expected": releaseChannels})
+		return
+	}
+	rollout, err := parseRolloutPercentage(c.PostForm("rollout_percentage"))
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid rollout_percentage", gin.H{
+			"expected": "integer between 0 and 100",
+		})
+		return
+	}
+	minOSVersion, err := parseMinOSVersion(c.PostForm("min_os_version"))
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid min_os_version", gin.H{
+			"expected": "dotted numeric version, e.g. 8 or 14.2",
+		})
+		return
+	}
+	publishAt, err := parsePublishAt(c.PostForm("publish_at"))
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid publish_at", gin.H{
+			"expected": "RFC 3339 time, e.g. 2025-06-01T09:00:00Z",
+		})
+		return
+	}
+	// dry_run=true validates everything but writes nothing, for CI to fail early
+	dryRunField, err := parseOptionalBool(c.PostForm("dry_run"))
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid dry_run", gin.H{
+			"expected": "true or false",
+		})
+		return
+	}
+	dryRun := dryRunField != nil && *dryRunField
+
+	// Validate required fields
+	if version == "" || versionCodeStr == "" {
+		respondErrorDetails(c, http.StatusBadRequest, codeMissingFields, "Missing required fields", gin.H{
+			"required": []string{"version", "version_code"},
+		})
+		return
+	}
+
+	version, ok := normalizeVersion(version)
+	if !ok {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version", gin.H{
+			"expected": config.VersionPattern.String(),
+		})
+		return
+	}
+
+	// Validate version code
+	versionCode, err := strconv.Atoi(versionCodeStr)
+	if err != nil || versionCode <= 0 {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version_code", gin.H{
+			"expected": "positive integer",
+		})
+		return
+	}
+
+	// 3. Process file uploads: the universal build in "file" and/or split
+	// builds in file_<abi> fields
+	files, err := parseUploadFiles(c.Request.MultipartForm)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, err.Error(), gin.H{
+			"expected": "a file field and/or one file_<abi> field per ABI, e.g. file_arm64-v8a",
+		})
+		return
+	}
+
+	// CI can point at an artifact it already published instead of sending it
+	if raw := strings.TrimSpace(c.PostForm("source_url")); raw != "" {
+		if len(files) > 0 {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "Send either files or source_url, not both")
+			return
+		}
+		source, ok := parseSourceURL(raw)
+		if !ok {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid source_url", gin.H{
+				"expected": "absolute http or https URL",
+			})
+			return
+		}
+		fetched, tmp, err := fetchSource(ctx, source, maxUploadBytes())
+		var serr *sourceError
+		switch {
+		case errors.Is(err, errSourceTooLarge):
+			respondTooLarge(c, maxUploadBytes())
+			return
+		case errors.As(err, &serr):
+			loggerFrom(ctx).Warn("fetching upload source failed", "source_host", source.Host, "err", err)
+			respondErrorDetails(c, serr.status, serr.code, serr.message, serr.details)
+			return
+		case err != nil:
+			loggerFrom(ctx).Error("fetching upload source failed", "source_host", source.Host, "err", err)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to fetch source_url")
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		loggerFrom(ctx).Info("fetched upload source", "source_host", source.Host, "filename", fetched.Header.Filename, "file_size", fetched.Header.Size)
+		files = []uploadFile{fetched}
+	}
+	if len(files) == 0 {
+		respondError(c, http.StatusBadRequest, codeMissingFields, "No file uploaded")
+		return
+	}
+	for _, f := range files {
+		if limit := maxUploadBytes(); limit > 0 && f.Header.Size > limit {
+			respondTooLarge(c, limit)
+			return
+		}
+	}
+	file := files[0].Header
+
+	// Infer the platform from the file extension when it wasn't given
+	if platform == "" {
+		inferred, ok := inferPlatform(file.Filename)
+		if !ok {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Could not infer platform from file extension", gin.H{
+				"required": []string{"platform"},
+			})
+			return
+		}
+		platform = inferred
+	}
+	if !isSupportedPlatform(platform) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform", gin.H{
+			"expected": supportedPlatforms,
+		})
+		return
+	}
+	if !authorizePlatform(c, platform) {
+		return
+	}
+
+	// Run the platform-specific upload validation on every file
+	staged := make([]stagedUpload, len(files))
+	for i, f := range files {
+		src, err := f.open()
+		if err != nil {
+			loggerFrom(ctx).Error("file open failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to process uploaded file")
+			return
+		}
+		defer src.Close()
+
+		artifact := &UploadArtifact{
+			Platform:    platform,
+			Version:     version,
+			VersionCode: versionCode,
+			File:        f.Header,
+			Content:     src,
+		}
+		err = validatorFor(platform).Validate(artifact)
+		if err == nil {
+			err = checkFilenameConvention(artifact)
+		}
+		if err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				details := gin.H{}
+				if verr.Expected != "" {
+					details["expected"] = verr.Expected
+				}
+				if f.ABI != "" {
+					details["abi"] = f.ABI
+				}
+				respondErrorDetails(c, http.StatusBadRequest, codeInvalidFile, verr.Message, details)
+				return
+			}
+			loggerFrom(ctx).Error("upload validation failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to validate uploaded file")
+			return
+		}
+
+		// 4. Checksum the file before anything is written
+		sums, _, err := hashStream(src, "sha256")
+		if err == nil {
+			_, err = src.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("file read failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to process uploaded file")
+			return
+		}
+		staged[i] = stagedUpload{uploadFile: f, src: src, meta: artifact, checksum: sums["sha256"]}
+	}
+
+	// A retried upload of the same binary returns the existing record
+	duplicate, err := s.findVersionByChecksum(ctx, platform, staged[0].checksum)
+	if err != nil {
+		loggerFrom(ctx).Error("version lookup failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Could not check for existing versions")
+		return
+	}
+	if duplicate != nil {
+		duplicate.DownloadURL = s.downloadURL(duplicate.Version, platform)
+		response := gin.H{
+			"message":      "Identical file already uploaded",
+			"duplicate":    true,
+			"version":      duplicate,
+			"download_url": duplicate.DownloadURL,
+			"access":       artifactAccess(*duplicate),
+		}
+		if dryRun {
+			response["dry_run"] = true
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// 5. Lay out the objects and the record they would get
+	id := newPushID(time.Now())
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	artifacts := make([]Artifact, len(staged))
+	for i, f := range staged {
+		storagePath, ok := s.storagePathFor(platform, version, versionCode, f.ABI, strings.ToLower(filepath.Ext(f.Header.Filename)))
+		if !ok {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Version gives an unsafe storage path", gin.H{
+				"storage_path": storagePath,
+			})
+			return
+		}
+		// Paths must not repeat within the upload or replace a stored object
+		taken := slices.ContainsFunc(artifacts[:i], func(a Artifact) bool { return a.StoragePath == storagePath })
+		if !taken {
+			taken, err = s.storagePathTaken(ctx, storagePath)
+			if err != nil {
+				loggerFrom(ctx).Error("storage path check failed", "storage_path", storagePath, "err", err)
+				respondError(c, http.StatusInternalServerError, codeStorageError, "Could not check the storage path")
+				return
+			}
+		}
+		if taken {
+			respondErrorDetails(c, http.StatusConflict, codePathConflict, "Storage path already in use", gin.H{
+				"storage_path": storagePath,
+			})
+			return
+		}
+		artifacts[i] = Artifact{
+			ABI:         f.ABI,
+			StoragePath: storagePath,
+			FileSize:    f.Header.Size,
+			Checksum:    f.checksum,
+		}
+	}
+	now := time.Now()
+	appVersion := AppVersion{
+		ID:                    id,
+		Version:               version,
+		VersionCode:           versionCode,
+		DownloadURL:           s.downloadURL(version, platform),
+		ReleaseNotes:          releaseNotes,
+		FileSize:              artifacts[0].FileSize,
+		Checksum:              artifacts[0].Checksum,
+		CreatedAt:             now,
+		UpdatedAt:             now,
+		StoragePath:           artifacts[0].StoragePath,
+		Platform:              platform,
+		IsMandatory:           isMandatory,
+		Channel:               channel,
+		RolloutPercentage:     rollout,
+		MinOSVersion:          minOSVersion,
+		PackageName:           staged[0].meta.PackageName,
+		BundleID:              staged[0].meta.BundleID,
+		LocalizedReleaseNotes: localizedNotes,
+		PublishAt:             publishAt,
+		Artifacts:             artifacts,
+	}
+
+	// A dry run stops here: it checks the version code without claiming it,
+	// so a concurrent upload could still take the code before the real one
+	if dryRun {
+		exists, err := s.versionCodeExists(ctx, versionCode)
+		if err != nil {
+			loggerFrom(ctx).Error("version lookup failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Could not check for existing versions")
+			return
+		}
+		if exists {
+			respondError(c, http.StatusConflict, codeVersionExists, fmt.Sprintf("Version code %d already exists", versionCode))
+			return
+		}
+		loggerFrom(ctx).Info("upload dry run passed", "platform", platform, "version", version, "version_code", versionCode)
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Upload would succeed; nothing was stored",
+			"dry_run":      true,
+			"duplicate":    false,
+			"version":      appVersion,
+			"download_url": appVersion.DownloadURL,
+			"access":       artifactAccess(appVersion),
+		})
+		return
+	}
+
+	// 6. Claim the version code; a failed upload gives it back
+	claimed, err := s.claimVersionCode(ctx, versionCode, id)
+	if err != nil {
+		loggerFrom(ctx).Error("version lookup failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Could not check for existing versions")
+		return
+	}
+
+	if !claimed {
+		respondError(c, http.StatusConflict, codeVersionExists, fmt.Sprintf("Version code %d already exists", versionCode))
+		return
+	}
+	pending := &pendingUpload{s: s, code: versionCode, id: id}
+	defer pending.rollback(ctx)
+
+	// 7. Upload every file to storage
+	var torrent *torrentHasher
+	for i, f := range staged {
+		storagePath := artifacts[i].StoragePath
+		pending.objectPaths = append(pending.objectPaths, storagePath)
+		body := io.Reader(f.src)
+
+		// Peer-assisted distribution identifiers are computed from the same
+		// stream, for the primary artifact
+		if i == 0 {
+			if torrent = newDistributionHasher(); torrent != nil {
+				body = io.TeeReader(f.src, torrent)
+			}
+		}
+		if err := s.store.UploadObject(ctx, storagePath, body); err != nil {
+			loggerFrom(ctx).Error("file upload failed", "storage_path", storagePath, "err", err)
+			respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to upload file")
+			return
+		}
+	}
+
+	// The version is created once its files are stored
+	now = time.Now()
+	appVersion.CreatedAt, appVersion.UpdatedAt = now, now
+	appVersion.DistributionLinks = torrent.distributionLinks(version, ext)
+
+	// 8. Publish the objects and save the version record
+	if err := s.publishVersion(ctx, platform, appVersion); err != nil {
+		loggerFrom(ctx).Error("version save failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to save version information")
+		return
+	}
+
+	pending.commit()
+	s.audit(c, auditUpload, &appVersion, nil)
+
+	for _, a := range artifacts {
+		uploadBytes.WithLabelValues(platform).Observe(float64(a.FileSize))
+	}
+	uploadDuration.WithLabelValues(platform).Observe(time.Since(start).Seconds())
+	webhook.notify(ctx, ReleaseEvent{Type: EventVersionPublished, AppID: s.appID, Version: appVersion, Actor: actorFrom(c)})
+
+	// 9. Return success response
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Version uploaded successfully",
+		"duplicate":    false,
+		"version":      appVersion,
+		"download_url": appVersion.DownloadURL,
+		"access":       artifactAccess(appVersion),
+	})
+}
+
+func (s *Server) deleteVersion(c *gin.Context) {
+	err := s.deleteByID(c, c.Param("id"), c.GetHeader("If-Match"))
+	if errors.Is(err, errVersionNotFound) {
+		respondError(c, http.StatusNotFound, codeNotFound, "Version not found")
+		return
+	}
+	var stale *staleVersionError
+	if errors.As(err, &stale) {
+		respondPreconditionFailed(c, stale.current)
+		return
+	}
+	var forbidden *platformForbiddenError
+	if errors.As(err, &forbidden) {
+		respondPlatformForbidden(c, forbidden.platform)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to delete version")
+		return
+	}
+
+	// A trashed version can be restored until TRASH_RETENTION has passed
+	c.JSON(http.StatusOK, gin.H{"message": "Version deleted successfully", "trashed": config.TrashRetention > 0})
+}
+
+// errVersionNotFound is returned by deleteByID for an unknown id
+var errVersionNotFound = errors.New("version not found")
+
+// staleVersionError is returned by deleteByID when If-Match names an older
+// state of the version than current
+type staleVersionError struct {
+	current AppVersion
+}
+
+func (e *staleVersionError) Error() string {
+	return "version changed since it was read"
+}
+
+// platformForbiddenError is returned by deleteByID when the request's API
+// key is scoped to other platforms than the version's
+type platformForbiddenError struct {
+	platform string
+}
+
+func (e *platformForbiddenError) Error() string {
+	return "API key is not allowed to act on platform " + e.platform
+}
+
+// deleteByID moves a version to the trash, or with TRASH_RETENTION=0 deletes
+// its storage objects and record, then audits and announces the deletion.
+// Single and batch deletes share it; ifMatch is the single delete's If-Match.
+func (s *Server) deleteByID(c *gin.Context, id, ifMatch string) error {
+	ctx := requestContext(c)
+	if !isValidKey(id) {
+		return errVersionNotFound
+	}
+
+	// Get version info first
+	version, err := s.store.GetVersion(ctx, id)
+	if err != nil {
+		loggerFrom(ctx).Error("version read failed", "version_id", id, "err", err)
+		return err
+	}
+	if version == nil {
+		return errVersionNotFound
+	}
+	if platform := platformOf(*version); !keyAllowsPlatform(c, platform) {
+		return &platformForbiddenError{platform: platform}
+	}
+	if !ifMatchHolds(ifMatch, *version) {
+		return &staleVersionError{current: *version}
+	}
+
+	// Trash it, or delete from storage and DB
+	trashed := config.TrashRetention > 0
+	remove := s.removeVersion
+	if trashed {
+		remove = s.trashVersion
+	}
+	if err := remove(ctx, *version); err != nil {
+		loggerFrom(ctx).Error("version delete failed", "version_id", id, "err", err)
+		return err
+	}
+	s.audit(c, auditDelete, version, map[string]string{"trashed": strconv.FormatBool(trashed)})
+	webhook.notify(ctx, ReleaseEvent{Type: EventVersionDeleted, AppID: s.appID, Version: *version, Actor: actorFrom(c)})
+	return nil
+}
+
+// getLatestVersion returns the platform's highest released version code on a
+// channel, selected the same way as check-update but for no particular device
+func (s *Server) getLatestVersion(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Query("platform")
+	if !isSupportedPlatform(platform) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform", gin.H{
+			"expected": supportedPlatforms,
+		})
+		return
+	}
+	channel := c.DefaultQuery("channel", defaultChannel)
+	if !isReleaseChannel(channel) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidChannel, "Invalid channel", gin.H{"expected": releaseChannels})
+		return
+	}
+
+	// The version latest/<platform> names is the answer whenever it is
+	// offered on the channel, since no enabled build ranks above it
+	now := time.Now()
+	latest, _ := selectLatest(offeredVersions(s.latestWindow(ctx, platform), channel, now), platform)
+	if latest == nil {
+		versions, err := s.store.ListVersions(ctx)
+		if err != nil {
+			// Fall back to the last-known-good latest while the database is
+			// unavailable, as check-update does
+			snap, ok := s.latest.get(platform, channel)
+			if !ok || snap.latest == nil {
+				loggerFrom(ctx).Error("version fetch failed", "err", err)
+				respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+				return
+			}
+			loggerFrom(ctx).Warn("version fetch failed, serving stale latest",
+				"platform", platform, "fetched_at", snap.fetchedAt.Format(time.RFC3339), "err", err)
+			c.Header("X-Stale", "true")
+			latest = snap.latest
+		} else {
+			latest, _ = selectLatest(offeredVersions(versions, channel, now), platform)
+		}
+	}
+	if latest == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, fmt.Sprintf("No released version for %s", platform))
+		return
+	}
+
+	latest.DownloadURL = s.downloadURL(latest.Version, platform)
+	latest.Status = versionStatus(*latest, now)
+	c.JSON(http.StatusOK, latest)
+}
+
+// getVersionByID returns a single version record
+func (s *Server) getVersionByID(c *gin.Context) {
+	ctx := requestContext(c)
+	id := c.Param("id")
+	if !isValidKey(id) {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version id")
+		return
+	}
+
+	version, err := s.store.GetVersion(ctx, id)
+	if err != nil {
+		loggerFrom(ctx).Error("version read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	if version == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, fmt.Sprintf("Version %s not found", id))
+		return
+	}
+
+	version.DownloadURL = s.downloadURL(version.Version, version.Platform)
+	version.Status = versionStatus(*version, time.Now())
+	c.Header("ETag", recordETag(*version))
+	c.JSON(http.StatusOK, version)
+}
+
+// isValidKey reports whether s can be used as a Realtime Database key
+func isValidKey(s string) bool {
+	return s != "" && !strings.ContainsAny(s, ".#$[]/")
+}
+
+// VersionUpdate is the editable metadata of a version; omitted fields are left unchanged
+type VersionUpdate struct {
+	Version           *string `json:"version"`
+	ReleaseNotes      *string `json:"release_notes"`
+	IsMandatory       *bool   `json:"is_mandatory"`
+	Channel           *string `json:"channel"`
+	RolloutPercentage *int    `json:"rollout_percentage"`
+	MinOSVersion      *string `json:"min_os_version"`
+	// PublishAt reschedules the release; "" publishes it immediately
+	PublishAt *string `json:"publish_at"`
+	// LocalizedReleaseNotes replaces all localized notes; {} removes them
+	LocalizedReleaseNotes map[string]string `json:"localized_release_notes"`
+}
+
+// fields lists the JSON names of the fields the request sets
+func (req VersionUpdate) fields() []string {
+	var fields []string
+	for name, set := range map[string]bool{
+		"version":                 req.Version != nil,
+		"release_notes":           req.ReleaseNotes != nil,
+		"localized_release_notes": req.LocalizedReleaseNotes != nil,
+		"is_mandatory":            req.IsMandatory != nil,
+		"channel":                 req.Channel != nil,
+		"rollout_percentage":      req.RolloutPercentage != nil,
+		"min_os_version":          req.MinOSVersion != nil,
+		"publish_at":              req.PublishAt != nil,
+	} {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// updateVersion edits a version's metadata in place. The artifact itself
+// (storage path, size, checksum) is never touched, so download URLs keep working.
+func (s *Server) updateVersion(c *gin.Context) {
+	ctx := requestContext(c)
+	var req VersionUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	version, err := s.store.GetVersion(ctx, c.Param("id"))
+	if err != nil {
+		loggerFrom(ctx).Error("version read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	if version == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, "Version not found")
+		return
+	}
+	if !authorizePlatform(c, platformOf(*version)) {
+		return
+	}
+	// An edit based on an older read would undo whatever changed since
+	if !ifMatchHolds(c.GetHeader("If-Match"), *version) {
+		respondPreconditionFailed(c, *version)
+		return
+	}
+
+	if req.Version != nil {
+		name := strings.TrimSpace(*req.Version)
+		if name == "" {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version", gin.H{
+				"expected": "non-empty string",
+			})
+			return
+		}
+		name, ok := normalizeVersion(name)
+		if !ok {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version", gin.H{
+				"expected": config.VersionPattern.String(),
+			})
+			return
+		}
+		// Downloads are addressed by version string, so it must stay unique per platform
+		if name != version.Version {
+			versions, err := s.store.ListVersions(ctx)
+			if err != nil {
+				loggerFrom(ctx).Error("version fetch failed", "err", err)
+				respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+				return
+			}
+			for _, v := range versions {
+				if v.Version == name && matchesPlatform(v, version.Platform) {
+					respondError(c, http.StatusConflict, codeVersionExists, fmt.Sprintf("Version %s already exists", name))
+					return
+				}
+			}
+		}
+		version.Version = name
+	}
+	if req.ReleaseNotes != nil {
+		version.ReleaseNotes = strings.TrimSpace(*req.ReleaseNotes)
+	}
+	if req.LocalizedReleaseNotes != nil {
+		fields := map[string][]string{}
+		for locale, text := range req.LocalizedReleaseNotes {
+			fields[localizedNotesField+locale] = []string{text}
+		}
+		notes, err := parseLocalizedNotes(fields)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, err.Error(), gin.H{
+				"expected": "locale keys such as es or pt-BR",
+			})
+			return
+		}
+		version.LocalizedReleaseNotes = notes
+	}
+	if req.IsMandatory != nil {
+		version.IsMandatory = req.IsMandatory
+	}
+	if req.Channel != nil {
+		// Promoting a build, e.g. beta to stable, is just a channel change
+		if !isReleaseChannel(*req.Channel) {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidChannel, "Invalid channel", gin.H{"expected": releaseChannels})
+			return
+		}
+		version.Channel = *req.Channel
+	}
+	if req.RolloutPercentage != nil {
+		// Ramping a staged rollout, e.g. 5 -> 25 -> 100
+		if !validRolloutPercentage(*req.RolloutPercentage) {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid rollout_percentage", gin.H{
+				"expected": "integer between 0 and 100",
+			})
+			return
+		}
+		if version.RolloutPaused && *req.RolloutPercentage != rolloutPercentage(*version) {
+			respondErrorDetails(c, http.StatusConflict, codeRolloutPaused, "Rollout is paused; resume it before changing rollout_percentage", gin.H{
+				"rollout_percentage": rolloutPercentage(*version),
+			})
+			return
+		}
+		version.RolloutPercentage = req.RolloutPercentage
+	}
+	if req.MinOSVersion != nil {
+		// "" clears the requirement
+		minOS, err := parseMinOSVersion(*req.MinOSVersion)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid min_os_version", gin.H{
+				"expected": "dotted numeric version, e.g. 8 or 14.2",
+			})
+			return
+		}
+		version.MinOSVersion = minOS
+	}
+	if req.PublishAt != nil {
+		publishAt, err := parsePublishAt(*req.PublishAt)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid publish_at", gin.H{
+				"expected": "RFC 3339 time, e.g. 2025-06-01T09:00:00Z",
+			})
+			return
+		}
+		version.PublishAt = publishAt
+	}
+	version.DownloadURL = s.downloadURL(version.Version, version.Platform)
+	version.UpdatedAt = time.Now()
+
+	err = s.store.UpdateVersions(ctx, []AppVersion{*version},
+		"version", "release_notes", "localized_release_notes", "is_mandatory", "channel", "rollout_percentage", "min_os_version", "publish_at", "download_url", "updated_at")
+	if err != nil {
+		loggerFrom(ctx).Error("version save failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to save version information")
+		return
+	}
+	s.audit(c, auditUpdate, version, map[string]string{"fields": strings.Join(req.fields(), ",")})
+
+	c.Header("ETag", recordETag(*version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Version updated successfully",
+		"version": version,
+	})
+}
+
+// sha256Digest converts a stored hex SHA-256 checksum into the base64 form
+// used by the Digest and Repr-Digest headers
+func sha256Digest(checksum string) (string, bool) {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) != sha256.Size {
+		return "", false
+	}
+	return base64.StdEncoding.EncodeToString(sum), true
+}
+
+// versionETag derives a strong entity tag from the stored checksum, or "" when there is none
+func versionETag(v *AppVersion) string {
+	if v.Checksum == "" {
+		return ""
+	}
+	return `"` + v.Checksum + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison RFC 9110 prescribes for that header
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// recordETag is a version record's entity tag for If-Match: its updated_at
+// in Unix nanoseconds, quoted. Every change to the record moves updated_at,
+// downloads (which only count) don't. It differs from versionETag, which
+// names the artifact.
+func recordETag(v AppVersion) string {
+	return `"` + strconv.FormatInt(v.UpdatedAt.UnixNano(), 10) + `"`
+}
+
+// ifMatchHolds reports whether an If-Match header permits changing v: when
+// it is absent or "*", or lists v's recordETag or its exact updated_at as an
+// RFC 3339 time. Entity tags compare strongly, so weak ones never match.
+func ifMatchHolds(header string, v AppVersion) bool {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return true
+	}
+	etag := recordETag(v)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == etag {
+			return true
+		}
+		if t, err := time.Parse(time.RFC3339Nano, strings.Trim(candidate, `"`)); err == nil && t.Equal(v.UpdatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// respondPreconditionFailed answers a failed If-Match with the record's
+// current tag, so the client can re-read and retry
+func respondPreconditionFailed(c *gin.Context, current AppVersion) {
+	c.Header("ETag", recordETag(current))
+	respondErrorDetails(c, http.StatusPreconditionFailed, codePreconditionFailed, "Version changed since it was read", gin.H{
+		"etag":       recordETag(current),
+		"updated_at": current.UpdatedAt,
+	})
+}
+
+// notModifiedSince reports whether an If-Modified-Since header is at or after
+// modified, which HTTP dates compare to the second
+func notModifiedSince(header string, modified time.Time) bool {
+	if header == "" || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// publishVersion turns a fully written storage object into a released
+// version. The record and its aggregates are saved in one atomic update; if
+// that fails the caller's pendingUpload deletes the object again.
+func (s *Server) publishVersion(ctx context.Context, platform string, v AppVersion) error {
+	// Objects stay private unless PUBLIC_ARTIFACTS opts in; clients then
+	// download through this server or a signed URL
+	if config.PublicArtifacts {
+		for _, a := range artifactsOf(v) {
+			if err := s.store.PublishObject(ctx, a.StoragePath); err != nil {
+				loggerFrom(ctx).Warn("setting public access failed", "storage_path", a.StoragePath, "err", err)
+			}
+		}
+	}
+
+	if err := s.store.PutVersion(ctx, v); err != nil {
+		return err
+	}
+	s.trackLatest(ctx, v, false)
+	s.notifyNewVersion(ctx, v)
+
+	// Enforce the per-platform retention limit, never failing the upload over it
+	if keep := config.KeepLastN; keep > 0 {
+		pruned, err := s.pruneVersions(ctx, platform, keep)
+		if err != nil {
+			loggerFrom(ctx).Warn("pruning old versions failed", "platform", platform, "err", err)
+		}
+		for _, v := range pruned {
+			loggerFrom(ctx).Info("pruned version", "platform", platform, "version", v.Version, "version_code", v.VersionCode)
+			s.auditSystem(ctx, auditPrune, &v, map[string]string{"keep": strconv.Itoa(keep)})
+		}
+	}
+	return nil
+}
+
+// artifactAccess tells the uploader who can read the stored file and how
+func artifactAccess(v AppVersion) gin.H {
+	if !config.PublicArtifacts || config.Store != "firebase" {
+		return gin.H{
+			"public": false,
+			"note":   "The file is private; clients download it through download_url or a signed URL from /download-url",
+		}
+	}
+	return gin.H{
+		"public":     true,
+		"public_url": fmt.Sprintf("https://storage.googleapis.com/%s/%s", config.StorageBucket, v.StoragePath),
+		"note":       "PUBLIC_ARTIFACTS is on: anyone with public_url can download the file, bypassing API keys, rollout and disabled-version checks",
+	}
+}
+
+// removeVersion deletes a version's storage objects and its database record.
+// A missing storage object is only logged so the record can still be removed.
+func (s *Server) removeVersion(ctx context.Context, v AppVersion) error {
+	for _, a := range artifactsOf(v) {
+		if err := s.store.DeleteObject(ctx, a.StoragePath); err != nil {
+			loggerFrom(ctx).Warn("deleting file from storage failed", "storage_path", a.StoragePath, "err", err)
+		}
+	}
+	for _, p := range v.Patches {
+		if err := s.store.DeleteObject(ctx, p.StoragePath); err != nil {
+			loggerFrom(ctx).Warn("deleting patch from storage failed", "storage_path", p.StoragePath, "err", err)
+		}
+	}
+	if err := s.store.DeleteVersion(ctx, v); err != nil {
+		return err
+	}
+	s.trackLatest(ctx, v, true)
+	s.releaseVersionCode(ctx, v.VersionCode, v.ID)
+	return nil
+}
+
+// pruneVersions deletes all but the keep newest versions of a platform and
+// returns the versions that were removed. Retained versions (mandatory or in
+// a staged rollout) and each channel's highest enabled version, which after a
+// rollback may be well below keep, are never deleted.
+func (s *Server) pruneVersions(ctx context.Context, platform string, keep int) ([]AppVersion, error) {
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []AppVersion
+	for _, v := range versions {
+		if matchesPlatform(v, platform) {
+			candidates = append(candidates, v)
+		}
+	}
+	if len(candidates) <= keep {
+		return nil, nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].VersionCode > candidates[j].VersionCode
+	})
+	// Sorted newest first, so the first enabled version of a channel is the one it offers
+	offered, channels := map[string]bool{}, map[string]bool{}
+	for _, v := range candidates {
+		if !channels[v.Channel] && isEnabled(v) {
+			channels[v.Channel] = true
+			offered[v.ID] = true
+		}
+	}
+
+	var pruned []AppVersion
+	for _, v := range candidates[keep:] {
+		if isRetained(v) || offered[v.ID] {
+			continue
+		}
+		if err := s.removeVersion(ctx, v); err != nil {
+			return pruned, fmt.Errorf("deleting version %s: %w", v.ID, err)
+		}
+		pruned = append(pruned, v)
+	}
+	return pruned, nil
+}
+
+// versionCodeExists reports whether any stored version already uses code
+func (s *Server) versionCodeExists(ctx context.Context, code int) (bool, error) {
+	existing, err := s.store.FindVersions(ctx, "version_code", code)
+	if err != nil {
+		return false, err
+	}
+	return len(existing) > 0, nil
+}
+
+// claimVersionCode reserves code for the version about to be published with
+// id. Records from before claims existed hold none, so they are looked up
+// first; the claim itself is atomic, so of concurrent uploads with the same
+// code only one gets true.
+func (s *Server) claimVersionCode(ctx context.Context, code int, id string) (bool, error) {
+	exists, err := s.versionCodeExists(ctx, code)
+	if err != nil || exists {
+		return false, err
+	}
+	return s.store.ClaimVersionCode(ctx, code, id)
+}
+
+// releaseVersionCode frees a claim after a failed upload or a deletion
+func (s *Server) releaseVersionCode(ctx context.Context, code int, id string) {
+	if err := s.store.ReleaseVersionCode(ctx, code, id); err != nil {
+		loggerFrom(ctx).Warn("releasing version code failed", "version_code", code, "version_id", id, "err", err)
+	}
+}
+
+// pendingUpload is what an upload has written before its version record is
+// saved. Deferring rollback undoes it on every failure path: the storage
+// object, complete or partial, is deleted and the version code freed. Once
+// the version is published, commit turns rollback into a no-op.
+type pendingUpload struct {
+	s           *Server
+	code        int
+	id          string
+	objectPaths []string // each added before its first byte is written
+	committed   bool
+}
+
+func (p *pendingUpload) commit() {
+	p.committed = true
+}
+
+func (p *pendingUpload) rollback(ctx context.Context) {
+	if p.committed {
+		return
+	}
+	for _, path := range p.objectPaths {
+		err := p.s.store.DeleteObject(ctx, path)
+		if err != nil && !errors.Is(err, errObjectNotFound) {
+			loggerFrom(ctx).Error("cleaning up uploaded file failed", "storage_path", path, "err", err)
+		}
+	}
+	p.s.releaseVersionCode(ctx, p.code, p.id)
+}
+
+// findVersionByChecksum returns the platform's version whose artifact has the
+// given SHA-256, or nil
+func (s *Server) findVersionByChecksum(ctx context.Context, platform, checksum string) (*AppVersion, error) {
+	versions, err := s.store.FindVersions(ctx, "checksum", checksum)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if matchesPlatform(v, platform) {
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+// versionWrites returns every database location that has to change when a
+// version is published, keyed by path from the database root. Aggregate nodes
+// derived from the version list belong here so they are written in the same
+// multi-location update as the record itself and can never lag behind it.
+func versionWrites(v AppVersion) map[string]interface{} {
+	writes := map[string]interface{}{
+		"versions/" + v.ID: v,
+	}
+	for path, value := range versionIndexWrites(v) {
+		writes[path] = value
+	}
+	return writes
+}
+
+// versionIndexWrites is a version's entry in versionIndex/<platform>/<code>,
+// which lets check-update read a platform's newest versions without the rest
+func versionIndexWrites(v AppVersion) map[string]interface{} {
+	platform := platformOf(v)
+	if platform == "" || v.VersionCode <= 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		fmt.Sprintf("versionIndex/%s/%d", platform, v.VersionCode): v.ID,
+	}
+}
+
+const pushIDChars = "-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+
+// newPushID generates a chronologically sortable key in the same format as
+// Firebase push IDs, so a record can be written without a separate Push call.
+func newPushID(t time.Time) string {
+	id := make([]byte, 20)
+	ms := t.UnixMilli()
+	for i := 7; i >= 0; i-- {
+		id[i] = pushIDChars[ms%64]
+		ms /= 64
+	}
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		log.Fatalf("Failed to generate push ID: %v", err)
+	}
+	for i, b := range random {
+		id[8+i] = pushIDChars[b%64]
+	}
+	return string(id)
+}
@@ -0,0 +1,793 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testAPIKey = "test-key"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	// Request logs would bury test failures
+	log.SetOutput(io.Discard)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// setConfig loads config from the memory store defaults plus env, given as
+// NAME=value pairs, and restores the previous config when the test ends
+func setConfig(t testing.TB, env ...string) {
+	t.Helper()
+	t.Setenv("OTA_STORE", "memory")
+	t.Setenv("OTA_API_KEYS", testAPIKey)
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		t.Setenv(name, value)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	previous := config
+	config = cfg
+	t.Cleanup(func() { config = previous })
+}
+
+// testServer is a single-app Server on a memory store behind the real router
+type testServer struct {
+	*Server
+	t      testing.TB
+	router http.Handler
+}
+
+func newTestServer(t *testing.T, env ...string) *testServer {
+	t.Helper()
+	return newTestServerWithStore(t, newMemoryStore(), env...)
+}
+
+// newTestServerWithStore is newTestServer on store, e.g. a memory store
+// wrapped to inject failures
+func newTestServerWithStore(t testing.TB, store Store, env ...string) *testServer {
+	t.Helper()
+	setConfig(t, env...)
+	s := newServer("", store, nil)
+	apps := newTenants(nil, func(string) *Server { return s })
+	return &testServer{Server: s, t: t, router: newRouter(apps)}
+}
+
+// seed stores a version and its file as an upload would, filling in what v
+// leaves blank, and returns the saved record
+func (ts *testServer) seed(v AppVersion) AppVersion {
+	ts.t.Helper()
+	ctx := context.Background()
+	if v.Platform == "" {
+		v.Platform = "android"
+	}
+	if v.ID == "" {
+		v.ID = fmt.Sprintf("%s-%d", v.Platform, v.VersionCode)
+	}
+	if v.Version == "" {
+		v.Version = fmt.Sprintf("1.0.%d", v.VersionCode)
+	}
+	if v.Channel == "" {
+		v.Channel = "stable"
+	}
+	if v.StoragePath == "" {
+		v.StoragePath = fmt.Sprintf("releases/%s/%s.apk", v.Platform, v.ID)
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now().Add(-time.Hour)
+	}
+	content := []byte("PK\x03\x04" + v.ID)
+	sum := sha256.Sum256(content)
+	v.FileSize, v.Checksum = int64(len(content)), hex.EncodeToString(sum[:])
+	if err := ts.store.UploadObject(ctx, v.StoragePath, bytes.NewReader(content)); err != nil {
+		ts.t.Fatalf("seeding %s: %v", v.ID, err)
+	}
+	if err := ts.publishVersion(ctx, v.Platform, v); err != nil {
+		ts.t.Fatalf("seeding %s: %v", v.ID, err)
+	}
+	return v
+}
+
+// do sends a request through the router with apiKey, if any; a non-nil body
+// is sent as JSON
+func (ts *testServer) do(method, target string, body any, apiKey string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var r io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		r = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return ts.send(req, apiKey)
+}
+
+// upload posts a multipart upload of content as filename with fields
+func (ts *testServer) upload(target string, fields map[string]string, filename string, content []byte, apiKey string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	if filename != "" {
+		fw, err := mw.CreateFormFile("file", filename)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		fw.Write(content)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return ts.send(req, apiKey)
+}
+
+func (ts *testServer) send(req *http.Request, apiKey string) *httptest.ResponseRecorder {
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// checkUpdate asks check-update what a device running currentCode is offered
+func (ts *testServer) checkUpdate(req UpdateCheckRequest) UpdateCheckResponse {
+	ts.t.Helper()
+	if req.Platform == "" {
+		req.Platform = "android"
+	}
+	if req.CurrentVersion == "" {
+		req.CurrentVersion = fmt.Sprintf("1.0.%d", req.CurrentCode)
+	}
+	w := ts.do(http.MethodPost, "/api/v1/ota/check-update", req, "")
+	if w.Code != http.StatusOK {
+		ts.t.Fatalf("check-update: %d %s", w.Code, w.Body)
+	}
+	var resp UpdateCheckResponse
+	decodeJSON(ts.t, w, &resp)
+	return resp
+}
+
+// decodeJSON decodes a JSON response body into v
+func decodeJSON(t testing.TB, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body.String(), err)
+	}
+}
+
+// errorCode returns the code of a JSON error response
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding error response %q: %v", w.Body.String(), err)
+	}
+	return body.Code
+}
+
+func TestDownloadDowngrades(t *testing.T) {
+	tests := []struct {
+		name        string
+		blocked     bool
+		rollback    bool
+		version     string
+		currentCode string
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "older blocked", blocked: true, version: "1.0.2", currentCode: "3", wantStatus: http.StatusForbidden, wantCode: codeDowngradeBlocked},
+		{name: "same allowed", blocked: true, version: "1.0.3", currentCode: "3", wantStatus: http.StatusOK},
+		{name: "newer allowed", blocked: true, version: "1.0.3", currentCode: "1", wantStatus: http.StatusOK},
+		{name: "no current code", blocked: true, version: "1.0.1", wantStatus: http.StatusOK},
+		{name: "not blocking", version: "1.0.1", currentCode: "3", wantStatus: http.StatusOK},
+		{name: "invalid current code", blocked: true, version: "1.0.1", currentCode: "x", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+		{name: "rollback target allowed", blocked: true, rollback: true, version: "1.0.2", currentCode: "3", wantStatus: http.StatusOK},
+		{name: "below rollback target blocked", blocked: true, rollback: true, version: "1.0.1", currentCode: "3", wantStatus: http.StatusForbidden, wantCode: codeDowngradeBlocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, fmt.Sprintf("BLOCK_DOWNGRADES=%t", tt.blocked))
+			for code := 1; code <= 3; code++ {
+				ts.seed(AppVersion{VersionCode: code})
+			}
+			if tt.rollback {
+				if w := ts.do(http.MethodPost, "/api/v1/ota/rollback", gin.H{"version_id": "android-2"}, testAPIKey); w.Code != http.StatusOK {
+					t.Fatalf("rollback: %d %s", w.Code, w.Body)
+				}
+			}
+
+			target := "/api/v1/ota/download/" + tt.version + "?platform=android"
+			if tt.currentCode != "" {
+				target += "&current_code=" + tt.currentCode
+			}
+			w := ts.do(http.MethodGet, target, nil, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestMandatoryPrecedence(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name        string
+		env         []string
+		mandatory   map[int]*bool // is_mandatory per version code 2..4; 1 is the client's
+		minCode     int
+		currentCode int
+		want        bool
+	}{
+		{name: "flag true one behind", mandatory: map[int]*bool{4: &yes}, currentCode: 3, want: true},
+		{name: "skipped flag true", mandatory: map[int]*bool{2: &yes, 4: &no}, currentCode: 1, want: true},
+		{name: "flags false far behind", mandatory: map[int]*bool{2: &no, 3: &no, 4: &no}, currentCode: 1, want: false},
+		{name: "one flag false stops heuristic", mandatory: map[int]*bool{3: &no}, currentCode: 1, want: false},
+		{name: "floor beats flag false", mandatory: map[int]*bool{4: &no}, minCode: 4, currentCode: 3, want: true},
+		{name: "floor one behind", minCode: 4, currentCode: 3, want: true},
+		{name: "heuristic default two behind", currentCode: 2, want: true},
+		{name: "heuristic default one behind", currentCode: 3, want: false},
+		{name: "heuristic threshold raised", env: []string{"MANDATORY_CODES_BEHIND=3"}, currentCode: 2, want: false},
+		{name: "heuristic threshold reached", env: []string{"MANDATORY_CODES_BEHIND=3"}, currentCode: 1, want: true},
+		{name: "heuristic threshold one", env: []string{"MANDATORY_CODES_BEHIND=1"}, currentCode: 3, want: true},
+		{name: "heuristic off", env: []string{"MANDATORY_CODES_BEHIND=0"}, currentCode: 1, want: false},
+		{name: "heuristic off keeps flags", env: []string{"MANDATORY_CODES_BEHIND=0"}, mandatory: map[int]*bool{4: &yes}, currentCode: 1, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.env...)
+			for code := 1; code <= 4; code++ {
+				ts.seed(AppVersion{VersionCode: code, IsMandatory: tt.mandatory[code]})
+			}
+			if tt.minCode != 0 {
+				w := ts.do(http.MethodPut, "/api/v1/ota/platforms/android/min-supported-code", gin.H{"min_supported_code": tt.minCode}, testAPIKey)
+				if w.Code != http.StatusOK {
+					t.Fatalf("min-supported-code: %d %s", w.Code, w.Body)
+				}
+			}
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: tt.currentCode})
+			if !resp.UpdateAvailable {
+				t.Fatal("no update offered")
+			}
+			if resp.IsMandatory != tt.want {
+				t.Errorf("is_mandatory = %t, want %t", resp.IsMandatory, tt.want)
+			}
+		})
+	}
+}
+
+func TestMandatoryCodesBehindConfig(t *testing.T) {
+	t.Setenv("OTA_STORE", "memory")
+	t.Setenv("OTA_API_KEYS", testAPIKey)
+	for _, raw := range []string{"-1", "two"} {
+		t.Setenv("MANDATORY_CODES_BEHIND", raw)
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("MANDATORY_CODES_BEHIND=%s loaded without error", raw)
+		}
+	}
+}
+
+func TestCheckUpdatePreviousVersion(t *testing.T) {
+	off := false
+	tests := []struct {
+		name         string
+		env          []string
+		versions     []AppVersion
+		channel      string
+		include      bool
+		wantPrevious int // 0 = no previous_version
+	}{
+		{name: "previous build", versions: []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3}}, include: true, wantPrevious: 2},
+		{name: "not requested", versions: []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3}}, wantPrevious: 0},
+		{name: "only one version", versions: []AppVersion{{VersionCode: 3}}, include: true, wantPrevious: 0},
+		{name: "skips disabled", versions: []AppVersion{{VersionCode: 1}, {VersionCode: 2, Enabled: &off}, {VersionCode: 3}}, include: true, wantPrevious: 1},
+		{name: "stable channel skips beta", versions: []AppVersion{{VersionCode: 1}, {VersionCode: 2, Channel: "beta"}, {VersionCode: 3}}, include: true, wantPrevious: 1},
+		{name: "beta channel includes beta", versions: []AppVersion{{VersionCode: 1}, {VersionCode: 2, Channel: "beta"}, {VersionCode: 3}}, channel: "beta", include: true, wantPrevious: 2},
+		{name: "beyond the read window", env: []string{"CHECK_UPDATE_WINDOW=1"}, versions: []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3}}, include: true, wantPrevious: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.env...)
+			for _, v := range tt.versions {
+				ts.seed(v)
+			}
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1, Channel: tt.channel, IncludePrevious: tt.include})
+			if resp.LatestVersion == nil || resp.LatestVersion.VersionCode != 3 {
+				t.Fatalf("latest = %+v, want code 3", resp.LatestVersion)
+			}
+			got := 0
+			if resp.PreviousVersion != nil {
+				got = resp.PreviousVersion.VersionCode
+			}
+			if got != tt.wantPrevious {
+				t.Errorf("previous_version code = %d, want %d", got, tt.wantPrevious)
+			}
+		})
+	}
+}
+
+func TestEmptyDatabase(t *testing.T) {
+	tests := []struct {
+		method     string
+		target     string
+		body       any
+		admin      bool
+		wantStatus int
+		wantBody   string // when set, the exact response body
+	}{
+		{http.MethodPost, "/api/v1/ota/check-update", UpdateCheckRequest{CurrentVersion: "1.0.0", CurrentCode: 1, Platform: "android"}, false, http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/ota/versions", nil, false, http.StatusOK, "[]"},
+		{http.MethodGet, "/api/v1/ota/versions?trashed=true", nil, false, http.StatusOK, "[]"},
+		{http.MethodGet, "/api/v1/ota/versions?limit=10", nil, false, http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/ota/versions/latest?platform=android", nil, false, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v1/ota/versions/missing", nil, false, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v1/ota/download/1.0.0?platform=android", nil, false, http.StatusNotFound, ""},
+		{http.MethodHead, "/api/v1/ota/download/1.0.0?platform=android", nil, false, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v1/ota/download-url/1.0.0?platform=android", nil, false, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v1/ota/whatsnew?platform=android&since_code=1", nil, false, http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/ota/review?platform=android&candidate=2&baseline=1", nil, false, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v1/ota/patch?platform=android&from=1&to=2", nil, false, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v1/ota/stats", nil, false, http.StatusOK, ""},
+		{http.MethodPut, "/api/v1/ota/versions/missing", VersionUpdate{ReleaseNotes: new(string)}, true, http.StatusNotFound, ""},
+		{http.MethodDelete, "/api/v1/ota/versions/missing", nil, true, http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/ota/versions/missing/restore", nil, true, http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/ota/versions/delete-batch", []string{"missing"}, true, http.StatusOK, ""},
+		{http.MethodPost, "/api/v1/ota/versions/missing/disable", nil, true, http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/ota/versions/missing/rollout/pause", nil, true, http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/ota/rollback", gin.H{"version_id": "missing"}, true, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v1/ota/versions/missing/verify", nil, true, http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/ota/versions/missing/rehash", nil, true, http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/ota/verify-all", nil, true, http.StatusOK, ""},
+		{http.MethodPost, "/api/v1/ota/gc", nil, true, http.StatusOK, ""},
+		{http.MethodPost, "/api/v1/ota/prune?platform=android&keep=1", nil, true, http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/ota/audit", nil, true, http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/ota/platforms/android/policy", nil, true, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			ts := newTestServer(t)
+			key := ""
+			if tt.admin {
+				key = testAPIKey
+			}
+			w := ts.do(tt.method, tt.target, tt.body, key)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGetVersionsOrder(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []string
+	}{
+		{name: "default oldest first", query: "", wantIDs: []string{"android-3", "android-1", "android-4", "android-2"}},
+		{name: "created_at descending", query: "?sort=-created_at", wantIDs: []string{"android-2", "android-4", "android-1", "android-3"}},
+		{name: "version_code", query: "?sort=version_code", wantIDs: []string{"android-1", "android-2", "android-3", "android-4"}},
+		{name: "version_code descending", query: "?sort=-version_code", wantIDs: []string{"android-4", "android-3", "android-2", "android-1"}},
+		{name: "paginated defaults to newest first", query: "?limit=2", wantIDs: []string{"android-2", "android-4"}},
+		{name: "paginated with sort", query: "?limit=2&offset=1&sort=version_code", wantIDs: []string{"android-2", "android-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			// android-1 and android-4 share a timestamp, so ID breaks the tie
+			ts.seed(AppVersion{VersionCode: 3, CreatedAt: base})
+			ts.seed(AppVersion{VersionCode: 1, CreatedAt: base.Add(time.Minute)})
+			ts.seed(AppVersion{VersionCode: 4, CreatedAt: base.Add(time.Minute)})
+			ts.seed(AppVersion{VersionCode: 2, CreatedAt: base.Add(2 * time.Minute)})
+
+			// Map iteration is random, so one lucky run proves little
+			for range 10 {
+				w := ts.do(http.MethodGet, "/api/v1/ota/versions"+tt.query, nil, "")
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d (%s)", w.Code, w.Body)
+				}
+				var versions []AppVersion
+				if strings.Contains(tt.query, "limit") {
+					var page struct {
+						Versions []AppVersion `json:"versions"`
+					}
+					decodeJSON(t, w, &page)
+					versions = page.Versions
+				} else {
+					decodeJSON(t, w, &versions)
+				}
+				var ids []string
+				for _, v := range versions {
+					ids = append(ids, v.ID)
+				}
+				if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+					t.Fatalf("order = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestGetVersionsInvalidSort(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodGet, "/api/v1/ota/versions?sort=size", nil, "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	if code := errorCode(t, w); code != codeInvalidRequest {
+		t.Errorf("code = %q, want %q", code, codeInvalidRequest)
+	}
+}
+
+func TestWhatsNew(t *testing.T) {
+	no := false
+	later := time.Now().Add(time.Hour)
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCodes  []int
+	}{
+		{name: "versions above since_code", query: "platform=android&since_code=1", wantStatus: http.StatusOK, wantCodes: []int{2, 3, 4}},
+		{name: "from scratch", query: "platform=android&since_code=0", wantStatus: http.StatusOK, wantCodes: []int{1, 2, 3, 4}},
+		{name: "up to date", query: "platform=android&since_code=4", wantStatus: http.StatusOK, wantCodes: []int{}},
+		{name: "ahead of the server", query: "platform=android&since_code=100", wantStatus: http.StatusOK, wantCodes: []int{}},
+		{name: "beta channel includes stable", query: "platform=android&since_code=3&channel=beta", wantStatus: http.StatusOK, wantCodes: []int{4, 5}},
+		{name: "other platform", query: "platform=ios&since_code=0", wantStatus: http.StatusOK, wantCodes: []int{8}},
+		{name: "missing since_code", query: "platform=android", wantStatus: http.StatusBadRequest},
+		{name: "negative since_code", query: "platform=android&since_code=-1", wantStatus: http.StatusBadRequest},
+		{name: "invalid platform", query: "platform=symbian&since_code=1", wantStatus: http.StatusBadRequest},
+		{name: "invalid channel", query: "platform=android&since_code=1&channel=nightly", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			yes := true
+			for code := 1; code <= 4; code++ {
+				v := AppVersion{VersionCode: code, ReleaseNotes: fmt.Sprintf("notes %d", code)}
+				if code == 3 {
+					v.IsMandatory = &yes
+				}
+				ts.seed(v)
+			}
+			ts.seed(AppVersion{VersionCode: 5, Channel: "beta"})
+			ts.seed(AppVersion{VersionCode: 6, Enabled: &no})
+			ts.seed(AppVersion{VersionCode: 7, PublishAt: &later})
+			ts.seed(AppVersion{VersionCode: 8, Platform: "ios"})
+			ts.seed(AppVersion{VersionCode: 9})
+			if w := ts.do(http.MethodDelete, "/api/v1/ota/versions/android-9", nil, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("delete: %d %s", w.Code, w.Body)
+			}
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/whatsnew?"+tt.query, nil, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if len(tt.wantCodes) == 0 && strings.TrimSpace(w.Body.String()) != "[]" {
+				t.Errorf("body = %s, want []", w.Body)
+			}
+			var entries []WhatsNewEntry
+			decodeJSON(t, w, &entries)
+			codes := []int{}
+			for _, e := range entries {
+				codes = append(codes, e.VersionCode)
+				if e.VersionCode <= 4 && e.ReleaseNotes != fmt.Sprintf("notes %d", e.VersionCode) {
+					t.Errorf("code %d release_notes = %q", e.VersionCode, e.ReleaseNotes)
+				}
+				if e.Mandatory != (e.VersionCode == 3) {
+					t.Errorf("code %d mandatory = %t", e.VersionCode, e.Mandatory)
+				}
+			}
+			if fmt.Sprint(codes) != fmt.Sprint(tt.wantCodes) {
+				t.Errorf("codes = %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestWhatsNewMandatoryPolicy(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		own    *bool
+		policy *bool
+		want   bool
+	}{
+		{name: "neither", want: false},
+		{name: "own flag", own: &yes, want: true},
+		{name: "policy", policy: &yes, want: true},
+		{name: "own flag wins over policy", own: &no, policy: &yes, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1, IsMandatory: tt.own})
+			if w := ts.do(http.MethodPut, "/api/v1/ota/platforms/android/policy", PlatformPolicy{IsMandatory: tt.policy}, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("set policy: %d %s", w.Code, w.Body)
+			}
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/whatsnew?platform=android&since_code=0", nil, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", w.Code, w.Body)
+			}
+			var entries []WhatsNewEntry
+			decodeJSON(t, w, &entries)
+			if len(entries) != 1 || entries[0].Mandatory != tt.want {
+				t.Errorf("entries = %+v, want mandatory %t", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetVersionsMinCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCodes  []int
+		wantTotal  int // paginated responses only
+	}{
+		{name: "at and above min_code", query: "?min_code=3&sort=version_code", wantStatus: http.StatusOK, wantCodes: []int{3, 4, 5}},
+		{name: "with platform", query: "?min_code=3&platform=android&sort=version_code", wantStatus: http.StatusOK, wantCodes: []int{3, 5}},
+		{name: "with channel", query: "?min_code=2&channel=beta&sort=version_code", wantStatus: http.StatusOK, wantCodes: []int{5}},
+		{name: "above everything", query: "?min_code=6", wantStatus: http.StatusOK, wantCodes: []int{}},
+		{name: "paginated", query: "?min_code=2&sort=version_code&limit=2&offset=1", wantStatus: http.StatusOK, wantCodes: []int{3, 4}, wantTotal: 4},
+		{name: "invalid", query: "?min_code=three", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for code := 1; code <= 3; code++ {
+				ts.seed(AppVersion{VersionCode: code})
+			}
+			ts.seed(AppVersion{VersionCode: 4, Platform: "ios"})
+			ts.seed(AppVersion{VersionCode: 5, Channel: "beta"})
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/versions"+tt.query, nil, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var versions []AppVersion
+			if tt.wantTotal > 0 {
+				var page struct {
+					Versions []AppVersion `json:"versions"`
+					Total    int          `json:"total"`
+				}
+				decodeJSON(t, w, &page)
+				versions = page.Versions
+				if page.Total != tt.wantTotal {
+					t.Errorf("total = %d, want %d", page.Total, tt.wantTotal)
+				}
+			} else {
+				decodeJSON(t, w, &versions)
+			}
+			codes := []int{}
+			for _, v := range versions {
+				codes = append(codes, v.VersionCode)
+			}
+			if fmt.Sprint(codes) != fmt.Sprint(tt.wantCodes) {
+				t.Errorf("codes = %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestCheckUpdateChangeLog(t *testing.T) {
+	no := false
+	tests := []struct {
+		name        string
+		currentCode int
+		channel     string
+		want        string
+	}{
+		{name: "three behind", currentCode: 1, want: "1.0.2:\nFixes\n\n1.0.4:\nNew home screen"},
+		{name: "one behind", currentCode: 3, want: "1.0.4:\nNew home screen"},
+		{name: "up to date", currentCode: 4, want: ""},
+		{name: "beta channel adds its builds", currentCode: 3, channel: "beta", want: "1.0.4:\nNew home screen\n\n1.0.5:\nBeta feature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1, ReleaseNotes: "First"})
+			ts.seed(AppVersion{VersionCode: 2, ReleaseNotes: "Fixes"})
+			ts.seed(AppVersion{VersionCode: 3, ReleaseNotes: "Pulled", Enabled: &no})
+			ts.seed(AppVersion{VersionCode: 4, ReleaseNotes: "New home screen"})
+			ts.seed(AppVersion{VersionCode: 5, ReleaseNotes: "Beta feature", Channel: "beta"})
+			ts.seed(AppVersion{VersionCode: 6, ReleaseNotes: "iOS only", Platform: "ios"})
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: tt.currentCode, Channel: tt.channel})
+			if resp.UpdateAvailable != (tt.want != "") {
+				t.Errorf("update_available = %t", resp.UpdateAvailable)
+			}
+			if resp.ChangeLog != tt.want {
+				t.Errorf("change_log = %q, want %q", resp.ChangeLog, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentUploadsSameCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		codes       []int // one concurrent upload per entry
+		existing    bool  // a record from before claims already holds code 1
+		wantCreated int
+	}{
+		{name: "same code, one wins", codes: []int{1, 1, 1, 1, 1, 1, 1, 1}, wantCreated: 1},
+		{name: "different codes all succeed", codes: []int{1, 2, 3, 4}, wantCreated: 4},
+		{name: "code held by an unclaimed record", codes: []int{1, 1}, existing: true, wantCreated: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if tt.existing {
+				ts.seed(AppVersion{VersionCode: 1, Version: "0.9.0"})
+			}
+
+			statuses := make([]int, len(tt.codes))
+			bodies := make([]string, len(tt.codes))
+			var wg sync.WaitGroup
+			for i, code := range tt.codes {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// Distinct versions and files, so only the code can collide
+					fields := map[string]string{"version": fmt.Sprintf("2.0.%d", i), "version_code": fmt.Sprint(code), "platform": "android"}
+					w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte(fmt.Sprintf("PK\x03\x04aab-%d", i)), testAPIKey)
+					statuses[i], bodies[i] = w.Code, w.Body.String()
+				}()
+			}
+			wg.Wait()
+
+			created := 0
+			for i, status := range statuses {
+				switch status {
+				case http.StatusOK:
+					created++
+				case http.StatusConflict:
+					if !strings.Contains(bodies[i], codeVersionExists) {
+						t.Errorf("upload %d: conflict body %s", i, bodies[i])
+					}
+				default:
+					t.Errorf("upload %d: status = %d (%s)", i, status, bodies[i])
+				}
+			}
+			if created != tt.wantCreated {
+				t.Errorf("%d uploads succeeded, want %d", created, tt.wantCreated)
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			perCode := map[int]int{}
+			for _, v := range versions {
+				perCode[v.VersionCode]++
+			}
+			stored := len(versions)
+			if tt.existing {
+				stored--
+			}
+			if stored != created {
+				t.Errorf("%d versions stored for %d successful uploads", stored, created)
+			}
+			for code, n := range perCode {
+				if n > 1 {
+					t.Errorf("version code %d stored %d times", code, n)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckUpdateReads(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         UpdateCheckRequest
+		wantPointer bool
+		wantRecent  bool
+		wantList    bool
+	}{
+		{name: "up to date", req: UpdateCheckRequest{CurrentCode: 30}, wantPointer: true},
+		{name: "one build behind", req: UpdateCheckRequest{CurrentCode: 29}, wantPointer: true},
+		{name: "within the window", req: UpdateCheckRequest{CurrentCode: 25}, wantPointer: true, wantRecent: true},
+		{name: "previous version asked for", req: UpdateCheckRequest{CurrentCode: 29, IncludePrevious: true}, wantPointer: true, wantRecent: true},
+		{name: "behind the window", req: UpdateCheckRequest{CurrentCode: 5}, wantPointer: true, wantRecent: true, wantList: true},
+		{name: "semver ordering", req: UpdateCheckRequest{CurrentCode: 29, CompareMode: "semver"}, wantPointer: true, wantRecent: true, wantList: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &countingStore{memoryStore: newMemoryStore()}
+			ts := newTestServerWithStore(t, store)
+			for code := 1; code <= 30; code++ {
+				ts.seed(AppVersion{VersionCode: code})
+			}
+			store.reset()
+
+			resp := ts.checkUpdate(tt.req)
+			if tt.req.CurrentCode < 30 && (!resp.UpdateAvailable || resp.LatestVersion.VersionCode != 30) {
+				t.Errorf("offered %+v, want code 30", resp.LatestVersion)
+			}
+			got := [3]bool{store.pointerReads > 0, store.recentReads > 0, store.listReads > 0}
+			if want := [3]bool{tt.wantPointer, tt.wantRecent, tt.wantList}; got != want {
+				t.Errorf("read pointer, recent, all = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// BenchmarkCheckUpdate compares check-update answered from the latest
+// pointer or the recent window with one that reads every version, as every
+// check did before. The memory store scans everything even for the window,
+// so full-reads/op, not time, shows what Firebase is spared.
+func BenchmarkCheckUpdate(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		req  UpdateCheckRequest
+	}{
+		{name: "latest pointer", req: UpdateCheckRequest{CurrentCode: 999}},
+		{name: "recent window", req: UpdateCheckRequest{CurrentCode: 995}},
+		{name: "every version", req: UpdateCheckRequest{CurrentCode: 999, CompareMode: "semver"}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			store := &countingStore{memoryStore: newMemoryStore()}
+			ts := newTestServerWithStore(b, store)
+			for code := 1; code <= 1000; code++ {
+				ts.seed(AppVersion{VersionCode: code})
+			}
+			store.reset()
+
+			req := bm.req
+			req.Platform, req.CurrentVersion = "android", fmt.Sprintf("1.0.%d", req.CurrentCode)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if w := ts.do(http.MethodPost, "/api/v1/ota/check-update", req, ""); w.Code != http.StatusOK {
+					b.Fatalf("status = %d (%s)", w.Code, w.Body)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(store.listReads)/float64(b.N), "full-reads/op")
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store that lives in process memory, for local development
+// (OTA_STORE=memory) and for exercising handlers without Firebase. Records are
+// kept as JSON so they round-trip exactly like they do through the database.
+type memoryStore struct {
+	mu        sync.Mutex
+	versions  map[string]json.RawMessage
+	trash     map[string]json.RawMessage
+	platforms map[string]PlatformConfig
+	objects   map[string]memoryObject
+	codes     map[int]string // version code claims
+	latest    map[string]LatestPointer
+	uploads   map[string]json.RawMessage
+	audit     []AuditEntry
+}
+
+type memoryObject struct {
+	data     []byte
+	updated  time.Time
+	metadata map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		versions:  map[string]json.RawMessage{},
+		trash:     map[string]json.RawMessage{},
+		platforms: map[string]PlatformConfig{},
+		objects:   map[string]memoryObject{},
+		codes:     map[int]string{},
+		latest:    map[string]LatestPointer{},
+		uploads:   map[string]json.RawMessage{},
+	}
+}
+
+func (s *memoryStore) ListVersions(ctx context.Context) (map[string]AppVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return decodeVersions(ctx, s.versions), nil
+}
+
+func (s *memoryStore) FindVersions(ctx context.Context, field string, value interface{}) (map[string]AppVersion, error) {
+	all, err := s.ListVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return versionsWhere(all, field, value), nil
+}
+
+func (s *memoryStore) RecentVersions(ctx context.Context, platform string, n int) (map[string]AppVersion, bool, error) {
+	all, err := s.ListVersions(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	var matched []AppVersion
+	for _, v := range all {
+		if matchesPlatform(v, platform) {
+			matched = append(matched, v)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].VersionCode > matched[j].VersionCode })
+	versions := map[string]AppVersion{}
+	for i := 0; i < n && i < len(matched); i++ {
+		versions[matched[i].ID] = matched[i]
+	}
+	return versions, len(matched) <= n, nil
+}
+
+func (s *memoryStore) GetVersion(ctx context.Context, id string) (*AppVersion, error) {
+	s.mu.Lock()
+	data, ok := s.versions[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	v, err := decodeVersion(id, data)
+	if err != nil || v.Version == "" {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *memoryStore) PutVersion(ctx context.Context, v AppVersion) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[v.ID] = data
+	return nil
+}
+
+func (s *memoryStore) UpdateVersions(ctx context.Context, versions []AppVersion, fields ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Build every record first so a failure leaves none of them changed
+	updated := map[string]json.RawMessage{}
+	for _, v := range versions {
+		values, err := versionFields(v)
+		if err != nil {
+			return err
+		}
+		stored := map[string]json.RawMessage{}
+		if data, ok := s.versions[v.ID]; ok {
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return err
+			}
+		}
+		for _, field := range fields {
+			if value, ok := values[field]; ok {
+				stored[field] = value
+			} else {
+				delete(stored, field)
+			}
+		}
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		updated[v.ID] = data
+	}
+	for id, data := range updated {
+		s.versions[id] = data
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteVersion(ctx context.Context, v AppVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.versions, v.ID)
+	return nil
+}
+
+func (s *memoryStore) TrashVersion(ctx context.Context, v AppVersion) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.versions, v.ID)
+	s.trash[v.ID] = data
+	return nil
+}
+
+func (s *memoryStore) RestoreVersion(ctx context.Context, v AppVersion) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.trash, v.ID)
+	s.versions[v.ID] = data
+	return nil
+}
+
+func (s *memoryStore) ListTrashed(ctx context.Context) (map[string]AppVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return decodeVersions(ctx, s.trash), nil
+}
+
+func (s *memoryStore) GetTrashed(ctx context.Context, id string) (*AppVersion, error) {
+	s.mu.Lock()
+	data, ok := s.trash[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	v, err := decodeVersion(id, data)
+	if err != nil || v.Version == "" {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *memoryStore) PurgeTrashed(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.trash, id)
+	return nil
+}
+
+func (s *memoryStore) ClaimVersionCode(ctx context.Context, code int, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if holder, ok := s.codes[code]; ok && holder != id {
+		return false, nil
+	}
+	s.codes[code] = id
+	return true, nil
+}
+
+func (s *memoryStore) ReleaseVersionCode(ctx context.Context, code int, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.codes[code] == id {
+		delete(s.codes, code)
+	}
+	return nil
+}
+
+func (s *memoryStore) GetLatestPointer(ctx context.Context, platform string) (*LatestPointer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pointer, ok := s.latest[platform]
+	if !ok {
+		return nil, nil
+	}
+	return &pointer, nil
+}
+
+func (s *memoryStore) UpdateLatestPointer(ctx context.Context, platform string, update func(*LatestPointer) *LatestPointer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var current *LatestPointer
+	if pointer, ok := s.latest[platform]; ok {
+		current = &pointer
+	}
+	if next := update(current); next != nil {
+		s.latest[platform] = *next
+	} else {
+		delete(s.latest, platform)
+	}
+	return nil
+}
+
+func (s *memoryStore) IncrementDownloadCount(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.versions[id]
+	if !ok {
+		return nil
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	count, _ := record["download_count"].(float64)
+	record["download_count"] = int64(count) + 1
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.versions[id] = data
+	return nil
+}
+
+func (s *memoryStore) GetPlatformConfig(ctx context.Context, platform string) (PlatformConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.platforms[platform], nil
+}
+
+func (s *memoryStore) SetPlatformPaused(ctx context.Context, platform string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := s.platforms[platform]
+	cfg.Paused = paused
+	s.platforms[platform] = cfg
+	return nil
+}
+
+func (s *memoryStore) SetMinSupportedCode(ctx context.Context, platform string, code int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := s.platforms[platform]
+	cfg.MinSupportedCode = code
+	s.platforms[platform] = cfg
+	return nil
+}
+
+func (s *memoryStore) SetPlatformPolicy(ctx context.Context, platform string, mandatory *bool, rollout *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := s.platforms[platform]
+	cfg.Mandatory, cfg.RolloutPercentage = mandatory, rollout
+	s.platforms[platform] = cfg
+	return nil
+}
+
+func (s *memoryStore) CreateUploadSession(ctx context.Context, session UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[session.ID] = data
+	return nil
+}
+
+func (s *memoryStore) GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	s.mu.Lock()
+	data, ok := s.uploads[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *memoryStore) UpdateUploadSession(ctx context.Context, id string, update func(*UploadSession) error) (UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var session UploadSession
+	if data, ok := s.uploads[id]; ok {
+		if err := json.Unmarshal(data, &session); err != nil {
+			return UploadSession{}, err
+		}
+	}
+	if err := update(&session); err != nil {
+		return UploadSession{}, err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	s.uploads[id] = data
+	return session, nil
+}
+
+func (s *memoryStore) ListUploadSessions(ctx context.Context) (map[string]UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make(map[string]UploadSession, len(s.uploads))
+	for id, data := range s.uploads {
+		var session UploadSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			return nil, err
+		}
+		sessions[id] = session
+	}
+	return sessions, nil
+}
+
+func (s *memoryStore) DeleteUploadSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, id)
+	return nil
+}
+
+func (s *memoryStore) AppendAudit(ctx context.Context, e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+func (s *memoryStore) ListAudit(ctx context.Context) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.audit), nil
+}
+
+func (s *memoryStore) UploadObject(ctx context.Context, path string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[path] = memoryObject{data: data, updated: time.Now()}
+	return nil
+}
+
+func (s *memoryStore) OpenObject(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	obj, ok := s.objects[path]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	data := obj.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memoryStore) DeleteObject(ctx context.Context, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[path]; !ok {
+		return errObjectNotFound
+	}
+	delete(s.objects, path)
+	return nil
+}
+
+func (s *memoryStore) ComposeObjects(ctx context.Context, dst string, srcs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(srcs) == 0 {
+		return errors.New("no data to compose")
+	}
+	var data []byte
+	for _, src := range srcs {
+		obj, ok := s.objects[src]
+		if !ok {
+			return errObjectNotFound
+		}
+		data = append(data, obj.data...)
+	}
+	s.objects[dst] = memoryObject{data: data, updated: time.Now()}
+	return nil
+}
+
+func (s *memoryStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []ObjectInfo
+	for path, obj := range s.objects {
+		if strings.HasPrefix(path, prefix) {
+			objects = append(objects, ObjectInfo{Path: path, Size: int64(len(obj.data)), Updated: obj.updated})
+		}
+	}
+	// Same order as Cloud Storage listings
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+// Ping always succeeds: there is nothing to reach
+func (s *memoryStore) Ping(ctx context.Context) map[string]error {
+	return map[string]error{"memory": nil}
+}
+
+// PublishObject is a no-op: memory objects are only served through this server
+func (s *memoryStore) PublishObject(ctx context.Context, path string) error {
+	return nil
+}
+
+func (s *memoryStore) SetObjectMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[path]
+	if !ok {
+		return errObjectNotFound
+	}
+	obj.metadata = metadata
+	obj.updated = time.Now()
+	s.objects[path] = obj
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics served on /metrics
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ota_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ota_http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	uploadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ota_upload_bytes",
+		Help:    "Size of uploaded artifacts.",
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 10), // 1 MiB to 512 MiB
+	}, []string{"platform"})
+
+	uploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ota_upload_duration_seconds",
+		Help:    "Time to receive, validate and store an upload.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"platform"})
+
+	downloadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ota_download_bytes",
+		Help:    "Bytes streamed per download; the sum is download bandwidth.",
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 10),
+	}, []string{"platform"})
+
+	versionsStored = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ota_versions_stored",
+		Help: "Version records in the database as of the last full read, by app.",
+	}, []string{"app"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ota_requests_in_flight",
+		Help: "Requests holding a slot of the MAX_INFLIGHT_REQUESTS limit.",
+	}, func() float64 {
+		if requestLimiter == nil {
+			return 0
+		}
+		return float64(requestLimiter.InFlight())
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ota_requests_queued",
+		Help: "Requests waiting for a slot of the MAX_INFLIGHT_REQUESTS limit.",
+	}, func() float64 {
+		if requestLimiter == nil {
+			return 0
+		}
+		return float64(requestLimiter.Queued())
+	})
+)
+
+// requestMetrics counts and times every request by its route pattern, so
+// path parameters don't explode the label set
+func requestMetrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	method := c.Request.Method
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+}
+
+// instrumentedStore records the number of stored versions whenever the full
+// list is read, which update checks do constantly
+type instrumentedStore struct {
+	Store
+	app string
+}
+
+func (s instrumentedStore) ListVersions(ctx context.Context) (map[string]AppVersion, error) {
+	versions, err := s.Store.ListVersions(ctx)
+	if err == nil {
+		versionsStored.WithLabelValues(s.app).Set(float64(len(versions)))
+	}
+	return versions, err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec describes the client-facing endpoints and the admin ones client
+// teams use, with the APIError shape. It is written by hand: keep it in step
+// with the handlers when request or response fields change.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// swaggerUIVersion pins the Swagger UI assets /docs loads from the CDN
+const swaggerUIVersion = "5.17.14"
+
+func serveOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
+
+// serveDocs renders Swagger UI for the spec. The page is static, so it does
+// not depend on the app or an API key; "Authorize" takes the key for admin calls.
+func serveDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>OTA Update API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@`+swaggerUIVersion+`/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@`+swaggerUIVersion+`/swagger-ui-bundle.js"></script>
+  <script>
+    SwaggerUIBundle({url: "/api/v1/ota/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`))
+}
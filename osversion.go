@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseOSVersion parses dotted numeric OS versions like "8", "13.1" or
+// "17.4.1"
+func parseOSVersion(s string) ([]int, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false
+	}
+	var parts []int
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p[0] == '+' {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// compareOSVersion compares parsed OS versions numerically, segment by
+// segment; missing segments count as zero, so "13" equals "13.0" and sorts
+// below "13.1"
+func compareOSVersion(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// supportsOS reports whether v can be installed on a device running
+// osVersion. Versions without a minimum, and devices that don't report a
+// parseable OS version, are not restricted.
+func supportsOS(v AppVersion, osVersion string) bool {
+	required, ok := parseOSVersion(v.MinOSVersion)
+	if !ok {
+		return true
+	}
+	device, ok := parseOSVersion(osVersion)
+	if !ok {
+		return true
+	}
+	return compareOSVersion(device, required) >= 0
+}
+
+// compatibleWith keeps the versions installable on osVersion
+func compatibleWith(versions map[string]AppVersion, osVersion string) map[string]AppVersion {
+	compatible := make(map[string]AppVersion, len(versions))
+	for id, v := range versions {
+		if supportsOS(v, osVersion) {
+			compatible[id] = v
+		}
+	}
+	return compatible
+}
+
+// parseMinOSVersion validates an optional minimum OS version, returning "" when blank
+func parseMinOSVersion(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if _, ok := parseOSVersion(s); !ok {
+		return "", fmt.Errorf("invalid OS version %q", s)
+	}
+	return s, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PatchInfo describes a binary diff (e.g. bsdiff) that turns the build with
+// FromCode into the artifact for ABI of the version it is stored on
+type PatchInfo struct {
+	FromCode    int       `json:"from_code"`
+	ABI         string    `json:"abi,omitempty"` // "" = the universal build
+	StoragePath string    `json:"storage_path"`
+	Checksum    string    `json:"checksum"` // SHA-256 of the patch itself
+	FileSize    int64     `json:"file_size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// patchStoragePath returns the object path of the patch between two builds
+func (s *Server) patchStoragePath(platform string, from, to int, abi string) string {
+	if abi != "" {
+		abi = "-" + abi
+	}
+	return fmt.Sprintf("%spatches/%s/%d-%d%s.patch", s.prefix, platform, from, to, abi)
+}
+
+// patchFrom returns the patch stored on v that applies to the build fromCode
+// and produces v's artifact for abi
+func patchFrom(v AppVersion, fromCode int, abi string) (PatchInfo, bool) {
+	for _, p := range v.Patches {
+		if p.FromCode == fromCode && p.ABI == abi {
+			return p, true
+		}
+	}
+	return PatchInfo{}, false
+}
+
+// hasArtifact reports whether v has an artifact for exactly abi, "" being
+// the universal build
+func hasArtifact(v AppVersion, abi string) bool {
+	for _, a := range artifactsOf(v) {
+		if a.ABI == abi {
+			return true
+		}
+	}
+	return false
+}
+
+// versionByCode returns the version of a platform with the given version
+// code, or nil when there is none
+func (s *Server) versionByCode(ctx context.Context, platform string, code int) (*AppVersion, error) {
+	versions, err := s.store.FindVersions(ctx, "version_code", code)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if matchesPlatform(v, platform) {
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+// parsePatchCodes reads the from/to version codes of a patch request
+func parsePatchCodes(from, to string) (int, int, bool) {
+	fromCode, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil || fromCode <= 0 {
+		return 0, 0, false
+	}
+	toCode, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil || toCode <= fromCode {
+		return 0, 0, false
+	}
+	return fromCode, toCode, true
+}
+
+// downloadPatch streams a precomputed patch between two builds. Without one
+// it answers 404 so the client falls back to the full download.
+func (s *Server) downloadPatch(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.DefaultQuery("platform", "android")
+	if !isSupportedPlatform(platform) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform", gin.H{
+			"expected": supportedPlatforms,
+		})
+		return
+	}
+	fromCode, toCode, ok := parsePatchCodes(c.Query("from"), c.Query("to"))
+	if !ok {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid from/to", gin.H{
+			"expected": "positive version codes with from below to",
+		})
+		return
+	}
+
+	target, err := s.versionByCode(ctx, platform, toCode)
+	if err != nil {
+		loggerFrom(ctx).Error("version lookup failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	if target == nil || !isPublished(*target, time.Now()) {
+		respondError(c, http.StatusNotFound, codeNotFound, "Target version not found")
+		return
+	}
+	if !isEnabled(*target) {
+		respondError(c, http.StatusGone, codeVersionDisabled, "Version has been disabled")
+		return
+	}
+	// The patch must rebuild the file a full download would give this device
+	artifact, ok := selectArtifact(*target, c.Query("abi"))
+	if !ok {
+		respondErrorDetails(c, http.StatusNotFound, codeNotFound, "No build for the requested ABI", gin.H{
+			"expected": artifactABIs(*target),
+		})
+		return
+	}
+	patch, ok := patchFrom(*target, fromCode, artifact.ABI)
+	if !ok {
+		respondErrorDetails(c, http.StatusNotFound, codeNotFound, "No patch available", gin.H{
+			"download_url": s.downloadURL(target.Version, platform),
+		})
+		return
+	}
+
+	reader, err := s.store.OpenObject(ctx, patch.StoragePath, 0, -1)
+	if err != nil {
+		loggerFrom(ctx).Error("storage read failed", "storage_path", patch.StoragePath, "version_id", target.ID, "err", err)
+		respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to read file from storage")
+		return
+	}
+	defer reader.Close()
+
+	// The patch checksum verifies the transfer, the target checksum the
+	// file the client reconstructs from it
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=app-%d-%d.patch", fromCode, toCode))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", fmt.Sprintf("%d", patch.FileSize))
+	c.Header("X-Patch-Checksum", patch.Checksum)
+	c.Header("X-Target-Checksum", artifact.Checksum)
+	if digest, ok := sha256Digest(patch.Checksum); ok {
+		c.Header("Digest", "sha-256="+digest)
+	}
+	if _, err := io.Copy(c.Writer, reader); err != nil {
+		loggerFrom(ctx).Warn("streaming patch failed", "err", err)
+	}
+}
+
+// uploadPatch stores a patch produced offline (e.g. with bsdiff) between two
+// builds of a platform and attaches it to the target version
+func (s *Server) uploadPatch(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Minute)
+	defer cancel()
+
+	if !limitUploadBody(c) {
+		return
+	}
+	platform := strings.ToLower(strings.TrimSpace(c.PostForm("platform")))
+	if !isSupportedPlatform(platform) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform", gin.H{
+			"expected": supportedPlatforms,
+		})
+		return
+	}
+	if !authorizePlatform(c, platform) {
+		return
+	}
+	fromCode, toCode, ok := parsePatchCodes(c.PostForm("from_code"), c.PostForm("to_code"))
+	if !ok {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid from_code/to_code", gin.H{
+			"expected": "positive version codes with from_code below to_code",
+		})
+		return
+	}
+	abi := strings.ToLower(strings.TrimSpace(c.PostForm("abi")))
+	if abi != "" && !abiPattern.MatchString(abi) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid abi", gin.H{
+			"expected": abiPattern.String(),
+		})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeMissingFields, "No file uploaded")
+		return
+	}
+	if limit := maxUploadBytes(); limit > 0 && file.Size > limit {
+		respondTooLarge(c, limit)
+		return
+	}
+
+	// Both builds must exist; the patch is stored on the one it produces
+	var target *AppVersion
+	for _, code := range []int{fromCode, toCode} {
+		v, err := s.versionByCode(ctx, platform, code)
+		if err != nil {
+			loggerFrom(ctx).Error("version lookup failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Could not check for existing versions")
+			return
+		}
+		if v == nil {
+			respondErrorDetails(c, http.StatusNotFound, codeNotFound, "Version not found", gin.H{"version_code": code})
+			return
+		}
+		target = v
+	}
+	// Split builds differ per ABI, so each needs its own patch
+	if !hasArtifact(*target, abi) {
+		msg := "Target version has no build for abi"
+		if abi == "" {
+			msg = "Target version has per-ABI builds only; abi is required"
+		}
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, msg, gin.H{
+			"expected": artifactABIs(*target),
+		})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		loggerFrom(ctx).Error("file open failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to process uploaded file")
+		return
+	}
+	defer src.Close()
+
+	sums, size, err := hashStream(src, "sha256")
+	if err == nil {
+		_, err = src.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("file read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to process uploaded file")
+		return
+	}
+
+	patch := PatchInfo{
+		FromCode:    fromCode,
+		ABI:         abi,
+		StoragePath: s.patchStoragePath(platform, fromCode, toCode, abi),
+		Checksum:    sums["sha256"],
+		FileSize:    size,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.store.UploadObject(ctx, patch.StoragePath, src); err != nil {
+		loggerFrom(ctx).Error("file upload failed", "storage_path", patch.StoragePath, "err", err)
+		respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to upload file")
+		return
+	}
+
+	// A re-uploaded patch replaces the previous one for the same pair and ABI
+	patches := []PatchInfo{patch}
+	for _, p := range target.Patches {
+		if p.FromCode != fromCode || p.ABI != abi {
+			patches = append(patches, p)
+		}
+	}
+	target.Patches = patches
+	target.UpdatedAt = patch.CreatedAt
+	if err := s.store.UpdateVersions(ctx, []AppVersion{*target}, "patches", "updated_at"); err != nil {
+		loggerFrom(ctx).Error("version save failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to save version information")
+		return
+	}
+	loggerFrom(ctx).Info("patch uploaded",
+		"platform", platform, "from_code", fromCode, "to_code", toCode, "abi", abi, "version_id", target.ID, "file_size", size)
+	details := map[string]string{"from_code": strconv.Itoa(fromCode)}
+	if abi != "" {
+		details["abi"] = abi
+	}
+	s.audit(c, auditPatch, target, details)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Patch uploaded successfully",
+		"patch":   patch,
+	})
+}
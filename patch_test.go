@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPatchesPerABI(t *testing.T) {
+	split := []Artifact{
+		{ABI: "arm64-v8a", StoragePath: "releases/android/2-arm64-v8a.apk", Checksum: "arm64sum"},
+		{ABI: "x86_64", StoragePath: "releases/android/2-x86_64.apk", Checksum: "x86sum"},
+	}
+	universal := append([]Artifact{{StoragePath: "releases/android/2.apk", Checksum: "universalsum"}}, split...)
+	tests := []struct {
+		name         string
+		artifacts    []Artifact
+		uploadABIs   []string
+		wantUpload   int
+		downloadABI  string
+		wantDownload int
+		wantTarget   string
+		wantPatch    string // abi the served patch was uploaded for
+	}{
+		{name: "single file", uploadABIs: []string{""}, wantUpload: http.StatusCreated, wantDownload: http.StatusOK},
+		{name: "split patch for device abi", artifacts: split, uploadABIs: []string{"arm64-v8a", "x86_64"}, wantUpload: http.StatusCreated,
+			downloadABI: "x86_64", wantDownload: http.StatusOK, wantTarget: "x86sum", wantPatch: "x86_64"},
+		{name: "split without abi", artifacts: split, uploadABIs: []string{""}, wantUpload: http.StatusBadRequest},
+		{name: "abi the target lacks", artifacts: split, uploadABIs: []string{"armeabi-v7a"}, wantUpload: http.StatusBadRequest},
+		{name: "invalid abi", artifacts: split, uploadABIs: []string{"../x"}, wantUpload: http.StatusBadRequest},
+		{name: "other abi not served", artifacts: split, uploadABIs: []string{"arm64-v8a"}, wantUpload: http.StatusCreated,
+			downloadABI: "x86_64", wantDownload: http.StatusNotFound},
+		{name: "device without build", artifacts: split, uploadABIs: []string{"arm64-v8a"}, wantUpload: http.StatusCreated,
+			downloadABI: "mips", wantDownload: http.StatusNotFound},
+		{name: "universal fallback", artifacts: universal, uploadABIs: []string{"", "arm64-v8a"}, wantUpload: http.StatusCreated,
+			downloadABI: "mips", wantDownload: http.StatusOK, wantTarget: "universalsum"},
+		{name: "split preferred over universal", artifacts: universal, uploadABIs: []string{"", "arm64-v8a"}, wantUpload: http.StatusCreated,
+			downloadABI: "arm64-v8a", wantDownload: http.StatusOK, wantTarget: "arm64sum", wantPatch: "arm64-v8a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			target := ts.seed(AppVersion{VersionCode: 2, Artifacts: tt.artifacts})
+			if tt.wantTarget == "" {
+				tt.wantTarget = target.Checksum
+			}
+
+			for _, abi := range tt.uploadABIs {
+				fields := map[string]string{"platform": "android", "from_code": "1", "to_code": "2", "abi": abi}
+				w := ts.upload("/api/v1/ota/patches", fields, "1-2.patch", []byte("patch for "+abi), testAPIKey)
+				if w.Code != tt.wantUpload {
+					t.Fatalf("upload abi %q: status = %d, want %d (%s)", abi, w.Code, tt.wantUpload, w.Body)
+				}
+			}
+			if tt.wantUpload != http.StatusCreated {
+				return
+			}
+
+			url := "/api/v1/ota/patch?platform=android&from=1&to=2"
+			if tt.downloadABI != "" {
+				url += "&abi=" + tt.downloadABI
+			}
+			w := ts.do(http.MethodGet, url, nil, "")
+			if w.Code != tt.wantDownload {
+				t.Fatalf("download: status = %d, want %d (%s)", w.Code, tt.wantDownload, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("X-Target-Checksum"); got != tt.wantTarget {
+				t.Errorf("X-Target-Checksum = %q, want %q", got, tt.wantTarget)
+			}
+			if got := w.Body.String(); got != "patch for "+tt.wantPatch {
+				t.Errorf("served %q, want the patch for abi %q", got, tt.wantPatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlatformSpec describes a platform versions can be released for and the
+// artifacts it accepts
+type PlatformSpec struct {
+	Name string
+	// Extensions are the accepted artifact extensions, lower case; the first
+	// is used for records whose storage path has none
+	Extensions []string
+	// ContentTypes maps an extension to the Content-Type it is downloaded
+	// with; others are served as application/octet-stream
+	ContentTypes map[string]string
+	// Zip is set when every artifact is a ZIP archive (APK, AAB, IPA), so
+	// uploads are checked for its signature
+	Zip bool
+}
+
+// platforms is the registry of supported platforms. An extension belongs to
+// one platform only, since uploads without a platform are routed by it.
+var platforms = []PlatformSpec{
+	{
+		Name:         "android",
+		Extensions:   []string{".apk", ".aab"},
+		ContentTypes: map[string]string{".apk": "application/vnd.android.package-archive"},
+		Zip:          true,
+	},
+	{
+		Name:       "ios",
+		Extensions: []string{".ipa"},
+		Zip:        true,
+	},
+	{
+		Name:       "windows",
+		Extensions: []string{".exe", ".msi", ".msix"},
+		ContentTypes: map[string]string{
+			".exe":  "application/vnd.microsoft.portable-executable",
+			".msi":  "application/x-msi",
+			".msix": "application/msix",
+		},
+	},
+	{
+		Name:         "macos",
+		Extensions:   []string{".dmg", ".pkg"},
+		ContentTypes: map[string]string{".dmg": "application/x-apple-diskimage"},
+	},
+	{
+		Name:       "linux",
+		Extensions: []string{".appimage", ".deb", ".rpm"},
+		ContentTypes: map[string]string{
+			".appimage": "application/vnd.appimage",
+			".deb":      "application/vnd.debian.binary-package",
+			".rpm":      "application/x-rpm",
+		},
+	},
+}
+
+// supportedPlatforms lists the platforms versions can be released for
+var supportedPlatforms = platformNames()
+
+func platformNames() []string {
+	names := make([]string, len(platforms))
+	for i, p := range platforms {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// platformSpec returns the registry entry of a platform
+func platformSpec(platform string) (PlatformSpec, bool) {
+	for _, p := range platforms {
+		if p.Name == platform {
+			return p, true
+		}
+	}
+	return PlatformSpec{}, false
+}
+
+func isSupportedPlatform(platform string) bool {
+	_, ok := platformSpec(platform)
+	return ok
+}
+
+// inferPlatform derives the platform from an artifact's file extension
+func inferPlatform(filename string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, p := range platforms {
+		for _, want := range p.Extensions {
+			if ext == want {
+				return p.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// artifactExtension returns the extension a stored version is served with:
+// that of its storage path when the platform accepts it, else the platform's first
+func (p PlatformSpec) artifactExtension(storagePath string) string {
+	ext := strings.ToLower(filepath.Ext(storagePath))
+	for _, want := range p.Extensions {
+		if ext == want {
+			return ext
+		}
+	}
+	return p.Extensions[0]
+}
+
+// contentType returns the Content-Type artifacts with ext are downloaded with
+func (p PlatformSpec) contentType(ext string) string {
+	if t, ok := p.ContentTypes[ext]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// PlatformConfig holds admin-controlled settings for a whole platform,
+// stored under config/<platform>
+type PlatformConfig struct {
+	// Paused stops check-update from offering any version for the platform
+	Paused bool `json:"paused"`
+	// MinSupportedCode makes every update mandatory for clients below it; 0 = no floor
+	MinSupportedCode int `json:"min_supported_code,omitempty"`
+	// Mandatory and RolloutPercentage are the platform's policy for versions
+	// that don't set is_mandatory or rollout_percentage themselves. Kept apart
+	// from the version records, they outlive deleting or rolling back the
+	// build that is the latest; nil leaves the version's own default.
+	Mandatory         *bool `json:"is_mandatory,omitempty"`
+	RolloutPercentage *int  `json:"rollout_percentage,omitempty"`
+}
+
+// applyPolicy returns versions with the platform policy filled in where a
+// version doesn't set its own, so the effective policy is always that of the
+// versions currently offered. A paused rollout is frozen, so the rollout
+// policy never reaches it.
+func (cfg PlatformConfig) applyPolicy(versions map[string]AppVersion) map[string]AppVersion {
+	if cfg.Mandatory == nil && cfg.RolloutPercentage == nil {
+		return versions
+	}
+	applied := make(map[string]AppVersion, len(versions))
+	for id, v := range versions {
+		if v.IsMandatory == nil {
+			v.IsMandatory = cfg.Mandatory
+		}
+		if v.RolloutPercentage == nil && !v.RolloutPaused {
+			v.RolloutPercentage = cfg.RolloutPercentage
+		}
+		applied[id] = v
+	}
+	return applied
+}
+
+// setPlatformPaused returns a handler that pauses or resumes update offers for a platform
+func (s *Server) setPlatformPaused(paused bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := requestContext(c)
+		platform := c.Param("platform")
+		if !isSupportedPlatform(platform) {
+			respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
+			return
+		}
+		if !authorizePlatform(c, platform) {
+			return
+		}
+
+		if err := s.store.SetPlatformPaused(ctx, platform, paused); err != nil {
+			loggerFrom(ctx).Error("platform config save failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to update platform")
+			return
+		}
+
+		loggerFrom(ctx).Info("platform pause changed", "platform", platform, "paused", paused)
+		action := auditResume
+		if paused {
+			action = auditPause
+		}
+		s.audit(c, action, nil, map[string]string{"platform": platform})
+		c.JSON(http.StatusOK, gin.H{"platform": platform, "paused": paused})
+	}
+}
+
+// PlatformPolicy is the body of PUT /platforms/:platform/policy; null or an
+// omitted field removes that part of the policy
+type PlatformPolicy struct {
+	IsMandatory       *bool `json:"is_mandatory"`
+	RolloutPercentage *int  `json:"rollout_percentage"`
+}
+
+// setPlatformPolicy replaces the platform's mandatory and rollout policy
+func (s *Server) setPlatformPolicy(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Param("platform")
+	if !isSupportedPlatform(platform) {
+		respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
+		return
+	}
+	if !authorizePlatform(c, platform) {
+		return
+	}
+	var req PlatformPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if req.RolloutPercentage != nil && !validRolloutPercentage(*req.RolloutPercentage) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid rollout_percentage", gin.H{
+			"expected": "integer between 0 and 100, or null",
+		})
+		return
+	}
+
+	if err := s.store.SetPlatformPolicy(ctx, platform, req.IsMandatory, req.RolloutPercentage); err != nil {
+		loggerFrom(ctx).Error("platform config save failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to update platform")
+		return
+	}
+
+	loggerFrom(ctx).Info("platform policy changed", "platform", platform, "is_mandatory", req.IsMandatory, "rollout_percentage", req.RolloutPercentage)
+	details := map[string]string{"platform": platform}
+	if req.IsMandatory != nil {
+		details["is_mandatory"] = strconv.FormatBool(*req.IsMandatory)
+	}
+	if req.RolloutPercentage != nil {
+		details["rollout_percentage"] = strconv.Itoa(*req.RolloutPercentage)
+	}
+	s.audit(c, auditPolicy, nil, details)
+	s.respondPlatformPolicy(c, platform)
+}
+
+// getPlatformPolicy returns the platform's policy and its effect on the
+// current latest version
+func (s *Server) getPlatformPolicy(c *gin.Context) {
+	platform := c.Param("platform")
+	if !isSupportedPlatform(platform) {
+		respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
+		return
+	}
+	s.respondPlatformPolicy(c, platform)
+}
+
+// respondPlatformPolicy writes the stored policy and the effective one: that
+// of the highest enabled version, which after a deletion or rollback is the
+// build that took over
+func (s *Server) respondPlatformPolicy(c *gin.Context, platform string) {
+	ctx := requestContext(c)
+	cfg, err := s.store.GetPlatformConfig(ctx, platform)
+	if err != nil {
+		loggerFrom(ctx).Error("platform config read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	response := gin.H{
+		"platform": platform,
+		"policy":   PlatformPolicy{IsMandatory: cfg.Mandatory, RolloutPercentage: cfg.RolloutPercentage},
+	}
+
+	latest := s.latestWindow(ctx, platform)
+	if latest == nil {
+		versions, err := s.store.ListVersions(ctx)
+		if err != nil {
+			loggerFrom(ctx).Error("version fetch failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+			return
+		}
+		if pointer := highestEnabled(versions, platform); pointer != nil {
+			latest = map[string]AppVersion{pointer.ID: versions[pointer.ID]}
+		}
+	}
+	for _, v := range cfg.applyPolicy(latest) {
+		response["effective"] = gin.H{
+			"version_id":         v.ID,
+			"version_code":       v.VersionCode,
+			"is_mandatory":       v.IsMandatory,
+			"rollout_percentage": rolloutPercentage(v),
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// setMinSupportedCode sets the version code below which check-update marks
+// every update mandatory, for dropping support of old builds; 0 removes it
+func (s *Server) setMinSupportedCode(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Param("platform")
+	if !isSupportedPlatform(platform) {
+		respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
+		return
+	}
+	if !authorizePlatform(c, platform) {
+		return
+	}
+	var req struct {
+		MinSupportedCode *int `json:"min_supported_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.MinSupportedCode == nil || *req.MinSupportedCode < 0 {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid min_supported_code", gin.H{
+			"expected": "a version code, or 0 to remove the floor",
+		})
+		return
+	}
+
+	code := *req.MinSupportedCode
+	if err := s.store.SetMinSupportedCode(ctx, platform, code); err != nil {
+		loggerFrom(ctx).Error("platform config save failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to update platform")
+		return
+	}
+
+	loggerFrom(ctx).Info("minimum supported code changed", "platform", platform, "min_supported_code", code)
+	s.audit(c, auditMinSupported, nil, map[string]string{"platform": platform, "min_supported_code": strconv.Itoa(code)})
+	c.JSON(http.StatusOK, gin.H{"platform": platform, "min_supported_code": code})
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlatformPolicy(t *testing.T) {
+	yes, no := true, false
+	pct := func(n int) *int { return &n }
+	tests := []struct {
+		name        string
+		versions    []AppVersion
+		policy      PlatformPolicy
+		deleteID    string
+		currentCode int
+		wantUpdate  bool
+		wantLatest  int
+		wantMand    bool
+	}{
+		{
+			name:        "policy makes one build behind mandatory",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 2}},
+			policy:      PlatformPolicy{IsMandatory: &yes},
+			currentCode: 1, wantUpdate: true, wantLatest: 2, wantMand: true,
+		},
+		{
+			name:        "policy survives deleting the latest",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3}},
+			policy:      PlatformPolicy{IsMandatory: &yes},
+			deleteID:    "android-3",
+			currentCode: 1, wantUpdate: true, wantLatest: 2, wantMand: true,
+		},
+		{
+			name:        "version flag goes with its version",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3, IsMandatory: &yes}},
+			deleteID:    "android-3",
+			currentCode: 1, wantUpdate: true, wantLatest: 2, wantMand: false,
+		},
+		{
+			name:        "version flag wins over policy",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 2, IsMandatory: &no}},
+			policy:      PlatformPolicy{IsMandatory: &yes},
+			currentCode: 1, wantUpdate: true, wantLatest: 2, wantMand: false,
+		},
+		{
+			name:        "policy false disables the heuristic",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 3}},
+			policy:      PlatformPolicy{IsMandatory: &no},
+			currentCode: 1, wantUpdate: true, wantLatest: 3, wantMand: false,
+		},
+		{
+			name:        "rollout policy withholds unstaged versions",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 2}},
+			policy:      PlatformPolicy{RolloutPercentage: pct(0)},
+			currentCode: 1, wantUpdate: false,
+		},
+		{
+			name:        "paused rollout ignores rollout policy",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 2, RolloutPaused: true}},
+			policy:      PlatformPolicy{RolloutPercentage: pct(0)},
+			currentCode: 1, wantUpdate: true, wantLatest: 2,
+		},
+		{
+			name:        "version rollout wins over policy",
+			versions:    []AppVersion{{VersionCode: 1}, {VersionCode: 2, RolloutPercentage: pct(100)}},
+			policy:      PlatformPolicy{RolloutPercentage: pct(0)},
+			currentCode: 1, wantUpdate: true, wantLatest: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, v := range tt.versions {
+				ts.seed(v)
+			}
+			if w := ts.do(http.MethodPut, "/api/v1/ota/platforms/android/policy", tt.policy, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("set policy: %d %s", w.Code, w.Body)
+			}
+			if tt.deleteID != "" {
+				if w := ts.do(http.MethodDelete, "/api/v1/ota/versions/"+tt.deleteID, nil, testAPIKey); w.Code != http.StatusOK {
+					t.Fatalf("delete: %d %s", w.Code, w.Body)
+				}
+			}
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: tt.currentCode, DeviceID: "device-1"})
+			if resp.UpdateAvailable != tt.wantUpdate {
+				t.Fatalf("update_available = %t, want %t", resp.UpdateAvailable, tt.wantUpdate)
+			}
+			if !tt.wantUpdate {
+				return
+			}
+			if resp.LatestVersion.VersionCode != tt.wantLatest {
+				t.Errorf("latest = %d, want %d", resp.LatestVersion.VersionCode, tt.wantLatest)
+			}
+			if resp.IsMandatory != tt.wantMand {
+				t.Errorf("is_mandatory = %t, want %t", resp.IsMandatory, tt.wantMand)
+			}
+		})
+	}
+}
+
+func TestPlatformPolicyEffective(t *testing.T) {
+	ts := newTestServer(t)
+	yes := true
+	ts.seed(AppVersion{VersionCode: 1})
+	ts.seed(AppVersion{VersionCode: 2, RolloutPercentage: func(n int) *int { return &n }(10)})
+	ts.do(http.MethodPut, "/api/v1/ota/platforms/android/policy", PlatformPolicy{IsMandatory: &yes}, testAPIKey)
+
+	type effective struct {
+		VersionID         string `json:"version_id"`
+		IsMandatory       *bool  `json:"is_mandatory"`
+		RolloutPercentage int    `json:"rollout_percentage"`
+	}
+	get := func() effective {
+		t.Helper()
+		w := ts.do(http.MethodGet, "/api/v1/ota/platforms/android/policy", nil, testAPIKey)
+		if w.Code != http.StatusOK {
+			t.Fatalf("get policy: %d %s", w.Code, w.Body)
+		}
+		var body struct {
+			Policy    PlatformPolicy `json:"policy"`
+			Effective effective      `json:"effective"`
+		}
+		decodeJSON(t, w, &body)
+		if body.Policy.IsMandatory == nil || !*body.Policy.IsMandatory {
+			t.Errorf("policy = %+v", body.Policy)
+		}
+		return body.Effective
+	}
+
+	if got := get(); got.VersionID != "android-2" || got.RolloutPercentage != 10 || got.IsMandatory == nil || !*got.IsMandatory {
+		t.Errorf("effective before delete = %+v", got)
+	}
+	if w := ts.do(http.MethodDelete, "/api/v1/ota/versions/android-2", nil, testAPIKey); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if got := get(); got.VersionID != "android-1" || got.RolloutPercentage != 100 || got.IsMandatory == nil || !*got.IsMandatory {
+		t.Errorf("effective after delete = %+v", got)
+	}
+}
+
+func TestPlatformPolicyValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		platform   string
+		body       any
+		wantStatus int
+	}{
+		{"valid", "android", map[string]any{"is_mandatory": true, "rollout_percentage": 50}, http.StatusOK},
+		{"removal", "android", map[string]any{"is_mandatory": nil}, http.StatusOK},
+		{"rollout out of range", "android", map[string]any{"rollout_percentage": 150}, http.StatusBadRequest},
+		{"unknown platform", "symbian", map[string]any{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.do(http.MethodPut, "/api/v1/ota/platforms/"+tt.platform+"/policy", tt.body, testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestUploadInfersPlatform(t *testing.T) {
+	tests := []struct {
+		name         string
+		platform     string
+		filename     string
+		content      []byte
+		resumable    bool
+		wantStatus   int
+		wantCode     string
+		wantPlatform string
+	}{
+		{name: "apk", filename: "app.apk", content: buildAPK(t, "com.example.app", 3), wantStatus: http.StatusOK, wantPlatform: "android"},
+		{name: "aab", filename: "app.aab", content: []byte("PK\x03\x04aab"), wantStatus: http.StatusOK, wantPlatform: "android"},
+		{name: "upper-case extension", filename: "APP.AAB", content: []byte("PK\x03\x04aab"), wantStatus: http.StatusOK, wantPlatform: "android"},
+		{name: "ipa", filename: "app.ipa", content: buildIPA(t, "com.example.app", "1.0.3", 3), wantStatus: http.StatusOK, wantPlatform: "ios"},
+		{name: "explicit platform wins", platform: "ios", filename: "app.aab", content: []byte("PK\x03\x04aab"), wantStatus: http.StatusBadRequest, wantCode: codeInvalidFile},
+		{name: "unknown extension", filename: "app.zip", content: []byte("PK\x03\x04zip"), wantStatus: http.StatusBadRequest, wantCode: codeInvalidPlatform},
+		{name: "no extension", filename: "app", content: []byte("PK\x03\x04"), wantStatus: http.StatusBadRequest, wantCode: codeInvalidPlatform},
+		{name: "resumable aab", filename: "app.aab", content: []byte("PK\x03\x04aab"), resumable: true, wantStatus: http.StatusNoContent, wantPlatform: "android"},
+		{name: "resumable unknown extension", filename: "app.zip", content: []byte("PK\x03\x04zip"), resumable: true, wantStatus: http.StatusBadRequest, wantCode: codeInvalidPlatform},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			fields := map[string]string{"version": "1.0.3", "version_code": "3"}
+			if tt.platform != "" {
+				fields["platform"] = tt.platform
+			}
+			var w *httptest.ResponseRecorder
+			if tt.resumable {
+				fields["filename"] = tt.filename
+				w = ts.tusUpload(fields, tt.content)
+			} else {
+				w = ts.upload("/api/v1/ota/upload", fields, tt.filename, tt.content, testAPIKey)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+				return
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil || len(versions) != 1 {
+				t.Fatalf("versions = %v, %v", versions, err)
+			}
+			for _, v := range versions {
+				if v.Platform != tt.wantPlatform {
+					t.Errorf("platform = %q, want %q", v.Platform, tt.wantPlatform)
+				}
+			}
+		})
+	}
+}
+
+func TestPlatformPause(t *testing.T) {
+	tests := []struct {
+		name       string
+		actions    []string // pause/resume URLs, in order
+		wantPaused bool
+	}{
+		{name: "running", wantPaused: false},
+		{name: "paused", actions: []string{"/platforms/android/pause"}, wantPaused: true},
+		{name: "resumed", actions: []string{"/platforms/android/pause", "/platforms/android/resume"}, wantPaused: false},
+		{name: "other platform paused", actions: []string{"/platforms/ios/pause"}, wantPaused: false},
+		{name: "paused twice", actions: []string{"/platforms/android/pause", "/platforms/android/pause"}, wantPaused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2})
+			for _, action := range tt.actions {
+				if w := ts.do(http.MethodPost, "/api/v1/ota"+action, nil, testAPIKey); w.Code != http.StatusOK {
+					t.Fatalf("%s: %d %s", action, w.Code, w.Body)
+				}
+			}
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1})
+			if resp.Paused != tt.wantPaused || resp.UpdateAvailable == tt.wantPaused {
+				t.Errorf("paused = %t, update_available = %t, want paused %t", resp.Paused, resp.UpdateAvailable, tt.wantPaused)
+			}
+			if tt.wantPaused && resp.LatestVersion != nil {
+				t.Errorf("paused platform offered %+v", resp.LatestVersion)
+			}
+
+			// Known versions stay downloadable either way
+			if w := ts.do(http.MethodGet, "/api/v1/ota/download/1.0.2?platform=android", nil, ""); w.Code != http.StatusOK {
+				t.Errorf("download: %d %s", w.Code, w.Body)
+			}
+
+			entries, err := ts.store.ListAudit(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.actions) {
+				t.Errorf("%d audit entries, want %d", len(entries), len(tt.actions))
+			}
+		})
+	}
+}
+
+func TestPlatformPauseValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		apiKey     string
+		wantStatus int
+	}{
+		{"unknown platform", "/api/v1/ota/platforms/symbian/pause", testAPIKey, http.StatusBadRequest},
+		{"no api key", "/api/v1/ota/platforms/android/pause", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if w := ts.do(http.MethodPost, tt.target, nil, tt.apiKey); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestMatchesPlatform(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        AppVersion
+		platform string
+		want     bool
+	}{
+		{name: "android record", v: AppVersion{Platform: "android"}, platform: "android", want: true},
+		{name: "ios record", v: AppVersion{Platform: "ios"}, platform: "ios", want: true},
+		{name: "other platform", v: AppVersion{Platform: "ios"}, platform: "android", want: false},
+		{name: "legacy android path", v: AppVersion{StoragePath: "releases/android/1.0.0-1.apk"}, platform: "android", want: true},
+		{name: "legacy ios path", v: AppVersion{StoragePath: "releases/ios/1.0.0-1.ipa"}, platform: "android", want: false},
+		{name: "old prefix convention", v: AppVersion{StoragePath: "android_1.0.0.apk"}, platform: "android", want: false},
+		{name: "empty matches all", v: AppVersion{Platform: "ios"}, platform: "", want: true},
+		{name: "unknown platform", v: AppVersion{Platform: "android"}, platform: "symbian", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t)
+			if got := matchesPlatform(tt.v, tt.platform); got != tt.want {
+				t.Errorf("matchesPlatform(%+v, %q) = %t, want %t", tt.v, tt.platform, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlatformFilterAgreement(t *testing.T) {
+	tests := []struct {
+		platform   string
+		wantIDs    []string
+		wantLatest int // check-update's offer to a device on code 1; 0 for none
+	}{
+		{platform: "android", wantIDs: []string{"android-1", "android-3"}, wantLatest: 3},
+		{platform: "ios", wantIDs: []string{"ios-2", "ios-4"}, wantLatest: 4},
+		{platform: "", wantIDs: []string{"android-1", "ios-2", "android-3", "ios-4"}},
+		{platform: "symbian", wantIDs: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run("platform="+tt.platform, func(t *testing.T) {
+			ts := newTestServer(t)
+			ctx := context.Background()
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2, Platform: "ios"})
+			// Records from before the platform field carry it in their path only
+			for _, v := range []AppVersion{{VersionCode: 3}, {VersionCode: 4, Platform: "ios"}} {
+				legacy := ts.seed(v)
+				legacy.Platform = ""
+				if err := ts.store.PutVersion(ctx, legacy); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/versions?sort=version_code&platform="+tt.platform, nil, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("list: %d %s", w.Code, w.Body)
+			}
+			var versions []AppVersion
+			decodeJSON(t, w, &versions)
+			ids := []string{}
+			for _, v := range versions {
+				ids = append(ids, v.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("listed %v, want %v", ids, tt.wantIDs)
+			}
+			if tt.wantLatest == 0 {
+				return
+			}
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1, Platform: tt.platform})
+			if !resp.UpdateAvailable || resp.LatestVersion.VersionCode != tt.wantLatest {
+				t.Errorf("check-update = %+v, want code %d", resp, tt.wantLatest)
+			}
+			target := fmt.Sprintf("/api/v1/ota/download/1.0.%d?platform=%s", tt.wantLatest, tt.platform)
+			if w := ts.do(http.MethodGet, target, nil, ""); w.Code != http.StatusOK {
+				t.Errorf("download: %d %s", w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"firebase.google.com/go/messaging"
+)
+
+// pushClient sends FCM messages; nil unless PUSH_NOTIFICATIONS is enabled
+// with the Firebase store
+var pushClient *messaging.Client
+
+// pushTimeout bounds the FCM call so a slow push never holds up an upload
+const pushTimeout = 10 * time.Second
+
+// topicPattern is the character set FCM accepts in topic names
+var topicPattern = regexp.MustCompile(`^[a-zA-Z0-9_.~%-]+$`)
+
+// pushTopic returns the FCM topic devices of a platform subscribe to, or ""
+// for desktop platforms, which get no pushes. With several apps the app id
+// is prepended, e.g. shop-ota-android.
+func (s *Server) pushTopic(platform string) string {
+	var topic string
+	switch platform {
+	case "android":
+		topic = config.PushTopicAndroid
+	case "ios":
+		topic = config.PushTopicIOS
+	default:
+		return ""
+	}
+	if s.appID != "" {
+		topic = s.appID + "-" + topic
+	}
+	return topic
+}
+
+// notifyNewVersion nudges devices on the version's platform topic to check
+// for updates. Failures are only logged; the version is already published.
+func (s *Server) notifyNewVersion(ctx context.Context, v AppVersion) {
+	topic := s.pushTopic(v.Platform)
+	if pushClient == nil || topic == "" {
+		return
+	}
+	// Devices would find nothing yet; scheduled releases aren't announced
+	if !isPublished(v, time.Now()) {
+		loggerFrom(ctx).Info("push notification skipped for scheduled version", "version_id", v.ID, "publish_at", v.PublishAt)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	mandatory := v.IsMandatory != nil && *v.IsMandatory
+	channel := v.Channel
+	if channel == "" {
+		channel = defaultChannel
+	}
+	data := map[string]string{
+		"type":         "ota_update",
+		"version":      v.Version,
+		"version_code": strconv.Itoa(v.VersionCode),
+		"platform":     v.Platform,
+		"channel":      channel,
+		"is_mandatory": strconv.FormatBool(mandatory),
+	}
+	if s.appID != "" {
+		data["app_id"] = s.appID
+	}
+	msg := &messaging.Message{
+		Topic: topic,
+		Data:  data,
+		Notification: &messaging.Notification{
+			Title: "Update available",
+			Body:  fmt.Sprintf("Version %s is ready to install", v.Version),
+		},
+	}
+	if mandatory {
+		msg.Notification.Title = "Required update available"
+	}
+
+	id, err := pushClient.Send(ctx, msg)
+	if err != nil {
+		loggerFrom(ctx).Warn("push notification failed", "topic", topic, "version_id", v.ID, "err", err)
+		return
+	}
+	loggerFrom(ctx).Info("push notification sent", "topic", topic, "version_id", v.ID, "message_id", id)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ipRateLimiter is a token bucket per client IP: each IP may burst up to
+// burst requests and then gets perMinute requests a minute. It protects
+// endpoints from a single client polling or uploading in a tight loop.
+type ipRateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newIPRateLimiter returns nil when perMinute is 0, meaning no limit; a
+// burst of 0 defaults to perMinute
+func newIPRateLimiter(perMinute, burst int) *ipRateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &ipRateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// bucketState describes a bucket after a request, for the RateLimit headers
+type bucketState struct {
+	remaining int           // whole tokens left
+	reset     time.Duration // until the bucket is full again
+	wait      time.Duration // until the next token, when none is left
+}
+
+// allow takes a token for key, reporting whether there was one and the
+// bucket's state afterwards
+func (l *ipRateLimiter) allow(key string, now time.Time) (bool, bucketState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	state := bucketState{
+		remaining: int(b.tokens),
+		reset:     time.Duration((l.burst - b.tokens) / l.rate * float64(time.Second)),
+	}
+	if !allowed {
+		state.wait = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	return allowed, state
+}
+
+// ceilSeconds rounds d up to whole seconds, at least minimum
+func ceilSeconds(d time.Duration, minimum int) int {
+	return max(int(math.Ceil(d.Seconds())), minimum)
+}
+
+// sweep drops buckets that have refilled completely, which behave exactly
+// like a missing bucket, so memory stays bounded by recently active IPs
+func (l *ipRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// middleware rejects requests over the limit with 429 and Retry-After. Every
+// response carries RateLimit-Limit (the burst), RateLimit-Remaining (whole
+// tokens left) and RateLimit-Reset (seconds until the bucket is full again)
+// from the client's bucket, keyed by clientIP.
+func (l *ipRateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+		ok, state := l.allow(clientIP(c), time.Now())
+		c.Header("RateLimit-Limit", strconv.Itoa(int(l.burst)))
+		c.Header("RateLimit-Remaining", strconv.Itoa(state.remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(state.reset, 0)))
+		if !ok {
+			retryAfter := ceilSeconds(state.wait, 1)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			respondErrorDetails(c, http.StatusTooManyRequests, codeRateLimited, "Too many requests, please retry later", gin.H{
+				"retry_after": retryAfter,
+			})
+			return
+		}
+		c.Next()
+	}
+}
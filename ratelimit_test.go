@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterState(t *testing.T) {
+	// 60 a minute is one token a second, with room for 3
+	l := newIPRateLimiter(60, 3)
+	start := time.Now()
+	tests := []struct {
+		at            time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantReset     time.Duration
+		wantWait      time.Duration
+	}{
+		{at: 0, wantAllowed: true, wantRemaining: 2, wantReset: time.Second},
+		{at: 0, wantAllowed: true, wantRemaining: 1, wantReset: 2 * time.Second},
+		{at: 0, wantAllowed: true, wantRemaining: 0, wantReset: 3 * time.Second},
+		{at: 0, wantAllowed: false, wantRemaining: 0, wantReset: 3 * time.Second, wantWait: time.Second},
+		{at: 500 * time.Millisecond, wantAllowed: false, wantRemaining: 0, wantReset: 2500 * time.Millisecond, wantWait: 500 * time.Millisecond},
+		{at: 2 * time.Second, wantAllowed: true, wantRemaining: 1, wantReset: 2 * time.Second},
+		{at: 10 * time.Second, wantAllowed: true, wantRemaining: 2, wantReset: time.Second},
+	}
+	for i, tt := range tests {
+		allowed, state := l.allow("192.0.2.1", start.Add(tt.at))
+		if allowed != tt.wantAllowed || state.remaining != tt.wantRemaining || state.reset != tt.wantReset || state.wait != tt.wantWait {
+			t.Errorf("request %d at %v: allowed=%t state=%+v, want allowed=%t remaining=%d reset=%v wait=%v",
+				i, tt.at, allowed, state, tt.wantAllowed, tt.wantRemaining, tt.wantReset, tt.wantWait)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	ts := newTestServer(t, "CHECK_UPDATE_RATE_LIMIT=60", "CHECK_UPDATE_BURST=3")
+	ts.seed(AppVersion{VersionCode: 1})
+
+	tests := []struct {
+		wantStatus     int
+		wantRemaining  string
+		wantReset      string
+		wantRetryAfter string
+	}{
+		{http.StatusOK, "2", "1", ""},
+		{http.StatusOK, "1", "2", ""},
+		{http.StatusOK, "0", "3", ""},
+		{http.StatusTooManyRequests, "0", "3", "1"},
+	}
+	for i, tt := range tests {
+		w := ts.do(http.MethodPost, "/api/v1/ota/check-update", UpdateCheckRequest{CurrentVersion: "1.0.1", CurrentCode: 1, Platform: "android"}, "")
+		if w.Code != tt.wantStatus {
+			t.Fatalf("request %d: status = %d, want %d", i, w.Code, tt.wantStatus)
+		}
+		headers := map[string]string{
+			"RateLimit-Limit":     "3",
+			"RateLimit-Remaining": tt.wantRemaining,
+			"RateLimit-Reset":     tt.wantReset,
+			"Retry-After":         tt.wantRetryAfter,
+		}
+		for name, want := range headers {
+			if got := w.Header().Get(name); got != want {
+				t.Errorf("request %d: %s = %q, want %q", i, name, got, want)
+			}
+		}
+	}
+}
+
+func TestRateLimitHeadersUnlimited(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPost, "/api/v1/ota/check-update", UpdateCheckRequest{CurrentVersion: "1.0.1", CurrentCode: 1, Platform: "android"}, "")
+	for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"} {
+		if got := w.Header().Get(name); got != "" {
+			t.Errorf("%s = %q without a limit", name, got)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		xff  []string
+		want string
+	}{
+		{name: "no header", want: "192.0.2.1"},
+		{name: "proxy's entry", xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "client-sent entries ignored", xff: []string{"198.51.100.1, 10.0.0.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "repeated headers", xff: []string{"198.51.100.1", "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "mapped IPv4", xff: []string{"::ffff:203.0.113.7"}, want: "203.0.113.7"},
+		{name: "garbage in the trusted entry", xff: []string{"203.0.113.7, not-an-ip"}, want: "192.0.2.1"},
+		{name: "two hops", env: []string{"TRUSTED_PROXY_HOPS=2"}, xff: []string{"198.51.100.1, 203.0.113.7, 10.0.0.1"}, want: "203.0.113.7"},
+		{name: "fewer entries than hops", env: []string{"TRUSTED_PROXY_HOPS=2"}, xff: []string{"203.0.113.7"}, want: "192.0.2.1"},
+		{name: "no trusted proxies", env: []string{"TRUSTED_PROXY_HOPS=0"}, xff: []string{"203.0.113.7"}, want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, tt.env...)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+			if got := clientIP(c); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitSpoofedForwardedFor(t *testing.T) {
+	ts := newTestServer(t, "CHECK_UPDATE_RATE_LIMIT=60", "CHECK_UPDATE_BURST=2")
+	ts.seed(AppVersion{VersionCode: 1})
+	body := UpdateCheckRequest{CurrentVersion: "1.0.1", CurrentCode: 1, Platform: "android"}
+
+	tests := []struct {
+		xff        string
+		wantStatus int
+	}{
+		{"198.51.100.1, 203.0.113.7", http.StatusOK},
+		{"198.51.100.2, 203.0.113.7", http.StatusOK},
+		// A new forged entry is still the same client behind Cloud Run
+		{"198.51.100.3, 203.0.113.7", http.StatusTooManyRequests},
+		{"203.0.113.7", http.StatusTooManyRequests},
+		// Another client has its own bucket
+		{"198.51.100.3, 203.0.113.8", http.StatusOK},
+	}
+	for i, tt := range tests {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ota/check-update", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", tt.xff)
+		if w := ts.send(req, ""); w.Code != tt.wantStatus {
+			t.Errorf("request %d (X-Forwarded-For: %s): status = %d, want %d", i, tt.xff, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestAuditClientIP(t *testing.T) {
+	ts := newTestServer(t)
+	ts.seed(AppVersion{VersionCode: 1})
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/ota/versions/android-1", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+	if w := ts.send(req, testAPIKey); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	entries, err := ts.store.ListAudit(context.Background())
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("audit = %v, %v", entries, err)
+	}
+	if got := entries[0].ClientIP; got != "203.0.113.7" {
+		t.Errorf("client_ip = %q, want 203.0.113.7", got)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isRetained reports whether a version must survive pruning: mandatory
+// builds, and builds still being rolled out to part of the fleet
+func isRetained(v AppVersion) bool {
+	if v.IsMandatory != nil && *v.IsMandatory {
+		return true
+	}
+	return v.RolloutPercentage != nil && *v.RolloutPercentage < 100
+}
+
+// pruneVersionsHandler applies the retention policy to one platform on
+// demand; keep overrides KEEP_LAST_N for this call
+func (s *Server) pruneVersionsHandler(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Query("platform")
+	if !isSupportedPlatform(platform) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform", gin.H{
+			"expected": supportedPlatforms,
+		})
+		return
+	}
+	if !authorizePlatform(c, platform) {
+		return
+	}
+	keep := config.KeepLastN
+	if raw := c.Query("keep"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid keep", gin.H{"expected": "positive integer"})
+			return
+		}
+		keep = n
+	}
+	if keep <= 0 {
+		respondErrorDetails(c, http.StatusBadRequest, codeMissingFields, "No retention limit configured", gin.H{
+			"required": []string{"keep or KEEP_LAST_N"},
+		})
+		return
+	}
+
+	pruned, err := s.pruneVersions(ctx, platform, keep)
+	for _, v := range pruned {
+		loggerFrom(ctx).Info("pruned version", "platform", platform, "version", v.Version, "version_code", v.VersionCode)
+		s.audit(c, auditPrune, &v, map[string]string{"keep": strconv.Itoa(keep)})
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("pruning old versions failed", "platform", platform, "err", err)
+		respondErrorDetails(c, http.StatusInternalServerError, codeDatabaseError, "Failed to prune versions", gin.H{
+			"pruned": pruned,
+		})
+		return
+	}
+	if pruned == nil {
+		pruned = []AppVersion{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"platform": platform,
+		"keep":     keep,
+		"pruned":   pruned,
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestPruneOnUpload(t *testing.T) {
+	yes := true
+	staged := 50
+	tests := []struct {
+		name       string
+		env        []string
+		existing   []AppVersion
+		failDelete bool
+		wantCodes  []int // android codes left after uploading code 3
+	}{
+		{name: "unlimited by default", existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}}, wantCodes: []int{1, 2, 3}},
+		{name: "oldest pruned", env: []string{"KEEP_LAST_N=2"}, existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}}, wantCodes: []int{2, 3}},
+		{name: "under the limit", env: []string{"KEEP_LAST_N=3"}, existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}}, wantCodes: []int{1, 2, 3}},
+		{name: "legacy variable", env: []string{"MAX_VERSIONS_PER_PLATFORM=1"}, existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}}, wantCodes: []int{3}},
+		{name: "mandatory kept", env: []string{"KEEP_LAST_N=1"}, existing: []AppVersion{{VersionCode: 1, IsMandatory: &yes}, {VersionCode: 2}}, wantCodes: []int{1, 3}},
+		{name: "staged rollout kept", env: []string{"KEEP_LAST_N=1"}, existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2, RolloutPercentage: &staged}}, wantCodes: []int{2, 3}},
+		{name: "other platforms untouched", env: []string{"KEEP_LAST_N=1"}, existing: []AppVersion{{VersionCode: 1, Platform: "ios"}, {VersionCode: 2}}, wantCodes: []int{3}},
+		{name: "failed prune keeps the upload", env: []string{"KEEP_LAST_N=1"}, existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}}, failDelete: true, wantCodes: []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingStore{memoryStore: newMemoryStore(), failDelete: tt.failDelete}
+			ts := newTestServerWithStore(t, store, tt.env...)
+			var seeded []AppVersion
+			for _, v := range tt.existing {
+				seeded = append(seeded, ts.seed(v))
+			}
+
+			fields := map[string]string{"version": "1.0.3", "version_code": "3", "platform": "android"}
+			if w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("upload: %d %s", w.Code, w.Body)
+			}
+
+			ctx := context.Background()
+			versions, err := store.ListVersions(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var codes []int
+			for _, v := range versions {
+				if v.Platform == "android" {
+					codes = append(codes, v.VersionCode)
+				}
+			}
+			slices.Sort(codes)
+			if !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("android codes = %v, want %v", codes, tt.wantCodes)
+			}
+			if tt.failDelete {
+				return
+			}
+			// Pruned versions take their files with them
+			for _, v := range seeded {
+				_, kept := versions[v.ID]
+				objects, _ := store.ListObjects(ctx, v.StoragePath)
+				if kept != (len(objects) == 1) {
+					t.Errorf("%s: record kept %t, %d objects", v.ID, kept, len(objects))
+				}
+			}
+		})
+	}
+}
+
+func TestPruneAfterRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  []AppVersion
+		rollback  string
+		keep      string
+		wantCodes []int
+	}{
+		{name: "no rollback", existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3}, {VersionCode: 4}}, keep: "2", wantCodes: []int{3, 4}},
+		{name: "rollback target kept", existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3}, {VersionCode: 4}}, rollback: "android-2", keep: "2", wantCodes: []int{2, 3, 4}},
+		{name: "rollback target within keep", existing: []AppVersion{{VersionCode: 1}, {VersionCode: 2}, {VersionCode: 3}}, rollback: "android-2", keep: "2", wantCodes: []int{2, 3}},
+		{name: "each channel's latest kept", existing: []AppVersion{{VersionCode: 1, Channel: "beta"}, {VersionCode: 2}, {VersionCode: 3}, {VersionCode: 4}}, keep: "1", wantCodes: []int{1, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, v := range tt.existing {
+				ts.seed(v)
+			}
+			if tt.rollback != "" {
+				if w := ts.do(http.MethodPost, "/api/v1/ota/rollback", map[string]string{"version_id": tt.rollback}, testAPIKey); w.Code != http.StatusOK {
+					t.Fatalf("rollback: %d %s", w.Code, w.Body)
+				}
+			}
+			if w := ts.do(http.MethodPost, "/api/v1/ota/prune?platform=android&keep="+tt.keep, nil, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("prune: %d %s", w.Code, w.Body)
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var codes []int
+			for _, v := range versions {
+				codes = append(codes, v.VersionCode)
+			}
+			slices.Sort(codes)
+			if !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("codes = %v, want %v", codes, tt.wantCodes)
+			}
+			if tt.rollback != "" {
+				if resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1}); !resp.UpdateAvailable || resp.LatestVersion.ID != tt.rollback {
+					t.Errorf("check-update offers %+v, want %s", resp.LatestVersion, tt.rollback)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneOnUploadAudited(t *testing.T) {
+	ts := newTestServer(t, "KEEP_LAST_N=1")
+	ts.seed(AppVersion{VersionCode: 1})
+	fields := map[string]string{"version": "1.0.2", "version_code": "2", "platform": "android"}
+	if w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey); w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body)
+	}
+
+	entries, err := ts.store.ListAudit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pruned []AuditEntry
+	for _, e := range entries {
+		if e.Action == auditPrune {
+			pruned = append(pruned, e)
+		}
+	}
+	if len(pruned) != 1 {
+		t.Fatalf("prune entries = %+v, want one", pruned)
+	}
+	if e := pruned[0]; e.VersionID != "android-1" || e.APIKeyID != auditSystemActor || e.ClientIP != "" || e.Details["keep"] != "1" {
+		t.Errorf("prune entry = %+v", e)
+	}
+}
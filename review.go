@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuildSummary is the subset of a version shown in a release review
+type BuildSummary struct {
+	ID           string `json:"id"`
+	Version      string `json:"version"`
+	VersionCode  int    `json:"version_code"`
+	FileSize     int64  `json:"file_size"`
+	Checksum     string `json:"checksum"`
+	ReleaseNotes string `json:"release_notes"`
+}
+
+// BuildReview describes what changed between a baseline and a candidate build
+type BuildReview struct {
+	Platform           string       `json:"platform"`
+	Baseline           BuildSummary `json:"baseline"`
+	Candidate          BuildSummary `json:"candidate"`
+	VersionCodeDelta   int          `json:"version_code_delta"`
+	SizeDelta          int64        `json:"size_delta"`
+	SameArtifact       bool         `json:"same_artifact"`
+	ReleaseNotesDiffer bool         `json:"release_notes_differ"`
+}
+
+// reviewBuilds compares a candidate build against a baseline (usually the
+// current production build) to support a gated release process
+func (s *Server) reviewBuilds(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Query("platform")
+	candidateID := c.Query("candidate")
+	baselineID := c.Query("baseline")
+
+	if !isSupportedPlatform(platform) {
+		respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
+		return
+	}
+	if candidateID == "" || baselineID == "" {
+		respondErrorDetails(c, http.StatusBadRequest, codeMissingFields, "Missing required fields", gin.H{
+			"required": []string{"candidate", "baseline"},
+		})
+		return
+	}
+
+	builds := map[string]*AppVersion{}
+	for _, id := range []string{candidateID, baselineID} {
+		v, err := s.store.GetVersion(ctx, id)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+			return
+		}
+		if v == nil {
+			respondErrorDetails(c, http.StatusNotFound, codeNotFound, "Version not found", gin.H{"id": id})
+			return
+		}
+		if !matchesPlatform(*v, platform) {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Version does not belong to platform "+platform, gin.H{
+				"id": id,
+			})
+			return
+		}
+		builds[id] = v
+	}
+
+	candidate, baseline := builds[candidateID], builds[baselineID]
+	c.JSON(http.StatusOK, BuildReview{
+		Platform:           platform,
+		Baseline:           summarizeBuild(baseline),
+		Candidate:          summarizeBuild(candidate),
+		VersionCodeDelta:   candidate.VersionCode - baseline.VersionCode,
+		SizeDelta:          candidate.FileSize - baseline.FileSize,
+		SameArtifact:       candidate.Checksum != "" && candidate.Checksum == baseline.Checksum,
+		ReleaseNotesDiffer: candidate.ReleaseNotes != baseline.ReleaseNotes,
+	})
+}
+
+func summarizeBuild(v *AppVersion) BuildSummary {
+	return BuildSummary{
+		ID:           v.ID,
+		Version:      v.Version,
+		VersionCode:  v.VersionCode,
+		FileSize:     v.FileSize,
+		Checksum:     v.Checksum,
+		ReleaseNotes: v.ReleaseNotes,
+	}
+}
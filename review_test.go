@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReviewBuilds(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCode   string
+		want       BuildReview
+	}{
+		{
+			name:       "candidate against production",
+			query:      "platform=android&candidate=android-candidate&baseline=prod",
+			wantStatus: http.StatusOK,
+			want: BuildReview{
+				Platform:           "android",
+				VersionCodeDelta:   2,
+				SizeDelta:          int64(len("android-candidate") - len("prod")),
+				ReleaseNotesDiffer: true,
+			},
+		},
+		{
+			name:       "build against itself",
+			query:      "platform=android&candidate=prod&baseline=prod",
+			wantStatus: http.StatusOK,
+			want:       BuildReview{Platform: "android", SameArtifact: true},
+		},
+		{name: "missing baseline", query: "platform=android&candidate=prod", wantStatus: http.StatusBadRequest, wantCode: codeMissingFields},
+		{name: "unknown build", query: "platform=android&candidate=missing&baseline=prod", wantStatus: http.StatusNotFound, wantCode: codeNotFound},
+		{name: "build of another platform", query: "platform=android&candidate=ios-3&baseline=prod", wantStatus: http.StatusBadRequest, wantCode: codeInvalidPlatform},
+		{name: "invalid platform", query: "platform=symbian&candidate=prod&baseline=prod", wantStatus: http.StatusBadRequest, wantCode: codeInvalidPlatform},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{ID: "prod", VersionCode: 1, ReleaseNotes: "Bug fixes"})
+			ts.seed(AppVersion{ID: "android-candidate", VersionCode: 3, ReleaseNotes: "New onboarding"})
+			ts.seed(AppVersion{VersionCode: 3, Platform: "ios"})
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/review?"+tt.query, nil, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+				return
+			}
+			var got BuildReview
+			decodeJSON(t, w, &got)
+			if got.Platform != tt.want.Platform || got.VersionCodeDelta != tt.want.VersionCodeDelta || got.SizeDelta != tt.want.SizeDelta ||
+				got.SameArtifact != tt.want.SameArtifact || got.ReleaseNotesDiffer != tt.want.ReleaseNotesDiffer {
+				t.Errorf("review = %+v, want %+v", got, tt.want)
+			}
+			if got.Baseline.ID != "prod" || got.Baseline.ReleaseNotes != "Bug fixes" || got.Baseline.Checksum == "" {
+				t.Errorf("baseline = %+v", got.Baseline)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isEnabled reports whether a version may be offered; records without the
+// flag predate it and are enabled
+func isEnabled(v AppVersion) bool {
+	return v.Enabled == nil || *v.Enabled
+}
+
+// enabledVersions drops disabled versions
+func enabledVersions(versions map[string]AppVersion) map[string]AppVersion {
+	enabled := make(map[string]AppVersion, len(versions))
+	for id, v := range versions {
+		if isEnabled(v) {
+			enabled[id] = v
+		}
+	}
+	return enabled
+}
+
+// RollbackRequest names the version that should become the latest again
+type RollbackRequest struct {
+	VersionID string `json:"version_id" binding:"required"`
+}
+
+// rollback makes an older build the latest again by disabling every newer
+// version on its platform and channel. Nothing is deleted, so the disabled
+// builds can be re-enabled once fixed.
+func (s *Server) rollback(c *gin.Context) {
+	ctx := requestContext(c)
+	var req RollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if !isValidKey(req.VersionID) {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version id")
+		return
+	}
+
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("version fetch failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	target, ok := versions[req.VersionID]
+	if !ok {
+		respondError(c, http.StatusNotFound, codeNotFound, "Version not found")
+		return
+	}
+	if !authorizePlatform(c, platformOf(target)) {
+		return
+	}
+
+	now := time.Now()
+	enabled, disabled := true, false
+	var changed []AppVersion
+	disabledIDs := []string{}
+	for _, v := range versions {
+		if v.ID == target.ID || !matchesPlatform(v, target.Platform) || v.Channel != target.Channel ||
+			v.VersionCode <= target.VersionCode || !isEnabled(v) {
+			continue
+		}
+		v.Enabled = &disabled
+		v.UpdatedAt = now
+		changed = append(changed, v)
+		disabledIDs = append(disabledIDs, v.ID)
+	}
+	if !isEnabled(target) {
+		target.Enabled = &enabled
+		target.UpdatedAt = now
+		changed = append(changed, target)
+	}
+	sort.Strings(disabledIDs)
+
+	if len(changed) > 0 {
+		if err := s.store.UpdateVersions(ctx, changed, "enabled", "updated_at"); err != nil {
+			loggerFrom(ctx).Error("version save failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to roll back")
+			return
+		}
+		for _, v := range changed {
+			s.trackLatest(ctx, v, false)
+		}
+	}
+	loggerFrom(ctx).Info("rolled back",
+		"platform", target.Platform, "channel", target.Channel, "version_id", target.ID, "disabled", disabledIDs)
+	s.audit(c, auditRollback, &target, map[string]string{"disabled": strings.Join(disabledIDs, ",")})
+
+	target.DownloadURL = s.downloadURL(target.Version, target.Platform)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Rolled back successfully",
+		"version":  target,
+		"disabled": disabledIDs,
+	})
+}
+
+// setVersionEnabled returns a handler that withdraws a version from clients
+// or brings it back, keeping its artifact and metadata
+func (s *Server) setVersionEnabled(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := requestContext(c)
+		id := c.Param("id")
+		if !isValidKey(id) {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version id")
+			return
+		}
+
+		version, err := s.store.GetVersion(ctx, id)
+		if err != nil {
+			loggerFrom(ctx).Error("version read failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+			return
+		}
+		if version == nil {
+			respondError(c, http.StatusNotFound, codeNotFound, "Version not found")
+			return
+		}
+		if !authorizePlatform(c, platformOf(*version)) {
+			return
+		}
+
+		if isEnabled(*version) != enabled {
+			version.Enabled = &enabled
+			version.UpdatedAt = time.Now()
+			if err := s.store.UpdateVersions(ctx, []AppVersion{*version}, "enabled", "updated_at"); err != nil {
+				loggerFrom(ctx).Error("version save failed", "err", err)
+				respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to save version information")
+				return
+			}
+			s.trackLatest(ctx, *version, false)
+			loggerFrom(ctx).Info("version enabled changed", "version_id", id, "enabled", enabled)
+			action := auditDisable
+			if enabled {
+				action = auditEnable
+			}
+			s.audit(c, action, version, nil)
+		}
+
+		version.DownloadURL = s.downloadURL(version.Version, version.Platform)
+		c.JSON(http.StatusOK, gin.H{"version": version})
+	}
+}
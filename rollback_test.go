@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRollback(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name         string
+		predisabled  string
+		target       string
+		wantStatus   int
+		wantCode     string
+		wantDisabled []string // ids disabled afterwards, sorted
+		wantLatest   int      // code offered to a client on 1
+	}{
+		{name: "disables newer builds", target: "android-2", wantStatus: http.StatusOK, wantDisabled: []string{"android-3", "android-4"}, wantLatest: 2},
+		{name: "latest is a no-op", target: "android-4", wantStatus: http.StatusOK, wantDisabled: []string{}, wantLatest: 4},
+		{name: "re-enables a disabled target", predisabled: "android-3", target: "android-3", wantStatus: http.StatusOK, wantDisabled: []string{"android-4"}, wantLatest: 3},
+		{name: "unknown version", target: "missing", wantStatus: http.StatusNotFound, wantCode: codeNotFound, wantLatest: 4},
+		{name: "invalid id", target: "a/b", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest, wantLatest: 4},
+		{name: "missing id", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest, wantLatest: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2})
+			ts.seed(AppVersion{VersionCode: 3})
+			ts.seed(AppVersion{VersionCode: 4})
+			// Neither another channel nor another platform is touched
+			ts.seed(AppVersion{VersionCode: 5, Channel: "beta"})
+			ts.seed(AppVersion{VersionCode: 6, Platform: "ios"})
+			if tt.predisabled != "" {
+				v, _ := ts.store.GetVersion(context.Background(), tt.predisabled)
+				v.Enabled = &disabled
+				ts.store.PutVersion(context.Background(), *v)
+			}
+
+			w := ts.do(http.MethodPost, "/api/v1/ota/rollback", gin.H{"version_id": tt.target}, testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+			} else {
+				var body struct {
+					Version  AppVersion `json:"version"`
+					Disabled []string   `json:"disabled"`
+				}
+				decodeJSON(t, w, &body)
+				if body.Version.ID != tt.target || !isEnabled(body.Version) || body.Version.DownloadURL == "" {
+					t.Errorf("version = %+v", body.Version)
+				}
+				if !slices.Equal(body.Disabled, tt.wantDisabled) {
+					t.Errorf("disabled = %v, want %v", body.Disabled, tt.wantDisabled)
+				}
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, v := range versions {
+				if want := !slices.Contains(tt.wantDisabled, id); isEnabled(v) != want {
+					t.Errorf("%s enabled = %t, want %t", id, isEnabled(v), want)
+				}
+			}
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1})
+			if !resp.UpdateAvailable || resp.LatestVersion.VersionCode != tt.wantLatest {
+				t.Errorf("check-update offers %+v, want code %d", resp.LatestVersion, tt.wantLatest)
+			}
+		})
+	}
+}
+
+func TestRollbackMandatory(t *testing.T) {
+	tests := []struct {
+		name          string
+		mandatory     int // version code flagged mandatory
+		current       int
+		wantAvailable bool
+		wantMandatory bool
+	}{
+		{name: "pulled mandatory build no longer forces", mandatory: 3, current: 1, wantAvailable: true, wantMandatory: false},
+		{name: "mandatory build at or below the target still forces", mandatory: 2, current: 1, wantAvailable: true, wantMandatory: true},
+		{name: "device on a pulled build is not downgraded", mandatory: 3, current: 3, wantAvailable: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			yes := true
+			for code := 1; code <= 3; code++ {
+				v := AppVersion{VersionCode: code}
+				if code == tt.mandatory {
+					v.IsMandatory = &yes
+				}
+				ts.seed(v)
+			}
+			if w := ts.do(http.MethodPost, "/api/v1/ota/rollback", gin.H{"version_id": "android-2"}, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("rollback: %d %s", w.Code, w.Body)
+			}
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: tt.current})
+			if resp.UpdateAvailable != tt.wantAvailable {
+				t.Fatalf("update_available = %t, want %t", resp.UpdateAvailable, tt.wantAvailable)
+			}
+			if tt.wantAvailable && (resp.LatestVersion.VersionCode != 2 || resp.IsMandatory != tt.wantMandatory) {
+				t.Errorf("offered code %d mandatory %t, want code 2 mandatory %t",
+					resp.LatestVersion.VersionCode, resp.IsMandatory, tt.wantMandatory)
+			}
+		})
+	}
+}
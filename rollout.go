@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rolloutPercentage returns the share of devices a version is offered to;
+// versions without a staged rollout go to everyone
+func rolloutPercentage(v AppVersion) int {
+	if v.RolloutPercentage == nil {
+		return 100
+	}
+	return *v.RolloutPercentage
+}
+
+// rolloutBucket deterministically places a device in [0, 100) for a version,
+// so ramping a percentage up only ever adds devices
+func rolloutBucket(deviceID, versionID string) int {
+	sum := sha256.Sum256([]byte(deviceID + ":" + versionID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// inRollout reports whether a device is offered v. Devices that don't send
+// an id only see fully rolled-out versions.
+func inRollout(v AppVersion, deviceID string) bool {
+	pct := rolloutPercentage(v)
+	if pct >= 100 {
+		return true
+	}
+	if deviceID == "" {
+		return false
+	}
+	return rolloutBucket(deviceID, v.ID) < pct
+}
+
+// rolledOutTo keeps the versions that are rolled out to deviceID
+func rolledOutTo(versions map[string]AppVersion, deviceID string) map[string]AppVersion {
+	offered := make(map[string]AppVersion, len(versions))
+	for id, v := range versions {
+		if inRollout(v, deviceID) {
+			offered[id] = v
+		}
+	}
+	return offered
+}
+
+// parseRolloutPercentage parses an optional rollout percentage, returning nil when blank
+func parseRolloutPercentage(s string) (*int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	pct, err := strconv.Atoi(s)
+	if err != nil || !validRolloutPercentage(pct) {
+		return nil, fmt.Errorf("invalid rollout percentage %q", s)
+	}
+	return &pct, nil
+}
+
+func validRolloutPercentage(pct int) bool {
+	return pct >= 0 && pct <= 100
+}
+
+// setRolloutPaused returns a handler that freezes a version's rollout at its
+// current percentage, or lets it be ramped again. Pausing stores the
+// effective percentage, the platform policy's when the version has none, so
+// check-update keeps offering the version to the same devices however the
+// policy changes meanwhile; only percentage changes are refused.
+func (s *Server) setRolloutPaused(paused bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := requestContext(c)
+		id := c.Param("id")
+		if !isValidKey(id) {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version id")
+			return
+		}
+
+		version, err := s.store.GetVersion(ctx, id)
+		if err != nil {
+			loggerFrom(ctx).Error("version read failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+			return
+		}
+		if version == nil {
+			respondError(c, http.StatusNotFound, codeNotFound, "Version not found")
+			return
+		}
+		if !authorizePlatform(c, platformOf(*version)) {
+			return
+		}
+
+		if version.RolloutPaused != paused {
+			if paused && version.RolloutPercentage == nil {
+				cfg, err := s.store.GetPlatformConfig(ctx, platformOf(*version))
+				if err != nil {
+					loggerFrom(ctx).Error("platform config read failed", "err", err)
+					respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+					return
+				}
+				pct := rolloutPercentage(cfg.applyPolicy(map[string]AppVersion{id: *version})[id])
+				version.RolloutPercentage = &pct
+			}
+			version.RolloutPaused = paused
+			version.UpdatedAt = time.Now()
+			if err := s.store.UpdateVersions(ctx, []AppVersion{*version}, "rollout_paused", "rollout_percentage", "updated_at"); err != nil {
+				loggerFrom(ctx).Error("version save failed", "err", err)
+				respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to save version information")
+				return
+			}
+			loggerFrom(ctx).Info("rollout pause changed", "version_id", id, "paused", paused, "rollout_percentage", rolloutPercentage(*version))
+			action := auditRolloutResume
+			if paused {
+				action = auditRolloutPause
+			}
+			s.audit(c, action, version, map[string]string{"rollout_percentage": strconv.Itoa(rolloutPercentage(*version))})
+		}
+
+		version.DownloadURL = s.downloadURL(version.Version, version.Platform)
+		c.JSON(http.StatusOK, gin.H{"version": version})
+	}
+}
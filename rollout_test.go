@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// devicesAround returns a device inside and one outside pct of v's rollout
+func devicesAround(t *testing.T, versionID string, pct int) (in, out string) {
+	t.Helper()
+	for i := 0; in == "" || out == ""; i++ {
+		id := fmt.Sprintf("device-%d", i)
+		if rolloutBucket(id, versionID) < pct {
+			in = id
+		} else {
+			out = id
+		}
+	}
+	return in, out
+}
+
+func TestRolloutPause(t *testing.T) {
+	pct := func(n int) *int { return &n }
+	tests := []struct {
+		name       string
+		pause      bool
+		resume     bool
+		rollout    *int
+		wantStatus int
+		wantPct    int
+	}{
+		{name: "ramp while running", rollout: pct(80), wantStatus: http.StatusOK, wantPct: 80},
+		{name: "ramp while paused", pause: true, rollout: pct(80), wantStatus: http.StatusConflict, wantPct: 30},
+		{name: "roll back while paused", pause: true, rollout: pct(10), wantStatus: http.StatusConflict, wantPct: 30},
+		{name: "same percentage while paused", pause: true, rollout: pct(30), wantStatus: http.StatusOK, wantPct: 30},
+		{name: "other edit while paused", pause: true, wantStatus: http.StatusOK, wantPct: 30},
+		{name: "ramp after resume", pause: true, resume: true, rollout: pct(80), wantStatus: http.StatusOK, wantPct: 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2, RolloutPercentage: pct(30)})
+			if tt.pause {
+				if w := ts.do(http.MethodPost, "/api/v1/ota/versions/android-2/rollout/pause", nil, testAPIKey); w.Code != http.StatusOK {
+					t.Fatalf("pause: %d %s", w.Code, w.Body)
+				}
+			}
+			if tt.resume {
+				if w := ts.do(http.MethodPost, "/api/v1/ota/versions/android-2/rollout/resume", nil, testAPIKey); w.Code != http.StatusOK {
+					t.Fatalf("resume: %d %s", w.Code, w.Body)
+				}
+			}
+
+			w := ts.do(http.MethodPut, "/api/v1/ota/versions/android-2", VersionUpdate{
+				ReleaseNotes:      new(string),
+				RolloutPercentage: tt.rollout,
+			}, testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusConflict {
+				if code := errorCode(t, w); code != codeRolloutPaused {
+					t.Errorf("code = %q, want %q", code, codeRolloutPaused)
+				}
+			}
+
+			v, err := ts.store.GetVersion(context.Background(), "android-2")
+			if err != nil || v == nil {
+				t.Fatalf("GetVersion: %v, %v", v, err)
+			}
+			if got := rolloutPercentage(*v); got != tt.wantPct {
+				t.Errorf("rollout_percentage = %d, want %d", got, tt.wantPct)
+			}
+			if v.RolloutPaused != (tt.pause && !tt.resume) {
+				t.Errorf("rollout_paused = %t", v.RolloutPaused)
+			}
+		})
+	}
+}
+
+func TestRolloutPauseKeepsOffering(t *testing.T) {
+	ts := newTestServer(t)
+	ts.seed(AppVersion{VersionCode: 1})
+	ts.seed(AppVersion{VersionCode: 2, RolloutPercentage: func(n int) *int { return &n }(30)})
+	in, out := devicesAround(t, "android-2", 30)
+
+	if w := ts.do(http.MethodPost, "/api/v1/ota/versions/android-2/rollout/pause", nil, testAPIKey); w.Code != http.StatusOK {
+		t.Fatalf("pause: %d %s", w.Code, w.Body)
+	}
+	for device, want := range map[string]bool{in: true, out: false} {
+		resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1, DeviceID: device})
+		if resp.UpdateAvailable != want {
+			t.Errorf("device %s: update_available = %t, want %t", device, resp.UpdateAvailable, want)
+		}
+	}
+
+	if w := ts.do(http.MethodPost, "/api/v1/ota/versions/android-2/rollout/resume", nil, testAPIKey); w.Code != http.StatusOK {
+		t.Fatalf("resume: %d %s", w.Code, w.Body)
+	}
+	entries, err := ts.store.ListAudit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+		if e.Details["rollout_percentage"] != "30" {
+			t.Errorf("%s details = %v", e.Action, e.Details)
+		}
+	}
+	if len(actions) != 2 || actions[0] != auditRolloutPause || actions[1] != auditRolloutResume {
+		t.Errorf("audit actions = %v", actions)
+	}
+}
+
+func TestRolloutPauseUnknownVersion(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPost, "/api/v1/ota/versions/missing/rollout/pause", nil, testAPIKey)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestRolloutPauseFreezesPolicy(t *testing.T) {
+	pct := func(n int) *int { return &n }
+	tests := []struct {
+		name    string
+		own     *int // the version's rollout_percentage
+		policy  *int // platform policy before pausing
+		wantPct int
+	}{
+		{name: "policy percentage frozen", policy: pct(40), wantPct: 40},
+		{name: "own percentage kept", own: pct(30), policy: pct(40), wantPct: 30},
+		{name: "no policy freezes full rollout", wantPct: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2, RolloutPercentage: tt.own})
+			setPolicy := func(p *int) {
+				if w := ts.do(http.MethodPut, "/api/v1/ota/platforms/android/policy", PlatformPolicy{RolloutPercentage: p}, testAPIKey); w.Code != http.StatusOK {
+					t.Fatalf("set policy: %d %s", w.Code, w.Body)
+				}
+			}
+			setPolicy(tt.policy)
+			if w := ts.do(http.MethodPost, "/api/v1/ota/versions/android-2/rollout/pause", nil, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("pause: %d %s", w.Code, w.Body)
+			}
+
+			// Ramping the policy while paused must not reach the frozen version
+			setPolicy(pct(90))
+			v, err := ts.store.GetVersion(context.Background(), "android-2")
+			if err != nil || v == nil {
+				t.Fatalf("GetVersion: %v, %v", v, err)
+			}
+			if got := rolloutPercentage(*v); got != tt.wantPct {
+				t.Errorf("rollout_percentage = %d, want %d", got, tt.wantPct)
+			}
+			if tt.wantPct < 90 {
+				// A device the policy's 90% would reach but the frozen share doesn't
+				var out string
+				for i := 0; out == ""; i++ {
+					if id := fmt.Sprintf("device-%d", i); rolloutBucket(id, "android-2") >= tt.wantPct && rolloutBucket(id, "android-2") < 90 {
+						out = id
+					}
+				}
+				if resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1, DeviceID: out}); resp.UpdateAvailable {
+					t.Errorf("device outside the frozen %d%% offered the version", tt.wantPct)
+				}
+			}
+
+			entries, err := ts.store.ListAudit(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Action == auditRolloutPause && e.Details["rollout_percentage"] != strconv.Itoa(tt.wantPct) {
+					t.Errorf("pause audit details = %v, want %d", e.Details, tt.wantPct)
+				}
+			}
+		})
+	}
+}
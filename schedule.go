@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Version statuses shown in listings
+const (
+	statusPublished = "published"
+	statusScheduled = "scheduled"
+	statusDisabled  = "disabled"
+	statusTrashed   = "trashed"
+)
+
+// isPublished reports whether a scheduled version has reached its publish
+// time; from that instant on it is offered. Unscheduled versions always are.
+func isPublished(v AppVersion, now time.Time) bool {
+	return v.PublishAt == nil || !now.Before(*v.PublishAt)
+}
+
+// releasedVersions keeps the versions clients may get: enabled and past
+// their publish time
+func releasedVersions(versions map[string]AppVersion, now time.Time) map[string]AppVersion {
+	released := make(map[string]AppVersion, len(versions))
+	for id, v := range enabledVersions(versions) {
+		if isPublished(v, now) {
+			released[id] = v
+		}
+	}
+	return released
+}
+
+// versionStatus summarizes whether clients can get a version, for the admin view
+func versionStatus(v AppVersion, now time.Time) string {
+	switch {
+	case v.DeletedAt != nil:
+		return statusTrashed
+	case !isEnabled(v):
+		return statusDisabled
+	case !isPublished(v, now):
+		return statusScheduled
+	default:
+		return statusPublished
+	}
+}
+
+// parsePublishAt reads an optional RFC 3339 publish time; "" means publish immediately
+func parsePublishAt(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	t = t.UTC()
+	return &t, nil
+}
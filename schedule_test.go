@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsPublished(t *testing.T) {
+	at := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		now  time.Time
+		want bool
+	}{
+		{name: "a nanosecond before", now: at.Add(-time.Nanosecond), want: false},
+		{name: "at the publish time", now: at, want: true},
+		{name: "after", now: at.Add(time.Nanosecond), want: true},
+		{name: "same instant in another zone", now: at.In(time.FixedZone("CEST", 2*60*60)), want: true},
+	}
+	for _, tt := range tests {
+		if got := isPublished(AppVersion{PublishAt: &at}, tt.now); got != tt.want {
+			t.Errorf("%s: isPublished = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+	if !isPublished(AppVersion{}, at) {
+		t.Error("unscheduled version not published")
+	}
+}
+
+func TestScheduledRelease(t *testing.T) {
+	tests := []struct {
+		name         string
+		publishAt    time.Duration // from now
+		wantOffered  bool
+		wantDownload int
+		wantStatus   string
+	}{
+		{name: "future", publishAt: time.Hour, wantOffered: false, wantDownload: http.StatusNotFound, wantStatus: statusScheduled},
+		{name: "past", publishAt: -time.Second, wantOffered: true, wantDownload: http.StatusOK, wantStatus: statusPublished},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			at := time.Now().Add(tt.publishAt)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2, PublishAt: &at})
+
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1})
+			if resp.UpdateAvailable != tt.wantOffered {
+				t.Errorf("update_available = %t, want %t", resp.UpdateAvailable, tt.wantOffered)
+			}
+
+			if w := ts.do(http.MethodGet, "/api/v1/ota/download/1.0.2?platform=android", nil, ""); w.Code != tt.wantDownload {
+				t.Errorf("download status = %d, want %d", w.Code, tt.wantDownload)
+			}
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/versions?platform=android", nil, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("versions: %d %s", w.Code, w.Body)
+			}
+			var versions []AppVersion
+			decodeJSON(t, w, &versions)
+			found := false
+			for _, v := range versions {
+				if v.ID == "android-2" {
+					found = true
+					if v.Status != tt.wantStatus {
+						t.Errorf("status = %q, want %q", v.Status, tt.wantStatus)
+					}
+				}
+			}
+			if !found {
+				t.Error("scheduled version missing from the admin listing")
+			}
+		})
+	}
+}
+
+func TestUploadPublishAt(t *testing.T) {
+	tests := []struct {
+		name       string
+		publishAt  string
+		wantStatus int
+		wantAt     *time.Time
+	}{
+		{name: "unset", wantStatus: http.StatusOK},
+		{name: "RFC 3339", publishAt: "2030-06-01T11:00:00+02:00", wantStatus: http.StatusOK, wantAt: func() *time.Time {
+			at := time.Date(2030, 6, 1, 9, 0, 0, 0, time.UTC)
+			return &at
+		}()},
+		{name: "not a time", publishAt: "tomorrow", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			fields := map[string]string{"version": "1.0.1", "version_code": "1", "platform": "android", "publish_at": tt.publishAt}
+			w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != codeInvalidRequest {
+					t.Errorf("code = %q, want %q", code, codeInvalidRequest)
+				}
+				return
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil || len(versions) != 1 {
+				t.Fatalf("versions = %v, %v", versions, err)
+			}
+			for _, v := range versions {
+				switch {
+				case tt.wantAt == nil && v.PublishAt != nil:
+					t.Errorf("publish_at = %v, want unset", v.PublishAt)
+				case tt.wantAt != nil && (v.PublishAt == nil || !v.PublishAt.Equal(*tt.wantAt)):
+					t.Errorf("publish_at = %v, want %v", v.PublishAt, tt.wantAt)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// defaultVersionPattern is the regular expression semver.org gives for a
+// full MAJOR.MINOR.PATCH version with optional pre-release and build metadata
+const defaultVersionPattern = `^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`
+
+// normalizeVersion readies a submitted version string for storage: without
+// a leading "v" when STRIP_VERSION_PREFIX is set, and ok only when it then
+// matches VERSION_PATTERN, so one release isn't stored as "v1.2.0" and the
+// next as "1.2"
+func normalizeVersion(version string) (string, bool) {
+	if config.StripVersionPrefix {
+		version = strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+	}
+	if config.VersionPattern != nil && !config.VersionPattern.MatchString(version) {
+		return version, false
+	}
+	return version, true
+}
+
+// semver is a parsed semantic version; build metadata is dropped since it
+// carries no precedence
+type semver struct {
+	core [3]int
+	pre  []string
+}
+
+// parseSemver parses versions like "1.4.2", "v2.0" or "1.0.0-beta.2+45".
+// Missing minor/patch segments count as zero.
+func parseSemver(s string) (semver, bool) {
+	var v semver
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		v.pre = strings.Split(s[i+1:], ".")
+		for _, id := range v.pre {
+			if id == "" {
+				return semver{}, false
+			}
+		}
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return semver{}, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p[0] == '+' {
+			return semver{}, false
+		}
+		v.core[i] = n
+	}
+	return v, true
+}
+
+// compareSemver returns -1, 0 or 1 as a is lower, equal to or higher than b
+// in semver precedence. Strings that don't parse sort below valid versions;
+// use parseSemver first when a fallback ordering is needed.
+func compareSemver(a, b string) int {
+	va, okA := parseSemver(a)
+	vb, okB := parseSemver(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+
+	for i := range va.core {
+		if c := cmp.Compare(va.core[i], vb.core[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A pre-release ranks below the release it precedes
+	switch {
+	case len(va.pre) == 0 && len(vb.pre) == 0:
+		return 0
+	case len(va.pre) == 0:
+		return 1
+	case len(vb.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		if c := comparePrerelease(va.pre[i], vb.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(va.pre), len(vb.pre))
+}
+
+// comparePrerelease orders a single pre-release identifier: numeric ones
+// compare numerically and rank below alphanumeric ones
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
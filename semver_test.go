@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10.0", "1.9.0", 1},
+		{"1.9.0", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0", "1.0.0", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1", "1.0.0", 0},
+		{"1.2", "1.2.1", -1},
+		{"1.0.0+45", "1.0.0+46", 0},
+		{"1.0.0-rc.1+build", "1.0.0-rc.1", 0},
+		// The precedence chain from semver.org
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		// Invalid strings sort below valid ones
+		{"latest", "0.0.1", -1},
+		{"1.0.0", "1.0.0.0", 1},
+		{"1..0", "1.0.0", -1},
+		{"1.0.0-", "1.0.0", -1},
+		{"1.0.x", "nightly", 0},
+		{"-1.0.0", "0.0.0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareSemver(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareSemver(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareBySemver(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b AppVersion
+		want int
+	}{
+		{name: "semver wins over code", a: AppVersion{Version: "1.10.0", VersionCode: 1}, b: AppVersion{Version: "1.9.0", VersionCode: 2}, want: 1},
+		{name: "equal versions fall back to code", a: AppVersion{Version: "1.0.0", VersionCode: 1}, b: AppVersion{Version: "1.0.0", VersionCode: 2}, want: -1},
+		{name: "invalid version falls back to code", a: AppVersion{Version: "nightly", VersionCode: 3}, b: AppVersion{Version: "1.9.0", VersionCode: 2}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareBySemver(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareBySemver = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUpdateCompareMode(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           string
+		currentVersion string
+		currentCode    int
+		wantStatus     int
+		wantVersion    string // "" when no update is offered
+	}{
+		{name: "by version code", currentVersion: "1.8.0", currentCode: 1, wantStatus: http.StatusOK, wantVersion: "1.9.0"},
+		{name: "explicit version code", mode: "version_code", currentVersion: "1.8.0", currentCode: 1, wantStatus: http.StatusOK, wantVersion: "1.9.0"},
+		{name: "by semver", mode: "semver", currentVersion: "1.8.0", currentCode: 1, wantStatus: http.StatusOK, wantVersion: "1.10.0"},
+		{name: "semver up to date", mode: "semver", currentVersion: "1.10.0", currentCode: 2, wantStatus: http.StatusOK},
+		{name: "semver pre-release behind release", mode: "semver", currentVersion: "1.10.0-rc.1", currentCode: 2, wantStatus: http.StatusOK, wantVersion: "1.10.0"},
+		{name: "unknown mode", mode: "date", currentVersion: "1.8.0", currentCode: 1, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1, Version: "1.8.0"})
+			ts.seed(AppVersion{VersionCode: 2, Version: "1.10.0"})
+			ts.seed(AppVersion{VersionCode: 3, Version: "1.9.0"})
+
+			req := UpdateCheckRequest{CurrentVersion: tt.currentVersion, CurrentCode: tt.currentCode, Platform: "android", CompareMode: tt.mode}
+			w := ts.do(http.MethodPost, "/api/v1/ota/check-update", req, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp UpdateCheckResponse
+			decodeJSON(t, w, &resp)
+			if resp.UpdateAvailable != (tt.wantVersion != "") {
+				t.Fatalf("update_available = %t, want %t", resp.UpdateAvailable, tt.wantVersion != "")
+			}
+			if tt.wantVersion != "" && resp.LatestVersion.Version != tt.wantVersion {
+				t.Errorf("latest = %s, want %s", resp.LatestVersion.Version, tt.wantVersion)
+			}
+		})
+	}
+}
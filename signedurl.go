@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// errSigningUnsupported means the store has no signed URLs to hand out
+var errSigningUnsupported = errors.New("store does not support signed URLs")
+
+// getDownloadURL hands out a time-limited signed Storage URL for a version so
+// clients download straight from Google's edge instead of through this
+// server. When signing isn't possible the streaming download path is returned.
+func (s *Server) getDownloadURL(c *gin.Context) {
+	ctx := requestContext(c)
+	version := c.Param("version")
+	platform := c.Query("platform")
+	if platform == "" {
+		platform = "android"
+	}
+
+	matched, ok := s.resolveDownload(c, version, platform)
+	if !ok {
+		return
+	}
+
+	signed, expires, err := s.signedDownloadURL(ctx, matched, platform)
+	if err != nil {
+		// Credentials without signing rights, or a store that can't sign:
+		// fall back to proxying the file
+		loggerFrom(ctx).Warn("signing URL failed, falling back to streaming", "storage_path", matched.StoragePath, "err", err)
+		fallback := s.downloadURL(matched.Version, platform)
+		if c.Query("redirect") == "true" {
+			c.Redirect(http.StatusFound, fallback)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"url": fallback, "signed": false})
+		return
+	}
+
+	if c.Query("redirect") == "true" {
+		c.Redirect(http.StatusFound, signed)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":        signed,
+		"signed":     true,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+		"checksum":   matched.Checksum,
+		"file_size":  matched.FileSize,
+	})
+}
+
+// signedDownloadURL signs a GET URL for a version's object that is valid for
+// SIGNED_URL_TTL and makes Storage serve it as the same attachment the
+// streaming download would
+func (s *Server) signedDownloadURL(ctx context.Context, v *AppVersion, platform string) (string, time.Time, error) {
+	expires := time.Now().Add(config.SignedURLTTL)
+	if s.bucket == nil {
+		return "", expires, errSigningUnsupported
+	}
+	fileName, contentType := artifactType(v, platform)
+	done := timeOp(ctx, opStorage, "sign url")
+	defer done()
+	signed, err := s.bucket.SignedURL(v.StoragePath, &storage.SignedURLOptions{
+		Method:  http.MethodGet,
+		Expires: expires,
+		Scheme:  storage.SigningSchemeV4,
+		QueryParameters: map[string][]string{
+			"response-content-disposition": {fmt.Sprintf("attachment; filename=%s", fileName)},
+			"response-content-type":        {contentType},
+		},
+	})
+	return signed, expires, err
+}
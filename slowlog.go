@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Backend kinds timed by timeOp, each with its own slow threshold
+const (
+	opDB      = "db"
+	opStorage = "storage"
+)
+
+// timeOp starts timing a Firebase or Storage call and returns a function that
+// logs a structured warning, on the request's logger, if the call took longer
+// than the configured threshold (SLOW_DB_THRESHOLD / SLOW_STORAGE_THRESHOLD).
+// Typical use:
+//
+//	defer timeOp(ctx, opDB, "read versions")()
+func timeOp(ctx context.Context, kind, name string) func() {
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		threshold := slowThreshold(kind)
+		if elapsed <= threshold {
+			return
+		}
+		loggerFrom(ctx).Warn("slow backend operation",
+			"kind", kind,
+			"op", name,
+			"duration_ms", elapsed.Milliseconds(),
+			"threshold_ms", threshold.Milliseconds(),
+		)
+	}
+}
+
+func slowThreshold(kind string) time.Duration {
+	if kind == opStorage {
+		return config.SlowStorageThreshold
+	}
+	return config.SlowDBThreshold
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestTimeOp(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      []string
+		kind     string
+		took     time.Duration
+		wantWarn bool
+	}{
+		{name: "slow read", env: []string{"SLOW_DB_THRESHOLD=1ms"}, kind: opDB, took: 10 * time.Millisecond, wantWarn: true},
+		{name: "fast read", env: []string{"SLOW_DB_THRESHOLD=1h"}, kind: opDB, took: time.Millisecond},
+		{name: "slow storage", env: []string{"SLOW_STORAGE_THRESHOLD=1ms"}, kind: opStorage, took: 10 * time.Millisecond, wantWarn: true},
+		{name: "storage uses its own threshold", env: []string{"SLOW_DB_THRESHOLD=1ms", "SLOW_STORAGE_THRESHOLD=1h"}, kind: opStorage, took: 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, tt.env...)
+			var out bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&out, nil)).With("request_id", "req-1")
+			ctx := context.WithValue(context.Background(), loggerKey{}, logger)
+
+			done := timeOp(ctx, tt.kind, "read versions")
+			time.Sleep(tt.took)
+			done()
+
+			if !tt.wantWarn {
+				if out.Len() != 0 {
+					t.Errorf("logged %s", out.String())
+				}
+				return
+			}
+			var entry map[string]any
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("log line %q: %v", out.String(), err)
+			}
+			want := map[string]any{"level": "WARN", "msg": "slow backend operation", "kind": tt.kind, "op": "read versions", "request_id": "req-1"}
+			for key, value := range want {
+				if entry[key] != value {
+					t.Errorf("%s = %v, want %v", key, entry[key], value)
+				}
+			}
+			if ms, _ := entry["duration_ms"].(float64); ms < float64(tt.took.Milliseconds()) {
+				t.Errorf("duration_ms = %v, want at least %d", entry["duration_ms"], tt.took.Milliseconds())
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxSourceRedirects bounds how many redirects a source_url fetch follows,
+// e.g. from a CI artifact link to its signed storage URL
+const maxSourceRedirects = 5
+
+// sourceClient fetches source_url uploads. It has no timeout of its own: the
+// upload's context bounds the whole transfer. Since the URL is the caller's,
+// every hop must be on SOURCE_URL_ALLOWED_HOSTS when that is set, and
+// connections are only made to public addresses, checked on the address
+// actually dialled so DNS tricks and redirects can't reach internal services.
+// Proxies are not used, as the dial would then go to the proxy.
+var sourceClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   dialPublicOnly,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxSourceRedirects {
+			return fmt.Errorf("more than %d redirects", maxSourceRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		if !sourceHostAllowed(req.URL.Hostname()) {
+			return fmt.Errorf("redirect to %q: %w", req.URL.Hostname(), errSourceNotAllowed)
+		}
+		return nil
+	},
+}
+
+// errSourceNotAllowed is a source_url, or a hop of its redirects, that is not
+// on SOURCE_URL_ALLOWED_HOSTS or resolves to a non-public address
+var errSourceNotAllowed = errors.New("source not allowed")
+
+// sourceAddrAllowed decides which addresses source_url fetches may connect to
+var sourceAddrAllowed = isPublicAddr
+
+// cgnatPrefix is the shared address space carriers and some clouds use internally
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether addr is globally routable: not loopback,
+// private, link-local (which includes the 169.254.169.254 metadata server),
+// shared, multicast or unspecified
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() &&
+		!cgnatPrefix.Contains(addr) && !(addr.Is4() && addr.As4()[0] == 0)
+}
+
+// dialPublicOnly is the source dialer's Control, refusing connections to
+// addresses sourceAddrAllowed rejects
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !sourceAddrAllowed(addr) {
+		return fmt.Errorf("connecting to %s: %w", address, errSourceNotAllowed)
+	}
+	return nil
+}
+
+// sourceHostAllowed checks a host against SOURCE_URL_ALLOWED_HOSTS; any host
+// is allowed when it is empty
+func sourceHostAllowed(host string) bool {
+	if len(config.SourceURLAllowedHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range config.SourceURLAllowedHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceError is a source_url fetch that failed in a way the uploader can
+// fix, answered with status and code instead of a server error. The message
+// is all the uploader sees; cause, which may name internal addresses or
+// what the source answered, is only logged.
+type sourceError struct {
+	status  int
+	code    string
+	message string
+	details map[string]any
+	cause   error
+}
+
+func (e *sourceError) Error() string {
+	if e.cause != nil {
+		return e.message + ": " + e.cause.Error()
+	}
+	return e.message
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.cause
+}
+
+// sourceNotAllowed is the answer to a source_url that may not be fetched.
+// It doesn't say whether the host or its address was refused.
+func sourceNotAllowed(cause error) *sourceError {
+	e := &sourceError{
+		status:  http.StatusBadRequest,
+		code:    codeInvalidRequest,
+		message: "source_url is not allowed",
+		cause:   cause,
+	}
+	if len(config.SourceURLAllowedHosts) > 0 {
+		e.details = map[string]any{"allowed_hosts": config.SourceURLAllowedHosts}
+	}
+	return e
+}
+
+// sourceFetchFailed is the answer to a source_url that could not be fetched,
+// the same whatever went wrong
+func sourceFetchFailed(cause error) *sourceError {
+	return &sourceError{
+		status:  http.StatusBadGateway,
+		code:    codeSourceFetchFailed,
+		message: "Could not fetch source_url",
+		cause:   cause,
+	}
+}
+
+// parseSourceURL checks that a source_url is an absolute http(s) URL
+func parseSourceURL(raw string) (*url.URL, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, false
+	}
+	return u, true
+}
+
+// fetchSource downloads a source_url into a temporary file, at most limit
+// bytes when limit is positive, so it can be validated and hashed like a
+// multipart file. The caller closes and removes the file. Problems with the
+// source itself are *sourceError.
+func fetchSource(ctx context.Context, source *url.URL, limit int64) (uploadFile, *os.File, error) {
+	if !sourceHostAllowed(source.Hostname()) {
+		return uploadFile{}, nil, sourceNotAllowed(fmt.Errorf("host %q: %w", source.Hostname(), errSourceNotAllowed))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return uploadFile{}, nil, err
+	}
+	resp, err := sourceClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return uploadFile{}, nil, ctx.Err()
+		}
+		if errors.Is(err, errSourceNotAllowed) {
+			return uploadFile{}, nil, sourceNotAllowed(err)
+		}
+		return uploadFile{}, nil, sourceFetchFailed(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return uploadFile{}, nil, sourceFetchFailed(fmt.Errorf("answered %d", resp.StatusCode))
+	}
+	if limit > 0 && resp.ContentLength > limit {
+		return uploadFile{}, nil, errSourceTooLarge
+	}
+
+	f, err := os.CreateTemp("", "ota-source-*")
+	if err != nil {
+		return uploadFile{}, nil, err
+	}
+	body := io.Reader(resp.Body)
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+	n, err := io.Copy(f, body)
+	if err == nil && limit > 0 && n > limit {
+		err = errSourceTooLarge
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		if !errors.Is(err, errSourceTooLarge) && ctx.Err() == nil {
+			err = sourceFetchFailed(fmt.Errorf("reading body: %w", err))
+		}
+		return uploadFile{}, nil, err
+	}
+
+	header := &multipart.FileHeader{Filename: sourceFilename(resp), Size: n}
+	return uploadFile{Header: header, Content: f}, f, nil
+}
+
+// errSourceTooLarge is returned by fetchSource when the source is over the upload limit
+var errSourceTooLarge = errors.New("source exceeds the upload limit")
+
+// sourceFilename names a fetched artifact, which decides its extension:
+// the Content-Disposition filename if any, else the last segment of the
+// requested URL's path, else that of the URL redirects ended at
+func sourceFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/")); name != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+	requests := []*http.Request{resp.Request}
+	for r := resp.Request; r.Response != nil; r = r.Response.Request {
+		requests = append([]*http.Request{r.Response.Request}, requests...)
+	}
+	for _, r := range requests {
+		if name := path.Base(r.URL.Path); path.Ext(name) != "" {
+			return name
+		}
+	}
+	return path.Base(resp.Request.URL.Path)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2607:f8b0::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %t, want %t", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestSourceHostAllowed(t *testing.T) {
+	setConfig(t, "SOURCE_URL_ALLOWED_HOSTS=ci.example.com, *.storage.example.net")
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"ci.example.com", true},
+		{"CI.example.com.", true},
+		{"evil.example.com", false},
+		{"a.storage.example.net", true},
+		{"a.b.storage.example.net", true},
+		{"storage.example.net", false},
+		{"storage.example.net.evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := sourceHostAllowed(tt.host); got != tt.want {
+			t.Errorf("sourceHostAllowed(%q) = %t, want %t", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestUploadSourceURL(t *testing.T) {
+	artifact := []byte("PK\x03\x04source")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.aab":
+			w.Write(artifact)
+		case "/redirect-localhost":
+			http.Redirect(w, r, strings.Replace(r.Host, "127.0.0.1", "http://localhost", 1)+"/app.aab", http.StatusFound)
+		case "/redirect-metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		default:
+			http.Error(w, "secret internal detail", http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name          string
+		env           []string
+		allowLoopback bool
+		path          string
+		wantStatus    int
+		wantCode      string
+	}{
+		{name: "loopback refused", path: "/app.aab", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+		{name: "public source fetched", allowLoopback: true, path: "/app.aab", wantStatus: http.StatusOK},
+		{name: "host not on allowlist", env: []string{"SOURCE_URL_ALLOWED_HOSTS=ci.example.com"}, allowLoopback: true, path: "/app.aab", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+		{name: "host on allowlist", env: []string{"SOURCE_URL_ALLOWED_HOSTS=127.0.0.1"}, allowLoopback: true, path: "/app.aab", wantStatus: http.StatusOK},
+		{name: "redirect off allowlist", env: []string{"SOURCE_URL_ALLOWED_HOSTS=127.0.0.1"}, allowLoopback: true, path: "/redirect-localhost", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+		{name: "redirect to metadata server", allowLoopback: true, path: "/redirect-metadata", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+		{name: "upstream error not echoed", allowLoopback: true, path: "/missing.aab", wantStatus: http.StatusBadGateway, wantCode: codeSourceFetchFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.env...)
+			if tt.allowLoopback {
+				sourceAddrAllowed = func(addr netip.Addr) bool { return addr.IsLoopback() || isPublicAddr(addr) }
+				t.Cleanup(func() { sourceAddrAllowed = isPublicAddr })
+			}
+
+			fields := map[string]string{"version": "1.0.0", "version_code": "1", "platform": "android", "source_url": upstream.URL + tt.path}
+			w := ts.upload("/api/v1/ota/upload", fields, "", nil, testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+			}
+			// Only the configured allowlist may be echoed
+			leaks := []string{"169.254", "secret", "404", "localhost"}
+			if len(tt.env) == 0 {
+				leaks = append(leaks, "127.0.0.1")
+			}
+			for _, leak := range leaks {
+				if w.Code != http.StatusOK && strings.Contains(w.Body.String(), leak) {
+					t.Errorf("response leaks %q: %s", leak, w.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// latestSnapshot is the last latest/previous selection read successfully for a platform and channel
+type latestSnapshot struct {
+	latest    *AppVersion
+	previous  *AppVersion
+	fetchedAt time.Time
+}
+
+// staleLatestCache keeps the last-known-good latest version per platform and channel so
+// update checks and downloads of that build keep working during brief
+// Realtime Database outages. It is only consulted when STALE_LATEST_ENABLED is set.
+type staleLatestCache struct {
+	mu      sync.RWMutex
+	entries map[string]latestSnapshot
+}
+
+func newStaleLatestCache() *staleLatestCache {
+	return &staleLatestCache{entries: map[string]latestSnapshot{}}
+}
+
+// put records a successful selection for platform and channel
+func (c *staleLatestCache) put(platform, channel string, latest, previous *AppVersion) {
+	if !config.StaleLatestEnabled {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[platform+"/"+channel] = latestSnapshot{
+		latest:    copyVersion(latest),
+		previous:  copyVersion(previous),
+		fetchedAt: time.Now(),
+	}
+}
+
+// refresh records latest read on its own, e.g. from the latest pointer,
+// keeping the cached previous build only while the latest is unchanged
+func (c *staleLatestCache) refresh(platform, channel string, latest *AppVersion) {
+	if !config.StaleLatestEnabled {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := platform + "/" + channel
+	var previous *AppVersion
+	if snap, ok := c.entries[key]; ok && snap.latest != nil && snap.latest.ID == latest.ID {
+		previous = snap.previous
+	}
+	c.entries[key] = latestSnapshot{
+		latest:    copyVersion(latest),
+		previous:  previous,
+		fetchedAt: time.Now(),
+	}
+}
+
+// get returns the cached selection for platform and channel if it is within
+// the configured staleness window
+func (c *staleLatestCache) get(platform, channel string) (latestSnapshot, bool) {
+	if !config.StaleLatestEnabled {
+		return latestSnapshot{}, false
+	}
+	c.mu.RLock()
+	snap, ok := c.entries[platform+"/"+channel]
+	c.mu.RUnlock()
+	if !ok || time.Since(snap.fetchedAt) > config.StaleLatestMaxAge {
+		return latestSnapshot{}, false
+	}
+	return latestSnapshot{
+		latest:    copyVersion(snap.latest),
+		previous:  copyVersion(snap.previous),
+		fetchedAt: snap.fetchedAt,
+	}, true
+}
+
+func copyVersion(v *AppVersion) *AppVersion {
+	if v == nil {
+		return nil
+	}
+	cp := *v
+	return &cp
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStaleLatest(t *testing.T) {
+	const enabled = "STALE_LATEST_ENABLED=true"
+	tests := []struct {
+		name        string
+		env         []string
+		warm        bool
+		currentCode int
+		download    string
+		wantStatus  int
+		wantUpdate  bool
+	}{
+		{name: "served from cache", env: []string{enabled}, warm: true, currentCode: 1, wantStatus: http.StatusOK, wantUpdate: true},
+		{name: "up to date from cache", env: []string{enabled}, warm: true, currentCode: 2, wantStatus: http.StatusOK},
+		{name: "nothing cached", env: []string{enabled}, currentCode: 1, wantStatus: http.StatusInternalServerError},
+		{name: "disabled by default", warm: true, currentCode: 1, wantStatus: http.StatusInternalServerError},
+		{name: "cache too old", env: []string{enabled, "STALE_LATEST_MAX_AGE=1ns"}, warm: true, currentCode: 1, wantStatus: http.StatusInternalServerError},
+		{name: "download cached latest", env: []string{enabled}, warm: true, download: "1.0.2", wantStatus: http.StatusOK},
+		{name: "download older version", env: []string{enabled}, warm: true, download: "1.0.1", wantStatus: http.StatusInternalServerError},
+		{name: "download without cache", env: []string{enabled}, download: "1.0.2", wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingStore{memoryStore: newMemoryStore()}
+			ts := newTestServerWithStore(t, store, tt.env...)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2})
+			if tt.warm {
+				if resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1}); resp.Stale {
+					t.Fatal("fresh answer marked stale")
+				}
+			}
+			store.failReads = true
+
+			if tt.download != "" {
+				w := ts.do(http.MethodGet, "/api/v1/ota/download/"+tt.download+"?platform=android", nil, "")
+				if w.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+				}
+				if tt.wantStatus == http.StatusOK && w.Body.String() != "PK\x03\x04android-2" {
+					t.Errorf("body = %q", w.Body)
+				}
+				return
+			}
+
+			req := UpdateCheckRequest{CurrentVersion: "1.0.0", CurrentCode: tt.currentCode, Platform: "android"}
+			w := ts.do(http.MethodPost, "/api/v1/ota/check-update", req, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != codeDatabaseError {
+					t.Errorf("code = %q, want %q", code, codeDatabaseError)
+				}
+				return
+			}
+			var resp UpdateCheckResponse
+			decodeJSON(t, w, &resp)
+			if !resp.Stale || resp.UpdateAvailable != tt.wantUpdate {
+				t.Errorf("stale = %t, update_available = %t, want true, %t", resp.Stale, resp.UpdateAvailable, tt.wantUpdate)
+			}
+			if tt.wantUpdate && (resp.LatestVersion == nil || resp.LatestVersion.VersionCode != 2) {
+				t.Errorf("latest = %+v, want code 2", resp.LatestVersion)
+			}
+		})
+	}
+}
+
+func TestStaleLatestKeepsPrevious(t *testing.T) {
+	store := &failingStore{memoryStore: newMemoryStore()}
+	ts := newTestServerWithStore(t, store, "STALE_LATEST_ENABLED=true")
+	ts.seed(AppVersion{VersionCode: 1})
+	ts.seed(AppVersion{VersionCode: 2})
+	// The first check reads everything, the second only the latest pointer
+	ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1, IncludePrevious: true})
+	ts.checkUpdate(UpdateCheckRequest{CurrentCode: 2})
+	store.failReads = true
+
+	resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1, IncludePrevious: true})
+	if !resp.Stale || resp.PreviousVersion == nil || resp.PreviousVersion.VersionCode != 1 {
+		t.Errorf("stale = %t, previous = %+v, want code 1", resp.Stale, resp.PreviousVersion)
+	}
+}
+
+func TestStaleLatestVersion(t *testing.T) {
+	const enabled = "STALE_LATEST_ENABLED=true"
+	tests := []struct {
+		name       string
+		env        []string
+		warm       bool
+		wantStatus int
+	}{
+		{name: "served from cache", env: []string{enabled}, warm: true, wantStatus: http.StatusOK},
+		{name: "nothing cached", env: []string{enabled}, wantStatus: http.StatusInternalServerError},
+		{name: "disabled by default", warm: true, wantStatus: http.StatusInternalServerError},
+		{name: "cache too old", env: []string{enabled, "STALE_LATEST_MAX_AGE=1ns"}, warm: true, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingStore{memoryStore: newMemoryStore()}
+			ts := newTestServerWithStore(t, store, tt.env...)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2})
+			if tt.warm {
+				ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1})
+			}
+			store.failReads = true
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/versions/latest?platform=android", nil, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != codeDatabaseError {
+					t.Errorf("code = %q, want %q", code, codeDatabaseError)
+				}
+				return
+			}
+			var v AppVersion
+			decodeJSON(t, w, &v)
+			if v.ID != "android-2" || v.DownloadURL == "" {
+				t.Errorf("latest = %+v, want android-2", v)
+			}
+			if w.Header().Get("X-Stale") != "true" {
+				t.Errorf("X-Stale = %q, want true", w.Header().Get("X-Stale"))
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stallGuard aborts a transfer that makes no progress for a whole timeout
+// window, so a client reading at a trickle (or a storage read that hangs)
+// can't hold a handler and its storage reader forever. io.Copy has no
+// deadline of its own; on a stall the guard cancels the storage read and moves
+// the connection's write deadline into the past, failing whichever side is
+// blocked. The failed connection is then closed by net/http.
+type stallGuard struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	timeout time.Duration
+	cancel  context.CancelFunc
+
+	sent     atomic.Int64
+	last     atomic.Int64 // UnixNano of the last successful write
+	stalled  atomic.Bool
+	finished chan struct{}
+}
+
+// newStallGuard watches writes to c's response; cancel must cancel the
+// context the body is read with. A timeout of 0 only passes writes through.
+func newStallGuard(c *gin.Context, timeout time.Duration, cancel context.CancelFunc) *stallGuard {
+	g := &stallGuard{
+		w:        c.Writer,
+		rc:       http.NewResponseController(c.Writer),
+		timeout:  timeout,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+	g.last.Store(time.Now().UnixNano())
+	if timeout > 0 {
+		go g.watch()
+	}
+	return g
+}
+
+func (g *stallGuard) watch() {
+	ticker := time.NewTicker(g.timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.finished:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, g.last.Load())) < g.timeout {
+				continue
+			}
+			g.stalled.Store(true)
+			g.cancel()
+			// Unblocks a Write stuck on a full socket buffer
+			_ = g.rc.SetWriteDeadline(now)
+			return
+		}
+	}
+}
+
+// Write passes p to the response and records the progress
+func (g *stallGuard) Write(p []byte) (int, error) {
+	n, err := g.w.Write(p)
+	if n > 0 {
+		g.sent.Add(int64(n))
+		g.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// stop ends the watch and reports whether the transfer was aborted as stalled
+func (g *stallGuard) stop() bool {
+	close(g.finished)
+	return g.stalled.Load()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CatalogStats summarizes the release catalog for dashboards. Sizes count
+// every artifact of a version, so split builds add up; disabled and
+// scheduled versions still take storage and are counted too.
+type CatalogStats struct {
+	TotalVersions int                       `json:"total_versions"`
+	StorageBytes  int64                     `json:"storage_bytes"`
+	Downloads     int64                     `json:"downloads"`
+	Platforms     map[string]*PlatformStats `json:"platforms"`
+	// MostDownloaded is nil until something has been downloaded
+	MostDownloaded *AppVersion `json:"most_downloaded"`
+	GeneratedAt    time.Time   `json:"generated_at"`
+}
+
+// PlatformStats is CatalogStats for one platform. Latest is what a stable
+// client is offered, as from /versions/latest, or nil when nothing is.
+type PlatformStats struct {
+	Versions     int         `json:"versions"`
+	StorageBytes int64       `json:"storage_bytes"`
+	Downloads    int64       `json:"downloads"`
+	Latest       *AppVersion `json:"latest"`
+}
+
+// statsCache holds the last computed stats for STATS_CACHE_TTL, so a
+// dashboard refreshing every few seconds doesn't list the catalog each time
+type statsCache struct {
+	mu         sync.Mutex
+	stats      *CatalogStats
+	computedAt time.Time
+}
+
+// get returns cached stats that are still fresh, else computes them. The lock
+// is held while computing, so concurrent refreshes share one listing.
+func (c *statsCache) get(ctx context.Context, compute func(context.Context) (*CatalogStats, error)) (*CatalogStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats != nil && time.Since(c.computedAt) < config.StatsCacheTTL {
+		return c.stats, nil
+	}
+	stats, err := compute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.stats, c.computedAt = stats, time.Now()
+	return stats, nil
+}
+
+// computeStats aggregates every version record
+func (s *Server) computeStats(ctx context.Context) (*CatalogStats, error) {
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	stats := &CatalogStats{Platforms: map[string]*PlatformStats{}, GeneratedAt: now.UTC()}
+	for _, v := range versions {
+		platform := platformOf(v)
+		ps := stats.Platforms[platform]
+		if ps == nil {
+			ps = &PlatformStats{}
+			stats.Platforms[platform] = ps
+		}
+		var size int64
+		for _, a := range artifactsOf(v) {
+			size += a.FileSize
+		}
+		ps.Versions++
+		ps.StorageBytes += size
+		ps.Downloads += v.DownloadCount
+		stats.TotalVersions++
+		stats.StorageBytes += size
+		stats.Downloads += v.DownloadCount
+
+		if v.DownloadCount > 0 && (stats.MostDownloaded == nil ||
+			v.DownloadCount > stats.MostDownloaded.DownloadCount ||
+			v.DownloadCount == stats.MostDownloaded.DownloadCount && v.VersionCode > stats.MostDownloaded.VersionCode) {
+			most := v
+			stats.MostDownloaded = &most
+		}
+	}
+	if most := stats.MostDownloaded; most != nil {
+		most.DownloadURL = s.downloadURL(most.Version, platformOf(*most))
+		most.Status = versionStatus(*most, now)
+	}
+
+	offered := offeredVersions(versions, defaultChannel, now)
+	for platform, ps := range stats.Platforms {
+		if platform == "" {
+			// Records too old to name their platform can't be offered
+			continue
+		}
+		latest, _ := selectLatest(offered, platform)
+		if latest != nil {
+			latest.DownloadURL = s.downloadURL(latest.Version, platform)
+			latest.Status = versionStatus(*latest, now)
+		}
+		ps.Latest = latest
+	}
+	return stats, nil
+}
+
+// getStats serves GET /stats
+func (s *Server) getStats(c *gin.Context) {
+	ctx := requestContext(c)
+	stats, err := s.stats.get(ctx, s.computeStats)
+	if err != nil {
+		loggerFrom(ctx).Error("version fetch failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
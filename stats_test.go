@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	no := false
+	tests := []struct {
+		name          string
+		versions      []AppVersion
+		wantTotal     int
+		wantDownloads int64
+		wantMost      string            // most downloaded id, "" for none
+		wantLatest    map[string]string // platform to latest id, "" for none
+	}{
+		{name: "empty catalog", wantLatest: map[string]string{}},
+		{
+			name: "per platform",
+			versions: []AppVersion{
+				{VersionCode: 1, DownloadCount: 10},
+				{VersionCode: 2, DownloadCount: 25},
+				{VersionCode: 3, Platform: "ios", DownloadCount: 5},
+			},
+			wantTotal:     3,
+			wantDownloads: 40,
+			wantMost:      "android-2",
+			wantLatest:    map[string]string{"android": "android-2", "ios": "ios-3"},
+		},
+		{
+			name:          "ties go to the higher code",
+			versions:      []AppVersion{{VersionCode: 1, DownloadCount: 7}, {VersionCode: 2, DownloadCount: 7}},
+			wantTotal:     2,
+			wantDownloads: 14,
+			wantMost:      "android-2",
+			wantLatest:    map[string]string{"android": "android-2"},
+		},
+		{
+			name:       "nothing downloaded",
+			versions:   []AppVersion{{VersionCode: 1}},
+			wantTotal:  1,
+			wantLatest: map[string]string{"android": "android-1"},
+		},
+		{
+			name: "disabled and beta builds counted but not latest",
+			versions: []AppVersion{
+				{VersionCode: 1},
+				{VersionCode: 2, Enabled: &no, DownloadCount: 3},
+				{VersionCode: 3, Channel: "beta"},
+				{VersionCode: 4, Platform: "ios", Enabled: &no},
+			},
+			wantTotal:     4,
+			wantDownloads: 3,
+			wantMost:      "android-2",
+			wantLatest:    map[string]string{"android": "android-1", "ios": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			var wantBytes int64
+			for _, v := range tt.versions {
+				wantBytes += ts.seed(v).FileSize
+			}
+
+			w := ts.do(http.MethodGet, "/api/v1/ota/stats", nil, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", w.Code, w.Body)
+			}
+			var stats CatalogStats
+			decodeJSON(t, w, &stats)
+			if stats.TotalVersions != tt.wantTotal || stats.Downloads != tt.wantDownloads || stats.StorageBytes != wantBytes {
+				t.Errorf("totals = %d versions, %d downloads, %d bytes, want %d, %d, %d",
+					stats.TotalVersions, stats.Downloads, stats.StorageBytes, tt.wantTotal, tt.wantDownloads, wantBytes)
+			}
+			switch {
+			case tt.wantMost == "" && stats.MostDownloaded != nil:
+				t.Errorf("most_downloaded = %s, want none", stats.MostDownloaded.ID)
+			case tt.wantMost != "" && (stats.MostDownloaded == nil || stats.MostDownloaded.ID != tt.wantMost):
+				t.Errorf("most_downloaded = %+v, want %s", stats.MostDownloaded, tt.wantMost)
+			}
+
+			if len(stats.Platforms) != len(tt.wantLatest) {
+				t.Errorf("platforms = %v, want %v", stats.Platforms, tt.wantLatest)
+			}
+			var sum int
+			for platform, want := range tt.wantLatest {
+				ps := stats.Platforms[platform]
+				if ps == nil {
+					t.Errorf("no stats for %s", platform)
+					continue
+				}
+				sum += ps.Versions
+				got := ""
+				if ps.Latest != nil {
+					got = ps.Latest.ID
+				}
+				if got != want {
+					t.Errorf("%s latest = %q, want %q", platform, got, want)
+				}
+			}
+			if sum != stats.TotalVersions {
+				t.Errorf("platform versions add up to %d, total is %d", sum, stats.TotalVersions)
+			}
+		})
+	}
+}
+
+func TestStatsCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []string
+		wantTotal int // after a second upload
+	}{
+		{name: "cached by default", wantTotal: 1},
+		{name: "ttl 0 recomputes", env: []string{"STATS_CACHE_TTL=0"}, wantTotal: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.env...)
+			ts.seed(AppVersion{VersionCode: 1})
+			total := func() int {
+				w := ts.do(http.MethodGet, "/api/v1/ota/stats", nil, "")
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d (%s)", w.Code, w.Body)
+				}
+				var stats CatalogStats
+				decodeJSON(t, w, &stats)
+				return stats.TotalVersions
+			}
+			if got := total(); got != 1 {
+				t.Fatalf("total_versions = %d, want 1", got)
+			}
+			ts.seed(AppVersion{VersionCode: 2})
+			if got := total(); got != tt.wantTotal {
+				t.Errorf("total_versions = %d, want %d", got, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestStatsStoreFailure(t *testing.T) {
+	ts := newTestServerWithStore(t, &failingStore{memoryStore: newMemoryStore(), failReads: true})
+	w := ts.do(http.MethodGet, "/api/v1/ota/stats", nil, "")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500 (%s)", w.Code, w.Body)
+	}
+	if code := errorCode(t, w); code != codeDatabaseError {
+		t.Errorf("code = %q, want %q", code, codeDatabaseError)
+	}
+}
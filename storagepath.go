@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultStoragePathTemplate is the object layout uploads have always used,
+// e.g. releases/android/1.2.0-1717000000-arm64-v8a.apk
+const defaultStoragePathTemplate = "releases/{platform}/{version}-{timestamp}{abi}{ext}"
+
+// storagePathPlaceholders are what STORAGE_PATH_TEMPLATE may contain. {abi}
+// renders as "-<abi>" for split builds and "" otherwise; {ext} includes its dot.
+var storagePathPlaceholders = []string{"{platform}", "{version}", "{code}", "{abi}", "{ext}", "{timestamp}"}
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// reservedStorageRoots hold objects that are not release artifacts: resumable
+// upload staging, the readiness probe, patches and other apps' namespaces
+var reservedStorageRoots = []string{"uploads/", "_healthcheck/", "patches/", "apps/"}
+
+// storagePathRoot returns the fixed directories a template starts with, up to
+// the last slash before its first placeholder, e.g. "releases/" for the default
+func storagePathRoot(template string) string {
+	fixed := template
+	if i := strings.IndexByte(template, '{'); i >= 0 {
+		fixed = template[:i]
+	}
+	return fixed[:strings.LastIndexByte(fixed, '/')+1]
+}
+
+// storagePathPattern matches the paths a template renders to, capturing the
+// first {platform} as one of the supported platforms; nil when the template
+// can't be compiled
+func storagePathPattern(template string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	last, captured := 0, false
+	for _, loc := range placeholderPattern.FindAllStringIndex(template, -1) {
+		expr.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		if template[loc[0]:loc[1]] == "{platform}" && !captured {
+			// Only platform names, so a neighbouring {ext} or {abi} isn't swallowed
+			names := make([]string, len(supportedPlatforms))
+			for i, name := range supportedPlatforms {
+				names[i] = regexp.QuoteMeta(name)
+			}
+			expr.WriteString("(?P<platform>" + strings.Join(names, "|") + ")")
+			captured = true
+		} else {
+			expr.WriteString("[^/]*")
+		}
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(template[last:]) + "$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// storagePathPlatform reads the platform back from an object path relative
+// to the app's prefix, through STORAGE_PATH_TEMPLATE and then the default
+// releases/<platform>/ layout
+func storagePathPlatform(p string) string {
+	if re := config.StoragePathPattern; re != nil && re.SubexpIndex("platform") >= 0 {
+		if m := re.FindStringSubmatch(p); m != nil {
+			return m[re.SubexpIndex("platform")]
+		}
+	}
+	if rest, ok := strings.CutPrefix(p, "releases/"); ok {
+		if platform, _, found := strings.Cut(rest, "/"); found {
+			return platform
+		}
+	}
+	return ""
+}
+
+// checkStoragePathTemplate validates STORAGE_PATH_TEMPLATE, returning a problem or ""
+func checkStoragePathTemplate(template string) string {
+	for _, placeholder := range placeholderPattern.FindAllString(template, -1) {
+		if !slices.Contains(storagePathPlaceholders, placeholder) {
+			return fmt.Sprintf("unknown placeholder %s; expected %s", placeholder, strings.Join(storagePathPlaceholders, ", "))
+		}
+	}
+	if !strings.Contains(template, "{code}") && !strings.Contains(template, "{timestamp}") {
+		return "needs {code} or {timestamp} so that every version gets its own path"
+	}
+	root := storagePathRoot(template)
+	if root == "" {
+		return "needs a fixed leading directory such as releases/, which /gc scans for orphaned artifacts"
+	}
+	for _, reserved := range reservedStorageRoots {
+		if strings.HasPrefix(root, reserved) {
+			return fmt.Sprintf("renders under %s, which is reserved for objects that are not release artifacts", reserved)
+		}
+	}
+	sample := renderStoragePath(template, "android", "1.0.0", 1, "", ".apk", time.Now())
+	if !safeStoragePath(sample) {
+		return fmt.Sprintf("renders to %q; expected a relative path without empty, . or .. segments", sample)
+	}
+	return ""
+}
+
+// renderStoragePath fills in a storage path template
+func renderStoragePath(template, platform, version string, code int, abi, ext string, now time.Time) string {
+	if abi != "" {
+		abi = "-" + abi
+	}
+	return strings.NewReplacer(
+		"{platform}", platform,
+		"{version}", version,
+		"{code}", strconv.Itoa(code),
+		"{abi}", abi,
+		"{ext}", ext,
+		"{timestamp}", strconv.FormatInt(now.Unix(), 10),
+	).Replace(template)
+}
+
+// safeStoragePath reports whether p stays within the bucket layout: relative,
+// already clean (no empty, . or .. segments) and free of backslashes and
+// control characters, which a permissive VERSION_PATTERN could let through
+func safeStoragePath(p string) bool {
+	if p == "" || strings.HasPrefix(p, "/") || path.Clean(p) != p || strings.ContainsRune(p, '\\') {
+		return false
+	}
+	if slices.Contains(strings.Split(p, "/"), "..") {
+		return false
+	}
+	return !strings.ContainsFunc(p, func(r rune) bool { return r < 0x20 || r == 0x7f })
+}
+
+// storagePathFor returns the object path a new upload is stored under,
+// rendered from STORAGE_PATH_TEMPLATE, and whether it is safe to use
+func (s *Server) storagePathFor(platform, version string, code int, abi, ext string) (string, bool) {
+	template := config.StoragePathTemplate
+	if template == "" {
+		template = defaultStoragePathTemplate
+	}
+	rendered := renderStoragePath(template, platform, version, code, abi, ext, time.Now())
+	return s.prefix + rendered, safeStoragePath(rendered)
+}
+
+// storagePathTaken reports whether an object already exists at p, e.g. one
+// of a trashed version whose code was reused under a template without
+// {timestamp}; writing there would replace it
+func (s *Server) storagePathTaken(ctx context.Context, p string) (bool, error) {
+	objects, err := s.store.ListObjects(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	for _, obj := range objects {
+		if obj.Path == p {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckStoragePathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantOK   bool
+	}{
+		{defaultStoragePathTemplate, true},
+		{"builds/{platform}/{code}{abi}{ext}", true},
+		{"releases/v2/{platform}-{code}{ext}", true},
+		{"{platform}/{code}{ext}", false},
+		{"releases{code}/{platform}{ext}", false},
+		{"uploads/{platform}/{code}{ext}", false},
+		{"_healthcheck/{code}{ext}", false},
+		{"patches/{platform}/{code}{ext}", false},
+		{"apps/other/releases/{code}{ext}", false},
+		{"builds/{platform}/{version}{ext}", false},
+		{"builds/{flavor}/{code}{ext}", false},
+		{"/builds/{code}{ext}", false},
+		{"builds/../{code}{ext}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if problem := checkStoragePathTemplate(tt.template); (problem == "") != tt.wantOK {
+				t.Errorf("problem = %q, want ok %t", problem, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPlatformOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		v        AppVersion
+		want     string
+	}{
+		{name: "platform field", v: AppVersion{Platform: "ios", StoragePath: "releases/android/1.apk"}, want: "ios"},
+		{name: "default layout", v: AppVersion{StoragePath: "releases/android/1.0.0-1.apk"}, want: "android"},
+		{name: "hosted app", v: AppVersion{StoragePath: "apps/shop/releases/ios/1.0.0-1.ipa"}, want: "ios"},
+		{name: "custom template", template: "builds/{code}/{platform}{ext}", v: AppVersion{StoragePath: "builds/7/android.apk"}, want: "android"},
+		{name: "custom template hosted app", template: "builds/{code}/{platform}{ext}", v: AppVersion{StoragePath: "apps/shop/builds/7/ios.ipa"}, want: "ios"},
+		{name: "custom template before releases layout", template: "releases/{code}/{platform}{ext}", v: AppVersion{StoragePath: "releases/7/ios.ipa"}, want: "ios"},
+		{name: "older default layout object", template: "builds/{code}/{platform}{ext}", v: AppVersion{StoragePath: "releases/android/1.0.0-1.apk"}, want: "android"},
+		{name: "unknown layout", v: AppVersion{StoragePath: "misc/file.apk"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var env []string
+			if tt.template != "" {
+				env = append(env, "STORAGE_PATH_TEMPLATE="+tt.template)
+			}
+			setConfig(t, env...)
+			if got := platformOf(tt.v); got != tt.want {
+				t.Errorf("platformOf(%q) = %q, want %q", tt.v.StoragePath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCScansTemplateRoot(t *testing.T) {
+	ts := newTestServer(t, "STORAGE_PATH_TEMPLATE=builds/{platform}/{code}{ext}", "GC_GRACE_PERIOD=0s")
+	ts.seed(AppVersion{VersionCode: 1, StoragePath: "builds/android/1.apk"})
+	ctx := context.Background()
+	for _, path := range []string{"builds/android/9.apk", "releases/android/old.apk", "uploads/abc/chunk", "misc/keep.bin"} {
+		if err := ts.store.UploadObject(ctx, path, bytes.NewReader([]byte("x"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := ts.do(http.MethodPost, "/api/v1/ota/gc?dry_run=true", nil, testAPIKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("gc: %d %s", w.Code, w.Body)
+	}
+	var report GCReport
+	decodeJSON(t, w, &report)
+	var orphaned []string
+	for _, obj := range report.Orphaned {
+		orphaned = append(orphaned, obj.Path)
+	}
+	slices.Sort(orphaned)
+	if got := strings.Join(orphaned, ","); got != "builds/android/9.apk,releases/android/old.apk" {
+		t.Errorf("orphaned = %s", got)
+	}
+}
@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"firebase.google.com/go/db"
+	"google.golang.org/api/iterator"
+)
+
+// Store is everything the handlers persist: version records and platform
+// settings in the database, artifacts in object storage. Handlers only talk
+// to a Store, so they can run against the in-memory implementation.
+type Store interface {
+	// ListVersions returns every version record keyed by id
+	ListVersions(ctx context.Context) (map[string]AppVersion, error)
+	// FindVersions returns the versions whose JSON field equals value
+	FindVersions(ctx context.Context, field string, value interface{}) (map[string]AppVersion, error)
+	// RecentVersions returns the n versions of a platform with the highest
+	// version codes, and whether those are all of the platform's versions
+	RecentVersions(ctx context.Context, platform string, n int) (map[string]AppVersion, bool, error)
+	// GetVersion returns a single version, or nil when it does not exist
+	GetVersion(ctx context.Context, id string) (*AppVersion, error)
+	// PutVersion saves a version together with everything derived from it, atomically
+	PutVersion(ctx context.Context, v AppVersion) error
+	// UpdateVersions rewrites only the named JSON fields of the given stored
+	// versions, all in one atomic update
+	UpdateVersions(ctx context.Context, versions []AppVersion, fields ...string) error
+	// DeleteVersion removes a version together with everything derived from it
+	DeleteVersion(ctx context.Context, v AppVersion) error
+	// TrashVersion moves a version, DeletedAt set, to trash/<id>, removing
+	// it and everything derived from it in the same update
+	TrashVersion(ctx context.Context, v AppVersion) error
+	// RestoreVersion moves a trashed version back, atomically
+	RestoreVersion(ctx context.Context, v AppVersion) error
+	// ListTrashed returns every trashed version keyed by id
+	ListTrashed(ctx context.Context) (map[string]AppVersion, error)
+	// GetTrashed returns a trashed version, or nil when the trash has none with id
+	GetTrashed(ctx context.Context, id string) (*AppVersion, error)
+	// PurgeTrashed deletes trash/<id> for good
+	PurgeTrashed(ctx context.Context, id string) error
+	// ClaimVersionCode atomically reserves a version code for the version id,
+	// reporting false when another version already holds it
+	ClaimVersionCode(ctx context.Context, code int, id string) (bool, error)
+	// ReleaseVersionCode frees a code, but only if id still holds it
+	ReleaseVersionCode(ctx context.Context, code int, id string) error
+	IncrementDownloadCount(ctx context.Context, id string) error
+	// GetLatestPointer reads latest/<platform>, or nil when it is not set
+	GetLatestPointer(ctx context.Context, platform string) (*LatestPointer, error)
+	// UpdateLatestPointer atomically replaces latest/<platform> with what
+	// update returns for its current value; nil clears it
+	UpdateLatestPointer(ctx context.Context, platform string, update func(*LatestPointer) *LatestPointer) error
+
+	GetPlatformConfig(ctx context.Context, platform string) (PlatformConfig, error)
+	SetPlatformPaused(ctx context.Context, platform string, paused bool) error
+	// SetMinSupportedCode sets config/<platform>/min_supported_code; 0 removes it
+	SetMinSupportedCode(ctx context.Context, platform string, code int) error
+	// SetPlatformPolicy sets the policy fields of config/<platform>; nil removes one
+	SetPlatformPolicy(ctx context.Context, platform string, mandatory *bool, rollout *int) error
+
+	// CreateUploadSession saves a new resumable upload under uploads/<id>
+	CreateUploadSession(ctx context.Context, session UploadSession) error
+	// GetUploadSession returns a resumable upload, or nil when there is none with id
+	GetUploadSession(ctx context.Context, id string) (*UploadSession, error)
+	// UpdateUploadSession atomically replaces uploads/<id> with what update
+	// makes of it, and returns the result. update sees the zero session when
+	// there is none; an error from it leaves the session as it was and is
+	// returned unchanged.
+	UpdateUploadSession(ctx context.Context, id string, update func(*UploadSession) error) (UploadSession, error)
+	// ListUploadSessions returns every resumable upload keyed by id
+	ListUploadSessions(ctx context.Context) (map[string]UploadSession, error)
+	DeleteUploadSession(ctx context.Context, id string) error
+
+	// AppendAudit stores an audit entry under its id
+	AppendAudit(ctx context.Context, e AuditEntry) error
+	// ListAudit returns every audit entry, in no particular order
+	ListAudit(ctx context.Context) ([]AuditEntry, error)
+
+	UploadObject(ctx context.Context, path string, r io.Reader) error
+	// OpenObject reads length bytes from offset; a negative length reads to the end
+	OpenObject(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
+	DeleteObject(ctx context.Context, path string) error
+	// ComposeObjects writes the concatenation of the srcs objects to dst
+	ComposeObjects(ctx context.Context, dst string, srcs []string) error
+	// ListObjects returns every object whose path starts with prefix
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PublishObject makes an object publicly readable; only used with PUBLIC_ARTIFACTS
+	PublishObject(ctx context.Context, path string) error
+	// SetObjectMetadata replaces an object's custom metadata; an empty map clears it
+	SetObjectMetadata(ctx context.Context, path string, metadata map[string]string) error
+
+	// Ping checks each backend dependency cheaply, returning its error or nil by name
+	Ping(ctx context.Context) map[string]error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Updated time.Time `json:"updated"`
+}
+
+// errObjectNotFound is returned by OpenObject and DeleteObject when the path holds no object
+var errObjectNotFound = errors.New("object not found")
+
+// errIndexNotReady is returned by RecentVersions and GetLatestPointer until
+// the version index and latest pointers have been built; callers read every
+// version instead
+var errIndexNotReady = errors.New("version index not built yet")
+
+// gcsComposeLimit is the maximum number of sources in one GCS compose call
+const gcsComposeLimit = 32
+
+// errBucketNotConfigured is returned by object operations without FIREBASE_STORAGE_BUCKET
+var errBucketNotConfigured = errors.New("storage bucket not configured")
+
+// firebaseStore keeps versions in the Realtime Database and artifacts in
+// Firebase Storage
+type firebaseStore struct {
+	db     *db.Client
+	bucket *storage.BucketHandle // nil when no bucket is configured
+	root   string                // database prefix of an app, "" without tenants
+	// indexed is set once versionIndex/ and latest/ cover every stored version
+	indexed *atomic.Bool
+}
+
+func newFirebaseStore(client *db.Client, storageClient *storage.Client, bucketName string) *firebaseStore {
+	s := &firebaseStore{db: client, indexed: &atomic.Bool{}}
+	if bucketName != "" {
+		s.bucket = storageClient.Bucket(bucketName)
+	}
+	return s
+}
+
+// forApp returns a store whose database nodes live under apps/<appID>/
+func (s *firebaseStore) forApp(appID string) *firebaseStore {
+	scoped := *s
+	scoped.root = appScope(appID)
+	scoped.indexed = &atomic.Bool{}
+	return &scoped
+}
+
+func (s *firebaseStore) ref(path string) *db.Ref {
+	return s.db.NewRef(s.root + path)
+}
+
+func (s *firebaseStore) ListVersions(ctx context.Context) (map[string]AppVersion, error) {
+	return fetchVersions(ctx, s.ref("versions"))
+}
+
+// FindVersions uses an indexed query. That needs an ".indexOn" rule for the
+// field, which a fresh deployment usually lacks, so a failed query falls back
+// to a full scan.
+func (s *firebaseStore) FindVersions(ctx context.Context, field string, value interface{}) (map[string]AppVersion, error) {
+	ref := s.ref("versions")
+	versions, err := fetchVersions(ctx, ref.OrderByChild(field).EqualTo(value))
+	if err == nil {
+		return versions, nil
+	}
+	loggerFrom(ctx).Warn("indexed query failed, falling back to full scan", "field", field, "err", err)
+
+	all, err := fetchVersions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return versionsWhere(all, field, value), nil
+}
+
+// RecentVersions reads the newest entries of versionIndex/<platform>, whose
+// keys are version codes and so sort numerically, then those records. Only
+// n+1 small reads however many versions are stored.
+func (s *firebaseStore) RecentVersions(ctx context.Context, platform string, n int) (map[string]AppVersion, bool, error) {
+	if !s.indexed.Load() {
+		return nil, false, errIndexNotReady
+	}
+	var index map[string]string
+	done := timeOp(ctx, opDB, "read version index")
+	err := s.ref("versionIndex/"+platform).OrderByKey().LimitToLast(n).Get(ctx, &index)
+	done()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	versions := map[string]AppVersion{}
+	for _, id := range index {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			v, err := s.GetVersion(ctx, id)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil && firstErr == nil:
+				firstErr = err
+			case v != nil:
+				versions[id] = *v
+			}
+		}(id)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, false, firstErr
+	}
+	return versions, len(index) < n, nil
+}
+
+// buildVersionIndex adds the versionIndex/ entry of every stored version, for
+// records written before the index existed, and sets each platform's
+// latest/ pointer. Until it succeeds RecentVersions and GetLatestPointer
+// refuse to answer, since a missing entry would hide a version.
+func (s *firebaseStore) buildVersionIndex(ctx context.Context) error {
+	versions, err := fetchVersions(ctx, s.ref("versions"))
+	if err != nil {
+		return err
+	}
+	writes := map[string]interface{}{}
+	for _, v := range versions {
+		for path, value := range versionIndexWrites(v) {
+			writes[path] = value
+		}
+	}
+	if len(writes) > 0 {
+		done := timeOp(ctx, opDB, "write version index")
+		err := s.ref("").Update(ctx, writes)
+		done()
+		if err != nil {
+			return err
+		}
+	}
+	for _, platform := range supportedPlatforms {
+		highest := highestEnabled(versions, platform)
+		err := s.UpdateLatestPointer(ctx, platform, func(current *LatestPointer) *LatestPointer {
+			if current == nil {
+				return highest
+			}
+			// Keep a pointer an upload has moved past the scan since
+			if _, scanned := versions[current.ID]; !scanned && (highest == nil || current.VersionCode > highest.VersionCode) {
+				return current
+			}
+			return highest
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.indexed.Store(true)
+	return nil
+}
+
+func (s *firebaseStore) GetVersion(ctx context.Context, id string) (*AppVersion, error) {
+	defer timeOp(ctx, opDB, "read version")()
+
+	var raw json.RawMessage
+	if err := s.ref("versions/"+id).Get(ctx, &raw); err != nil {
+		return nil, err
+	}
+	v, err := decodeVersion(id, raw)
+	if err != nil || v.Version == "" {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *firebaseStore) PutVersion(ctx context.Context, v AppVersion) error {
+	defer timeOp(ctx, opDB, "write version")()
+	return s.ref("").Update(ctx, versionWrites(v))
+}
+
+// UpdateVersions writes the fields individually so a concurrent download
+// count increment on the same record isn't overwritten
+func (s *firebaseStore) UpdateVersions(ctx context.Context, versions []AppVersion, fields ...string) error {
+	writes := map[string]interface{}{}
+	for _, v := range versions {
+		values, err := versionFields(v)
+		if err != nil {
+			return err
+		}
+		for path, value := range versionWrites(v) {
+			writes[path] = value
+		}
+		delete(writes, "versions/"+v.ID)
+		for _, field := range fields {
+			// A field omitted from the JSON is written as null, which deletes it
+			writes["versions/"+v.ID+"/"+field] = values[field]
+		}
+	}
+
+	defer timeOp(ctx, opDB, "write versions")()
+	return s.ref("").Update(ctx, writes)
+}
+
+func (s *firebaseStore) DeleteVersion(ctx context.Context, v AppVersion) error {
+	deletes := map[string]interface{}{}
+	for path := range versionWrites(v) {
+		deletes[path] = nil
+	}
+	defer timeOp(ctx, opDB, "delete version")()
+	return s.ref("").Update(ctx, deletes)
+}
+
+func (s *firebaseStore) TrashVersion(ctx context.Context, v AppVersion) error {
+	writes := map[string]interface{}{}
+	for path := range versionWrites(v) {
+		writes[path] = nil
+	}
+	writes["trash/"+v.ID] = v
+	defer timeOp(ctx, opDB, "trash version")()
+	return s.ref("").Update(ctx, writes)
+}
+
+func (s *firebaseStore) RestoreVersion(ctx context.Context, v AppVersion) error {
+	writes := versionWrites(v)
+	writes["trash/"+v.ID] = nil
+	defer timeOp(ctx, opDB, "restore version")()
+	return s.ref("").Update(ctx, writes)
+}
+
+func (s *firebaseStore) ListTrashed(ctx context.Context) (map[string]AppVersion, error) {
+	return fetchVersions(ctx, s.ref("trash"))
+}
+
+func (s *firebaseStore) GetTrashed(ctx context.Context, id string) (*AppVersion, error) {
+	defer timeOp(ctx, opDB, "read trashed version")()
+
+	var raw json.RawMessage
+	if err := s.ref("trash/"+id).Get(ctx, &raw); err != nil {
+		return nil, err
+	}
+	v, err := decodeVersion(id, raw)
+	if err != nil || v.Version == "" {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *firebaseStore) PurgeTrashed(ctx context.Context, id string) error {
+	defer timeOp(ctx, opDB, "purge trashed version")()
+	return s.ref("trash/" + id).Delete(ctx)
+}
+
+// GetLatestPointer reads latest/<platform>; like RecentVersions it waits for
+// buildVersionIndex, which sets the pointers of versions older than them
+func (s *firebaseStore) GetLatestPointer(ctx context.Context, platform string) (*LatestPointer, error) {
+	if !s.indexed.Load() {
+		return nil, errIndexNotReady
+	}
+	defer timeOp(ctx, opDB, "read latest pointer")()
+
+	var pointer *LatestPointer
+	if err := s.ref("latest/"+platform).Get(ctx, &pointer); err != nil {
+		return nil, err
+	}
+	if pointer == nil || pointer.ID == "" {
+		return nil, nil
+	}
+	return pointer, nil
+}
+
+func (s *firebaseStore) UpdateLatestPointer(ctx context.Context, platform string, update func(*LatestPointer) *LatestPointer) error {
+	defer timeOp(ctx, opDB, "update latest pointer")()
+
+	return s.ref("latest/"+platform).Transaction(ctx, func(tn db.TransactionNode) (interface{}, error) {
+		var current *LatestPointer
+		if err := tn.Unmarshal(&current); err != nil {
+			return nil, err
+		}
+		if current != nil && current.ID == "" {
+			current = nil
+		}
+		next := update(current)
+		if next == nil {
+			return nil, nil
+		}
+		return next, nil
+	})
+}
+
+// ClaimVersionCode runs a transaction on versionCodes/<code>, so of two
+// uploads racing for the same code exactly one sees the node empty
+func (s *firebaseStore) ClaimVersionCode(ctx context.Context, code int, id string) (bool, error) {
+	defer timeOp(ctx, opDB, "claim version code")()
+
+	var claimed bool
+	err := s.ref("versionCodes/"+strconv.Itoa(code)).Transaction(ctx, func(tn db.TransactionNode) (interface{}, error) {
+		var holder string
+		if err := tn.Unmarshal(&holder); err != nil {
+			return nil, err
+		}
+		claimed = holder == "" || holder == id
+		if !claimed {
+			return holder, nil
+		}
+		return id, nil
+	})
+	return claimed, err
+}
+
+func (s *firebaseStore) ReleaseVersionCode(ctx context.Context, code int, id string) error {
+	defer timeOp(ctx, opDB, "release version code")()
+
+	return s.ref("versionCodes/"+strconv.Itoa(code)).Transaction(ctx, func(tn db.TransactionNode) (interface{}, error) {
+		var holder string
+		if err := tn.Unmarshal(&holder); err != nil {
+			return nil, err
+		}
+		if holder != id {
+			return holder, nil
+		}
+		return nil, nil
+	})
+}
+
+// IncrementDownloadCount bumps the counter in a transaction so concurrent
+// downloads never lose an increment. The whole record is transacted so a
+// version deleted meanwhile isn't resurrected as a bare counter.
+func (s *firebaseStore) IncrementDownloadCount(ctx context.Context, id string) error {
+	defer timeOp(ctx, opDB, "increment download count")()
+
+	return s.ref("versions/"+id).Transaction(ctx, func(tn db.TransactionNode) (interface{}, error) {
+		var record map[string]interface{}
+		if err := tn.Unmarshal(&record); err != nil {
+			return nil, err
+		}
+		if len(record) == 0 {
+			return nil, nil
+		}
+		count, _ := record["download_count"].(float64)
+		record["download_count"] = int64(count) + 1
+		return record, nil
+	})
+}
+
+// GetPlatformConfig reads config/<platform>; a missing node yields the zero value
+func (s *firebaseStore) GetPlatformConfig(ctx context.Context, platform string) (PlatformConfig, error) {
+	defer timeOp(ctx, opDB, "read platform config")()
+
+	var cfg PlatformConfig
+	err := s.ref("config/"+platform).Get(ctx, &cfg)
+	return cfg, err
+}
+
+func (s *firebaseStore) SetPlatformPaused(ctx context.Context, platform string, paused bool) error {
+	defer timeOp(ctx, opDB, "write platform config")()
+	return s.ref("config/"+platform+"/paused").Set(ctx, paused)
+}
+
+func (s *firebaseStore) SetMinSupportedCode(ctx context.Context, platform string, code int) error {
+	defer timeOp(ctx, opDB, "write platform config")()
+	ref := s.ref("config/" + platform + "/min_supported_code")
+	if code == 0 {
+		return ref.Delete(ctx)
+	}
+	return ref.Set(ctx, code)
+}
+
+func (s *firebaseStore) SetPlatformPolicy(ctx context.Context, platform string, mandatory *bool, rollout *int) error {
+	defer timeOp(ctx, opDB, "write platform config")()
+	// A nil value in an update deletes the child
+	return s.ref("config/"+platform).Update(ctx, map[string]interface{}{
+		"is_mandatory":       mandatory,
+		"rollout_percentage": rollout,
+	})
+}
+
+func (s *firebaseStore) CreateUploadSession(ctx context.Context, session UploadSession) error {
+	defer timeOp(ctx, opDB, "write upload session")()
+	return s.ref("uploads/"+session.ID).Set(ctx, session)
+}
+
+func (s *firebaseStore) GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	defer timeOp(ctx, opDB, "read upload session")()
+
+	var session UploadSession
+	if err := s.ref("uploads/"+id).Get(ctx, &session); err != nil {
+		return nil, err
+	}
+	if session.ID == "" {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *firebaseStore) UpdateUploadSession(ctx context.Context, id string, update func(*UploadSession) error) (UploadSession, error) {
+	defer timeOp(ctx, opDB, "update upload session")()
+
+	var updated UploadSession
+	err := s.ref("uploads/"+id).Transaction(ctx, func(tn db.TransactionNode) (interface{}, error) {
+		var current UploadSession
+		if err := tn.Unmarshal(&current); err != nil {
+			return nil, err
+		}
+		if err := update(&current); err != nil {
+			return nil, err
+		}
+		updated = current
+		return current, nil
+	})
+	return updated, err
+}
+
+func (s *firebaseStore) ListUploadSessions(ctx context.Context) (map[string]UploadSession, error) {
+	defer timeOp(ctx, opDB, "read upload sessions")()
+
+	var sessions map[string]UploadSession
+	err := s.ref("uploads").Get(ctx, &sessions)
+	return sessions, err
+}
+
+func (s *firebaseStore) DeleteUploadSession(ctx context.Context, id string) error {
+	defer timeOp(ctx, opDB, "delete upload session")()
+	return s.ref("uploads/" + id).Delete(ctx)
+}
+
+func (s *firebaseStore) AppendAudit(ctx context.Context, e AuditEntry) error {
+	defer timeOp(ctx, opDB, "write audit entry")()
+	return s.ref("audit/"+e.ID).Set(ctx, e)
+}
+
+func (s *firebaseStore) ListAudit(ctx context.Context) ([]AuditEntry, error) {
+	defer timeOp(ctx, opDB, "read audit log")()
+
+	var raw map[string]AuditEntry
+	if err := s.ref("audit").Get(ctx, &raw); err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(raw))
+	for id, e := range raw {
+		e.ID = id
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// UploadObject streams r into a new object. Cancelling the writer's context
+// on a failed copy discards the partial object instead of finalizing it.
+func (s *firebaseStore) UploadObject(ctx context.Context, path string, r io.Reader) error {
+	if s.bucket == nil {
+		return errBucketNotConfigured
+	}
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := s.bucket.Object(path).NewWriter(writeCtx)
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+
+	defer timeOp(ctx, opStorage, "finalize upload")()
+	return w.Close()
+}
+
+func (s *firebaseStore) OpenObject(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if s.bucket == nil {
+		return nil, errBucketNotConfigured
+	}
+	defer timeOp(ctx, opStorage, "open object")()
+
+	reader, err := s.bucket.Object(path).NewRangeReader(ctx, offset, length)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, errObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (s *firebaseStore) DeleteObject(ctx context.Context, path string) error {
+	if s.bucket == nil {
+		return errBucketNotConfigured
+	}
+	defer timeOp(ctx, opStorage, "delete object")()
+	err := s.bucket.Object(path).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return errObjectNotFound
+	}
+	return err
+}
+
+// ComposeObjects folds srcs into dst in batches, because GCS limits how many
+// sources a single compose may have
+func (s *firebaseStore) ComposeObjects(ctx context.Context, dst string, srcs []string) error {
+	if s.bucket == nil {
+		return errBucketNotConfigured
+	}
+	if len(srcs) == 0 {
+		return errors.New("no data to compose")
+	}
+	defer timeOp(ctx, opStorage, "compose objects")()
+
+	target := s.bucket.Object(dst)
+	var sources []*storage.ObjectHandle
+	for i, name := range srcs {
+		sources = append(sources, s.bucket.Object(name))
+		if len(sources) == gcsComposeLimit && i < len(srcs)-1 {
+			// Fold what we have into dst and continue appending to it
+			if _, err := target.ComposerFrom(sources...).Run(ctx); err != nil {
+				return err
+			}
+			sources = []*storage.ObjectHandle{target}
+		}
+	}
+	_, err := target.ComposerFrom(sources...).Run(ctx)
+	return err
+}
+
+func (s *firebaseStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if s.bucket == nil {
+		return nil, errBucketNotConfigured
+	}
+	defer timeOp(ctx, opStorage, "list objects")()
+
+	var objects []ObjectInfo
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, ObjectInfo{Path: attrs.Name, Size: attrs.Size, Updated: attrs.Updated})
+	}
+}
+
+func (s *firebaseStore) PublishObject(ctx context.Context, path string) error {
+	if s.bucket == nil {
+		return errBucketNotConfigured
+	}
+	return s.bucket.Object(path).ACL().Set(ctx, storage.AllUsers, storage.RoleReader)
+}
+
+func (s *firebaseStore) SetObjectMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	if s.bucket == nil {
+		return errBucketNotConfigured
+	}
+	defer timeOp(ctx, opStorage, "update object metadata")()
+	_, err := s.bucket.Object(path).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return errObjectNotFound
+	}
+	return err
+}
+
+// Ping does a shallow read of a small node and fetches the bucket's metadata
+func (s *firebaseStore) Ping(ctx context.Context) map[string]error {
+	var keys interface{}
+	results := map[string]error{
+		"database": s.ref("config").GetShallow(ctx, &keys),
+		"storage":  errBucketNotConfigured,
+	}
+	if s.bucket != nil {
+		_, results["storage"] = s.bucket.Attrs(ctx)
+	}
+	return results
+}
+
+// versionSource is satisfied by both *db.Ref and *db.Query
+type versionSource interface {
+	Get(ctx context.Context, v interface{}) error
+}
+
+// fetchVersions reads a set of version records, decoding each one separately
+// so that a single malformed record is logged and skipped instead of failing
+// the whole read.
+func fetchVersions(ctx context.Context, src versionSource) (map[string]AppVersion, error) {
+	var raw map[string]json.RawMessage
+	done := timeOp(ctx, opDB, "read versions")
+	err := src.Get(ctx, &raw)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return decodeVersions(ctx, raw), nil
+}
+
+func decodeVersions(ctx context.Context, raw map[string]json.RawMessage) map[string]AppVersion {
+	versions := make(map[string]AppVersion, len(raw))
+	skipped := 0
+	for key, data := range raw {
+		v, err := decodeVersion(key, data)
+		if err != nil {
+			loggerFrom(ctx).Warn("skipping malformed version record", "key", key, "err", err)
+			skipped++
+			continue
+		}
+		versions[key] = v
+	}
+	if skipped > 0 {
+		loggerFrom(ctx).Warn("skipped malformed version records", "skipped", skipped, "total", len(raw))
+	}
+	return versions
+}
+
+// decodeVersion decodes one stored record and fills in the fields older
+// records lack
+func decodeVersion(id string, data json.RawMessage) (AppVersion, error) {
+	var v AppVersion
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &v); err != nil {
+			return v, err
+		}
+	}
+	if v.ID == "" {
+		v.ID = id
+	}
+	v.Platform = platformOf(v)
+	v.Channel = channelOf(v)
+	return v, nil
+}
+
+// versionFields returns a version's stored JSON representation by field
+func versionFields(v AppVersion) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// versionsWhere keeps the versions whose JSON field equals value
+func versionsWhere(versions map[string]AppVersion, field string, value interface{}) map[string]AppVersion {
+	want, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	matched := map[string]AppVersion{}
+	for id, v := range versions {
+		if fields, err := versionFields(v); err == nil && bytes.Equal(fields[field], want) {
+			matched[id] = v
+		}
+	}
+	return matched
+}
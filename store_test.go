@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingStore is a memory store whose version writes fail while fail is
+// set, whose version deletes fail while failDelete is, and whose version
+// reads fail while failReads is
+type failingStore struct {
+	*memoryStore
+	fail       bool
+	failDelete bool
+	failReads  bool
+}
+
+var errInjected = errors.New("injected write failure")
+
+func (s *failingStore) PutVersion(ctx context.Context, v AppVersion) error {
+	if s.fail {
+		return errInjected
+	}
+	return s.memoryStore.PutVersion(ctx, v)
+}
+
+func (s *failingStore) ListVersions(ctx context.Context) (map[string]AppVersion, error) {
+	if s.failReads {
+		return nil, errInjected
+	}
+	return s.memoryStore.ListVersions(ctx)
+}
+
+func (s *failingStore) RecentVersions(ctx context.Context, platform string, n int) (map[string]AppVersion, bool, error) {
+	if s.failReads {
+		return nil, false, errInjected
+	}
+	return s.memoryStore.RecentVersions(ctx, platform, n)
+}
+
+func (s *failingStore) GetVersion(ctx context.Context, id string) (*AppVersion, error) {
+	if s.failReads {
+		return nil, errInjected
+	}
+	return s.memoryStore.GetVersion(ctx, id)
+}
+
+func (s *failingStore) GetLatestPointer(ctx context.Context, platform string) (*LatestPointer, error) {
+	if s.failReads {
+		return nil, errInjected
+	}
+	return s.memoryStore.GetLatestPointer(ctx, platform)
+}
+
+func (s *failingStore) DeleteVersion(ctx context.Context, v AppVersion) error {
+	if s.failDelete {
+		return errInjected
+	}
+	return s.memoryStore.DeleteVersion(ctx, v)
+}
+
+// countingStore counts the version reads check-update can make
+type countingStore struct {
+	*memoryStore
+	pointerReads, recentReads, listReads int
+}
+
+func (s *countingStore) GetLatestPointer(ctx context.Context, platform string) (*LatestPointer, error) {
+	s.pointerReads++
+	return s.memoryStore.GetLatestPointer(ctx, platform)
+}
+
+func (s *countingStore) RecentVersions(ctx context.Context, platform string, n int) (map[string]AppVersion, bool, error) {
+	s.recentReads++
+	return s.memoryStore.RecentVersions(ctx, platform, n)
+}
+
+func (s *countingStore) ListVersions(ctx context.Context) (map[string]AppVersion, error) {
+	s.listReads++
+	return s.memoryStore.ListVersions(ctx)
+}
+
+func (s *countingStore) reset() {
+	s.pointerReads, s.recentReads, s.listReads = 0, 0, 0
+}
+
+func TestVersionWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		v    AppVersion
+		want map[string]any
+	}{
+		{
+			name: "record and index",
+			v:    AppVersion{ID: "a", Platform: "android", VersionCode: 3},
+			want: map[string]any{"versions/a": nil, "versionIndex/android/3": "a"},
+		},
+		{
+			name: "platform from storage path",
+			v:    AppVersion{ID: "b", StoragePath: "releases/ios/1.0.0.ipa", VersionCode: 4},
+			want: map[string]any{"versions/b": nil, "versionIndex/ios/4": "b"},
+		},
+		{
+			name: "no code, no index",
+			v:    AppVersion{ID: "c", Platform: "android"},
+			want: map[string]any{"versions/c": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := versionWrites(tt.v)
+			if len(got) != len(tt.want) {
+				t.Fatalf("writes = %v, want paths of %v", got, tt.want)
+			}
+			for path, want := range tt.want {
+				value, ok := got[path]
+				if !ok {
+					t.Errorf("missing write to %s", path)
+				} else if want != nil && value != want {
+					t.Errorf("%s = %v, want %v", path, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFailedPublishLeavesNoAggregates(t *testing.T) {
+	store := &failingStore{memoryStore: newMemoryStore(), fail: true}
+	ts := newTestServerWithStore(t, store)
+	ctx := context.Background()
+	fields := map[string]string{"version": "1.0.2", "version_code": "2", "platform": "android"}
+
+	w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500 (%s)", w.Code, w.Body)
+	}
+	versions, _ := store.ListVersions(ctx)
+	pointer, _ := store.GetLatestPointer(ctx, "android")
+	objects, _ := store.ListObjects(ctx, "releases/")
+	if len(versions) != 0 || pointer != nil || len(objects) != 0 {
+		t.Errorf("failed upload left versions %v, latest pointer %+v, objects %v", versions, pointer, objects)
+	}
+	if resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1}); resp.UpdateAvailable {
+		t.Errorf("check-update offers %+v after a failed upload", resp.LatestVersion)
+	}
+
+	// The version code was given back, so the retry succeeds
+	store.fail = false
+	if w := ts.upload("/api/v1/ota/upload", fields, "app.aab", []byte("PK\x03\x04aab"), testAPIKey); w.Code != http.StatusOK {
+		t.Fatalf("retry: status = %d (%s)", w.Code, w.Body)
+	}
+	if pointer, _ := store.GetLatestPointer(ctx, "android"); pointer == nil || pointer.VersionCode != 2 {
+		t.Errorf("latest pointer after retry = %+v", pointer)
+	}
+}
+
+func TestMalformedVersionRecords(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		method string
+		body   any
+		check  func(t *testing.T, w *httptest.ResponseRecorder)
+	}{
+		{name: "list", method: http.MethodGet, target: "/api/v1/ota/versions?platform=android", check: func(t *testing.T, w *httptest.ResponseRecorder) {
+			var versions []AppVersion
+			decodeJSON(t, w, &versions)
+			if len(versions) != 2 {
+				t.Errorf("listed %d versions, want the 2 valid ones", len(versions))
+			}
+		}},
+		{name: "check-update", method: http.MethodPost, target: "/api/v1/ota/check-update",
+			body: UpdateCheckRequest{CurrentVersion: "1.0.1", CurrentCode: 1, Platform: "android"},
+			check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var resp UpdateCheckResponse
+				decodeJSON(t, w, &resp)
+				if !resp.UpdateAvailable || resp.LatestVersion.VersionCode != 2 {
+					t.Errorf("response = %+v, want version code 2", resp)
+				}
+			}},
+		{name: "latest", method: http.MethodGet, target: "/api/v1/ota/versions/latest?platform=android"},
+		{name: "stats", method: http.MethodGet, target: "/api/v1/ota/stats"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			ts := newTestServerWithStore(t, store)
+			ts.seed(AppVersion{VersionCode: 1})
+			ts.seed(AppVersion{VersionCode: 2})
+			store.versions["malformed"] = json.RawMessage(`{"version": "1.0.3", "version_code": "three", "platform": "android"}`)
+
+			w := ts.do(tt.method, tt.target, tt.body, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", w.Code, w.Body)
+			}
+			if tt.check != nil {
+				tt.check(t, w)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreVersions(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	v := AppVersion{ID: "android-1", Version: "1.0.1", VersionCode: 1, Platform: "android", Channel: "stable"}
+	if err := s.PutVersion(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetVersion(ctx, "android-1")
+	if err != nil || got == nil || got.Version != "1.0.1" {
+		t.Fatalf("GetVersion = %+v, %v", got, err)
+	}
+	// Records are copies; editing one doesn't reach the store
+	got.Version = "edited"
+	if again, _ := s.GetVersion(ctx, "android-1"); again.Version != "1.0.1" {
+		t.Errorf("stored version changed through a returned copy: %q", again.Version)
+	}
+	if missing, err := s.GetVersion(ctx, "missing"); missing != nil || err != nil {
+		t.Errorf("GetVersion(missing) = %+v, %v, want nil, nil", missing, err)
+	}
+	if versions, err := s.ListVersions(ctx); err != nil || len(versions) != 1 {
+		t.Errorf("ListVersions = %v, %v", versions, err)
+	}
+
+	if err := s.DeleteVersion(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+	if gone, err := s.GetVersion(ctx, "android-1"); gone != nil || err != nil {
+		t.Errorf("after delete GetVersion = %+v, %v", gone, err)
+	}
+}
+
+func TestMemoryStoreVersionCodes(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	steps := []struct {
+		op   string // claim or release
+		code int
+		id   string
+		want bool // claims only
+	}{
+		{"claim", 1, "a", true},
+		{"claim", 1, "a", true},
+		{"claim", 1, "b", false},
+		{"claim", 2, "b", true},
+		{"release", 1, "b", false},
+		{"claim", 1, "b", false},
+		{"release", 1, "a", false},
+		{"claim", 1, "b", true},
+	}
+	for i, st := range steps {
+		if st.op == "release" {
+			if err := s.ReleaseVersionCode(ctx, st.code, st.id); err != nil {
+				t.Fatalf("step %d: %v", i, err)
+			}
+			continue
+		}
+		ok, err := s.ClaimVersionCode(ctx, st.code, st.id)
+		if err != nil || ok != st.want {
+			t.Errorf("step %d: claim %d for %s = %t, %v, want %t", i, st.code, st.id, ok, err, st.want)
+		}
+	}
+}
+
+func TestMemoryStoreObjects(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	for path, data := range map[string]string{"uploads/x/1": "hello ", "uploads/x/2": "world", "releases/android/a.apk": "PK"} {
+		if err := s.UploadObject(ctx, path, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.ComposeObjects(ctx, "releases/android/b.apk", []string{"uploads/x/1", "uploads/x/2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reads := []struct {
+		path           string
+		offset, length int64
+		want           string
+		wantErr        error
+	}{
+		{path: "releases/android/b.apk", length: -1, want: "hello world"},
+		{path: "releases/android/b.apk", offset: 6, length: -1, want: "world"},
+		{path: "releases/android/b.apk", offset: 2, length: 3, want: "llo"},
+		{path: "releases/android/b.apk", offset: 6, length: 100, want: "world"},
+		{path: "releases/android/b.apk", offset: 100, length: -1, want: ""},
+		{path: "releases/android/missing.apk", length: -1, wantErr: errObjectNotFound},
+	}
+	for _, r := range reads {
+		rc, err := s.OpenObject(ctx, r.path, r.offset, r.length)
+		if !errors.Is(err, r.wantErr) {
+			t.Errorf("OpenObject(%s, %d, %d) err = %v, want %v", r.path, r.offset, r.length, err, r.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != r.want {
+			t.Errorf("OpenObject(%s, %d, %d) = %q, want %q", r.path, r.offset, r.length, data, r.want)
+		}
+	}
+
+	objects, err := s.ListObjects(ctx, "releases/")
+	if err != nil || len(objects) != 2 {
+		t.Errorf("ListObjects(releases/) = %v, %v", objects, err)
+	}
+	if err := s.DeleteObject(ctx, "releases/android/a.apk"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteObject(ctx, "releases/android/a.apk"); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("deleting twice = %v, want %v", err, errObjectNotFound)
+	}
+}
+
+func TestStorageBucketConfig(t *testing.T) {
+	firebaseEnv := map[string]string{
+		"OTA_STORE":                 "firebase",
+		"OTA_API_KEYS":              testAPIKey,
+		"FIREBASE_CREDENTIALS_JSON": "{}",
+		"FIREBASE_PROJECT_ID":       "my-app",
+		"FIREBASE_DB_URL":           "https://my-app.firebaseio.com",
+	}
+	tests := []struct {
+		name       string
+		bucket     string
+		wantErr    bool
+		wantBucket string
+	}{
+		{name: "unset fails at startup", bucket: "", wantErr: true},
+		{name: "blank fails at startup", bucket: "  ", wantErr: true},
+		{name: "set", bucket: " my-app.appspot.com ", wantBucket: "my-app.appspot.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range firebaseEnv {
+				t.Setenv(name, value)
+			}
+			t.Setenv("FIREBASE_STORAGE_BUCKET", tt.bucket)
+
+			cfg, err := loadConfig()
+			if tt.wantErr {
+				var errs ConfigErrors
+				if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Var != "FIREBASE_STORAGE_BUCKET" {
+					t.Fatalf("err = %v, want one FIREBASE_STORAGE_BUCKET error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.StorageBucket != tt.wantBucket {
+				t.Errorf("StorageBucket = %q, want %q", cfg.StorageBucket, tt.wantBucket)
+			}
+		})
+	}
+}
+
+func TestStorageBucketReadOnce(t *testing.T) {
+	// Handlers use the bucket resolved at startup, not the current environment
+	setConfig(t, "PUBLIC_ARTIFACTS=true")
+	config.Store = "firebase"
+	config.StorageBucket = "startup-bucket"
+	t.Setenv("FIREBASE_STORAGE_BUCKET", "changed-bucket")
+
+	access := artifactAccess(AppVersion{StoragePath: "releases/android/1.0.1.apk"})
+	if want := "https://storage.googleapis.com/startup-bucket/releases/android/1.0.1.apk"; access["public_url"] != want {
+		t.Errorf("public_url = %v, want %s", access["public_url"], want)
+	}
+}
+
+func TestFirebaseStoreWithoutBucket(t *testing.T) {
+	ctx := context.Background()
+	s := newFirebaseStore(nil, nil, "")
+	ops := map[string]func() error{
+		"UploadObject": func() error { return s.UploadObject(ctx, "a", strings.NewReader("x")) },
+		"OpenObject": func() error {
+			_, err := s.OpenObject(ctx, "a", 0, -1)
+			return err
+		},
+		"DeleteObject":   func() error { return s.DeleteObject(ctx, "a") },
+		"ComposeObjects": func() error { return s.ComposeObjects(ctx, "a", []string{"b"}) },
+		"ListObjects": func() error {
+			_, err := s.ListObjects(ctx, "")
+			return err
+		},
+	}
+	for name, op := range ops {
+		if err := op(); !errors.Is(err, errBucketNotConfigured) {
+			t.Errorf("%s = %v, want %v", name, err, errBucketNotConfigured)
+		}
+	}
+}
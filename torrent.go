@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+)
+
+// torrentPieceLength is the BitTorrent piece size used for info-hashes
+const torrentPieceLength = 256 * 1024
+
+// torrentHasher computes a single-file BitTorrent v1 info-hash from bytes
+// written to it, so it can sit in the upload's io.MultiWriter
+type torrentHasher struct {
+	piece  hash.Hash
+	filled int
+	pieces []byte
+	length int64
+}
+
+func newTorrentHasher() *torrentHasher {
+	return &torrentHasher{piece: sha1.New()}
+}
+
+func (t *torrentHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := torrentPieceLength - t.filled
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		t.piece.Write(p[:chunk])
+		t.filled += chunk
+		p = p[chunk:]
+		if t.filled == torrentPieceLength {
+			t.pieces = t.piece.Sum(t.pieces)
+			t.piece.Reset()
+			t.filled = 0
+		}
+	}
+	t.length += int64(n)
+	return n, nil
+}
+
+// infoHash returns the hex info-hash of the torrent describing the written
+// bytes as a single file called name
+func (t *torrentHasher) infoHash(name string) string {
+	pieces := t.pieces
+	if t.filled > 0 {
+		pieces = t.piece.Sum(append([]byte(nil), pieces...))
+	}
+
+	// Bencoded info dictionary; keys must be in sorted order
+	info := "d" +
+		bencodeString("length") + "i" + strconv.FormatInt(t.length, 10) + "e" +
+		bencodeString("name") + bencodeString(name) +
+		bencodeString("piece length") + "i" + strconv.Itoa(torrentPieceLength) + "e" +
+		bencodeString("pieces") + bencodeString(string(pieces)) +
+		"e"
+
+	sum := sha1.Sum([]byte(info))
+	return hex.EncodeToString(sum[:])
+}
+
+// magnetLink returns a magnet URI for the written bytes
+func (t *torrentHasher) magnetLink(name string) string {
+	return fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s&xl=%d", t.infoHash(name), url.QueryEscape(name), t.length)
+}
+
+// newDistributionHasher returns a hasher for a release's primary file, or
+// nil when DISTRIBUTION_MAGNET_LINKS is off
+func newDistributionHasher() *torrentHasher {
+	if !config.DistributionMagnetLinks {
+		return nil
+	}
+	return newTorrentHasher()
+}
+
+// distributionLinks returns the peer-assisted download links of the release
+// file whose bytes went through t, named as downloads name it; nil for a
+// nil hasher
+func (t *torrentHasher) distributionLinks(version, ext string) map[string]string {
+	if t == nil {
+		return nil
+	}
+	return map[string]string{"magnet": t.magnetLink(fmt.Sprintf("app-v%s%s", version, ext))}
+}
+
+func bencodeString(s string) string {
+	return strconv.Itoa(len(s)) + ":" + s
+}
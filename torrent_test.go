@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// referenceInfoHash hashes content as a whole, independently of how it was
+// written to a torrentHasher
+func referenceInfoHash(name string, content []byte) string {
+	var pieces []byte
+	for rest := content; len(rest) > 0; {
+		n := min(len(rest), torrentPieceLength)
+		sum := sha1.Sum(rest[:n])
+		pieces = append(pieces, sum[:]...)
+		rest = rest[n:]
+	}
+	info := fmt.Sprintf("d6:lengthi%de4:name%d:%s12:piece lengthi%de6:pieces%d:%se",
+		len(content), len(name), name, torrentPieceLength, len(pieces), pieces)
+	sum := sha1.Sum([]byte(info))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestTorrentInfoHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		chunk int // bytes per Write
+	}{
+		{name: "small file", size: 1000, chunk: 1000},
+		{name: "exactly one piece", size: torrentPieceLength, chunk: 4096},
+		{name: "one byte over a piece", size: torrentPieceLength + 1, chunk: 4096},
+		{name: "several pieces in one write", size: 3*torrentPieceLength + 17, chunk: 3*torrentPieceLength + 17},
+		{name: "writes straddling pieces", size: 2*torrentPieceLength + 5, chunk: 100003},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+			h := newTorrentHasher()
+			for rest := content; len(rest) > 0; {
+				n := min(len(rest), tt.chunk)
+				h.Write(rest[:n])
+				rest = rest[n:]
+			}
+			want := referenceInfoHash("app-v1.0.0.apk", content)
+			if got := h.infoHash("app-v1.0.0.apk"); got != want {
+				t.Errorf("infoHash = %s, want %s", got, want)
+			}
+			// infoHash must not consume the partial last piece
+			if got := h.infoHash("app-v1.0.0.apk"); got != want {
+				t.Errorf("second infoHash = %s, want %s", got, want)
+			}
+			wantLink := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=app-v1.0.0.apk&xl=%d", want, tt.size)
+			if got := h.magnetLink("app-v1.0.0.apk"); got != wantLink {
+				t.Errorf("magnetLink = %s, want %s", got, wantLink)
+			}
+		})
+	}
+}
+
+func TestUploadMagnetLink(t *testing.T) {
+	content := []byte("PK\x03\x04aab")
+	tests := []struct {
+		name       string
+		env        []string
+		resumable  bool
+		wantMagnet bool
+	}{
+		{name: "off by default"},
+		{name: "enabled", env: []string{"DISTRIBUTION_MAGNET_LINKS=true"}, wantMagnet: true},
+		{name: "resumable off by default", resumable: true},
+		{name: "resumable enabled", env: []string{"DISTRIBUTION_MAGNET_LINKS=true"}, resumable: true, wantMagnet: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.env...)
+			fields := map[string]string{"version": "1.0.2", "version_code": "2", "platform": "android"}
+			if tt.resumable {
+				fields["filename"] = "app.aab"
+				if w := ts.tusUpload(fields, content); w.Code != http.StatusNoContent {
+					t.Fatalf("resumable upload: %d %s", w.Code, w.Body)
+				}
+			} else if w := ts.upload("/api/v1/ota/upload", fields, "app.aab", content, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("upload: %d %s", w.Code, w.Body)
+			}
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil || len(versions) != 1 {
+				t.Fatalf("versions = %v, %v", versions, err)
+			}
+			var stored AppVersion
+			for _, v := range versions {
+				stored = v
+			}
+			magnet := stored.DistributionLinks["magnet"]
+			if !tt.wantMagnet {
+				if magnet != "" {
+					t.Errorf("magnet = %q, want none", magnet)
+				}
+				return
+			}
+			if want := "urn:btih:" + referenceInfoHash("app-v1.0.2.aab", content); !strings.Contains(magnet, want) {
+				t.Errorf("magnet = %q, want %s", magnet, want)
+			}
+
+			// Capable clients find it in check-update
+			resp := ts.checkUpdate(UpdateCheckRequest{CurrentCode: 1})
+			if resp.LatestVersion == nil || resp.LatestVersion.DistributionLinks["magnet"] != magnet {
+				t.Errorf("check-update latest = %+v", resp.LatestVersion)
+			}
+		})
+	}
+}
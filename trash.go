@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trashedAtMetadata is the custom metadata key set on the objects of a
+// trashed version, so they can be told apart in the bucket until purged
+const trashedAtMetadata = "ota-trashed-at"
+
+// trashPurgeInterval is how often versions trashed longer than
+// TRASH_RETENTION are deleted for good
+const trashPurgeInterval = time.Hour
+
+// trashVersion moves a version to the trash instead of deleting it. Its
+// objects stay where they are, marked with trashedAtMetadata; its version
+// code is freed like on a delete, and restoring claims it again.
+func (s *Server) trashVersion(ctx context.Context, v AppVersion) error {
+	now := time.Now().UTC()
+	v.DeletedAt = &now
+	v.Status = ""
+	if err := s.store.TrashVersion(ctx, v); err != nil {
+		return err
+	}
+	s.markObjects(ctx, v, map[string]string{trashedAtMetadata: now.Format(time.RFC3339)})
+	s.trackLatest(ctx, v, true)
+	s.releaseVersionCode(ctx, v.VersionCode, v.ID)
+	return nil
+}
+
+// markObjects sets the custom metadata of every object of v. Failures are
+// only logged: the trash/ record, not the mark, decides what is trashed.
+func (s *Server) markObjects(ctx context.Context, v AppVersion, metadata map[string]string) {
+	paths := []string{}
+	for _, a := range artifactsOf(v) {
+		paths = append(paths, a.StoragePath)
+	}
+	for _, p := range v.Patches {
+		paths = append(paths, p.StoragePath)
+	}
+	for _, path := range paths {
+		if err := s.store.SetObjectMetadata(ctx, path, metadata); err != nil {
+			loggerFrom(ctx).Warn("marking object failed", "storage_path", path, "version_id", v.ID, "err", err)
+		}
+	}
+}
+
+// restoreVersion serves POST /versions/:id/restore, moving a trashed version
+// back. It is refused with 409 when another version has since taken its
+// version code or, on its platform, its version string.
+func (s *Server) restoreVersion(c *gin.Context) {
+	ctx := requestContext(c)
+	id := c.Param("id")
+	if !isValidKey(id) {
+		respondError(c, http.StatusNotFound, codeNotFound, "Version not in trash")
+		return
+	}
+	version, err := s.store.GetTrashed(ctx, id)
+	if err != nil {
+		loggerFrom(ctx).Error("trashed version read failed", "version_id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	if version == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, "Version not in trash")
+		return
+	}
+	if !authorizePlatform(c, platformOf(*version)) {
+		return
+	}
+
+	same, err := s.store.FindVersions(ctx, "version", version.Version)
+	if err != nil {
+		loggerFrom(ctx).Error("version fetch failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	for _, v := range same {
+		if matchesPlatform(v, platformOf(*version)) {
+			respondError(c, http.StatusConflict, codeVersionExists, fmt.Sprintf("Version %s already exists", version.Version))
+			return
+		}
+	}
+	claimed, err := s.claimVersionCode(ctx, version.VersionCode, id)
+	if err != nil {
+		loggerFrom(ctx).Error("version code claim failed", "version_code", version.VersionCode, "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	if !claimed {
+		respondError(c, http.StatusConflict, codeVersionExists, fmt.Sprintf("Version code %d is already in use", version.VersionCode))
+		return
+	}
+
+	version.DeletedAt = nil
+	if err := s.store.RestoreVersion(ctx, *version); err != nil {
+		s.releaseVersionCode(ctx, version.VersionCode, id)
+		loggerFrom(ctx).Error("version restore failed", "version_id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to restore version")
+		return
+	}
+	s.markObjects(ctx, *version, map[string]string{})
+	s.trackLatest(ctx, *version, false)
+	s.audit(c, auditRestore, version, nil)
+
+	version.DownloadURL = s.downloadURL(version.Version, platformOf(*version))
+	version.Status = versionStatus(*version, time.Now())
+	c.JSON(http.StatusOK, gin.H{"message": "Version restored", "version": version})
+}
+
+// purgeTrash deletes the versions trashed more than TRASH_RETENTION ago,
+// objects first so a failure leaves the record to retry from, and returns
+// how many it purged
+func (s *Server) purgeTrash(ctx context.Context, now time.Time) (int, error) {
+	trashed, err := s.store.ListTrashed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for id, v := range trashed {
+		if v.DeletedAt != nil && now.Sub(*v.DeletedAt) < config.TrashRetention {
+			continue
+		}
+		failed := false
+		for _, a := range artifactsOf(v) {
+			if err := s.store.DeleteObject(ctx, a.StoragePath); err != nil && !errors.Is(err, errObjectNotFound) {
+				loggerFrom(ctx).Warn("deleting file from storage failed", "storage_path", a.StoragePath, "err", err)
+				failed = true
+			}
+		}
+		for _, p := range v.Patches {
+			if err := s.store.DeleteObject(ctx, p.StoragePath); err != nil && !errors.Is(err, errObjectNotFound) {
+				loggerFrom(ctx).Warn("deleting patch from storage failed", "storage_path", p.StoragePath, "err", err)
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+		if err := s.store.PurgeTrashed(ctx, id); err != nil {
+			return purged, fmt.Errorf("purging version %s: %w", id, err)
+		}
+		loggerFrom(ctx).Info("purged trashed version", "version_id", id, "version", v.Version, "platform", platformOf(v))
+		s.auditSystem(ctx, auditPurge, &v, nil)
+		purged++
+	}
+	return purged, nil
+}
+
+// runTrashPurge purges every app's expired trash now and then every
+// trashPurgeInterval, for as long as the process runs
+func runTrashPurge(ctx context.Context, servers []*Server) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		for _, s := range servers {
+			if _, err := s.purgeTrash(ctx, time.Now()); err != nil {
+				log.Printf("Warning: purging trashed versions failed (app %q): %v", s.appID, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
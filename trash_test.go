@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	tests := []struct {
+		name       string
+		age        time.Duration // since the delete
+		wantPurged int
+	}{
+		{name: "within retention", age: time.Hour, wantPurged: 0},
+		{name: "expired", age: 8 * 24 * time.Hour, wantPurged: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			ts := newTestServer(t)
+			v := ts.seed(AppVersion{VersionCode: 1})
+			if w := ts.do(http.MethodDelete, "/api/v1/ota/versions/"+v.ID, nil, testAPIKey); w.Code != http.StatusOK {
+				t.Fatalf("delete: %d %s", w.Code, w.Body)
+			}
+
+			purged, err := ts.purgeTrash(ctx, time.Now().Add(tt.age))
+			if err != nil || purged != tt.wantPurged {
+				t.Fatalf("purged = %d, %v, want %d", purged, err, tt.wantPurged)
+			}
+			trashed, err := ts.store.ListTrashed(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := trashed[v.ID]; ok != (tt.wantPurged == 0) {
+				t.Errorf("%s still trashed = %t", v.ID, ok)
+			}
+
+			entries, err := ts.store.ListAudit(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var purges []AuditEntry
+			for _, e := range entries {
+				if e.Action == auditPurge {
+					purges = append(purges, e)
+				}
+			}
+			if len(purges) != tt.wantPurged {
+				t.Fatalf("purge entries = %+v, want %d", purges, tt.wantPurged)
+			}
+			for _, e := range purges {
+				if e.VersionID != v.ID || e.Platform != "android" || e.APIKeyID != auditSystemActor {
+					t.Errorf("purge entry = %+v", e)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tus resumable upload protocol (https://tus.io/protocols/resumable-upload),
+// core protocol plus the creation and expiration extensions.
+//
+// Every PATCH is streamed into its own staging object under uploads/<id>/ and
+// the SHA-256 state is persisted on the session between requests. When the
+// last byte arrives the staged chunks are composed into the release object
+// and the version record is created exactly like a regular upload.
+
+const tusVersion = "1.0.0"
+
+// UploadSession is the state of a resumable upload, stored under uploads/<id>
+type UploadSession struct {
+	ID           string    `json:"id"`
+	Platform     string    `json:"platform"`
+	Version      string    `json:"version"`
+	VersionCode  int       `json:"version_code"`
+	ReleaseNotes string    `json:"release_notes"`
+	IsMandatory  *bool     `json:"is_mandatory,omitempty"`
+	Channel      string    `json:"channel"`
+	Rollout      *int      `json:"rollout_percentage,omitempty"`
+	MinOSVersion string    `json:"min_os_version,omitempty"`
+	Filename     string    `json:"filename"`
+	Length       int64     `json:"length"`
+	Offset       int64     `json:"offset"`
+	Chunks       []string  `json:"chunks,omitempty"` // Staging objects in upload order
+	HashState    string    `json:"hash_state"`       // Marshalled SHA-256 state after Offset bytes
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+
+	// LocalizedNotes come from release_notes_<locale> metadata keys
+	LocalizedNotes map[string]string `json:"localized_release_notes,omitempty"`
+	PublishAt      *time.Time        `json:"publish_at,omitempty"`
+}
+
+func (s *UploadSession) expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+// tusHeaders sets the headers every tus response carries
+func tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+}
+
+// requireTusVersion rejects requests for a protocol version we don't speak
+func requireTusVersion(c *gin.Context) bool {
+	tusHeaders(c)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		respondError(c, http.StatusPreconditionFailed, codeUnsupported, "Unsupported tus version")
+		return false
+	}
+	return true
+}
+
+// tusOptions advertises the supported protocol version and extensions
+func tusOptions(c *gin.Context) {
+	tusHeaders(c)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,expiration")
+	if limit := maxUploadBytes(); limit > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(limit, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// createUploadSession handles the tus creation request. The release fields
+// of a regular upload are passed in Upload-Metadata.
+func (s *Server) createUploadSession(c *gin.Context) {
+	ctx := requestContext(c)
+	if !requireTusVersion(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid Upload-Length")
+		return
+	}
+	if limit := maxUploadBytes(); limit > 0 && length > limit {
+		respondTooLarge(c, limit)
+		return
+	}
+
+	meta, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	version := strings.TrimSpace(meta["version"])
+	versionCodeStr := strings.TrimSpace(meta["version_code"])
+	platform := strings.ToLower(strings.TrimSpace(meta["platform"]))
+	filename := meta["filename"]
+
+	if version == "" || versionCodeStr == "" || filename == "" {
+		respondErrorDetails(c, http.StatusBadRequest, codeMissingFields, "Missing required metadata", gin.H{
+			"required": []string{"version", "version_code", "filename"},
+		})
+		return
+	}
+
+	if platform == "" {
+		inferred, ok := inferPlatform(filename)
+		if !ok {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Could not infer platform from file extension", gin.H{
+				"required": []string{"platform"},
+			})
+			return
+		}
+		platform = inferred
+	}
+	if !isSupportedPlatform(platform) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform", gin.H{
+			"expected": supportedPlatforms,
+		})
+		return
+	}
+	if !authorizePlatform(c, platform) {
+		return
+	}
+
+	version, ok := normalizeVersion(version)
+	if !ok {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version", gin.H{
+			"expected": config.VersionPattern.String(),
+		})
+		return
+	}
+	versionCode, err := strconv.Atoi(versionCodeStr)
+	if err != nil || versionCode <= 0 {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version_code", gin.H{
+			"expected": "positive integer",
+		})
+		return
+	}
+	isMandatory, err := parseOptionalBool(meta["is_mandatory"])
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid is_mandatory", gin.H{
+			"expected": "true or false",
+		})
+		return
+	}
+	channel := strings.ToLower(strings.TrimSpace(meta["channel"]))
+	if channel == "" {
+		channel = defaultChannel
+	}
+	if !isReleaseChannel(channel) {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidChannel, "Invalid channel", gin.H{"expected": releaseChannels})
+		return
+	}
+	rollout, err := parseRolloutPercentage(meta["rollout_percentage"])
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid rollout_percentage", gin.H{
+			"expected": "integer between 0 and 100",
+		})
+		return
+	}
+	minOSVersion, err := parseMinOSVersion(meta["min_os_version"])
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid min_os_version", gin.H{
+			"expected": "dotted numeric version, e.g. 8 or 14.2",
+		})
+		return
+	}
+	publishAt, err := parsePublishAt(meta["publish_at"])
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, "Invalid publish_at", gin.H{
+			"expected": "RFC 3339 time, e.g. 2025-06-01T09:00:00Z",
+		})
+		return
+	}
+
+	metaValues := map[string][]string{}
+	for key, value := range meta {
+		metaValues[key] = []string{value}
+	}
+	localizedNotes, err := parseLocalizedNotes(metaValues)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, codeInvalidRequest, err.Error(), gin.H{
+			"expected": "release_notes_<locale> metadata such as release_notes_es or release_notes_pt_BR",
+		})
+		return
+	}
+
+	artifact := &UploadArtifact{
+		Platform:    platform,
+		Version:     version,
+		VersionCode: versionCode,
+		File:        &multipart.FileHeader{Filename: filename, Size: length},
+	}
+	err = validatorFor(platform).Validate(artifact)
+	if err == nil {
+		err = checkFilenameConvention(artifact)
+	}
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			respondErrorDetails(c, http.StatusBadRequest, codeInvalidFile, verr.Message, gin.H{"expected": verr.Expected})
+			return
+		}
+		loggerFrom(ctx).Error("upload validation failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to validate upload")
+		return
+	}
+
+	exists, err := s.versionCodeExists(ctx, versionCode)
+	if err != nil {
+		loggerFrom(ctx).Error("version lookup failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Could not check for existing versions")
+		return
+	}
+	if exists {
+		respondError(c, http.StatusConflict, codeVersionExists, fmt.Sprintf("Version code %d already exists", versionCode))
+		return
+	}
+
+	hashState, err := marshalHash(sha256.New())
+	if err != nil {
+		loggerFrom(ctx).Error("hash state error", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to create upload")
+		return
+	}
+
+	now := time.Now()
+	session := UploadSession{
+		ID:             newPushID(now),
+		Platform:       platform,
+		Version:        version,
+		VersionCode:    versionCode,
+		ReleaseNotes:   strings.TrimSpace(meta["release_notes"]),
+		IsMandatory:    isMandatory,
+		Channel:        channel,
+		Rollout:        rollout,
+		MinOSVersion:   minOSVersion,
+		PublishAt:      publishAt,
+		LocalizedNotes: localizedNotes,
+		Filename:       filename,
+		Length:         length,
+		HashState:      hashState,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(config.UploadSessionTTL),
+	}
+	if err := s.store.CreateUploadSession(ctx, session); err != nil {
+		loggerFrom(ctx).Error("upload session save failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to create upload")
+		return
+	}
+
+	// Opportunistically clean up sessions that were abandoned
+	s.sweepExpiredUploads(ctx)
+
+	// Relative to the route used, /uploads or its /upload/sessions alias
+	location := c.FullPath() + "/" + session.ID
+	if s.appID != "" {
+		location += "?app_id=" + url.QueryEscape(s.appID)
+	}
+	c.Header("Location", location)
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// headUploadSession reports how many bytes of an upload the server has
+func (s *Server) headUploadSession(c *gin.Context) {
+	if !requireTusVersion(c) {
+		return
+	}
+	session, ok := s.loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// patchUploadSession appends the request body at Upload-Offset and finalizes
+// the version once all bytes have arrived
+func (s *Server) patchUploadSession(c *gin.Context) {
+	ctx := requestContext(c)
+	if !requireTusVersion(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		respondError(c, http.StatusUnsupportedMediaType, codeUnsupported, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	session, ok := s.loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid Upload-Offset")
+		return
+	}
+	if offset != session.Offset {
+		c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		respondError(c, http.StatusConflict, codeUploadConflict, "Upload-Offset does not match the current offset")
+		return
+	}
+
+	hash := sha256.New()
+	if err := unmarshalHash(hash, session.HashState); err != nil {
+		loggerFrom(ctx).Error("hash state error", "upload_id", session.ID, "err", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Corrupt upload session")
+		return
+	}
+
+	// Stream this request into its own staging object. A failed write, a
+	// body past Upload-Length or a dropped connection leaves no object behind.
+	chunkName := fmt.Sprintf("%suploads/%s/%020d-%s", s.prefix, session.ID, offset, newPushID(time.Now()))
+	body := &chunkReader{r: io.TeeReader(c.Request.Body, hash), remaining: session.Length - session.Offset}
+	err = s.store.UploadObject(c.Request.Context(), chunkName, body)
+	if errors.Is(err, errChunkTooLong) {
+		respondError(c, http.StatusRequestEntityTooLarge, codeTooLarge, "Upload exceeds Upload-Length")
+		return
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("upload chunk failed", "upload_id", session.ID, "bytes", body.n, "err", err)
+		c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to store upload data")
+		return
+	}
+	n := body.n
+
+	hashState, err := marshalHash(hash)
+	if err != nil {
+		loggerFrom(ctx).Error("hash state error", "upload_id", session.ID, "err", err)
+		respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to store upload data")
+		return
+	}
+
+	// Advance the offset only if no concurrent PATCH got there first
+	updated, err := s.store.UpdateUploadSession(ctx, session.ID, func(current *UploadSession) error {
+		if current.ID == "" || current.Offset != offset {
+			return errOffsetConflict
+		}
+		current.Offset += n
+		current.Chunks = append(current.Chunks, chunkName)
+		current.HashState = hashState
+		return nil
+	})
+	if err != nil {
+		if delErr := s.store.DeleteObject(ctx, chunkName); delErr != nil {
+			loggerFrom(ctx).Error("cleaning up upload chunk failed", "chunk", chunkName, "err", delErr)
+		}
+		if errors.Is(err, errOffsetConflict) {
+			respondError(c, http.StatusConflict, codeUploadConflict, "Upload-Offset does not match the current offset")
+			return
+		}
+		loggerFrom(ctx).Error("upload session update failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to store upload data")
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(updated.Offset, 10))
+	c.Header("Upload-Expires", updated.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	if updated.Offset < updated.Length {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	appVersion, duplicate, status, apiErr := s.finalizeUploadSession(ctx, &updated, hash.Sum(nil))
+	if appVersion == nil {
+		respondErrorDetails(c, status, apiErr.Code, apiErr.Message, apiErr.Details)
+		return
+	}
+	if duplicate {
+		c.Header("X-Version-ID", appVersion.ID)
+		c.Header("X-Duplicate", "true")
+		c.Status(http.StatusNoContent)
+		return
+	}
+	s.audit(c, auditUpload, appVersion, map[string]string{"upload_id": updated.ID})
+	webhook.notify(ctx, ReleaseEvent{Type: EventVersionPublished, AppID: s.appID, Version: *appVersion, Actor: actorFrom(c)})
+	c.Header("X-Version-ID", appVersion.ID)
+	c.Status(http.StatusNoContent)
+}
+
+var errOffsetConflict = errors.New("upload offset conflict")
+
+// errChunkTooLong is returned by chunkReader once a PATCH body goes past the
+// bytes the upload still expects
+var errChunkTooLong = errors.New("upload exceeds Upload-Length")
+
+// chunkReader counts a PATCH body as it is stored, failing the write with
+// errChunkTooLong as soon as it exceeds remaining
+type chunkReader struct {
+	r         io.Reader
+	remaining int64
+	n         int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remaining-r.n+1 {
+		p = p[:r.remaining-r.n+1]
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n > r.remaining {
+		return n, errChunkTooLong
+	}
+	return n, err
+}
+
+// finalizeUploadSession composes the staged chunks into the release object and
+// publishes the version. A file identical to an existing version of the
+// platform publishes nothing and returns that version and true, as a regular
+// upload does. On failure it returns a nil version with the status
+// and message to respond with. The session is removed either way, because a
+// completed upload cannot be resumed.
+func (s *Server) finalizeUploadSession(ctx context.Context, session *UploadSession, sum []byte) (*AppVersion, bool, int, APIError) {
+	defer s.discardUploadSession(ctx, session.ID)
+	checksum := fmt.Sprintf("%x", sum)
+
+	existing, err := s.findVersionByChecksum(ctx, session.Platform, checksum)
+	if err != nil {
+		loggerFrom(ctx).Error("version lookup failed", "err", err)
+		return nil, false, http.StatusInternalServerError, APIError{Code: codeDatabaseError, Message: "Could not check for existing versions"}
+	}
+	if existing != nil {
+		loggerFrom(ctx).Info("resumable upload is a duplicate", "upload_id", session.ID, "version_id", existing.ID)
+		return existing, true, http.StatusOK, APIError{}
+	}
+
+	id := newPushID(time.Now())
+	claimed, err := s.claimVersionCode(ctx, session.VersionCode, id)
+	if err != nil {
+		loggerFrom(ctx).Error("version lookup failed", "err", err)
+		return nil, false, http.StatusInternalServerError, APIError{Code: codeDatabaseError, Message: "Could not check for existing versions"}
+	}
+	if !claimed {
+		return nil, false, http.StatusConflict, APIError{Code: codeVersionExists, Message: fmt.Sprintf("Version code %d already exists", session.VersionCode)}
+	}
+	pending := &pendingUpload{s: s, code: session.VersionCode, id: id}
+	defer pending.rollback(ctx)
+
+	ext := strings.ToLower(filepath.Ext(session.Filename))
+	storagePath, ok := s.storagePathFor(session.Platform, session.Version, session.VersionCode, "", ext)
+	if !ok {
+		return nil, false, http.StatusBadRequest, APIError{Code: codeInvalidRequest, Message: "Version gives an unsafe storage path",
+			Details: gin.H{"storage_path": storagePath}}
+	}
+	taken, err := s.storagePathTaken(ctx, storagePath)
+	if err != nil {
+		loggerFrom(ctx).Error("storage path check failed", "storage_path", storagePath, "err", err)
+		return nil, false, http.StatusInternalServerError, APIError{Code: codeStorageError, Message: "Could not check the storage path"}
+	}
+	if taken {
+		return nil, false, http.StatusConflict, APIError{Code: codePathConflict, Message: "Storage path already in use",
+			Details: gin.H{"storage_path": storagePath}}
+	}
+	pending.objectPaths = append(pending.objectPaths, storagePath)
+	if err := s.store.ComposeObjects(ctx, storagePath, session.Chunks); err != nil {
+		loggerFrom(ctx).Error("upload compose failed", "upload_id", session.ID, "err", err)
+		return nil, false, http.StatusInternalServerError, APIError{Code: codeStorageError, Message: "Failed to complete upload"}
+	}
+
+	// The bytes weren't available when the upload was created, so the
+	// content checks of a regular upload happen here, as does the hashing
+	// for peer-assisted distribution
+	torrent := newDistributionHasher()
+	artifact, err := s.inspectUpload(ctx, session, storagePath, checksum, torrent)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			return nil, false, http.StatusBadRequest, APIError{Code: codeInvalidFile, Message: verr.Message, Details: gin.H{"expected": verr.Expected}}
+		}
+		loggerFrom(ctx).Error("upload read failed", "upload_id", session.ID, "err", err)
+		return nil, false, http.StatusInternalServerError, APIError{Code: codeStorageError, Message: "Failed to complete upload"}
+	}
+
+	now := time.Now()
+	appVersion := AppVersion{
+		ID:                    id,
+		Version:               session.Version,
+		VersionCode:           session.VersionCode,
+		DownloadURL:           s.downloadURL(session.Version, session.Platform),
+		ReleaseNotes:          session.ReleaseNotes,
+		FileSize:              session.Length,
+		Checksum:              checksum,
+		CreatedAt:             now,
+		UpdatedAt:             now,
+		StoragePath:           storagePath,
+		Platform:              session.Platform,
+		IsMandatory:           session.IsMandatory,
+		Channel:               session.Channel,
+		RolloutPercentage:     session.Rollout,
+		MinOSVersion:          session.MinOSVersion,
+		PackageName:           artifact.PackageName,
+		BundleID:              artifact.BundleID,
+		LocalizedReleaseNotes: session.LocalizedNotes,
+		PublishAt:             session.PublishAt,
+		DistributionLinks:     torrent.distributionLinks(session.Version, ext),
+	}
+	appVersion.Artifacts = []Artifact{{
+		StoragePath: appVersion.StoragePath,
+		FileSize:    appVersion.FileSize,
+		Checksum:    appVersion.Checksum,
+	}}
+	if err := s.publishVersion(ctx, session.Platform, appVersion); err != nil {
+		loggerFrom(ctx).Error("version save failed", "err", err)
+		return nil, false, http.StatusInternalServerError, APIError{Code: codeDatabaseError, Message: "Failed to save version information"}
+	}
+	pending.commit()
+	return &appVersion, false, http.StatusOK, APIError{}
+}
+
+// inspectUpload copies the composed object of a finished upload to a
+// temporary file and runs the platform's validator over it, as a regular
+// upload does before storing anything. The returned artifact carries what the
+// validator read from the file, e.g. the APK package name. A non-nil torrent
+// hashes the bytes on the way.
+func (s *Server) inspectUpload(ctx context.Context, session *UploadSession, path, checksum string, torrent *torrentHasher) (*UploadArtifact, error) {
+	r, err := s.store.OpenObject(ctx, path, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	src := io.Reader(r)
+	if torrent != nil {
+		src = io.TeeReader(r, torrent)
+	}
+	f, err := spoolVerified(src, checksum)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	artifact := &UploadArtifact{
+		Platform:    session.Platform,
+		Version:     session.Version,
+		VersionCode: session.VersionCode,
+		File:        &multipart.FileHeader{Filename: session.Filename, Size: session.Length},
+		Content:     f,
+	}
+	if err := validatorFor(session.Platform).Validate(artifact); err != nil {
+		return nil, err
+	}
+	// Platforms without a validator of their own still get the signature check
+	if spec, _ := platformSpec(session.Platform); spec.Zip {
+		if err := checkZipSignature(artifact); err != nil {
+			return nil, err
+		}
+	}
+	return artifact, nil
+}
+
+// loadUploadSession reads the session named in the URL, writing the error
+// response and returning false if it is missing or expired
+func (s *Server) loadUploadSession(c *gin.Context) (*UploadSession, bool) {
+	ctx := requestContext(c)
+	id := c.Param("id")
+	if !isValidKey(id) {
+		respondError(c, http.StatusNotFound, codeNotFound, "Upload not found")
+		return nil, false
+	}
+	session, err := s.store.GetUploadSession(ctx, id)
+	if err != nil {
+		loggerFrom(ctx).Error("upload session read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return nil, false
+	}
+	if session == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, "Upload not found")
+		return nil, false
+	}
+	// Sessions are the uploader's, so another platform's key can neither
+	// inspect nor finish one
+	if !authorizePlatform(c, session.Platform) {
+		return nil, false
+	}
+	if session.expired() {
+		s.discardUploadSession(ctx, session.ID)
+		respondError(c, http.StatusGone, codeUploadExpired, "Upload expired")
+		return nil, false
+	}
+	return session, true
+}
+
+// discardUploadSession deletes a session's staging objects and its record
+func (s *Server) discardUploadSession(ctx context.Context, id string) {
+	chunks, err := s.store.ListObjects(ctx, s.prefix+"uploads/"+id+"/")
+	if err != nil {
+		loggerFrom(ctx).Warn("listing upload staging objects failed", "upload_id", id, "err", err)
+	}
+	for _, chunk := range chunks {
+		if err := s.store.DeleteObject(ctx, chunk.Path); err != nil && !errors.Is(err, errObjectNotFound) {
+			loggerFrom(ctx).Warn("deleting upload staging object failed", "object", chunk.Path, "err", err)
+		}
+	}
+	if err := s.store.DeleteUploadSession(ctx, id); err != nil {
+		loggerFrom(ctx).Warn("deleting upload session failed", "upload_id", id, "err", err)
+	}
+}
+
+// sweepExpiredUploads removes abandoned upload sessions and their data
+func (s *Server) sweepExpiredUploads(ctx context.Context) {
+	sessions, err := s.store.ListUploadSessions(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("reading upload sessions failed", "err", err)
+		return
+	}
+	for id, session := range sessions {
+		if session.expired() {
+			loggerFrom(ctx).Info("discarding expired upload", "upload_id", id, "offset", session.Offset, "length", session.Length)
+			s.discardUploadSession(ctx, id)
+		}
+	}
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header, a comma
+// separated list of "key base64(value)" pairs where the value may be omitted
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			meta[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("metadata %q: %w", parts[0], err)
+			}
+			meta[parts[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("malformed metadata pair %q", pair)
+		}
+	}
+	return meta, nil
+}
+
+func marshalHash(h io.Writer) (string, error) {
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return "", errors.New("hash state cannot be marshalled")
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(state), nil
+}
+
+func unmarshalHash(h io.Writer, state string) error {
+	u, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errors.New("hash state cannot be unmarshalled")
+	}
+	raw, err := base64.StdEncoding.DecodeString(state)
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalBinary(raw)
+}
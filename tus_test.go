@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tusUpload creates a resumable upload with metadata and sends content in a
+// single PATCH, returning the creation response if it failed and the PATCH
+// response otherwise
+func (ts *testServer) tusUpload(meta map[string]string, content []byte) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	w := ts.tusCreate(meta, len(content))
+	if w.Code != http.StatusCreated {
+		return w
+	}
+	return ts.tusPatch(w.Header().Get("Location"), 0, content)
+}
+
+// tusCreate starts a resumable upload of length bytes described by meta
+func (ts *testServer) tusCreate(meta map[string]string, length int) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var pairs []string
+	for key, value := range meta {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ota/uploads", nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", strings.Join(pairs, ","))
+	return ts.send(req, testAPIKey)
+}
+
+// tusPatch appends chunk to the upload at location, claiming offset
+func (ts *testServer) tusPatch(location string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	req := httptest.NewRequest(http.MethodPatch, location, strings.NewReader(string(chunk)))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	return ts.send(req, testAPIKey)
+}
+
+func TestResumableUploadInspection(t *testing.T) {
+	tests := []struct {
+		name        string
+		filename    string
+		content     []byte
+		wantStatus  int
+		wantPackage string
+	}{
+		{name: "apk manifest read", filename: "app.apk", content: buildAPK(t, "com.example.app", 7), wantStatus: http.StatusNoContent, wantPackage: "com.example.app"},
+		{name: "apk versionCode mismatch", filename: "app.apk", content: buildAPK(t, "com.example.app", 8), wantStatus: http.StatusBadRequest},
+		{name: "apk without manifest", filename: "app.apk", content: buildZip(t, map[string][]byte{"classes.dex": {}}), wantStatus: http.StatusBadRequest},
+		{name: "not a zip", filename: "app.apk", content: []byte("not a zip archive"), wantStatus: http.StatusBadRequest},
+		{name: "bundle not inspected", filename: "app.aab", content: []byte("PK\x03\x04bundle"), wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.tusUpload(map[string]string{"filename": tt.filename, "version": "1.0.7", "version_code": "7", "platform": "android"}, tt.content)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus != http.StatusNoContent {
+				var body APIError
+				decodeJSON(t, w, &body)
+				if body.Code != codeInvalidFile || body.Details == nil {
+					t.Errorf("error = %+v, want %q with details", body, codeInvalidFile)
+				}
+				if len(versions) != 0 {
+					t.Errorf("rejected upload published %d versions", len(versions))
+				}
+				return
+			}
+			v, ok := versions[w.Header().Get("X-Version-ID")]
+			if len(versions) != 1 || !ok {
+				t.Fatalf("versions = %+v, X-Version-ID %q", versions, w.Header().Get("X-Version-ID"))
+			}
+			if v.PackageName != tt.wantPackage {
+				t.Errorf("package_name = %q, want %q", v.PackageName, tt.wantPackage)
+			}
+		})
+	}
+}
+
+func TestResumableUploadDuplicate(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		wantDuplicate bool
+		wantVersions  int
+	}{
+		{name: "same file", content: "PK\x03\x04android-1", wantDuplicate: true, wantVersions: 1},
+		{name: "different file", content: "PK\x03\x04android-5", wantVersions: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.seed(AppVersion{VersionCode: 1})
+			w := ts.tusUpload(map[string]string{"filename": "app.aab", "version": "1.0.5", "version_code": "5", "platform": "android"}, []byte(tt.content))
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("status = %d (%s)", w.Code, w.Body)
+			}
+			if got := w.Header().Get("X-Duplicate") == "true"; got != tt.wantDuplicate {
+				t.Errorf("X-Duplicate = %q, want duplicate %t", w.Header().Get("X-Duplicate"), tt.wantDuplicate)
+			}
+			if tt.wantDuplicate && w.Header().Get("X-Version-ID") != "android-1" {
+				t.Errorf("X-Version-ID = %q, want android-1", w.Header().Get("X-Version-ID"))
+			}
+
+			ctx := context.Background()
+			versions, err := ts.store.ListVersions(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != tt.wantVersions {
+				t.Errorf("%d versions, want %d", len(versions), tt.wantVersions)
+			}
+			entries, err := ts.store.ListAudit(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantEntries := tt.wantVersions - 1; len(entries) != wantEntries {
+				t.Errorf("%d audit entries, want %d", len(entries), wantEntries)
+			}
+		})
+	}
+}
+
+func TestResumableUploadProtocol(t *testing.T) {
+	content := []byte("PK\x03\x04resumable")
+	type step struct {
+		method      string // PATCH or HEAD
+		offset      int
+		chunk       string
+		contentType string // PATCH only; default application/offset+octet-stream
+		noTus       bool   // leave out Tus-Resumable
+		wantStatus  int
+		wantOffset  string
+	}
+	tests := []struct {
+		name        string
+		steps       []step
+		wantVersion bool
+	}{
+		{name: "two chunks", wantVersion: true, steps: []step{
+			{method: http.MethodPatch, offset: 0, chunk: string(content[:7]), wantStatus: http.StatusNoContent, wantOffset: "7"},
+			{method: http.MethodHead, wantStatus: http.StatusOK, wantOffset: "7"},
+			{method: http.MethodPatch, offset: 7, chunk: string(content[7:]), wantStatus: http.StatusNoContent, wantOffset: "13"},
+		}},
+		{name: "offset behind", steps: []step{
+			{method: http.MethodPatch, offset: 0, chunk: string(content[:7]), wantStatus: http.StatusNoContent, wantOffset: "7"},
+			{method: http.MethodPatch, offset: 0, chunk: string(content[:7]), wantStatus: http.StatusConflict, wantOffset: "7"},
+		}},
+		{name: "offset ahead", steps: []step{
+			{method: http.MethodPatch, offset: 3, chunk: string(content[3:]), wantStatus: http.StatusConflict, wantOffset: "0"},
+		}},
+		{name: "past Upload-Length", steps: []step{
+			{method: http.MethodPatch, offset: 0, chunk: string(content) + "extra", wantStatus: http.StatusRequestEntityTooLarge},
+			{method: http.MethodHead, wantStatus: http.StatusOK, wantOffset: "0"},
+		}},
+		{name: "wrong content type", steps: []step{
+			{method: http.MethodPatch, offset: 0, chunk: string(content), contentType: "application/octet-stream", wantStatus: http.StatusUnsupportedMediaType},
+		}},
+		{name: "missing Tus-Resumable", steps: []step{
+			{method: http.MethodHead, noTus: true, wantStatus: http.StatusPreconditionFailed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.tusCreate(map[string]string{"filename": "app.aab", "version": "1.0.1", "version_code": "1", "platform": "android"}, len(content))
+			if w.Code != http.StatusCreated {
+				t.Fatalf("create: %d %s", w.Code, w.Body)
+			}
+			location := w.Header().Get("Location")
+
+			for i, st := range tt.steps {
+				req := httptest.NewRequest(st.method, location, strings.NewReader(st.chunk))
+				if !st.noTus {
+					req.Header.Set("Tus-Resumable", "1.0.0")
+				}
+				if st.method == http.MethodPatch {
+					contentType := st.contentType
+					if contentType == "" {
+						contentType = "application/offset+octet-stream"
+					}
+					req.Header.Set("Content-Type", contentType)
+					req.Header.Set("Upload-Offset", strconv.Itoa(st.offset))
+				}
+				w := ts.send(req, testAPIKey)
+				if w.Code != st.wantStatus {
+					t.Fatalf("step %d: status = %d, want %d (%s)", i, w.Code, st.wantStatus, w.Body)
+				}
+				if got := w.Header().Get("Upload-Offset"); st.wantOffset != "" && got != st.wantOffset {
+					t.Errorf("step %d: Upload-Offset = %q, want %q", i, got, st.wantOffset)
+				}
+			}
+
+			versions, err := ts.store.ListVersions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantVersion {
+				if len(versions) != 0 {
+					t.Errorf("incomplete upload published %d versions", len(versions))
+				}
+				return
+			}
+			sum := sha256.Sum256(content)
+			if len(versions) != 1 {
+				t.Fatalf("versions = %+v", versions)
+			}
+			var v AppVersion
+			for _, stored := range versions {
+				v = stored
+			}
+			if v.VersionCode != 1 {
+				t.Errorf("version_code = %d, want 1", v.VersionCode)
+			}
+			if v.Checksum != hex.EncodeToString(sum[:]) || v.FileSize != int64(len(content)) {
+				t.Errorf("checksum = %s, file_size = %d", v.Checksum, v.FileSize)
+			}
+			rc, err := ts.store.OpenObject(context.Background(), v.StoragePath, 0, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if stored, _ := io.ReadAll(rc); string(stored) != string(content) {
+				t.Errorf("stored file = %q, want %q", stored, content)
+			}
+		})
+	}
+}
+
+func TestResumableUploadExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	meta := map[string]string{"filename": "app.aab", "version": "1.0.1", "version_code": "1", "platform": "android"}
+	w := ts.tusCreate(meta, 13)
+	location := w.Header().Get("Location")
+	id := location[strings.LastIndex(location, "/")+1:]
+	if w := ts.tusPatch(location, 0, []byte("PK\x03\x04")); w.Code != http.StatusNoContent {
+		t.Fatalf("patch: %d %s", w.Code, w.Body)
+	}
+
+	// Abandon the upload
+	if _, err := ts.store.UpdateUploadSession(ctx, id, func(s *UploadSession) error {
+		s.ExpiresAt = time.Now().Add(-time.Minute)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if w := ts.tusPatch(location, 4, []byte("resumable")); w.Code != http.StatusGone {
+		t.Fatalf("patch after expiry: %d, want 410 (%s)", w.Code, w.Body)
+	}
+
+	// The next upload sweeps it away with its data
+	meta["version_code"] = "2"
+	if w := ts.tusCreate(meta, 13); w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if session, err := ts.store.GetUploadSession(ctx, id); err != nil || session != nil {
+		t.Errorf("expired session = %+v, %v", session, err)
+	}
+	if chunks, err := ts.store.ListObjects(ctx, "uploads/"+id+"/"); err != nil || len(chunks) != 0 {
+		t.Errorf("expired chunks = %v, %v", chunks, err)
+	}
+}
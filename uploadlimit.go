@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is the slack allowed on top of MAX_UPLOAD_BYTES for the
+// other form fields and multipart boundaries of a regular upload
+const multipartOverhead = 1 << 20
+
+// maxUploadBytes returns the configured artifact size limit, or 0 for none
+func maxUploadBytes() int64 {
+	return config.MaxUploadBytes
+}
+
+// limitUploadBody caps the request body of a multipart upload and parses the
+// form. It writes 413 and returns false when the body is over the limit, so
+// nothing is read past it and nothing reaches storage.
+func limitUploadBody(c *gin.Context) bool {
+	limit := maxUploadBytes()
+	if limit <= 0 {
+		return true
+	}
+	if c.Request.ContentLength > limit+multipartOverhead {
+		respondTooLarge(c, limit)
+		return false
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+
+	// Other parse errors surface later as missing fields or file
+	if _, err := c.MultipartForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondTooLarge(c, limit)
+			return false
+		}
+	}
+	return true
+}
+
+func respondTooLarge(c *gin.Context, limit int64) {
+	respondErrorDetails(c, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d bytes", limit), gin.H{
+		"max_bytes": limit,
+	})
+}
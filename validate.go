@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UploadArtifact is an uploaded file together with the metadata submitted alongside it
+type UploadArtifact struct {
+	Platform    string
+	Version     string
+	VersionCode int
+	File        *multipart.FileHeader
+	// Content gives validators access to the file bytes; nil when only metadata is known yet
+	Content io.ReaderAt
+
+	// PackageName and BundleID are filled in by validators that can read them from the artifact
+	PackageName string
+	BundleID    string
+}
+
+// PlatformValidator checks an uploaded artifact before it is written to storage
+type PlatformValidator interface {
+	Validate(a *UploadArtifact) error
+}
+
+// ValidationError is returned by validators when an upload should be rejected with a 400
+type ValidationError struct {
+	Message  string
+	Expected string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+var platformValidators = map[string]PlatformValidator{
+	"android": apkValidator{},
+	"ios":     ipaValidator{},
+}
+
+// registerPlatformValidator installs the validator used for uploads to a platform
+func registerPlatformValidator(platform string, v PlatformValidator) {
+	platformValidators[platform] = v
+}
+
+// validatorFor returns the validator registered for a platform, or one that
+// only checks the registry's extensions when the platform has none
+func validatorFor(platform string) PlatformValidator {
+	if v, ok := platformValidators[platform]; ok {
+		return v
+	}
+	return extensionValidator{}
+}
+
+// extensionValidator accepts any artifact with one of its platform's
+// extensions, e.g. desktop installers, which are not inspected
+type extensionValidator struct{}
+
+func (extensionValidator) Validate(a *UploadArtifact) error {
+	spec, _ := platformSpec(a.Platform)
+	return checkExtension(a, spec.Extensions...)
+}
+
+// apkValidator validates Android uploads
+type apkValidator struct{}
+
+func (apkValidator) Validate(a *UploadArtifact) error {
+	if err := checkExtension(a, ".apk", ".aab"); err != nil {
+		return err
+	}
+	if err := checkZipSignature(a); err != nil {
+		return err
+	}
+	// App bundles store a protobuf manifest, so only APKs are inspected
+	if a.Content == nil || !strings.EqualFold(filepath.Ext(a.File.Filename), ".apk") {
+		return nil
+	}
+
+	m, err := readAPKManifest(a.Content, a.File.Size)
+	if err != nil {
+		return &ValidationError{Message: fmt.Sprintf("Could not read APK manifest: %v", err)}
+	}
+	if m.VersionCode != a.VersionCode {
+		return &ValidationError{
+			Message:  fmt.Sprintf("APK versionCode %d does not match submitted version_code", m.VersionCode),
+			Expected: strconv.Itoa(a.VersionCode),
+		}
+	}
+	a.PackageName = m.Package
+	return nil
+}
+
+// ipaValidator validates iOS uploads
+type ipaValidator struct{}
+
+func (ipaValidator) Validate(a *UploadArtifact) error {
+	if err := checkExtension(a, ".ipa"); err != nil {
+		return err
+	}
+	if err := checkZipSignature(a); err != nil {
+		return err
+	}
+	if a.Content == nil {
+		return nil
+	}
+
+	info, err := readIPAInfo(a.Content, a.File.Size)
+	if err != nil {
+		return &ValidationError{Message: fmt.Sprintf("Could not read IPA Info.plist: %v", err)}
+	}
+	if info.ShortVersion != a.Version {
+		return &ValidationError{
+			Message:  fmt.Sprintf("IPA CFBundleShortVersionString %q does not match submitted version", info.ShortVersion),
+			Expected: a.Version,
+		}
+	}
+	if code, err := strconv.Atoi(info.BundleVersion); err != nil || code != a.VersionCode {
+		return &ValidationError{
+			Message:  fmt.Sprintf("IPA CFBundleVersion %q does not match submitted version_code", info.BundleVersion),
+			Expected: strconv.Itoa(a.VersionCode),
+		}
+	}
+	a.BundleID = info.BundleID
+	return nil
+}
+
+func checkExtension(a *UploadArtifact, allowed ...string) error {
+	ext := strings.ToLower(filepath.Ext(a.File.Filename))
+	for _, want := range allowed {
+		if ext == want {
+			return nil
+		}
+	}
+	return &ValidationError{
+		Message:  fmt.Sprintf("Invalid file extension for %s platform", a.Platform),
+		Expected: strings.Join(allowed, " or "),
+	}
+}
+
+// zipSignature starts every APK, AAB and IPA, which are all ZIP archives
+var zipSignature = []byte("PK\x03\x04")
+
+// checkZipSignature rejects content that is not a ZIP archive whatever its
+// extension says, e.g. a renamed text file
+func checkZipSignature(a *UploadArtifact) error {
+	if a.Content == nil {
+		return nil
+	}
+	head := make([]byte, len(zipSignature))
+	if _, err := a.Content.ReadAt(head, 0); err != nil || !hasZipSignature(head) {
+		return &ValidationError{
+			Message:  fmt.Sprintf("File is not a valid %s artifact", a.Platform),
+			Expected: "ZIP archive starting with PK\\x03\\x04",
+		}
+	}
+	return nil
+}
+
+func hasZipSignature(head []byte) bool {
+	return bytes.HasPrefix(head, zipSignature)
+}
+
+// checkFilenameConvention rejects uploads whose filename does not encode the
+// submitted version, catching pipelines that attach the wrong artifact. Named
+// groups "version" and "code" of UPLOAD_FILENAME_PATTERN are compared with
+// the submitted form fields.
+func checkFilenameConvention(a *UploadArtifact) error {
+	filenamePattern := config.FilenamePattern
+	if filenamePattern == nil {
+		return nil
+	}
+
+	name := filepath.Base(a.File.Filename)
+	match := filenamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return &ValidationError{
+			Message:  fmt.Sprintf("Filename %q does not match the required naming convention", name),
+			Expected: filenamePattern.String(),
+		}
+	}
+
+	for i, group := range filenamePattern.SubexpNames() {
+		switch group {
+		case "version":
+			if match[i] != a.Version {
+				return &ValidationError{
+					Message:  fmt.Sprintf("Filename version %q does not match submitted version", match[i]),
+					Expected: a.Version,
+				}
+			}
+		case "code":
+			if code, err := strconv.Atoi(match[i]); err != nil || code != a.VersionCode {
+				return &ValidationError{
+					Message:  fmt.Sprintf("Filename version code %q does not match submitted version_code", match[i]),
+					Expected: strconv.Itoa(a.VersionCode),
+				}
+			}
+		}
+	}
+	return nil
+}
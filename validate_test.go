@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name        string
+		platform    string
+		filename    string
+		content     []byte
+		version     string
+		code        int
+		wantErr     bool
+		wantPackage string
+		wantBundle  string
+	}{
+		{name: "apk", platform: "android", filename: "app.apk", content: buildAPK(t, "com.example.app", 3), code: 3, wantPackage: "com.example.app"},
+		{name: "apk code mismatch", platform: "android", filename: "app.apk", content: buildAPK(t, "com.example.app", 4), code: 3, wantErr: true},
+		{name: "aab not inspected", platform: "android", filename: "app.aab", content: []byte("PK\x03\x04"), code: 3},
+		{name: "android renamed text", platform: "android", filename: "app.apk", content: []byte("hello"), code: 3, wantErr: true},
+		{name: "android wrong extension", platform: "android", filename: "app.ipa", content: []byte("PK\x03\x04"), code: 3, wantErr: true},
+		{name: "ipa", platform: "ios", filename: "app.ipa", content: buildIPA(t, "com.example.app", "1.0.3", 3), version: "1.0.3", code: 3, wantBundle: "com.example.app"},
+		{name: "ipa version mismatch", platform: "ios", filename: "app.ipa", content: buildIPA(t, "com.example.app", "1.0.2", 3), version: "1.0.3", code: 3, wantErr: true},
+		{name: "ios wrong extension", platform: "ios", filename: "app.apk", content: buildAPK(t, "com.example.app", 3), code: 3, wantErr: true},
+		{name: "desktop extension", platform: "windows", filename: "setup.msi", content: []byte("anything"), code: 3},
+		{name: "desktop wrong extension", platform: "windows", filename: "setup.dmg", content: []byte("anything"), code: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &UploadArtifact{
+				Platform:    tt.platform,
+				Version:     tt.version,
+				VersionCode: tt.code,
+				File:        &multipart.FileHeader{Filename: tt.filename, Size: int64(len(tt.content))},
+				Content:     bytes.NewReader(tt.content),
+			}
+			err := validatorFor(tt.platform).Validate(a)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			var verr *ValidationError
+			if err != nil && !errors.As(err, &verr) {
+				t.Errorf("err = %T, want *ValidationError", err)
+			}
+			if a.PackageName != tt.wantPackage || a.BundleID != tt.wantBundle {
+				t.Errorf("package_name = %q, bundle_id = %q", a.PackageName, a.BundleID)
+			}
+		})
+	}
+}
+
+// rejectingValidator refuses every upload, to observe dispatch
+type rejectingValidator struct{}
+
+func (rejectingValidator) Validate(a *UploadArtifact) error {
+	return &ValidationError{Message: "rejected by test validator", Expected: "nothing"}
+}
+
+func TestValidatorDispatch(t *testing.T) {
+	previous, registered := platformValidators["windows"]
+	registerPlatformValidator("windows", rejectingValidator{})
+	t.Cleanup(func() {
+		if registered {
+			platformValidators["windows"] = previous
+		} else {
+			delete(platformValidators, "windows")
+		}
+	})
+
+	tests := []struct {
+		platform   string
+		filename   string
+		content    []byte
+		wantStatus int
+	}{
+		{"windows", "setup.msi", []byte("msi"), http.StatusBadRequest},
+		{"linux", "app.deb", []byte("deb"), http.StatusOK},
+		{"android", "app.aab", []byte("PK\x03\x04aab"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			ts := newTestServer(t)
+			fields := map[string]string{"version": "1.0.0", "version_code": "1", "platform": tt.platform}
+			w := ts.upload("/api/v1/ota/upload", fields, tt.filename, tt.content, testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				var body APIError
+				decodeJSON(t, w, &body)
+				if body.Code != codeInvalidFile || body.Message != "rejected by test validator" {
+					t.Errorf("error = %+v", body)
+				}
+			}
+		})
+	}
+}
+
+func TestFilenameConvention(t *testing.T) {
+	const byCode = `UPLOAD_FILENAME_PATTERN=^app-(?P<code>\d+)\.(aab|apk)$`
+	const byBoth = `UPLOAD_FILENAME_PATTERN=^app-(?P<version>[\d.]+)-(?P<code>\d+)\.aab$`
+	tests := []struct {
+		name         string
+		env          []string
+		filename     string
+		resumable    bool
+		wantStatus   int
+		wantExpected string
+	}{
+		{name: "off by default", filename: "whatever.aab", wantStatus: http.StatusOK},
+		{name: "code matches", env: []string{byCode}, filename: "app-140.aab", wantStatus: http.StatusOK},
+		{name: "code mismatch", env: []string{byCode}, filename: "app-139.aab", wantStatus: http.StatusBadRequest, wantExpected: "140"},
+		{name: "leading zeros", env: []string{byCode}, filename: "app-0140.aab", wantStatus: http.StatusOK},
+		{name: "convention not followed", env: []string{byCode}, filename: "release.aab", wantStatus: http.StatusBadRequest, wantExpected: `^app-(?P<code>\d+)\.(aab|apk)$`},
+		{name: "version and code match", env: []string{byBoth}, filename: "app-1.4.0-140.aab", wantStatus: http.StatusOK},
+		{name: "version mismatch", env: []string{byBoth}, filename: "app-1.3.0-140.aab", wantStatus: http.StatusBadRequest, wantExpected: "1.4.0"},
+		{name: "resumable code matches", env: []string{byCode}, filename: "app-140.aab", resumable: true, wantStatus: http.StatusNoContent},
+		{name: "resumable code mismatch", env: []string{byCode}, filename: "app-139.aab", resumable: true, wantStatus: http.StatusBadRequest, wantExpected: "140"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.env...)
+			fields := map[string]string{"version": "1.4.0", "version_code": "140", "platform": "android"}
+			content := []byte("PK\x03\x04aab")
+			var w *httptest.ResponseRecorder
+			if tt.resumable {
+				fields["filename"] = tt.filename
+				w = ts.tusUpload(fields, content)
+			} else {
+				w = ts.upload("/api/v1/ota/upload", fields, tt.filename, content, testAPIKey)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusBadRequest {
+				return
+			}
+			var body struct {
+				Code    string `json:"code"`
+				Details struct {
+					Expected string `json:"expected"`
+				} `json:"details"`
+			}
+			decodeJSON(t, w, &body)
+			if body.Code != codeInvalidFile || body.Details.Expected != tt.wantExpected {
+				t.Errorf("code = %q, expected = %q, want %q, %q", body.Code, body.Details.Expected, codeInvalidFile, tt.wantExpected)
+			}
+		})
+	}
+}
+
+func TestFilenamePatternConfig(t *testing.T) {
+	t.Setenv("OTA_STORE", "memory")
+	t.Setenv("OTA_API_KEYS", testAPIKey)
+	t.Setenv("UPLOAD_FILENAME_PATTERN", `app-(?P<code>\d+`)
+	if _, err := loadConfig(); err == nil {
+		t.Error("invalid UPLOAD_FILENAME_PATTERN loaded without error")
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Outcomes of verifying a stored version against its Storage object
+const (
+	verifyOK         = "ok"
+	verifyMismatch   = "mismatch"
+	verifyMissing    = "missing"
+	verifyNoChecksum = "no_checksum"
+	verifyError      = "error"
+	verifyPending    = "pending" // dry run: would be verified
+)
+
+// errChecksumMismatch is returned when artifact bytes don't hash to the recorded checksum
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// spoolVerified copies r into a temporary file and checks its SHA-256 against
+// checksum before any of it is passed on. The caller closes and removes the file.
+func spoolVerified(r io.Reader, checksum string) (*os.File, error) {
+	f, err := os.CreateTemp("", "ota-download-*")
+	if err != nil {
+		return nil, err
+	}
+	sums, _, err := hashStream(io.TeeReader(r, f), "sha256")
+	if err == nil && !strings.EqualFold(sums["sha256"], checksum) {
+		err = fmt.Errorf("%w: got %s", errChecksumMismatch, sums["sha256"])
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// VerifyResult is the integrity check of a single artifact of a version
+type VerifyResult struct {
+	ID               string `json:"id"`
+	Version          string `json:"version"`
+	VersionCode      int    `json:"version_code"`
+	ABI              string `json:"abi,omitempty"`
+	StoragePath      string `json:"storage_path"`
+	Status           string `json:"status"`
+	ExpectedChecksum string `json:"expected_checksum,omitempty"`
+	ActualChecksum   string `json:"actual_checksum,omitempty"`
+	ExpectedSize     int64  `json:"expected_size"`
+	ActualSize       int64  `json:"actual_size,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// VerifyReport summarizes an integrity audit across many versions
+type VerifyReport struct {
+	DryRun   bool           `json:"dry_run"`
+	Platform string         `json:"platform,omitempty"`
+	Checked  int            `json:"checked"`
+	Counts   map[string]int `json:"counts"`
+	// Issues lists every version that is not ok (or, for a dry run, every version in scope)
+	Issues []VerifyResult `json:"issues"`
+}
+
+// pendingResult describes an artifact before it is verified
+func pendingResult(v AppVersion, a Artifact) VerifyResult {
+	return VerifyResult{
+		ID:               v.ID,
+		Version:          v.Version,
+		VersionCode:      v.VersionCode,
+		ABI:              a.ABI,
+		StoragePath:      a.StoragePath,
+		Status:           verifyPending,
+		ExpectedChecksum: a.Checksum,
+		ExpectedSize:     a.FileSize,
+	}
+}
+
+// verifyArtifact streams one of a version's objects and compares its size
+// and SHA-256 with the stored record
+func verifyArtifact(ctx context.Context, store Store, v AppVersion, a Artifact) VerifyResult {
+	result := pendingResult(v, a)
+
+	reader, err := store.OpenObject(ctx, a.StoragePath, 0, -1)
+	if errors.Is(err, errObjectNotFound) {
+		result.Status = verifyMissing
+		return result
+	}
+	if err != nil {
+		result.Status = verifyError
+		result.Error = err.Error()
+		return result
+	}
+	defer reader.Close()
+
+	sums, n, err := hashStream(reader, "sha256")
+	if err != nil {
+		result.Status = verifyError
+		result.Error = err.Error()
+		return result
+	}
+	result.ActualChecksum = sums["sha256"]
+	result.ActualSize = n
+
+	switch {
+	case n != a.FileSize:
+		result.Status = verifyMismatch
+	case a.Checksum == "":
+		result.Status = verifyNoChecksum
+	case !strings.EqualFold(a.Checksum, result.ActualChecksum):
+		result.Status = verifyMismatch
+	default:
+		result.Status = verifyOK
+	}
+	return result
+}
+
+// verifyVersionByID re-hashes a single version's objects; ok is true only
+// when size and SHA-256 of every artifact match the record
+func (s *Server) verifyVersionByID(c *gin.Context) {
+	ctx := requestContext(c)
+	id := c.Param("id")
+	if !isValidKey(id) {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version id")
+		return
+	}
+
+	version, err := s.store.GetVersion(ctx, id)
+	if err != nil {
+		loggerFrom(ctx).Error("version read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	if version == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, fmt.Sprintf("Version %s not found", id))
+		return
+	}
+
+	ok := true
+	var results []VerifyResult
+	for _, a := range artifactsOf(*version) {
+		result := verifyArtifact(ctx, s.store, *version, a)
+		if result.Status != verifyOK {
+			ok = false
+			loggerFrom(ctx).Warn("integrity check failed",
+				"version_id", id, "storage_path", result.StoragePath, "status", result.Status)
+		}
+		results = append(results, result)
+	}
+	// expected, actual and result are the primary artifact's
+	c.JSON(http.StatusOK, gin.H{
+		"ok":        ok,
+		"expected":  results[0].ExpectedChecksum,
+		"actual":    results[0].ActualChecksum,
+		"result":    results[0],
+		"artifacts": results,
+	})
+}
+
+// verifyAll re-hashes every stored object (optionally for one platform) with
+// bounded concurrency and reports mismatched and missing artifacts
+func (s *Server) verifyAll(c *gin.Context) {
+	ctx := requestContext(c)
+	platform := c.Query("platform")
+	if platform != "" && !isSupportedPlatform(platform) {
+		respondError(c, http.StatusBadRequest, codeInvalidPlatform, "Invalid platform")
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	versions, err := s.store.ListVersions(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("version fetch failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to fetch versions")
+		return
+	}
+
+	var scope []AppVersion
+	for _, v := range versions {
+		if matchesPlatform(v, platform) {
+			scope = append(scope, v)
+		}
+	}
+	sort.Slice(scope, func(i, j int) bool { return scope[i].ID < scope[j].ID })
+
+	// Every artifact of a split build is checked on its own
+	type target struct {
+		v AppVersion
+		a Artifact
+	}
+	var targets []target
+	for _, v := range scope {
+		for _, a := range artifactsOf(v) {
+			targets = append(targets, target{v, a})
+		}
+	}
+
+	results := make([]VerifyResult, len(targets))
+	if dryRun {
+		for i, t := range targets {
+			results[i] = pendingResult(t.v, t.a)
+		}
+	} else {
+		concurrency := config.VerifyConcurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for i, t := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, t target) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[i] = verifyArtifact(c.Request.Context(), s.store, t.v, t.a)
+			}(i, t)
+		}
+		wg.Wait()
+	}
+
+	report := VerifyReport{
+		DryRun:   dryRun,
+		Platform: platform,
+		Checked:  len(results),
+		Counts:   map[string]int{},
+		Issues:   []VerifyResult{},
+	}
+	for _, r := range results {
+		report.Counts[r.Status]++
+		if r.Status != verifyOK {
+			report.Issues = append(report.Issues, r)
+		}
+	}
+	if !dryRun {
+		loggerFrom(ctx).Info("integrity audit",
+			"checked", report.Checked,
+			"mismatched", report.Counts[verifyMismatch],
+			"missing", report.Counts[verifyMissing],
+			"errors", report.Counts[verifyError],
+		)
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// rehashVersion recomputes the SHA-256 and size of a version's stored objects
+// and writes them to the record, for versions uploaded before checksums were
+// recorded. Only empty checksums and zero sizes are filled in; a record that
+// disagrees with its object is left alone (409) unless force=true, since that
+// usually means the object is damaged rather than the record.
+func (s *Server) rehashVersion(c *gin.Context) {
+	ctx := requestContext(c)
+	id := c.Param("id")
+	if !isValidKey(id) {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid version id")
+		return
+	}
+	force, _ := strconv.ParseBool(c.Query("force"))
+
+	version, err := s.store.GetVersion(ctx, id)
+	if err != nil {
+		loggerFrom(ctx).Error("version read failed", "err", err)
+		respondError(c, http.StatusInternalServerError, codeDatabaseError, "Database error")
+		return
+	}
+	if version == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, fmt.Sprintf("Version %s not found", id))
+		return
+	}
+	if !authorizePlatform(c, platformOf(*version)) {
+		return
+	}
+
+	artifacts := append([]Artifact(nil), artifactsOf(*version)...)
+	changed := false
+	for i, a := range artifacts {
+		result := verifyArtifact(ctx, s.store, *version, a)
+		switch result.Status {
+		case verifyMissing:
+			respondErrorDetails(c, http.StatusNotFound, codeNotFound, "Stored file not found", gin.H{"storage_path": a.StoragePath})
+			return
+		case verifyError:
+			loggerFrom(ctx).Error("storage read failed", "storage_path", a.StoragePath, "version_id", id, "err", result.Error)
+			respondError(c, http.StatusInternalServerError, codeStorageError, "Failed to read file from storage")
+			return
+		}
+		agrees := (a.Checksum == "" || strings.EqualFold(a.Checksum, result.ActualChecksum)) &&
+			(a.FileSize == 0 || a.FileSize == result.ActualSize)
+		if !agrees && !force {
+			respondErrorDetails(c, http.StatusConflict, codeChecksumMismatch, "Stored file does not match the record; pass force=true to overwrite it", result)
+			return
+		}
+		if a.Checksum != result.ActualChecksum || a.FileSize != result.ActualSize {
+			artifacts[i].Checksum = result.ActualChecksum
+			artifacts[i].FileSize = result.ActualSize
+			changed = true
+		}
+	}
+
+	if changed {
+		fields := []string{"checksum", "file_size", "updated_at"}
+		if len(version.Artifacts) > 0 {
+			version.Artifacts = artifacts
+			fields = append(fields, "artifacts")
+		}
+		version.Checksum = artifacts[0].Checksum
+		version.FileSize = artifacts[0].FileSize
+		version.UpdatedAt = time.Now()
+		if err := s.store.UpdateVersions(ctx, []AppVersion{*version}, fields...); err != nil {
+			loggerFrom(ctx).Error("version save failed", "err", err)
+			respondError(c, http.StatusInternalServerError, codeDatabaseError, "Failed to save version information")
+			return
+		}
+		loggerFrom(ctx).Info("version rehashed", "version_id", id, "checksum", version.Checksum, "file_size", version.FileSize)
+		s.audit(c, auditRehash, version, map[string]string{"force": strconv.FormatBool(force)})
+	}
+
+	version.DownloadURL = s.downloadURL(version.Version, version.Platform)
+	c.JSON(http.StatusOK, gin.H{
+		"checksum":  version.Checksum,
+		"file_size": version.FileSize,
+		"changed":   changed,
+		"version":   version,
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+)
+
+// seedDamaged seeds android-1 intact, android-2 with a tampered object of
+// the same size, android-3 with its object gone and ios-4 truncated
+func seedDamaged(t *testing.T, ts *testServer) {
+	t.Helper()
+	ctx := context.Background()
+	ts.seed(AppVersion{VersionCode: 1})
+	tampered := ts.seed(AppVersion{VersionCode: 2})
+	missing := ts.seed(AppVersion{VersionCode: 3})
+	truncated := ts.seed(AppVersion{VersionCode: 4, Platform: "ios"})
+
+	if err := ts.store.UploadObject(ctx, tampered.StoragePath, bytes.NewReader([]byte("PK\x03\x04android-X"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.DeleteObject(ctx, missing.StoragePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.UploadObject(ctx, truncated.StoragePath, bytes.NewReader([]byte("PK\x03\x04"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantChecked int
+		wantCounts  map[string]int
+		wantIssues  map[string]string // id to status
+	}{
+		{
+			name:        "every version",
+			wantStatus:  http.StatusOK,
+			wantChecked: 4,
+			wantCounts:  map[string]int{verifyOK: 1, verifyMismatch: 2, verifyMissing: 1},
+			wantIssues:  map[string]string{"android-2": verifyMismatch, "android-3": verifyMissing, "ios-4": verifyMismatch},
+		},
+		{
+			name:        "one platform",
+			query:       "?platform=android",
+			wantStatus:  http.StatusOK,
+			wantChecked: 3,
+			wantCounts:  map[string]int{verifyOK: 1, verifyMismatch: 1, verifyMissing: 1},
+			wantIssues:  map[string]string{"android-2": verifyMismatch, "android-3": verifyMissing},
+		},
+		{
+			name:        "dry run",
+			query:       "?dry_run=true&platform=ios",
+			wantStatus:  http.StatusOK,
+			wantChecked: 1,
+			wantCounts:  map[string]int{verifyPending: 1},
+			wantIssues:  map[string]string{"ios-4": verifyPending},
+		},
+		{name: "invalid platform", query: "?platform=symbian", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, "VERIFY_CONCURRENCY=2")
+			seedDamaged(t, ts)
+
+			w := ts.do(http.MethodPost, "/api/v1/ota/verify-all"+tt.query, nil, testAPIKey)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var report VerifyReport
+			decodeJSON(t, w, &report)
+			if report.Checked != tt.wantChecked {
+				t.Errorf("checked = %d, want %d", report.Checked, tt.wantChecked)
+			}
+			if len(report.Counts) != len(tt.wantCounts) {
+				t.Errorf("counts = %v, want %v", report.Counts, tt.wantCounts)
+			}
+			for status, n := range tt.wantCounts {
+				if report.Counts[status] != n {
+					t.Errorf("counts[%s] = %d, want %d", status, report.Counts[status], n)
+				}
+			}
+			if len(report.Issues) != len(tt.wantIssues) {
+				t.Errorf("issues = %+v, want %v", report.Issues, tt.wantIssues)
+			}
+			for _, issue := range report.Issues {
+				if want := tt.wantIssues[issue.ID]; issue.Status != want {
+					t.Errorf("%s status = %q, want %q", issue.ID, issue.Status, want)
+				}
+				if issue.Status == verifyMismatch && issue.ActualChecksum == issue.ExpectedChecksum {
+					t.Errorf("%s mismatch without differing checksums: %+v", issue.ID, issue)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyAllRequiresAPIKey(t *testing.T) {
+	ts := newTestServer(t)
+	if w := ts.do(http.MethodPost, "/api/v1/ota/verify-all", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
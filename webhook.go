@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReleaseEventType names something that happened to a version. Add a type
+// here and a case in summary() to announce a new kind of event.
+type ReleaseEventType string
+
+const (
+	EventVersionPublished ReleaseEventType = "version.published"
+	EventVersionDeleted   ReleaseEventType = "version.deleted"
+)
+
+// ReleaseEvent is one announcement for the release channel
+type ReleaseEvent struct {
+	Type    ReleaseEventType
+	AppID   string
+	Version AppVersion
+	Actor   string // who caused it, e.g. "key 1a2b3c4d"; empty when unknown
+}
+
+// actorFrom identifies the caller of an authenticated request by its API key id
+func actorFrom(c *gin.Context) string {
+	if id := c.GetString("api_key_id"); id != "" {
+		return "key " + id
+	}
+	return ""
+}
+
+// summary renders the event as a single line of markdown both Slack and
+// Discord display
+func (e ReleaseEvent) summary() string {
+	var verb string
+	switch e.Type {
+	case EventVersionPublished:
+		verb = "Published"
+	case EventVersionDeleted:
+		verb = "Deleted"
+	default:
+		verb = string(e.Type)
+	}
+	v := e.Version
+	parts := []string{fmt.Sprintf("%s `%s` (code %d) for %s", verb, v.Version, v.VersionCode, v.Platform)}
+	if v.FileSize > 0 {
+		parts = append(parts, formatSize(v.FileSize))
+	}
+	if v.Channel != "" && v.Channel != defaultChannel {
+		parts = append(parts, v.Channel+" channel")
+	}
+	if v.IsMandatory != nil && *v.IsMandatory {
+		parts = append(parts, "mandatory")
+	}
+	if e.Actor != "" {
+		parts = append(parts, "by "+e.Actor)
+	}
+	line := strings.Join(parts, " · ")
+	if e.AppID != "" {
+		line = "[" + e.AppID + "] " + line
+	}
+	return line
+}
+
+// formatSize renders a byte count in binary units, e.g. "12.4 MiB"
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// webhookNotifier posts release events to a Slack or Discord incoming webhook
+type webhookNotifier struct {
+	url    string
+	kind   string // "slack" or "discord"
+	client *http.Client
+}
+
+// webhook is nil unless WEBHOOK_URL is set
+var webhook *webhookNotifier
+
+func newWebhookNotifier(url, kind string) *webhookNotifier {
+	if url == "" {
+		return nil
+	}
+	return &webhookNotifier{url: url, kind: kind, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// notify announces an event. Failures are only logged: the operation being
+// announced has already succeeded.
+func (n *webhookNotifier) notify(ctx context.Context, e ReleaseEvent) {
+	if n == nil {
+		return
+	}
+	if err := n.post(ctx, e); err != nil {
+		loggerFrom(ctx).Warn("webhook notification failed", "event", e.Type, "version_id", e.Version.ID, "err", err)
+	}
+}
+
+func (n *webhookNotifier) post(ctx context.Context, e ReleaseEvent) error {
+	// Slack reads "text", Discord "content"
+	field := "text"
+	if n.kind == "discord" {
+		field = "content"
+	}
+	body, err := json.Marshal(map[string]string{field: e.summary()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The URL embeds the webhook's secret, so keep it out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}